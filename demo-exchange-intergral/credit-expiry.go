@@ -0,0 +1,304 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 积分的过期：每条赚取明细记录剩余积分 remaining，可用积分是没有过期的赚取明细剩余积分之和。
+//   - 消费时按照过期时间从早到晚依次扣除赚取明细的剩余积分，一笔消费可能分配到多条赚取明细上，分配关系保存在 credit_allocation 表。
+//   - 退还时按照分配关系把积分还给原来的赚取明细，保留原来的过期时间。
+//   - 撤销赚取（比如订单退款）时收回这笔赚取剩余的积分；已经消费的部分不从其他积分中扣除，而是在这笔消费退还时不再退还。
+//   - 过期任务把过期的赚取明细的剩余积分记为一条过期明细，积分明细的总和与可用积分保持一致。
+
+const defaultExpirationBatchSize = 100
+
+// allocate 按照 available 的顺序（最早过期的在前）依次从赚取明细中扣除 credit，可用积分不足时返回 ErrInsufficientCredit
+func allocate(available []*CreditDetailEntity, credit int64) ([]CreditAllocation, error) {
+	var allocations []CreditAllocation
+	required := credit
+	for _, earnEntity := range available {
+		if required == 0 {
+			break
+		}
+		allocated := earnEntity.GetRemaining()
+		if allocated > required {
+			allocated = required
+		}
+		if allocated <= 0 {
+			continue
+		}
+		allocations = append(allocations, CreditAllocation{EarnDetailId: earnEntity.GetId(), Credit: allocated})
+		required -= allocated
+	}
+	if required > 0 {
+		return nil, fmt.Errorf("%w: %d available, %d required", ErrInsufficientCredit, credit-required, credit)
+	}
+	return allocations, nil
+}
+
+// expired 赚取明细在 now 时是否已经过期
+func expired(earnEntity *CreditDetailEntity, now time.Time) bool {
+	return !earnEntity.GetExpiredTime().IsZero() && !earnEntity.GetExpiredTime().After(now)
+}
+
+// Refund 退还渠道和事件对应的消费，积分还给消费时分配的赚取明细，已经过期或者撤销的部分不再退还。
+// 退还明细的渠道是 RefundChannel，事件是消费明细的 ID，一笔消费只能退还一次
+func (s *CreditService) Refund(ctx context.Context, userId, channelId, eventId string) (int64, error) {
+	var refundId int64
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		consumeEntity, err := s.detailRepo.GetDetailByEvent(ctx, channelId, eventId)
+		if err != nil {
+			return err
+		}
+		if consumeEntity.GetUserId() != userId || consumeEntity.GetCredit() >= 0 || channelId == ExpireChannel ||
+			channelId == ReverseChannel {
+			return fmt.Errorf("%w: %s/%s is not a consumption of %s", ErrInvalidRequest, channelId, eventId, userId)
+		}
+
+		refundEntity := NewCreditDetailEntity()
+		refundEntity.SetUserId(userId)
+		refundEntity.SetChannelId(RefundChannel)
+		refundEntity.SetEventId(strconv.FormatInt(consumeEntity.GetId(), 10))
+		refundEntity.SetCreateTime(s.now())
+		// 退还的积分取决于赚取明细是否过期，重复调用时不和已有明细比较积分，直接返回
+		existing, err := s.detailRepo.GetDetailByEvent(ctx, RefundChannel, refundEntity.GetEventId())
+		if err == nil {
+			refundId = existing.GetId()
+			return nil
+		} else if !errors.Is(err, ErrDetailNotFound) {
+			return err
+		}
+
+		allocations, err := s.detailRepo.GetAllocations(ctx, consumeEntity.GetId())
+		if err != nil {
+			return err
+		}
+		// 按照 ID 的顺序锁住赚取明细
+		sort.Slice(allocations, func(i, j int) bool { return allocations[i].EarnDetailId < allocations[j].EarnDetailId })
+		var refunds []CreditAllocation
+		for _, allocation := range allocations {
+			earnEntity, err := s.detailRepo.GetDetailForUpdate(ctx, allocation.EarnDetailId)
+			if err != nil {
+				return err
+			}
+			if expired(earnEntity, refundEntity.GetCreateTime()) {
+				continue
+			}
+			if reversed, err := s.reversed(ctx, earnEntity.GetId()); err != nil {
+				return err
+			} else if reversed {
+				continue
+			}
+			refunds = append(refunds, CreditAllocation{EarnDetailId: allocation.EarnDetailId, Credit: -allocation.Credit})
+			refundEntity.SetCredit(refundEntity.GetCredit() + allocation.Credit)
+		}
+		refundId, err = s.save(ctx, refundEntity, func(ctx context.Context) ([]CreditAllocation, error) {
+			return refunds, nil
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return refundId, nil
+}
+
+// Reverse 撤销渠道和事件对应的赚取，收回这笔赚取还没有被消费或者过期的积分。
+// 撤销明细的渠道是 ReverseChannel，事件是赚取明细的 ID，一笔赚取只能撤销一次
+func (s *CreditService) Reverse(ctx context.Context, userId, channelId, eventId string) (int64, error) {
+	var reverseId int64
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		earnEntity, err := s.detailRepo.GetDetailByEvent(ctx, channelId, eventId)
+		if err != nil {
+			return err
+		}
+		if earnEntity.GetUserId() != userId || earnEntity.GetCredit() <= 0 || channelId == RefundChannel {
+			return fmt.Errorf("%w: %s/%s is not an earning of %s", ErrInvalidRequest, channelId, eventId, userId)
+		}
+
+		reverseEntity := NewCreditDetailEntity()
+		reverseEntity.SetUserId(userId)
+		reverseEntity.SetChannelId(ReverseChannel)
+		reverseEntity.SetEventId(strconv.FormatInt(earnEntity.GetId(), 10))
+		reverseEntity.SetCreateTime(s.now())
+		// 收回的积分取决于剩余的积分，重复调用时不和已有明细比较积分，直接返回
+		existing, err := s.detailRepo.GetDetailByEvent(ctx, ReverseChannel, reverseEntity.GetEventId())
+		if err == nil {
+			reverseId = existing.GetId()
+			return nil
+		} else if !errors.Is(err, ErrDetailNotFound) {
+			return err
+		}
+
+		if earnEntity, err = s.detailRepo.GetDetailForUpdate(ctx, earnEntity.GetId()); err != nil {
+			return err
+		}
+		// 已经过期的积分留给过期任务处理
+		var allocations []CreditAllocation
+		if remaining := earnEntity.GetRemaining(); remaining > 0 && !expired(earnEntity, reverseEntity.GetCreateTime()) {
+			reverseEntity.SetCredit(-remaining)
+			allocations = []CreditAllocation{{EarnDetailId: earnEntity.GetId(), Credit: remaining}}
+		}
+		reverseId, err = s.save(ctx, reverseEntity, func(ctx context.Context) ([]CreditAllocation, error) {
+			return allocations, nil
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return reverseId, nil
+}
+
+// reversed 赚取明细是否已经撤销
+func (s *CreditService) reversed(ctx context.Context, earnId int64) (bool, error) {
+	_, err := s.detailRepo.GetDetailByEvent(ctx, ReverseChannel, strconv.FormatInt(earnId, 10))
+	if errors.Is(err, ErrDetailNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ExpiringCredit 即将过期的积分，Details 是还有剩余积分的赚取明细
+type ExpiringCredit struct {
+	Total   int64
+	Details []*CreditDetailBo
+}
+
+// GetExpiringCredits 查询未来 days 天内将要过期的积分
+func (s *CreditService) GetExpiringCredits(ctx context.Context, userId string, days int) (*ExpiringCredit, error) {
+	now := s.now()
+	earnEntities, err := s.detailRepo.GetExpiringDetails(ctx, userId, now, now.AddDate(0, 0, days))
+	if err != nil {
+		return nil, err
+	}
+
+	expiring := &ExpiringCredit{}
+	for _, earnEntity := range earnEntities {
+		expiring.Total += earnEntity.GetRemaining()
+		expiring.Details = append(expiring.Details, newCreditDetailBo(earnEntity))
+	}
+	return expiring, nil
+}
+
+// ExpireCredits 把最多 limit 条已经过期的赚取明细的剩余积分记为过期，返回处理的明细数量。
+// 每条赚取明细生成一条过期明细，渠道是 ExpireChannel，事件是赚取明细的 ID
+func (s *CreditService) ExpireCredits(ctx context.Context, limit int) (int, error) {
+	now := s.now()
+	earnEntities, err := s.detailRepo.GetExpiredDetails(ctx, now, limit)
+	if err != nil {
+		return 0, err
+	}
+	for i, earnEntity := range earnEntities {
+		if err := s.expire(ctx, earnEntity.GetId(), now); err != nil {
+			return i, err
+		}
+	}
+	return len(earnEntities), nil
+}
+
+// expire 锁住赚取明细后再检查剩余积分，其他的过期任务已经处理过时不再处理
+func (s *CreditService) expire(ctx context.Context, earnId int64, now time.Time) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		earnEntity, err := s.detailRepo.GetDetailForUpdate(ctx, earnId)
+		if err != nil {
+			return err
+		}
+		if earnEntity.GetRemaining() <= 0 {
+			return nil
+		}
+
+		expireEntity := NewCreditDetailEntity()
+		expireEntity.SetUserId(earnEntity.GetUserId())
+		expireEntity.SetChannelId(ExpireChannel)
+		expireEntity.SetEventId(strconv.FormatInt(earnId, 10))
+		expireEntity.SetCredit(-earnEntity.GetRemaining())
+		expireEntity.SetCreateTime(now)
+		_, err = s.save(ctx, expireEntity, func(ctx context.Context) ([]CreditAllocation, error) {
+			return []CreditAllocation{{EarnDetailId: earnId, Credit: earnEntity.GetRemaining()}}, nil
+		})
+		return err
+	})
+}
+
+// ExpirationJob 定时执行 CreditService.ExpireCredits 的后台任务。
+// 可用积分的查询已经排除了过期的积分，过期任务只是补上过期明细，执行得晚一些不会影响可用积分
+type ExpirationJob struct {
+	creditService *CreditService
+	batchSize     int
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewExpirationJob batchSize 小于等于 0 时使用默认值
+func NewExpirationJob(creditService *CreditService, batchSize int) *ExpirationJob {
+	if batchSize <= 0 {
+		batchSize = defaultExpirationBatchSize
+	}
+	return &ExpirationJob{creditService: creditService, batchSize: batchSize,
+		stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Start 立即清理一次过期积分，之后每隔 period 清理一次。重复调用或者 Close 之后调用不做任何事
+func (j *ExpirationJob) Start(period time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.started || j.closed {
+		return
+	}
+	j.started = true
+
+	ticker := time.NewTicker(period)
+	go func() {
+		defer close(j.done)
+		defer ticker.Stop()
+		for {
+			j.expireAll()
+			select {
+			case <-ticker.C:
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// expireAll 一批过期明细满了说明可能还有更多，继续处理下一批，直到不满一批或者出错；出错时等到下一个周期再试
+func (j *ExpirationJob) expireAll() {
+	for {
+		n, err := j.creditService.ExpireCredits(context.Background(), j.batchSize)
+		if err != nil {
+			log.Println("expire credits:", err)
+			return
+		}
+		if n < j.batchSize {
+			return
+		}
+	}
+}
+
+// Close 停止清理，正在清理的一批明细提交之后返回。没有调用过 Start 时直接返回，可以重复调用
+func (j *ExpirationJob) Close() {
+	j.mu.Lock()
+	started, closed := j.started, j.closed
+	j.closed = true
+	j.mu.Unlock()
+
+	if !closed {
+		close(j.stop)
+	}
+	if started {
+		<-j.done
+	}
+}
@@ -0,0 +1,245 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCreditController_ExpiryAndRefund(t *testing.T) {
+	controller, _ := newTestController(t)
+	ctx := context.Background()
+	now := testNow
+	controller.creditService.now = func() time.Time { return now }
+
+	mustEarn := func(channelId, eventId string, credit int64, expiredTime time.Time) {
+		t.Helper()
+		if _, err := controller.Earn(ctx, "u1", channelId, eventId, credit, expiredTime); err != nil {
+			t.Fatalf("Earn() error = %v", err)
+		}
+	}
+	mustEarn("ORDER", "order-1", 100, testNow.AddDate(0, 0, 30))
+	mustEarn("ORDER", "order-2", 50, testNow.AddDate(0, 0, 10))
+	mustEarn("SIGN_IN", "2021-06-01", 20, time.Time{})
+	remaining := func() []int64 {
+		t.Helper()
+		page, err := controller.GetEarnDetails(ctx, "u1", "", 0)
+		if err != nil {
+			t.Fatalf("GetEarnDetails() error = %v", err)
+		}
+		var got []int64
+		for _, detail := range page.Details {
+			if detail.ChannelId != RefundChannel {
+				got = append(got, detail.Remaining)
+			}
+		}
+		return got
+	}
+	wantTotal := func(want int64) {
+		t.Helper()
+		if total, err := controller.GetTotalCredit(ctx, "u1"); total != want || err != nil {
+			t.Errorf("GetTotalCredit() got = %d, %v, want %d", total, err, want)
+		}
+	}
+
+	// 先消费 10 天后过期的 50 积分，再消费 30 天后过期的积分，永不过期的积分最后消费
+	if _, err := controller.Consume(ctx, "u1", "COUPON", "coupon-1", 70); err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if got, want := remaining(), []int64{20, 0, 80}; !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining got = %v, want %v", got, want)
+	}
+	wantTotal(100)
+	if expiring, err := controller.GetExpiringCredits(ctx, "u1", 15); err != nil || expiring.Total != 0 || len(expiring.Details) != 0 {
+		t.Errorf("GetExpiringCredits(15) got = %+v, %v", expiring, err)
+	}
+	if expiring, err := controller.GetExpiringCredits(ctx, "u1", 30); err != nil || expiring.Total != 80 ||
+		len(expiring.Details) != 1 || expiring.Details[0].EventId != "order-1" {
+		t.Errorf("GetExpiringCredits(30) got = %+v, %v", expiring, err)
+	}
+
+	// 退还的积分回到原来的赚取明细，保留原来的过期时间
+	refundId, err := controller.Refund(ctx, "u1", "COUPON", "coupon-1")
+	if err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	if id, err := controller.Refund(ctx, "u1", "COUPON", "coupon-1"); id != refundId || err != nil {
+		t.Errorf("Refund() again got = %d, %v, want %d", id, err, refundId)
+	}
+	if got, want := remaining(), []int64{20, 50, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining after refund got = %v, want %v", got, want)
+	}
+	wantTotal(170)
+
+	if _, err := controller.Consume(ctx, "u1", "COUPON", "coupon-2", 30); err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	// 过期任务执行之前，可用积分已经不包含过期的积分，过期的部分也不再退还
+	now = testNow.AddDate(0, 0, 15)
+	wantTotal(120)
+	refundId, err = controller.Refund(ctx, "u1", "COUPON", "coupon-2")
+	if err != nil {
+		t.Fatalf("Refund() after expiry error = %v", err)
+	}
+	wantTotal(120)
+
+	if n, err := controller.creditService.ExpireCredits(ctx, 10); n != 1 || err != nil {
+		t.Fatalf("ExpireCredits() got = %d, %v, want 1", n, err)
+	}
+	if n, err := controller.creditService.ExpireCredits(ctx, 10); n != 0 || err != nil {
+		t.Fatalf("ExpireCredits() again got = %d, %v, want 0", n, err)
+	}
+	page, err := controller.GetDetails(ctx, "u1", "", 2)
+	if err != nil || page.Details[0].ChannelId != ExpireChannel || page.Details[0].Credit != -20 ||
+		page.Details[1].Id != refundId || page.Details[1].Credit != 0 {
+		t.Errorf("GetDetails() got = %+v, %v", page, err)
+	}
+	wantTotal(120)
+
+	if _, err := controller.Consume(ctx, "u1", "COUPON", "coupon-3", 121); !errors.Is(err, ErrInsufficientCredit) {
+		t.Errorf("Consume() error = %v, want %v", err, ErrInsufficientCredit)
+	}
+	if _, err := controller.Consume(ctx, "u1", "COUPON", "coupon-3", 120); err != nil {
+		t.Errorf("Consume() all error = %v", err)
+	}
+	wantTotal(0)
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"earn from reserved channel", func() error {
+			_, err := controller.Earn(ctx, "u1", ExpireChannel, "1", 10, time.Time{})
+			return err
+		}},
+		{"refund earning", func() error {
+			_, err := controller.Refund(ctx, "u1", "ORDER", "order-1")
+			return err
+		}},
+		{"refund consumption of another user", func() error {
+			_, err := controller.Refund(ctx, "u2", "COUPON", "coupon-3")
+			return err
+		}},
+		{"expiring days out of range", func() error {
+			_, err := controller.GetExpiringCredits(ctx, "u1", 0)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrInvalidRequest) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidRequest)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	available := []*CreditDetailEntity{{id: 3, remaining: 30}, {id: 1, remaining: 50}, {id: 2, remaining: 100}}
+	tests := []struct {
+		name    string
+		credit  int64
+		want    []CreditAllocation
+		wantErr error
+	}{
+		{"within first", 20, []CreditAllocation{{EarnDetailId: 3, Credit: 20}}, nil},
+		{"across details", 100, []CreditAllocation{{EarnDetailId: 3, Credit: 30}, {EarnDetailId: 1, Credit: 50},
+			{EarnDetailId: 2, Credit: 20}}, nil},
+		{"all", 180, []CreditAllocation{{EarnDetailId: 3, Credit: 30}, {EarnDetailId: 1, Credit: 50},
+			{EarnDetailId: 2, Credit: 100}}, nil},
+		{"insufficient", 181, nil, ErrInsufficientCredit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := allocate(available, tt.credit)
+			if !reflect.DeepEqual(got, tt.want) || !errors.Is(err, tt.wantErr) {
+				t.Errorf("allocate() got = %v, %v, want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpirationJob_Start(t *testing.T) {
+	controller, _ := newTestController(t)
+	ctx := context.Background()
+	for _, eventId := range []string{"order-1", "order-2", "order-3"} {
+		if _, err := controller.Earn(ctx, "u1", "ORDER", eventId, 10, testNow.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	controller.creditService.now = func() time.Time { return testNow.Add(2 * time.Hour) }
+
+	// 一批满了时立即处理下一批，不需要等到下一个周期
+	job := NewExpirationJob(controller.creditService, 2)
+	job.Start(time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		page, err := controller.GetConsumeDetails(ctx, "u1", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Details) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expired details got = %d, want 3", len(page.Details))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	job.Close()
+	job.Close()
+
+	// 没有启动过的任务关闭时直接返回，关闭之后 Start 不再启动
+	idle := NewExpirationJob(controller.creditService, 2)
+	idle.Close()
+	idle.Start(time.Hour)
+	idle.Close()
+}
+
+func TestCreditController_Reverse(t *testing.T) {
+	controller, _ := newTestController(t)
+	ctx := context.Background()
+	for _, eventId := range []string{"order-1", "order-2"} {
+		if _, err := controller.Earn(ctx, "u1", "ORDER", eventId, map[string]int64{"order-1": 100, "order-2": 50}[eventId],
+			time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantTotal := func(want int64) {
+		t.Helper()
+		if total, err := controller.GetTotalCredit(ctx, "u1"); total != want || err != nil {
+			t.Errorf("GetTotalCredit() got = %d, %v, want %d", total, err, want)
+		}
+	}
+
+	// 消费了 order-1 的 100 积分和 order-2 的 20 积分，撤销 order-1 时没有可以收回的积分
+	if _, err := controller.Consume(ctx, "u1", "COUPON", "coupon-1", 120); err != nil {
+		t.Fatal(err)
+	}
+	reverseId, err := controller.Reverse(ctx, "u1", "ORDER", "order-1")
+	if err != nil {
+		t.Fatalf("Reverse() error = %v", err)
+	}
+	if id, err := controller.Reverse(ctx, "u1", "ORDER", "order-1"); id != reverseId || err != nil {
+		t.Errorf("Reverse() again got = %d, %v, want %d", id, err, reverseId)
+	}
+	wantTotal(30)
+	// 退还消费时 order-1 的部分不再退还，相当于收回了 order-1 的积分
+	if _, err := controller.Refund(ctx, "u1", "COUPON", "coupon-1"); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	wantTotal(50)
+	if _, err := controller.Reverse(ctx, "u1", "ORDER", "order-2"); err != nil {
+		t.Fatalf("Reverse() error = %v", err)
+	}
+	wantTotal(0)
+
+	if _, err := controller.Reverse(ctx, "u1", "COUPON", "coupon-1"); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Reverse() consumption error = %v, want %v", err, ErrInvalidRequest)
+	}
+	if _, err := controller.Reverse(ctx, "u1", "ORDER", "order-9"); !errors.Is(err, ErrDetailNotFound) {
+		t.Errorf("Reverse() unknown order error = %v, want %v", err, ErrDetailNotFound)
+	}
+}
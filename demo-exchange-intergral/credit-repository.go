@@ -0,0 +1,219 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+var ErrDetailNotFound = errors.New("credit detail not found")
+
+var _ CreditDetailRepository = (*SQLCreditDetailRepository)(nil)
+
+// SQLCreditDetailRepository 基于 database/sql 的积分明细存储
+type SQLCreditDetailRepository struct {
+	db *sql.DB
+}
+
+func NewSQLCreditDetailRepository(db *sql.DB) *SQLCreditDetailRepository {
+	return &SQLCreditDetailRepository{db: db}
+}
+
+const detailColumns = "id, user_id, channel_id, event_id, credit, remaining, create_time, expired_time"
+
+// notExpired 赚取明细还有剩余积分并且没有过期的条件，参数是当前时间
+const notExpired = "remaining > 0 AND (expired_time IS NULL OR expired_time > ?)"
+
+func (r *SQLCreditDetailRepository) SaveDetail(ctx context.Context, entity *CreditDetailEntity) error {
+	// 永不过期的积分和消费明细的过期时间保存为 NULL
+	var expiredTime sql.NullTime
+	if !entity.expiredTime.IsZero() {
+		expiredTime = sql.NullTime{Time: entity.expiredTime, Valid: true}
+	}
+	result, err := sqlstore.ExecutorFrom(ctx, r.db).ExecContext(ctx,
+		"INSERT INTO credit_detail (user_id, channel_id, event_id, credit, remaining, create_time, expired_time) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?)",
+		entity.userId, entity.channelId, entity.eventId, entity.credit, entity.remaining, entity.createTime, expiredTime)
+	if err != nil {
+		return err
+	}
+
+	entity.id, err = result.LastInsertId()
+	return err
+}
+
+func (r *SQLCreditDetailRepository) GetDetailByEvent(ctx context.Context, channelId, eventId string) (*CreditDetailEntity, error) {
+	entity, err := scanDetail(sqlstore.ExecutorFrom(ctx, r.db).QueryRowContext(ctx,
+		"SELECT "+detailColumns+" FROM credit_detail WHERE channel_id = ? AND event_id = ?", channelId, eventId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrDetailNotFound, channelId, eventId)
+	}
+	return entity, err
+}
+
+func (r *SQLCreditDetailRepository) GetDetailForUpdate(ctx context.Context, id int64) (*CreditDetailEntity, error) {
+	entity, err := scanDetail(sqlstore.ExecutorFrom(ctx, r.db).QueryRowContext(ctx,
+		"SELECT "+detailColumns+" FROM credit_detail WHERE id = ? FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrDetailNotFound, id)
+	}
+	return entity, err
+}
+
+func (r *SQLCreditDetailRepository) GetTotalCredit(ctx context.Context, userId string, now time.Time) (int64, error) {
+	var total int64
+	err := sqlstore.ExecutorFrom(ctx, r.db).QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(remaining), 0) FROM credit_detail WHERE user_id = ? AND "+notExpired, userId, now).Scan(&total)
+	return total, err
+}
+
+// GetChannelCreditForUpdate 在 idx_user_channel 索引上锁住用户在这个渠道的明细和之后的间隙，
+// 撤销和退还明细的事件是原明细的 ID，通过 uk_event 索引排除已经撤销的赚取和已经退还的消费
+func (r *SQLCreditDetailRepository) GetChannelCreditForUpdate(ctx context.Context, userId, channelId string,
+	detailType int, since, until time.Time) (int64, error) {
+	var sum, sign, undoChannel string
+	switch detailType {
+	case EARN:
+		sum, sign, undoChannel = "d.credit", "d.credit > 0", ReverseChannel
+	case CONSUME:
+		sum, sign, undoChannel = "-d.credit", "d.credit < 0", RefundChannel
+	default:
+		return 0, fmt.Errorf("%w: detail type %d", ErrInvalidRequest, detailType)
+	}
+	query := "SELECT COALESCE(SUM(" + sum + "), 0) FROM credit_detail d WHERE d.user_id = ? AND d.channel_id = ? AND " + sign +
+		" AND NOT EXISTS (SELECT 1 FROM credit_detail u WHERE u.channel_id = ? AND u.event_id = CAST(d.id AS CHAR))"
+	args := []interface{}{userId, channelId, undoChannel}
+	if !since.IsZero() {
+		query += " AND d.create_time >= ?"
+		args = append(args, since)
+	}
+	if !until.IsZero() {
+		query += " AND d.create_time < ?"
+		args = append(args, until)
+	}
+	var total int64
+	err := sqlstore.ExecutorFrom(ctx, r.db).QueryRowContext(ctx, query+" FOR UPDATE", args...).Scan(&total)
+	return total, err
+}
+
+// GetAvailableDetailsForUpdate 只锁住可用的赚取明细，并发的消费会在这里排队；
+// 赚取新的积分不受影响，过期的明细只有过期任务会修改
+func (r *SQLCreditDetailRepository) GetAvailableDetailsForUpdate(ctx context.Context, userId string,
+	now time.Time) ([]*CreditDetailEntity, error) {
+	return r.queryDetails(ctx, "SELECT "+detailColumns+" FROM credit_detail WHERE user_id = ? AND "+notExpired+
+		" ORDER BY expired_time IS NULL, expired_time, id FOR UPDATE", userId, now)
+}
+
+func (r *SQLCreditDetailRepository) GetExpiringDetails(ctx context.Context, userId string,
+	now, until time.Time) ([]*CreditDetailEntity, error) {
+	return r.queryDetails(ctx, "SELECT "+detailColumns+" FROM credit_detail "+
+		"WHERE user_id = ? AND remaining > 0 AND expired_time > ? AND expired_time <= ? ORDER BY expired_time, id",
+		userId, now, until)
+}
+
+func (r *SQLCreditDetailRepository) GetExpiredDetails(ctx context.Context, now time.Time, limit int) ([]*CreditDetailEntity, error) {
+	return r.queryDetails(ctx, "SELECT "+detailColumns+" FROM credit_detail "+
+		"WHERE expired_time <= ? AND remaining > 0 ORDER BY expired_time, id LIMIT ?", now, limit)
+}
+
+// SaveAllocations 赚取明细已经在工作单元中锁住，直接在数据库中扣除剩余积分
+func (r *SQLCreditDetailRepository) SaveAllocations(ctx context.Context, allocations []CreditAllocation) error {
+	tx := sqlstore.ExecutorFrom(ctx, r.db)
+	placeholders := make([]string, 0, len(allocations))
+	args := make([]interface{}, 0, len(allocations)*3)
+	for _, allocation := range allocations {
+		placeholders = append(placeholders, "(?, ?, ?)")
+		args = append(args, allocation.DetailId, allocation.EarnDetailId, allocation.Credit)
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO credit_allocation (detail_id, earn_detail_id, credit) VALUES "+
+		strings.Join(placeholders, ", "), args...)
+	if err != nil {
+		return err
+	}
+
+	for _, allocation := range allocations {
+		_, err := tx.ExecContext(ctx, "UPDATE credit_detail SET remaining = remaining - ? WHERE id = ?",
+			allocation.Credit, allocation.EarnDetailId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLCreditDetailRepository) GetAllocations(ctx context.Context, detailId int64) ([]CreditAllocation, error) {
+	rows, err := sqlstore.ExecutorFrom(ctx, r.db).QueryContext(ctx,
+		"SELECT detail_id, earn_detail_id, credit FROM credit_allocation WHERE detail_id = ? ORDER BY id", detailId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var allocations []CreditAllocation
+	for rows.Next() {
+		var allocation CreditAllocation
+		if err := rows.Scan(&allocation.DetailId, &allocation.EarnDetailId, &allocation.Credit); err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, allocation)
+	}
+	return allocations, rows.Err()
+}
+
+func (r *SQLCreditDetailRepository) QueryDetails(ctx context.Context, filter CreditDetailFilter) ([]*CreditDetailEntity, error) {
+	query := "SELECT " + detailColumns + " FROM credit_detail WHERE user_id = ?"
+	args := []interface{}{filter.UserId}
+	switch filter.Type {
+	case EARN:
+		query += " AND credit > 0"
+	case CONSUME:
+		query += " AND credit < 0"
+	}
+	if filter.BeforeId > 0 {
+		query += " AND id < ?"
+		args = append(args, filter.BeforeId)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+	return r.queryDetails(ctx, query, args...)
+}
+
+func (r *SQLCreditDetailRepository) queryDetails(ctx context.Context, query string, args ...interface{}) ([]*CreditDetailEntity, error) {
+	rows, err := sqlstore.ExecutorFrom(ctx, r.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var details []*CreditDetailEntity
+	for rows.Next() {
+		entity, err := scanDetail(rows)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, entity)
+	}
+	return details, rows.Err()
+}
+
+// rowScanner *sql.Row 和 *sql.Rows 的公共方法
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDetail(row rowScanner) (*CreditDetailEntity, error) {
+	entity := &CreditDetailEntity{}
+	var expiredTime sql.NullTime
+	err := row.Scan(&entity.id, &entity.userId, &entity.channelId, &entity.eventId, &entity.credit, &entity.remaining,
+		&entity.createTime, &expiredTime)
+	if err != nil {
+		return nil, err
+	}
+	entity.expiredTime = expiredTime.Time
+	return entity, nil
+}
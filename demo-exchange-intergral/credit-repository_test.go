@@ -0,0 +1,167 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/promacanthus/design-patterns/internal/sqlstore/sqltest"
+)
+
+var (
+	selectEventSQL = regexp.QuoteMeta("SELECT id, user_id, channel_id, event_id, credit, remaining, create_time, expired_time " +
+		"FROM credit_detail WHERE channel_id = ? AND event_id = ?")
+	lockAvailableSQL = regexp.QuoteMeta("SELECT id, user_id, channel_id, event_id, credit, remaining, create_time, expired_time " +
+		"FROM credit_detail WHERE user_id = ? AND remaining > 0 AND (expired_time IS NULL OR expired_time > ?) " +
+		"ORDER BY expired_time IS NULL, expired_time, id FOR UPDATE")
+	lockDetailSQL = regexp.QuoteMeta("SELECT id, user_id, channel_id, event_id, credit, remaining, create_time, expired_time " +
+		"FROM credit_detail WHERE id = ? FOR UPDATE")
+	insertDetailSQL = regexp.QuoteMeta("INSERT INTO credit_detail (user_id, channel_id, event_id, credit, remaining, create_time, expired_time) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?)")
+	updateRemainingSQL = regexp.QuoteMeta("UPDATE credit_detail SET remaining = remaining - ? WHERE id = ?")
+	detailColumnNames  = []string{"id", "user_id", "channel_id", "event_id", "credit", "remaining", "create_time", "expired_time"}
+)
+
+func newSQLCreditService(db *sql.DB) *CreditService {
+	service := NewCreditService(NewSQLUnitOfWork(db), NewSQLCreditDetailRepository(db))
+	service.now = func() time.Time { return testNow }
+	return service
+}
+
+func TestMigrate(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	// 只执行最后一个还没有执行过的版本
+	sqltest.ExpectMigrate(mock, "credit_schema_migrations", len(creditMigrations)-1, creditMigrations[len(creditMigrations)-1])
+
+	if applied, err := Migrate(context.Background(), db); applied != 1 || err != nil {
+		t.Errorf("Migrate() got = %v, %v, want 1", applied, err)
+	}
+}
+
+func TestCreditService_ConsumeSQL(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	service := newSQLCreditService(db)
+
+	// 锁住可用的赚取明细，按照过期时间分配后保存消费明细和分配关系
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectEventSQL).WithArgs("COUPON", "coupon-1").WillReturnRows(sqlmock.NewRows(detailColumnNames))
+	mock.ExpectQuery(lockAvailableSQL).WithArgs("u1", testNow).WillReturnRows(sqlmock.NewRows(detailColumnNames).
+		AddRow(2, "u1", "ORDER", "order-2", 100, 50, testNow, testNow.AddDate(0, 1, 0)).
+		AddRow(1, "u1", "SIGN_IN", "2021-05-31", 100, 100, testNow, nil))
+	mock.ExpectExec(insertDetailSQL).WithArgs("u1", "COUPON", "coupon-1", -60, 0, testNow, nil).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO credit_allocation (detail_id, earn_detail_id, credit) VALUES (?, ?, ?), (?, ?, ?)")).
+		WithArgs(7, 2, 50, 7, 1, 10).WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec(updateRemainingSQL).WithArgs(50, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateRemainingSQL).WithArgs(10, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if id, err := service.Consume(context.Background(), "u1", "COUPON", "coupon-1", 60); id != 7 || err != nil {
+		t.Fatalf("Consume() got = %d, %v, want 7", id, err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectEventSQL).WithArgs("COUPON", "coupon-2").WillReturnRows(sqlmock.NewRows(detailColumnNames))
+	mock.ExpectQuery(lockAvailableSQL).WithArgs("u1", testNow).WillReturnRows(sqlmock.NewRows(detailColumnNames).
+		AddRow(1, "u1", "SIGN_IN", "2021-05-31", 100, 40, testNow, nil))
+	mock.ExpectRollback()
+	if _, err := service.Consume(context.Background(), "u1", "COUPON", "coupon-2", 60); !errors.Is(err, ErrInsufficientCredit) {
+		t.Fatalf("Consume() error = %v, want %v", err, ErrInsufficientCredit)
+	}
+}
+
+func TestCreditService_EarnDuplicateSQL(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	service := newSQLCreditService(db)
+	expiredTime := testNow.AddDate(1, 0, 0)
+
+	// 并发的重复消息先保存了明细，唯一索引导致保存失败时返回已有的明细
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectEventSQL).WithArgs("ORDER", "order-1").WillReturnRows(sqlmock.NewRows(detailColumnNames))
+	mock.ExpectExec(insertDetailSQL).WithArgs("u1", "ORDER", "order-1", 100, 100, testNow, expiredTime).
+		WillReturnError(errors.New("Duplicate entry 'ORDER-order-1' for key 'uk_event'"))
+	mock.ExpectRollback()
+	mock.ExpectQuery(selectEventSQL).WithArgs("ORDER", "order-1").
+		WillReturnRows(sqlmock.NewRows(detailColumnNames).AddRow(3, "u1", "ORDER", "order-1", 100, 100, testNow, expiredTime))
+	if id, err := service.Earn(context.Background(), "u1", "ORDER", "order-1", 100, expiredTime); id != 3 || err != nil {
+		t.Fatalf("Earn() got = %d, %v, want 3", id, err)
+	}
+}
+
+func TestSQLCreditDetailRepository_QueryDetails(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	repo := NewSQLCreditDetailRepository(db)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, channel_id, event_id, credit, remaining, create_time, expired_time FROM credit_detail "+
+		"WHERE user_id = ? AND credit > 0 AND id < ? ORDER BY id DESC LIMIT ?")).WithArgs("u1", 10, 3).
+		WillReturnRows(sqlmock.NewRows(detailColumnNames).
+			AddRow(9, "u1", "ORDER", "order-9", 100, 100, testNow, testNow.AddDate(1, 0, 0)).
+			AddRow(8, "u1", "SIGN_IN", "2021-06-01", 5, 0, testNow, nil))
+	details, err := repo.QueryDetails(context.Background(), CreditDetailFilter{UserId: "u1", Type: EARN, BeforeId: 10, Limit: 3})
+	if err != nil || len(details) != 2 {
+		t.Fatalf("QueryDetails() got = %v, %v", details, err)
+	}
+	if details[0].GetId() != 9 || !details[0].GetExpiredTime().Equal(testNow.AddDate(1, 0, 0)) || !details[1].GetExpiredTime().IsZero() {
+		t.Errorf("QueryDetails() got = %+v, %+v", details[0], details[1])
+	}
+}
+
+func TestCreditService_ExpireCreditsSQL(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	service := newSQLCreditService(db)
+	expiredTime := testNow.Add(-time.Hour)
+
+	// 每条过期的赚取明细在各自的事务中锁住后再检查剩余积分，已经被其他实例处理的明细跳过
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, channel_id, event_id, credit, remaining, create_time, expired_time "+
+		"FROM credit_detail WHERE expired_time <= ? AND remaining > 0 ORDER BY expired_time, id LIMIT ?")).
+		WithArgs(testNow, 10).WillReturnRows(sqlmock.NewRows(detailColumnNames).
+		AddRow(3, "u1", "ORDER", "order-3", 100, 30, testNow, expiredTime).
+		AddRow(4, "u2", "ORDER", "order-4", 100, 100, testNow, expiredTime))
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockDetailSQL).WithArgs(3).WillReturnRows(sqlmock.NewRows(detailColumnNames).
+		AddRow(3, "u1", "ORDER", "order-3", 100, 30, testNow, expiredTime))
+	mock.ExpectQuery(selectEventSQL).WithArgs(ExpireChannel, "3").WillReturnRows(sqlmock.NewRows(detailColumnNames))
+	mock.ExpectExec(insertDetailSQL).WithArgs("u1", ExpireChannel, "3", -30, 0, testNow, nil).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO credit_allocation (detail_id, earn_detail_id, credit) VALUES (?, ?, ?)")).
+		WithArgs(9, 3, 30).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(updateRemainingSQL).WithArgs(30, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockDetailSQL).WithArgs(4).WillReturnRows(sqlmock.NewRows(detailColumnNames).
+		AddRow(4, "u2", "ORDER", "order-4", 100, 0, testNow, expiredTime))
+	mock.ExpectCommit()
+
+	if n, err := service.ExpireCredits(context.Background(), 10); n != 2 || err != nil {
+		t.Fatalf("ExpireCredits() got = %d, %v, want 2", n, err)
+	}
+}
+
+func TestSQLCreditDetailRepository_GetChannelCreditForUpdate(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	repo := NewSQLCreditDetailRepository(db)
+	ctx := context.Background()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(SUM(d.credit), 0) FROM credit_detail d "+
+		"WHERE d.user_id = ? AND d.channel_id = ? AND d.credit > 0 "+
+		"AND NOT EXISTS (SELECT 1 FROM credit_detail u WHERE u.channel_id = ? AND u.event_id = CAST(d.id AS CHAR)) "+
+		"AND d.create_time >= ? AND d.create_time < ? FOR UPDATE")).
+		WithArgs("u1", "ORDER", ReverseChannel, testNow, testNow.AddDate(0, 0, 1)).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(80))
+	if total, err := repo.GetChannelCreditForUpdate(ctx, "u1", "ORDER", EARN, testNow, testNow.AddDate(0, 0, 1)); total != 80 || err != nil {
+		t.Errorf("GetChannelCreditForUpdate() got = %d, %v, want 80", total, err)
+	}
+
+	// since 和 until 为零值时统计用户在渠道的所有明细，消费的积分返回正数
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(SUM(-d.credit), 0) FROM credit_detail d "+
+		"WHERE d.user_id = ? AND d.channel_id = ? AND d.credit < 0 "+
+		"AND NOT EXISTS (SELECT 1 FROM credit_detail u WHERE u.channel_id = ? AND u.event_id = CAST(d.id AS CHAR)) FOR UPDATE")).
+		WithArgs("u1", "COUPON", RefundChannel).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(300))
+	if total, err := repo.GetChannelCreditForUpdate(ctx, "u1", "COUPON", CONSUME, time.Time{}, time.Time{}); total != 300 || err != nil {
+		t.Errorf("GetChannelCreditForUpdate() got = %d, %v, want 300", total, err)
+	}
+}
@@ -0,0 +1,556 @@
+package demo_exchange_intergral
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 积分规则：每个渠道的赚取规则把业务金额（如订单金额）换算为赚取的积分，兑换规则把兑换的金额换算为消费的积分。
+// 规则在 JSON 或者 YAML 文件中配置，修改文件之后不需要重启就会生效，格式见 ruleFile。
+
+var (
+	ErrRuleNotFound = errors.New("credit rule not found")
+	// ErrRulesUnavailable Service 没有配置 RuleProvider，不支持按照规则赚取和兑换积分
+	ErrRulesUnavailable = errors.New("credit rules unavailable")
+	// ErrCreditLimitExceeded 超过了规则的每日或者每个用户的积分上限
+	ErrCreditLimitExceeded = errors.New("credit limit exceeded")
+	// ErrNoCreditEarned 金额太小，按照规则换算的积分为 0，也是一种 ErrInvalidCredit
+	ErrNoCreditEarned = fmt.Errorf("%w: earns no credit", ErrInvalidCredit)
+)
+
+// Rounding 积分换算时小数部分的取舍方式，默认舍去
+const (
+	RoundDown   = "down"
+	RoundUp     = "up"
+	RoundHalfUp = "halfUp"
+)
+
+// CreditRule 一个渠道在一段时间内的积分规则，积分 = 金额 × Ratio 按照 Rounding 取整 + Bonus。
+// 例如订单金额 10% 的积分是 Ratio "0.1"，每次签到 5 积分是 Bonus 5，100 积分兑换 1 元是 Ratio "100"
+type CreditRule struct {
+	ChannelId string `json:"channelId" yaml:"channelId"`
+	// Ratio 每单位金额对应的积分，使用字符串，避免 JSON 的 number 被解析为浮点数
+	Ratio    string `json:"ratio" yaml:"ratio"`
+	Bonus    int64  `json:"bonus" yaml:"bonus"`
+	Rounding string `json:"rounding" yaml:"rounding"`
+	// DailyLimit 每个用户每天在这个渠道最多赚取或者兑换的积分，UserLimit 每个用户在这个渠道累计的上限，0 表示不限制
+	DailyLimit int64 `json:"dailyLimit" yaml:"dailyLimit"`
+	UserLimit  int64 `json:"userLimit" yaml:"userLimit"`
+	// ValidDays 赚取的积分在多少天后过期，0 表示永不过期，兑换规则不能设置
+	ValidDays int `json:"validDays" yaml:"validDays"`
+	// StartTime 和 EndTime 规则生效的时间范围 [StartTime, EndTime)，零值表示不限制；同一个渠道的规则时间范围不能重叠
+	StartTime time.Time `json:"startTime" yaml:"startTime"`
+	EndTime   time.Time `json:"endTime" yaml:"endTime"`
+
+	ratio *big.Rat
+}
+
+// Evaluate 把金额 amount 换算为积分，超出 int64 的范围时返回 ErrInvalidCredit
+func (r *CreditRule) Evaluate(amount int64) (int64, error) {
+	product := new(big.Rat).Mul(big.NewRat(amount, 1), r.ratio)
+	num, den := product.Num(), product.Denom()
+	credit := new(big.Int)
+	switch r.Rounding {
+	case RoundUp:
+		credit.Add(num, den).Sub(credit, big.NewInt(1)).Quo(credit, den)
+	case RoundHalfUp:
+		credit.Lsh(num, 1).Add(credit, den).Quo(credit, new(big.Int).Lsh(den, 1))
+	default:
+		credit.Quo(num, den)
+	}
+	if !credit.IsInt64() || credit.Int64() > math.MaxInt64-r.Bonus {
+		return 0, fmt.Errorf("%w: amount %d overflows", ErrInvalidCredit, amount)
+	}
+	return credit.Int64() + r.Bonus, nil
+}
+
+// ExpiredTime 在 now 时赚取的积分的过期时间，零值表示永不过期
+func (r *CreditRule) ExpiredTime(now time.Time) time.Time {
+	if r.ValidDays == 0 {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, r.ValidDays)
+}
+
+func (r *CreditRule) activeAt(now time.Time) bool {
+	return (r.StartTime.IsZero() || !now.Before(r.StartTime)) && (r.EndTime.IsZero() || now.Before(r.EndTime))
+}
+
+func (r *CreditRule) validate(redeem bool) error {
+	if err := validateIds(r.ChannelId); err != nil {
+		return err
+	}
+	if err := checkChannel(r.ChannelId); err != nil {
+		return err
+	}
+	r.ratio = new(big.Rat)
+	if r.Ratio != "" {
+		if _, ok := r.ratio.SetString(r.Ratio); !ok || r.ratio.Sign() < 0 {
+			return fmt.Errorf("invalid ratio %q", r.Ratio)
+		}
+	}
+	switch {
+	case r.Bonus < 0, r.ratio.Sign() == 0 && r.Bonus == 0:
+		return errors.New("ratio or bonus should be positive")
+	case r.Rounding != "" && r.Rounding != RoundDown && r.Rounding != RoundUp && r.Rounding != RoundHalfUp:
+		return fmt.Errorf("unknown rounding %q", r.Rounding)
+	case r.DailyLimit < 0 || r.UserLimit < 0:
+		return errors.New("limits should not be negative")
+	case r.ValidDays < 0, redeem && r.ValidDays != 0:
+		return errors.New("valid days should be 0 for redeem rules and not negative for earn rules")
+	case !r.StartTime.IsZero() && !r.EndTime.IsZero() && !r.EndTime.After(r.StartTime):
+		return errors.New("end time should be after start time")
+	}
+	return nil
+}
+
+// ruleFile 规则文件的格式，如
+//
+//	timezone: Asia/Shanghai
+//	earn:
+//	  - channelId: ORDER
+//	    ratio: "0.1"
+//	    dailyLimit: 1000
+//	    validDays: 365
+//	redeem:
+//	  - channelId: COUPON
+//	    ratio: "100"
+//
+// timezone 用于计算每日上限的自然日，默认使用本地时区
+type ruleFile struct {
+	Timezone string       `json:"timezone" yaml:"timezone"`
+	Earn     []CreditRule `json:"earn" yaml:"earn"`
+	Redeem   []CreditRule `json:"redeem" yaml:"redeem"`
+}
+
+var _ RuleProvider = (*CreditRules)(nil)
+
+// CreditRules 校验过的一组规则，创建之后不再修改，可以在多个 goroutine 中使用
+type CreditRules struct {
+	location *time.Location
+	earn     map[string][]*CreditRule
+	redeem   map[string][]*CreditRule
+}
+
+// ParseCreditRules 解析 format 为 "json" 或者 "yaml" 的规则，不认识的字段视为错误
+func ParseCreditRules(data []byte, format string) (*CreditRules, error) {
+	var file ruleFile
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&file); err != nil {
+			return nil, err
+		}
+	case "yaml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown rule format %q", format)
+	}
+
+	location := time.Local
+	if file.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(file.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	return NewCreditRules(location, file.Earn, file.Redeem)
+}
+
+// NewCreditRules 校验并索引规则，location 用于计算每日上限的自然日
+func NewCreditRules(location *time.Location, earn, redeem []CreditRule) (*CreditRules, error) {
+	rules := &CreditRules{location: location}
+	var err error
+	if rules.earn, err = indexRules(earn, false); err != nil {
+		return nil, fmt.Errorf("earn rule: %w", err)
+	}
+	if rules.redeem, err = indexRules(redeem, true); err != nil {
+		return nil, fmt.Errorf("redeem rule: %w", err)
+	}
+	return rules, nil
+}
+
+func indexRules(rules []CreditRule, redeem bool) (map[string][]*CreditRule, error) {
+	index := make(map[string][]*CreditRule)
+	for i := range rules {
+		rule := rules[i]
+		if err := rule.validate(redeem); err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.ChannelId, err)
+		}
+		index[rule.ChannelId] = append(index[rule.ChannelId], &rule)
+	}
+
+	for channelId, channelRules := range index {
+		sort.Slice(channelRules, func(i, j int) bool { return channelRules[i].StartTime.Before(channelRules[j].StartTime) })
+		for i := 1; i < len(channelRules); i++ {
+			if previous := channelRules[i-1]; previous.EndTime.IsZero() || previous.EndTime.After(channelRules[i].StartTime) {
+				return nil, fmt.Errorf("%s: overlapping rules", channelId)
+			}
+		}
+	}
+	return index, nil
+}
+
+func (r *CreditRules) Rules(ctx context.Context) (*CreditRules, error) {
+	return r, nil
+}
+
+// EarnRule 渠道在 now 时生效的赚取规则，没有时返回 ErrRuleNotFound
+func (r *CreditRules) EarnRule(channelId string, now time.Time) (*CreditRule, error) {
+	return findRule(r.earn, channelId, now)
+}
+
+// RedeemRule 渠道在 now 时生效的兑换规则，没有时返回 ErrRuleNotFound
+func (r *CreditRules) RedeemRule(channelId string, now time.Time) (*CreditRule, error) {
+	return findRule(r.redeem, channelId, now)
+}
+
+func findRule(index map[string][]*CreditRule, channelId string, now time.Time) (*CreditRule, error) {
+	for _, rule := range index[channelId] {
+		if rule.activeAt(now) {
+			return rule, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s at %s", ErrRuleNotFound, channelId, now.Format(time.RFC3339))
+}
+
+// dayStart now 所在自然日的开始时间
+func (r *CreditRules) dayStart(now time.Time) time.Time {
+	year, month, day := now.In(r.location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, r.location)
+}
+
+// RuleProvider 积分规则的来源
+type RuleProvider interface {
+	Rules(ctx context.Context) (*CreditRules, error)
+}
+
+var _ RuleProvider = (*FileRuleProvider)(nil)
+
+// FileRuleProvider 从 .json、.yaml 或者 .yml 文件中读取积分规则。EarnByRule 和 Redeem 查询规则时只读内存中的规则，不访问文件系统；
+// Start 之后由后台协程定期检查规则文件，修改过才重新加载。运营改错了规则文件时记录日志并继续使用上一次加载成功的规则，
+// 同一个错误的文件只加载一次，修改之后再加载
+type FileRuleProvider struct {
+	path   string
+	format string
+
+	// mu 保护加载的结果
+	mu      sync.Mutex
+	modTime time.Time
+	rules   *CreditRules
+	// failedModTime 最近一次加载失败的文件修改时间
+	failedModTime time.Time
+
+	// state 保护 started 和 closed，stop 关闭之后后台协程退出，退出时关闭 done
+	state   sync.Mutex
+	started bool
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewFileRuleProvider 立即加载一次规则文件，需要在文件修改之后自动重新加载时调用 Start
+func NewFileRuleProvider(path string) (*FileRuleProvider, error) {
+	p := &FileRuleProvider{path: path, stop: make(chan struct{}), done: make(chan struct{})}
+	switch filepath.Ext(path) {
+	case ".json":
+		p.format = "json"
+	case ".yaml", ".yml":
+		p.format = "yaml"
+	default:
+		return nil, fmt.Errorf("unknown rule file %s", path)
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload 重新加载规则文件
+func (p *FileRuleProvider) Reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	rules, err := p.load()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.failedModTime = info.ModTime()
+		return err
+	}
+	p.rules, p.modTime = rules, info.ModTime()
+	return nil
+}
+
+func (p *FileRuleProvider) load() (*CreditRules, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ParseCreditRules(data, p.format)
+	if err != nil {
+		return nil, fmt.Errorf("parse credit rules %s: %w", p.path, err)
+	}
+	return rules, nil
+}
+
+func (p *FileRuleProvider) Rules(ctx context.Context) (*CreditRules, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rules, nil
+}
+
+// Start 每隔 period 检查一次规则文件的修改时间。重复调用或者 Close 之后调用不做任何事
+func (p *FileRuleProvider) Start(period time.Duration) {
+	p.state.Lock()
+	defer p.state.Unlock()
+	if p.started || p.closed {
+		return
+	}
+	p.started = true
+
+	ticker := time.NewTicker(period)
+	go func() {
+		defer close(p.done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.reloadIfModified()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close 停止检查规则文件，之后 Rules 继续返回最后加载的规则。没有调用过 Start 时直接返回，可以重复调用
+func (p *FileRuleProvider) Close() {
+	p.state.Lock()
+	started, closed := p.started, p.closed
+	p.closed = true
+	p.state.Unlock()
+
+	if !closed {
+		close(p.stop)
+	}
+	if started {
+		<-p.done
+	}
+}
+
+// reloadIfModified 文件在上一次加载之后修改过才重新加载，出错只记录日志
+func (p *FileRuleProvider) reloadIfModified() {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		log.Println("check credit rules:", err)
+		return
+	}
+	if !p.modified(info.ModTime()) {
+		return
+	}
+	if err := p.Reload(); err != nil {
+		log.Println("reload credit rules:", err)
+	}
+}
+
+// modified 文件在上一次加载（无论成功或者失败）之后是否修改过
+func (p *FileRuleProvider) modified(modTime time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !modTime.Equal(p.modTime) && !modTime.Equal(p.failedModTime)
+}
+
+// ServiceOption CreditService 的可选配置
+type ServiceOption func(*CreditService)
+
+// WithClock 替换获取当前时间的函数，规则的生效时间、每日上限和积分的过期都按照它计算，方便用假的时钟测试
+func WithClock(now func() time.Time) ServiceOption {
+	return func(s *CreditService) {
+		s.now = now
+	}
+}
+
+// WithRuleProvider 按照规则赚取和兑换积分，见 CreditService.EarnByRule 和 CreditService.Redeem
+func WithRuleProvider(rules RuleProvider) ServiceOption {
+	return func(s *CreditService) {
+		s.rules = rules
+	}
+}
+
+// EarnByRule 按照渠道当前的赚取规则把业务金额 amount 换算为积分并赚取，积分的过期时间由规则决定。
+// 超过每日或者每个用户的上限时只赚取上限以内的积分，已经达到上限时返回 ErrCreditLimitExceeded；
+// 相同渠道的相同事件重复调用时返回第一次的明细 ID，即使规则已经修改
+func (s *CreditService) EarnByRule(ctx context.Context, userId, channelId, eventId string, amount int64) (int64, error) {
+	return s.EarnByRuleAt(ctx, userId, channelId, eventId, amount, s.now())
+}
+
+// EarnByRuleAt 与 EarnByRule 相同，但是规则、每日上限和过期时间都按照事件发生的时间 eventTime 计算，明细的时间也是 eventTime，
+// 同一个事件无论什么时候处理结果都一样；按照 eventTime 计算已经过期的积分不再赚取，返回 ErrNoCreditEarned。
+// eventTime 为零值时使用当前时间
+func (s *CreditService) EarnByRuleAt(ctx context.Context, userId, channelId, eventId string, amount int64,
+	eventTime time.Time) (int64, error) {
+	if eventTime.IsZero() {
+		eventTime = s.now()
+	}
+	if amount < 0 {
+		return 0, fmt.Errorf("%w: amount %d", ErrInvalidCredit, amount)
+	}
+	var earnId int64
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		existing, err := s.findUserEvent(ctx, userId, channelId, eventId)
+		if err != nil {
+			return err
+		} else if existing != nil {
+			earnId = existing.GetId()
+			return nil
+		}
+		rules, err := s.creditRules(ctx)
+		if err != nil {
+			return err
+		}
+		rule, err := rules.EarnRule(channelId, eventTime)
+		if err != nil {
+			return err
+		}
+		credit, err := rule.Evaluate(amount)
+		if err != nil {
+			return err
+		}
+		if credit <= 0 {
+			return fmt.Errorf("%w: amount %d", ErrNoCreditEarned, amount)
+		}
+		if credit, err = s.limitCredit(ctx, rules, rule, EARN, userId, credit, eventTime); err != nil {
+			return err
+		}
+		if credit <= 0 {
+			return fmt.Errorf("%w: %s earned by %s", ErrCreditLimitExceeded, channelId, userId)
+		}
+		expiredTime := rule.ExpiredTime(eventTime)
+		if !expiredTime.IsZero() && !expiredTime.After(s.now()) {
+			return fmt.Errorf("%w: expired at %s", ErrNoCreditEarned, expiredTime.Format(time.RFC3339))
+		}
+		if err := checkChannel(channelId); err != nil {
+			return err
+		}
+		earnId, err = s.earn(ctx, userId, channelId, eventId, credit, expiredTime, eventTime)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return earnId, nil
+}
+
+// Redeem 按照渠道当前的兑换规则计算兑换金额 amount 需要的积分并消费，超过每日或者每个用户的上限时返回 ErrCreditLimitExceeded；
+// 相同渠道的相同事件重复调用时返回第一次的明细 ID，即使规则已经修改
+func (s *CreditService) Redeem(ctx context.Context, userId, channelId, eventId string, amount int64) (int64, error) {
+	if amount <= 0 {
+		return 0, fmt.Errorf("%w: amount %d", ErrInvalidCredit, amount)
+	}
+	var consumeId int64
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		existing, err := s.findUserEvent(ctx, userId, channelId, eventId)
+		if err != nil {
+			return err
+		} else if existing != nil {
+			consumeId = existing.GetId()
+			return nil
+		}
+		now := s.now()
+		rules, err := s.creditRules(ctx)
+		if err != nil {
+			return err
+		}
+		rule, err := rules.RedeemRule(channelId, now)
+		if err != nil {
+			return err
+		}
+		credit, err := rule.Evaluate(amount)
+		if err != nil {
+			return err
+		}
+		limited, err := s.limitCredit(ctx, rules, rule, CONSUME, userId, credit, now)
+		if err != nil {
+			return err
+		}
+		if limited < credit {
+			return fmt.Errorf("%w: %s redeemed by %s", ErrCreditLimitExceeded, channelId, userId)
+		}
+		consumeId, err = s.Consume(ctx, userId, channelId, eventId, credit)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return consumeId, nil
+}
+
+func (s *CreditService) creditRules(ctx context.Context) (*CreditRules, error) {
+	if s.rules == nil {
+		return nil, ErrRulesUnavailable
+	}
+	return s.rules.Rules(ctx)
+}
+
+// findUserEvent 查找渠道和事件对应的明细，属于其他用户时返回 ErrEventConflict
+func (s *CreditService) findUserEvent(ctx context.Context, userId, channelId, eventId string) (*CreditDetailEntity, error) {
+	existing, err := s.detailRepo.GetDetailByEvent(ctx, channelId, eventId)
+	if errors.Is(err, ErrDetailNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if existing.GetUserId() != userId {
+		return nil, fmt.Errorf("%w: %s/%s", ErrEventConflict, channelId, eventId)
+	}
+	return existing, nil
+}
+
+// limitCredit 按照规则的每日上限和每个用户的上限限制 credit，每日上限按照 now 所在的自然日计算。
+// 赚取规则的 detailType 为 EARN，只统计赚取的积分；兑换规则为 CONSUME，只统计兑换消费的积分。
+// 查询已经赚取或者兑换的积分时锁住用户在这个渠道的明细，并发的请求不会超过上限
+func (s *CreditService) limitCredit(ctx context.Context, rules *CreditRules, rule *CreditRule, detailType int, userId string,
+	credit int64, now time.Time) (int64, error) {
+	dayStart := rules.dayStart(now)
+	limits := []struct {
+		limit        int64
+		since, until time.Time
+	}{
+		{rule.DailyLimit, dayStart, dayStart.AddDate(0, 0, 1)},
+		{rule.UserLimit, time.Time{}, time.Time{}},
+	}
+	for _, l := range limits {
+		if l.limit == 0 {
+			continue
+		}
+		used, err := s.detailRepo.GetChannelCreditForUpdate(ctx, userId, rule.ChannelId, detailType, l.since, l.until)
+		if err != nil {
+			return 0, err
+		}
+		if left := l.limit - used; credit > left {
+			credit = left
+		}
+	}
+	return credit, nil
+}
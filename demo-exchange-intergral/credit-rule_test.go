@@ -0,0 +1,320 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testRules = `
+timezone: Asia/Shanghai
+earn:
+  - channelId: ORDER
+    ratio: 0.1
+    dailyLimit: 100
+    userLimit: 250
+    validDays: 30
+    endTime: 2021-06-18T00:00:00+08:00
+  # 618 期间双倍积分
+  - channelId: ORDER
+    ratio: "0.2"
+    dailyLimit: 100
+    userLimit: 250
+    validDays: 30
+    startTime: 2021-06-18T00:00:00+08:00
+    endTime: 2021-06-19T00:00:00+08:00
+  - channelId: SIGN_IN
+    bonus: 5
+redeem:
+  - channelId: COUPON
+    ratio: "100"
+    dailyLimit: 150
+`
+
+func mustEvaluate(t *testing.T, rule *CreditRule, amount int64) int64 {
+	t.Helper()
+	credit, err := rule.Evaluate(amount)
+	if err != nil {
+		t.Fatalf("Evaluate(%d) error = %v", amount, err)
+	}
+	return credit
+}
+
+func TestParseCreditRules(t *testing.T) {
+	rules, err := ParseCreditRules([]byte(testRules), "yaml")
+	if err != nil {
+		t.Fatalf("ParseCreditRules() error = %v", err)
+	}
+	promotion := time.Date(2021, 6, 18, 12, 0, 0, 0, time.UTC)
+	if rule, err := rules.EarnRule("ORDER", promotion); err != nil || mustEvaluate(t, rule, 100) != 20 {
+		t.Errorf("EarnRule() at promotion got = %+v, %v", rule, err)
+	}
+	if rule, err := rules.EarnRule("ORDER", testNow); err != nil || mustEvaluate(t, rule, 100) != 10 ||
+		!rule.ExpiredTime(testNow).Equal(testNow.AddDate(0, 0, 30)) {
+		t.Errorf("EarnRule() got = %+v, %v", rule, err)
+	}
+	if _, err := rules.EarnRule("ORDER", promotion.AddDate(0, 0, 1)); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("EarnRule() after promotion error = %v, want %v", err, ErrRuleNotFound)
+	}
+	if _, err := rules.RedeemRule("ORDER", testNow); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("RedeemRule() error = %v, want %v", err, ErrRuleNotFound)
+	}
+
+	jsonRules, err := ParseCreditRules([]byte(`{"earn": [{"channelId": "SIGN_IN", "bonus": 5}]}`), "json")
+	if err != nil {
+		t.Fatalf("ParseCreditRules(json) error = %v", err)
+	}
+	if rule, err := jsonRules.EarnRule("SIGN_IN", testNow); err != nil || mustEvaluate(t, rule, 0) != 5 || !rule.ExpiredTime(testNow).IsZero() {
+		t.Errorf("EarnRule(json) got = %+v, %v", rule, err)
+	}
+
+	tests := []struct {
+		name   string
+		data   string
+		format string
+	}{
+		{"unknown field", `{"earn": [{"channelId": "ORDER", "ratio": "0.1", "dailyLimt": 100}]}`, "json"},
+		{"ratio as number", `{"earn": [{"channelId": "ORDER", "ratio": 0.1}]}`, "json"},
+		{"invalid ratio", "earn: [{channelId: ORDER, ratio: ten}]", "yaml"},
+		{"no ratio or bonus", "earn: [{channelId: ORDER}]", "yaml"},
+		{"unknown rounding", "earn: [{channelId: ORDER, ratio: 1, rounding: ceil}]", "yaml"},
+		{"reserved channel", "earn: [{channelId: EXPIRE, bonus: 1}]", "yaml"},
+		{"validity of redeem rule", "redeem: [{channelId: COUPON, ratio: 100, validDays: 30}]", "yaml"},
+		{"overlapping", "earn: [{channelId: ORDER, ratio: 1}, {channelId: ORDER, ratio: 2, startTime: 2021-06-18T00:00:00Z}]", "yaml"},
+		{"unknown timezone", "timezone: Mars/Olympus", "yaml"},
+		{"unknown format", "earn: []", "toml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCreditRules([]byte(tt.data), tt.format); err == nil {
+				t.Errorf("ParseCreditRules() error = nil")
+			}
+		})
+	}
+}
+
+func TestCreditRule_Evaluate(t *testing.T) {
+	tests := []struct {
+		rounding string
+		amount   int64
+		want     int64
+	}{
+		{"", 1234, 123},
+		{RoundDown, 1239, 123},
+		{RoundUp, 1231, 124},
+		{RoundUp, 1230, 123},
+		{RoundHalfUp, 1234, 123},
+		{RoundHalfUp, 1235, 124},
+	}
+	for _, tt := range tests {
+		rules, err := NewCreditRules(time.UTC, []CreditRule{{ChannelId: "ORDER", Ratio: "0.1", Rounding: tt.rounding}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rule, _ := rules.EarnRule("ORDER", testNow)
+		if got := mustEvaluate(t, rule, tt.amount); got != tt.want {
+			t.Errorf("Evaluate(%d) with rounding %q got = %d, want %d", tt.amount, tt.rounding, got, tt.want)
+		}
+	}
+
+	rules, err := NewCreditRules(time.UTC, []CreditRule{{ChannelId: "ORDER", Ratio: "10"},
+		{ChannelId: "SIGN_IN", Ratio: "1", Bonus: 1}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, channelId := range []string{"ORDER", "SIGN_IN"} {
+		rule, _ := rules.EarnRule(channelId, testNow)
+		if _, err := rule.Evaluate(math.MaxInt64); !errors.Is(err, ErrInvalidCredit) {
+			t.Errorf("Evaluate(MaxInt64) of %s error = %v, want %v", channelId, err, ErrInvalidCredit)
+		}
+	}
+}
+
+func TestCreditController_EarnByRuleAndRedeem(t *testing.T) {
+	rules, err := ParseCreditRules([]byte(testRules), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	now := testNow
+	controller := NewCreditController(NewCreditService(store.UnitOfWork(), store.DetailRepository(), WithRuleProvider(rules),
+		WithClock(func() time.Time { return now })))
+	ctx := context.Background()
+
+	earn := func(eventId string, amount int64, want int64, wantErr error) int64 {
+		t.Helper()
+		total, _ := controller.GetTotalCredit(ctx, "u1")
+		id, err := controller.EarnByRule(ctx, "u1", "ORDER", eventId, amount)
+		if !errors.Is(err, wantErr) {
+			t.Fatalf("EarnByRule(%s) error = %v, want %v", eventId, err, wantErr)
+		}
+		if got, _ := controller.GetTotalCredit(ctx, "u1"); got-total != want {
+			t.Errorf("EarnByRule(%s) earned %d, want %d", eventId, got-total, want)
+		}
+		return id
+	}
+
+	// 北京时间 6 月 1 日 20:00，每日上限 100
+	id := earn("order-1", 505, 50, nil)
+	earn("order-2", 800, 50, nil)
+	earn("order-3", 1000, 0, ErrCreditLimitExceeded)
+	if got, err := controller.EarnByRule(ctx, "u1", "ORDER", "order-1", 505); got != id || err != nil {
+		t.Errorf("EarnByRule() again got = %d, %v, want %d", got, err, id)
+	}
+	if expiring, err := controller.GetExpiringCredits(ctx, "u1", 30); err != nil || expiring.Total != 100 {
+		t.Errorf("GetExpiringCredits() got = %+v, %v, want 100", expiring, err)
+	}
+
+	// 北京时间的第二天，每日上限重新计算
+	now = testNow.Add(4*time.Hour + time.Minute)
+	earn("order-3", 1000, 100, nil)
+	// 618 双倍积分，累计上限 250 只剩 50
+	now = time.Date(2021, 6, 18, 12, 0, 0, 0, time.UTC)
+	earn("order-4", 1000, 50, nil)
+	earn("order-5", 1000, 0, ErrCreditLimitExceeded)
+	now = time.Date(2021, 6, 20, 12, 0, 0, 0, time.UTC)
+	earn("order-6", 1000, 0, ErrRuleNotFound)
+
+	if _, err := controller.EarnByRule(ctx, "u1", "SIGN_IN", "2021-06-20", 0); err != nil {
+		t.Errorf("EarnByRule(SIGN_IN) error = %v", err)
+	}
+	if _, err := controller.Redeem(ctx, "u1", "COUPON", "coupon-1", 1); err != nil {
+		t.Errorf("Redeem() error = %v", err)
+	}
+	if _, err := controller.Redeem(ctx, "u1", "COUPON", "coupon-2", 1); !errors.Is(err, ErrCreditLimitExceeded) {
+		t.Errorf("Redeem() over daily limit error = %v, want %v", err, ErrCreditLimitExceeded)
+	}
+	if total, err := controller.GetTotalCredit(ctx, "u1"); total != 155 || err != nil {
+		t.Errorf("GetTotalCredit() got = %d, %v, want 155", total, err)
+	}
+
+	withoutRules := NewCreditController(NewCreditService(store.UnitOfWork(), store.DetailRepository()))
+	if _, err := withoutRules.EarnByRule(ctx, "u1", "ORDER", "order-7", 100); !errors.Is(err, ErrRulesUnavailable) {
+		t.Errorf("EarnByRule() without rules error = %v, want %v", err, ErrRulesUnavailable)
+	}
+}
+
+func TestCreditService_LimitExcludesUndone(t *testing.T) {
+	rules, err := ParseCreditRules([]byte(`
+earn: [{channelId: ORDER, ratio: 0.1, dailyLimit: 100}]
+redeem: [{channelId: COUPON, ratio: "100", dailyLimit: 100}]
+`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	service := NewCreditService(store.UnitOfWork(), store.DetailRepository(), WithRuleProvider(rules),
+		WithClock(func() time.Time { return testNow }))
+	ctx := context.Background()
+
+	if _, err := service.EarnByRule(ctx, "u1", "ORDER", "order-1", 1000); err != nil {
+		t.Fatalf("EarnByRule() error = %v", err)
+	}
+	if _, err := service.EarnByRule(ctx, "u1", "ORDER", "order-2", 1000); !errors.Is(err, ErrCreditLimitExceeded) {
+		t.Fatalf("EarnByRule() error = %v, want %v", err, ErrCreditLimitExceeded)
+	}
+	// 撤销的赚取不占用赚取的上限
+	if _, err := service.Reverse(ctx, "u1", "ORDER", "order-1"); err != nil {
+		t.Fatalf("Reverse() error = %v", err)
+	}
+	if _, err := service.EarnByRule(ctx, "u1", "ORDER", "order-2", 1000); err != nil {
+		t.Fatalf("EarnByRule() after reverse error = %v", err)
+	}
+
+	if _, err := service.Redeem(ctx, "u1", "COUPON", "coupon-1", 1); err != nil {
+		t.Fatalf("Redeem() error = %v", err)
+	}
+	if _, err := service.Redeem(ctx, "u1", "COUPON", "coupon-2", 1); !errors.Is(err, ErrCreditLimitExceeded) {
+		t.Fatalf("Redeem() error = %v, want %v", err, ErrCreditLimitExceeded)
+	}
+	// 退还的消费不占用兑换的上限
+	if _, err := service.Refund(ctx, "u1", "COUPON", "coupon-1"); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	if _, err := service.Redeem(ctx, "u1", "COUPON", "coupon-2", 1); err != nil {
+		t.Errorf("Redeem() after refund error = %v", err)
+	}
+}
+
+func TestFileRuleProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	write := func(data string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ratio := func(provider *FileRuleProvider) int64 {
+		t.Helper()
+		rules, err := provider.Rules(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		rule, err := rules.EarnRule("ORDER", testNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mustEvaluate(t, rule, 100)
+	}
+
+	write("earn: [{channelId: ORDER, ratio: 0.1}]", testNow)
+	provider, err := NewFileRuleProvider(path)
+	if err != nil {
+		t.Fatalf("NewFileRuleProvider() error = %v", err)
+	}
+	if got := ratio(provider); got != 10 {
+		t.Errorf("Evaluate() got = %d, want 10", got)
+	}
+
+	// 文件修改之后重新加载，加载失败时继续使用之前的规则
+	write("earn: [{channelId: ORDER, ratio: 0.2}]", testNow.Add(time.Second))
+	if got := ratio(provider); got != 10 {
+		t.Errorf("Evaluate() before reload got = %d, want 10", got)
+	}
+	provider.reloadIfModified()
+	if got := ratio(provider); got != 20 {
+		t.Errorf("Evaluate() after reload got = %d, want 20", got)
+	}
+	write("earn: [{channelId: ORDER, ratio: -1}]", testNow.Add(2*time.Second))
+	provider.reloadIfModified()
+	if got := ratio(provider); got != 20 {
+		t.Errorf("Evaluate() after invalid reload got = %d, want 20", got)
+	}
+	if err := provider.Reload(); err == nil {
+		t.Errorf("Reload() error = nil")
+	}
+	// 加载失败的文件在再次修改之前不再重新加载
+	if provider.modified(testNow.Add(2 * time.Second)) {
+		t.Errorf("modified() of the failed file got = true")
+	}
+	write("earn: [{channelId: ORDER, ratio: 0.3}]", testNow.Add(3*time.Second))
+	provider.reloadIfModified()
+	if got := ratio(provider); got != 30 {
+		t.Errorf("Evaluate() after fixing the file got = %d, want 30", got)
+	}
+
+	// 后台协程定期检查规则文件
+	provider.Start(time.Millisecond)
+	provider.Start(time.Millisecond)
+	write("earn: [{channelId: ORDER, ratio: 0.4}]", testNow.Add(4*time.Second))
+	deadline := time.Now().Add(time.Second)
+	for ratio(provider) != 40 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	provider.Close()
+	provider.Close()
+	if got := ratio(provider); got != 40 {
+		t.Errorf("Evaluate() after periodic reload got = %d, want 40", got)
+	}
+
+	if _, err := NewFileRuleProvider(filepath.Join(t.TempDir(), "rules.txt")); err == nil {
+		t.Errorf("NewFileRuleProvider(.txt) error = nil")
+	}
+}
@@ -0,0 +1,565 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+// 积分系统业务比较简单，基于贫血模型的传统开发模式，分为 Controller、Service、Repository 三层，
+// 每层定义各自的数据对象 VO、BO、Entity。
+// 积分的增减都记录在积分明细中，总积分等统计数据通过积分明细计算，不单独保存。
+// 消费、过期、退还的积分按照分配关系记在赚取明细上，可用积分是没有过期的赚取明细剩余积分之和，见 credit-expiry.go。
+
+var (
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrInvalidCredit  = errors.New("invalid credit")
+	// ErrInsufficientCredit 可用积分不足
+	ErrInsufficientCredit = errors.New("insufficient credit")
+	// ErrEventConflict 相同渠道的相同事件已经用于了其他的积分操作
+	ErrEventConflict = errors.New("event used by another credit operation")
+)
+
+const (
+	// maxIdLength 与 user_id、channel_id、event_id 列的长度一致
+	maxIdLength = 64
+	// detailPageSize 积分明细默认一页的条数，一个用户的明细很多，maxDetailPageSize 限制一次查询的行数
+	detailPageSize    = 20
+	maxDetailPageSize = 100
+	// maxExpiringDays 查询即将过期的积分时最多查询的天数
+	maxExpiringDays = 365
+)
+
+type CreditController struct {
+	creditService *CreditService
+}
+
+func NewCreditController(creditService *CreditService) *CreditController {
+	return &CreditController{creditService: creditService}
+}
+
+// CreditDetailVo 积分明细，ExpiredTime 为空表示永不过期；Remaining 是赚取明细中还没有被消费或者过期的积分
+type CreditDetailVo struct {
+	Id          int64      `json:"id"`
+	UserId      string     `json:"userId"`
+	ChannelId   string     `json:"channelId"`
+	EventId     string     `json:"eventId"`
+	Credit      int64      `json:"credit"`
+	Remaining   int64      `json:"remaining"`
+	CreateTime  time.Time  `json:"createTime"`
+	ExpiredTime *time.Time `json:"expiredTime,omitempty"`
+}
+
+// CreditDetailPageVo 一页积分明细，NextCursor 为空表示没有下一页
+type CreditDetailPageVo struct {
+	Details    []*CreditDetailVo `json:"details"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
+
+// ExpiringCreditVo 即将过期的积分，Details 按照过期时间排序
+type ExpiringCreditVo struct {
+	Total   int64             `json:"total"`
+	Details []*CreditDetailVo `json:"details"`
+}
+
+// Earn 赚取积分，expiredTime 为零值时永不过期，返回积分明细 ID；相同渠道的相同事件重复调用时返回第一次的明细 ID
+func (c *CreditController) Earn(ctx context.Context, userId, channelId, eventId string, credit int64,
+	expiredTime time.Time) (int64, error) {
+	if err := validateIds(userId, channelId, eventId); err != nil {
+		return 0, err
+	}
+	return c.creditService.Earn(ctx, userId, channelId, eventId, credit, expiredTime)
+}
+
+// Consume 消费积分，返回积分明细 ID；相同渠道的相同事件重复调用时返回第一次的明细 ID
+func (c *CreditController) Consume(ctx context.Context, userId, channelId, eventId string, credit int64) (int64, error) {
+	if err := validateIds(userId, channelId, eventId); err != nil {
+		return 0, err
+	}
+	return c.creditService.Consume(ctx, userId, channelId, eventId, credit)
+}
+
+// EarnByRule 按照渠道的赚取规则把业务金额换算为积分并赚取，返回积分明细 ID
+func (c *CreditController) EarnByRule(ctx context.Context, userId, channelId, eventId string, amount int64) (int64, error) {
+	if err := validateIds(userId, channelId, eventId); err != nil {
+		return 0, err
+	}
+	return c.creditService.EarnByRule(ctx, userId, channelId, eventId, amount)
+}
+
+// EarnByRuleAt 按照事件发生时 eventTime 的赚取规则赚取积分，用于处理延迟送达或者重新消费的事件
+func (c *CreditController) EarnByRuleAt(ctx context.Context, userId, channelId, eventId string, amount int64,
+	eventTime time.Time) (int64, error) {
+	if err := validateIds(userId, channelId, eventId); err != nil {
+		return 0, err
+	}
+	return c.creditService.EarnByRuleAt(ctx, userId, channelId, eventId, amount, eventTime)
+}
+
+// Redeem 按照渠道的兑换规则消费兑换 amount 需要的积分，返回积分明细 ID
+func (c *CreditController) Redeem(ctx context.Context, userId, channelId, eventId string, amount int64) (int64, error) {
+	if err := validateIds(userId, channelId, eventId); err != nil {
+		return 0, err
+	}
+	return c.creditService.Redeem(ctx, userId, channelId, eventId, amount)
+}
+
+// Refund 退还渠道和事件对应的消费，返回退还明细的 ID；重复调用时返回第一次的明细 ID
+func (c *CreditController) Refund(ctx context.Context, userId, channelId, eventId string) (int64, error) {
+	if err := validateIds(userId, channelId, eventId); err != nil {
+		return 0, err
+	}
+	return c.creditService.Refund(ctx, userId, channelId, eventId)
+}
+
+// Reverse 撤销渠道和事件对应的赚取，返回撤销明细的 ID；重复调用时返回第一次的明细 ID
+func (c *CreditController) Reverse(ctx context.Context, userId, channelId, eventId string) (int64, error) {
+	if err := validateIds(userId, channelId, eventId); err != nil {
+		return 0, err
+	}
+	return c.creditService.Reverse(ctx, userId, channelId, eventId)
+}
+
+// GetTotalCredit 查询总可用积分
+func (c *CreditController) GetTotalCredit(ctx context.Context, userId string) (int64, error) {
+	if err := validateIds(userId); err != nil {
+		return 0, err
+	}
+	return c.creditService.GetTotalCredit(ctx, userId)
+}
+
+// GetExpiringCredits 查询未来 days 天内将要过期的积分，days 为 1 到 365
+func (c *CreditController) GetExpiringCredits(ctx context.Context, userId string, days int) (*ExpiringCreditVo, error) {
+	if err := validateIds(userId); err != nil {
+		return nil, err
+	}
+	if days <= 0 || days > maxExpiringDays {
+		return nil, fmt.Errorf("%w: days should be 1 to %d", ErrInvalidRequest, maxExpiringDays)
+	}
+	expiring, err := c.creditService.GetExpiringCredits(ctx, userId, days)
+	if err != nil {
+		return nil, err
+	}
+
+	expiringVo := &ExpiringCreditVo{Total: expiring.Total, Details: make([]*CreditDetailVo, 0, len(expiring.Details))}
+	for _, detailBo := range expiring.Details {
+		expiringVo.Details = append(expiringVo.Details, newCreditDetailVo(detailBo))
+	}
+	return expiringVo, nil
+}
+
+// GetDetails 查询总积分明细，cursor 是上一页返回的 NextCursor
+func (c *CreditController) GetDetails(ctx context.Context, userId, cursor string, pageSize int) (*CreditDetailPageVo, error) {
+	return c.getDetails(ctx, CreditDetailQuery{UserId: userId, Cursor: cursor, PageSize: pageSize})
+}
+
+// GetEarnDetails 查询赚取积分明细
+func (c *CreditController) GetEarnDetails(ctx context.Context, userId, cursor string, pageSize int) (*CreditDetailPageVo, error) {
+	return c.getDetails(ctx, CreditDetailQuery{UserId: userId, Type: EARN, Cursor: cursor, PageSize: pageSize})
+}
+
+// GetConsumeDetails 查询消费积分明细
+func (c *CreditController) GetConsumeDetails(ctx context.Context, userId, cursor string, pageSize int) (*CreditDetailPageVo, error) {
+	return c.getDetails(ctx, CreditDetailQuery{UserId: userId, Type: CONSUME, Cursor: cursor, PageSize: pageSize})
+}
+
+func (c *CreditController) getDetails(ctx context.Context, query CreditDetailQuery) (*CreditDetailPageVo, error) {
+	if err := validateIds(query.UserId); err != nil {
+		return nil, err
+	}
+	page, err := c.creditService.QueryDetails(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	pageVo := &CreditDetailPageVo{Details: make([]*CreditDetailVo, 0, len(page.Details)), NextCursor: page.NextCursor}
+	for _, detailBo := range page.Details {
+		pageVo.Details = append(pageVo.Details, newCreditDetailVo(detailBo))
+	}
+	return pageVo, nil
+}
+
+func newCreditDetailVo(detailBo *CreditDetailBo) *CreditDetailVo {
+	detailVo := &CreditDetailVo{Id: detailBo.Id, UserId: detailBo.UserId, ChannelId: detailBo.ChannelId,
+		EventId: detailBo.EventId, Credit: detailBo.Credit, Remaining: detailBo.Remaining, CreateTime: detailBo.CreateTime}
+	if !detailBo.ExpiredTime.IsZero() {
+		expiredTime := detailBo.ExpiredTime
+		detailVo.ExpiredTime = &expiredTime
+	}
+	return detailVo
+}
+
+// validateIds 用户、渠道、事件的 ID 不能为空，也不能超过列的长度
+func validateIds(ids ...string) error {
+	for _, id := range ids {
+		if id == "" || len(id) > maxIdLength {
+			return fmt.Errorf("%w: id should be 1 to %d characters", ErrInvalidRequest, maxIdLength)
+		}
+	}
+	return nil
+}
+
+//------------------------------
+// Service 和 BO 负责核心业务逻辑
+
+// CreditDetailType 积分明细的类型，用于查询时过滤；EARN 包含退还明细，CONSUME 包含过期和撤销明细
+const (
+	ALL = iota
+	EARN
+	CONSUME
+)
+
+// ExpireChannel、RefundChannel 和 ReverseChannel 是积分系统自己使用的渠道，不能用于赚取和消费
+const (
+	ExpireChannel  = "EXPIRE"
+	RefundChannel  = "REFUND"
+	ReverseChannel = "REVERSE"
+)
+
+// CreditDetailBo 积分明细，消费和过期的积分为负数
+type CreditDetailBo struct {
+	Id          int64
+	UserId      string
+	ChannelId   string
+	EventId     string
+	Credit      int64
+	Remaining   int64
+	CreateTime  time.Time
+	ExpiredTime time.Time
+}
+
+func newCreditDetailBo(detailEntity *CreditDetailEntity) *CreditDetailBo {
+	return &CreditDetailBo{Id: detailEntity.GetId(), UserId: detailEntity.GetUserId(),
+		ChannelId: detailEntity.GetChannelId(), EventId: detailEntity.GetEventId(), Credit: detailEntity.GetCredit(),
+		Remaining: detailEntity.GetRemaining(), CreateTime: detailEntity.GetCreateTime(), ExpiredTime: detailEntity.GetExpiredTime()}
+}
+
+// CreditDetailQuery 分页查询的条件，Type 为 ALL、EARN 或者 CONSUME
+type CreditDetailQuery struct {
+	UserId string
+	Type   int
+	Cursor string
+	// PageSize 默认 20，最大 100
+	PageSize int
+}
+
+// CreditDetailPage 按照明细 ID 倒序排列的一页积分明细
+type CreditDetailPage struct {
+	Details    []*CreditDetailBo
+	NextCursor string
+}
+
+type CreditService struct {
+	uow        UnitOfWork
+	detailRepo CreditDetailRepository
+	// rules 见 WithRuleProvider
+	rules RuleProvider
+	now   func() time.Time
+}
+
+func NewCreditService(uow UnitOfWork, detailRepo CreditDetailRepository, opts ...ServiceOption) *CreditService {
+	s := &CreditService{uow: uow, detailRepo: detailRepo, now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Earn 赚取积分，expiredTime 为零值时永不过期
+func (s *CreditService) Earn(ctx context.Context, userId, channelId, eventId string, credit int64,
+	expiredTime time.Time) (int64, error) {
+	if credit <= 0 {
+		return 0, fmt.Errorf("%w: earn %d", ErrInvalidCredit, credit)
+	}
+	if err := checkChannel(channelId); err != nil {
+		return 0, err
+	}
+	now := s.now()
+	if !expiredTime.IsZero() && !expiredTime.After(now) {
+		return 0, fmt.Errorf("%w: expired at %s", ErrInvalidCredit, expiredTime.Format(time.RFC3339))
+	}
+	return s.earn(ctx, userId, channelId, eventId, credit, expiredTime, now)
+}
+
+// earn 保存赚取明细，明细的时间是 createTime
+func (s *CreditService) earn(ctx context.Context, userId, channelId, eventId string, credit int64,
+	expiredTime, createTime time.Time) (int64, error) {
+	detailEntity := NewCreditDetailEntity()
+	detailEntity.SetUserId(userId)
+	detailEntity.SetChannelId(channelId)
+	detailEntity.SetEventId(eventId)
+	detailEntity.SetCredit(credit)
+	detailEntity.SetRemaining(credit)
+	detailEntity.SetCreateTime(createTime)
+	detailEntity.SetExpiredTime(expiredTime)
+	return s.save(ctx, detailEntity, nil)
+}
+
+// Consume 消费积分，优先消费最早过期的积分。
+// 可用积分的查询和消费明细的保存在同一个工作单元中，并发的消费不会超过可用积分
+func (s *CreditService) Consume(ctx context.Context, userId, channelId, eventId string, credit int64) (int64, error) {
+	if credit <= 0 {
+		return 0, fmt.Errorf("%w: consume %d", ErrInvalidCredit, credit)
+	}
+	if err := checkChannel(channelId); err != nil {
+		return 0, err
+	}
+
+	detailEntity := NewCreditDetailEntity()
+	detailEntity.SetUserId(userId)
+	detailEntity.SetChannelId(channelId)
+	detailEntity.SetEventId(eventId)
+	detailEntity.SetCredit(-credit)
+	detailEntity.SetCreateTime(s.now())
+	return s.save(ctx, detailEntity, func(ctx context.Context) ([]CreditAllocation, error) {
+		available, err := s.detailRepo.GetAvailableDetailsForUpdate(ctx, userId, detailEntity.GetCreateTime())
+		if err != nil {
+			return nil, err
+		}
+		return allocate(available, credit)
+	})
+}
+
+// checkChannel 系统使用的渠道不能用于赚取和消费
+func checkChannel(channelId string) error {
+	if channelId == ExpireChannel || channelId == RefundChannel || channelId == ReverseChannel {
+		return fmt.Errorf("%w: channel %s is reserved", ErrInvalidRequest, channelId)
+	}
+	return nil
+}
+
+// save 在工作单元中保存积分明细和 allocate 返回的分配关系；
+// 渠道和事件对应的明细已经存在时返回已有明细的 ID，不会重复增减积分
+func (s *CreditService) save(ctx context.Context, detailEntity *CreditDetailEntity,
+	allocate func(ctx context.Context) ([]CreditAllocation, error)) (int64, error) {
+	saved := detailEntity
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		existing, err := s.findEvent(ctx, detailEntity)
+		if err != nil || existing != nil {
+			saved = existing
+			return err
+		}
+		var allocations []CreditAllocation
+		if allocate != nil {
+			if allocations, err = allocate(ctx); err != nil {
+				return err
+			}
+		}
+		if err := s.detailRepo.SaveDetail(ctx, detailEntity); err != nil {
+			return err
+		}
+		if len(allocations) == 0 {
+			return nil
+		}
+		for i := range allocations {
+			allocations[i].DetailId = detailEntity.GetId()
+		}
+		return s.detailRepo.SaveAllocations(ctx, allocations)
+	})
+	if err != nil && !errors.Is(err, ErrInsufficientCredit) && !errors.Is(err, ErrEventConflict) {
+		// 并发的重复请求已经保存了明细，唯一索引导致保存失败
+		if existing, lookupErr := s.findEvent(ctx, detailEntity); existing != nil || lookupErr != nil {
+			saved, err = existing, lookupErr
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return saved.GetId(), nil
+}
+
+// findEvent 查找渠道和事件对应的明细，用户或者积分不一致时返回 ErrEventConflict
+func (s *CreditService) findEvent(ctx context.Context, detailEntity *CreditDetailEntity) (*CreditDetailEntity, error) {
+	existing, err := s.detailRepo.GetDetailByEvent(ctx, detailEntity.GetChannelId(), detailEntity.GetEventId())
+	if errors.Is(err, ErrDetailNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if existing.GetUserId() != detailEntity.GetUserId() || existing.GetCredit() != detailEntity.GetCredit() {
+		return nil, fmt.Errorf("%w: %s/%s", ErrEventConflict, detailEntity.GetChannelId(), detailEntity.GetEventId())
+	}
+	return existing, nil
+}
+
+// GetTotalCredit 总可用积分，不包含已经过期的积分，即使过期任务还没有处理
+func (s *CreditService) GetTotalCredit(ctx context.Context, userId string) (int64, error) {
+	return s.detailRepo.GetTotalCredit(ctx, userId, s.now())
+}
+
+// QueryDetails 按照明细 ID 倒序分页查询，使用游标分页，翻页期间有新的明细写入时不会出现重复或者遗漏
+func (s *CreditService) QueryDetails(ctx context.Context, query CreditDetailQuery) (*CreditDetailPage, error) {
+	if query.Type != ALL && query.Type != EARN && query.Type != CONSUME {
+		return nil, fmt.Errorf("%w: unknown detail type %d", ErrInvalidRequest, query.Type)
+	}
+	// 客户端修改过的游标返回 ErrInvalidRequest
+	beforeId, err := sqlstore.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor %q", ErrInvalidRequest, query.Cursor)
+	}
+	pageSize := query.PageSize
+	if pageSize <= 0 {
+		pageSize = detailPageSize
+	} else if pageSize > maxDetailPageSize {
+		pageSize = maxDetailPageSize
+	}
+
+	detailEntities, err := s.detailRepo.QueryDetails(ctx, CreditDetailFilter{UserId: query.UserId, Type: query.Type,
+		BeforeId: beforeId, Limit: pageSize + 1})
+	if err != nil {
+		return nil, err
+	}
+	page := &CreditDetailPage{}
+	if len(detailEntities) > pageSize {
+		detailEntities = detailEntities[:pageSize]
+		page.NextCursor = sqlstore.EncodeCursor(detailEntities[pageSize-1].GetId())
+	}
+	for _, detailEntity := range detailEntities {
+		page.Details = append(page.Details, newCreditDetailBo(detailEntity))
+	}
+	return page, nil
+}
+
+//------------------------------
+// Repository 和 Entity 负责数据存储
+
+type CreditDetailEntity struct {
+	id        int64
+	userId    string
+	channelId string
+	eventId   string
+	// credit 赚取和退还为正，消费和过期为负
+	credit int64
+	// remaining 赚取明细中还没有被消费或者过期的积分，其他明细为 0
+	remaining  int64
+	createTime time.Time
+	// expiredTime 为零值时永不过期，消费明细没有过期时间
+	expiredTime time.Time
+}
+
+func NewCreditDetailEntity() *CreditDetailEntity {
+	return &CreditDetailEntity{}
+}
+
+func (e *CreditDetailEntity) GetId() int64 {
+	return e.id
+}
+
+func (e *CreditDetailEntity) GetUserId() string {
+	return e.userId
+}
+
+func (e *CreditDetailEntity) SetUserId(userId string) {
+	e.userId = userId
+}
+
+func (e *CreditDetailEntity) GetChannelId() string {
+	return e.channelId
+}
+
+func (e *CreditDetailEntity) SetChannelId(channelId string) {
+	e.channelId = channelId
+}
+
+func (e *CreditDetailEntity) GetEventId() string {
+	return e.eventId
+}
+
+func (e *CreditDetailEntity) SetEventId(eventId string) {
+	e.eventId = eventId
+}
+
+func (e *CreditDetailEntity) GetCredit() int64 {
+	return e.credit
+}
+
+func (e *CreditDetailEntity) SetCredit(credit int64) {
+	e.credit = credit
+}
+
+func (e *CreditDetailEntity) GetRemaining() int64 {
+	return e.remaining
+}
+
+func (e *CreditDetailEntity) SetRemaining(remaining int64) {
+	e.remaining = remaining
+}
+
+func (e *CreditDetailEntity) GetCreateTime() time.Time {
+	return e.createTime
+}
+
+func (e *CreditDetailEntity) SetCreateTime(createTime time.Time) {
+	e.createTime = createTime
+}
+
+func (e *CreditDetailEntity) GetExpiredTime() time.Time {
+	return e.expiredTime
+}
+
+func (e *CreditDetailEntity) SetExpiredTime(expiredTime time.Time) {
+	e.expiredTime = expiredTime
+}
+
+// CreditDetailFilter 查询 UserId 的积分明细，BeforeId 为 0 表示从最新的明细开始
+type CreditDetailFilter struct {
+	UserId   string
+	Type     int
+	BeforeId int64
+	Limit    int
+}
+
+// matches MemoryStore 使用的过滤条件，SQLCreditDetailRepository.QueryDetails 用 WHERE 子句实现同样的条件：
+// 按照积分的正负区分 EARN 和 CONSUME，见 CreditDetailType
+func (f CreditDetailFilter) matches(entity *CreditDetailEntity) bool {
+	switch {
+	case entity.userId != f.UserId, f.BeforeId > 0 && entity.id >= f.BeforeId:
+		return false
+	case f.Type == EARN:
+		return entity.credit > 0
+	case f.Type == CONSUME:
+		return entity.credit < 0
+	default:
+		return true
+	}
+}
+
+// CreditAllocation 明细 DetailId 从赚取明细 EarnDetailId 中扣除的积分，退还时为负数
+type CreditAllocation struct {
+	DetailId     int64
+	EarnDetailId int64
+	Credit       int64
+}
+
+// CreditDetailRepository 积分明细的存储，实现见 SQLCreditDetailRepository。
+// 赚取明细在 now 时没有过期指的是过期时间为零值或者晚于 now
+type CreditDetailRepository interface {
+	// SaveDetail 保存积分明细并回填 ID，相同渠道的相同事件只能保存一次
+	SaveDetail(ctx context.Context, entity *CreditDetailEntity) error
+	// GetDetailByEvent 明细不存在时返回 ErrDetailNotFound
+	GetDetailByEvent(ctx context.Context, channelId, eventId string) (*CreditDetailEntity, error)
+	// GetDetailForUpdate 在工作单元中查询明细并锁住，明细不存在时返回 ErrDetailNotFound
+	GetDetailForUpdate(ctx context.Context, id int64) (*CreditDetailEntity, error)
+	// GetChannelCreditForUpdate 在工作单元中计算用户在渠道中创建时间在 [since, until) 的积分并锁住这些明细，
+	// 同时阻止其他事务插入用户在这个渠道的明细；since 或者 until 为零值时不限制开始或者结束时间。
+	// detailType 为 EARN 时返回赚取的积分，不包含已经撤销的赚取；为 CONSUME 时返回消费的积分（正数），不包含已经退还的消费
+	GetChannelCreditForUpdate(ctx context.Context, userId, channelId string, detailType int, since, until time.Time) (int64, error)
+	// GetTotalCredit 在 now 时没有过期的赚取明细的剩余积分之和
+	GetTotalCredit(ctx context.Context, userId string, now time.Time) (int64, error)
+	// GetAvailableDetailsForUpdate 在工作单元中查询 now 时没有过期、还有剩余积分的赚取明细并锁住，
+	// 按照过期时间排序，永不过期的排在最后
+	GetAvailableDetailsForUpdate(ctx context.Context, userId string, now time.Time) ([]*CreditDetailEntity, error)
+	// GetExpiringDetails 过期时间在 (now, until] 之间、还有剩余积分的赚取明细，按照过期时间排序
+	GetExpiringDetails(ctx context.Context, userId string, now, until time.Time) ([]*CreditDetailEntity, error)
+	// GetExpiredDetails 所有用户在 now 时已经过期、还有剩余积分的赚取明细，按照过期时间排序，最多 limit 条
+	GetExpiredDetails(ctx context.Context, now time.Time, limit int) ([]*CreditDetailEntity, error)
+	// SaveAllocations 保存分配关系，同时从赚取明细的剩余积分中扣除分配的积分
+	SaveAllocations(ctx context.Context, allocations []CreditAllocation) error
+	// GetAllocations 明细 detailId 的分配关系
+	GetAllocations(ctx context.Context, detailId int64) ([]CreditAllocation, error)
+	// QueryDetails 按照明细 ID 倒序返回最多 filter.Limit 条明细
+	QueryDetails(ctx context.Context, filter CreditDetailFilter) ([]*CreditDetailEntity, error)
+}
@@ -0,0 +1,159 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestController(t *testing.T) (*CreditController, *MemoryStore) {
+	t.Helper()
+	store := NewMemoryStore()
+	service := NewCreditService(store.UnitOfWork(), store.DetailRepository(),
+		WithClock(func() time.Time { return testNow }))
+	return NewCreditController(service), store
+}
+
+func TestCreditController_EarnAndConsume(t *testing.T) {
+	controller, _ := newTestController(t)
+	ctx := context.Background()
+	expiredTime := testNow.AddDate(1, 0, 0)
+
+	earnId, err := controller.Earn(ctx, "u1", "ORDER", "order-1", 100, expiredTime)
+	if err != nil {
+		t.Fatalf("Earn() error = %v", err)
+	}
+	// 重复的订单消息只增加一次积分
+	if id, err := controller.Earn(ctx, "u1", "ORDER", "order-1", 100, expiredTime); err != nil || id != earnId {
+		t.Errorf("Earn() again got = %d, %v, want %d", id, err, earnId)
+	}
+	if _, err := controller.Earn(ctx, "u1", "SIGN_IN", "2021-06-01", 5, time.Time{}); err != nil {
+		t.Fatalf("Earn() without expiry error = %v", err)
+	}
+	consumeId, err := controller.Consume(ctx, "u1", "COUPON", "coupon-1", 60)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if id, err := controller.Consume(ctx, "u1", "COUPON", "coupon-1", 60); err != nil || id != consumeId {
+		t.Errorf("Consume() again got = %d, %v, want %d", id, err, consumeId)
+	}
+	if _, err := controller.Consume(ctx, "u1", "COUPON", "coupon-2", 46); !errors.Is(err, ErrInsufficientCredit) {
+		t.Errorf("Consume() error = %v, want %v", err, ErrInsufficientCredit)
+	}
+	if total, err := controller.GetTotalCredit(ctx, "u1"); err != nil || total != 45 {
+		t.Errorf("GetTotalCredit() got = %d, %v, want 45", total, err)
+	}
+
+	tests := []struct {
+		name    string
+		call    func() (int64, error)
+		wantErr error
+	}{
+		{"empty user", func() (int64, error) { return controller.Earn(ctx, "", "ORDER", "order-2", 1, time.Time{}) }, ErrInvalidRequest},
+		{"zero credit", func() (int64, error) { return controller.Earn(ctx, "u1", "ORDER", "order-2", 0, time.Time{}) }, ErrInvalidCredit},
+		{"already expired", func() (int64, error) { return controller.Earn(ctx, "u1", "ORDER", "order-2", 1, testNow) }, ErrInvalidCredit},
+		{"negative consume", func() (int64, error) { return controller.Consume(ctx, "u1", "COUPON", "coupon-3", -1) }, ErrInvalidCredit},
+		{"event of another user", func() (int64, error) { return controller.Earn(ctx, "u2", "ORDER", "order-1", 100, expiredTime) },
+			ErrEventConflict},
+		{"event with another credit", func() (int64, error) { return controller.Consume(ctx, "u1", "COUPON", "coupon-1", 1) },
+			ErrEventConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.call(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreditController_GetDetails(t *testing.T) {
+	controller, _ := newTestController(t)
+	ctx := context.Background()
+	for _, eventId := range []string{"order-1", "order-2", "order-3"} {
+		if _, err := controller.Earn(ctx, "u1", "ORDER", eventId, 10, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := controller.Earn(ctx, "u2", "ORDER", "order-4", 10, testNow.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, eventId := range []string{"coupon-1", "coupon-2"} {
+		if _, err := controller.Consume(ctx, "u1", "COUPON", eventId, 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(get func(ctx context.Context, userId, cursor string, pageSize int) (*CreditDetailPageVo, error)) [][]int64 {
+		var pages [][]int64
+		cursor := ""
+		for {
+			page, err := get(ctx, "u1", cursor, 2)
+			if err != nil {
+				t.Fatalf("get details error = %v", err)
+			}
+			var pageIds []int64
+			for _, detail := range page.Details {
+				pageIds = append(pageIds, detail.Id)
+			}
+			pages = append(pages, pageIds)
+			if cursor = page.NextCursor; cursor == "" {
+				return pages
+			}
+		}
+	}
+	if got, want := ids(controller.GetDetails), [][]int64{{6, 5}, {3, 2}, {1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetDetails() got = %v, want %v", got, want)
+	}
+	if got, want := ids(controller.GetEarnDetails), [][]int64{{3, 2}, {1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetEarnDetails() got = %v, want %v", got, want)
+	}
+	if got, want := ids(controller.GetConsumeDetails), [][]int64{{6, 5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetConsumeDetails() got = %v, want %v", got, want)
+	}
+
+	page, err := controller.GetDetails(ctx, "u2", "", 0)
+	if err != nil || len(page.Details) != 1 || page.Details[0].Credit != 10 || page.Details[0].ExpiredTime == nil ||
+		!page.Details[0].ExpiredTime.Equal(testNow.Add(time.Hour)) {
+		t.Errorf("GetDetails(u2) got = %+v, %v", page, err)
+	}
+	if _, err := controller.GetDetails(ctx, "u1", "not a cursor", 2); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("GetDetails() with invalid cursor error = %v, want %v", err, ErrInvalidRequest)
+	}
+}
+
+func TestCreditService_ConsumeConcurrent(t *testing.T) {
+	controller, _ := newTestController(t)
+	ctx := context.Background()
+	if _, err := controller.Earn(ctx, "u1", "ORDER", "order-1", 100, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发消费时只有 10 次能成功，可用积分不会变成负数
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := controller.Consume(ctx, "u1", "COUPON", "coupon-"+string(rune('a'+i)), 10)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				succeeded++
+			} else if !errors.Is(err, ErrInsufficientCredit) {
+				t.Errorf("Consume() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if total, err := controller.GetTotalCredit(ctx, "u1"); succeeded != 10 || total != 0 || err != nil {
+		t.Errorf("succeeded %d, GetTotalCredit() got = %d, %v", succeeded, total, err)
+	}
+}
@@ -0,0 +1,252 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore 内存中的积分明细，用于单元测试和本地演示。
+// 工作单元持有整个存储的锁，相当于锁住了所有用户的积分明细；fn 返回错误时恢复到工作单元开始时的状态。
+type MemoryStore struct {
+	mu          sync.Mutex
+	details     []CreditDetailEntity
+	allocations []CreditAllocation
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) UnitOfWork() UnitOfWork {
+	return memoryUnitOfWork{s}
+}
+
+func (s *MemoryStore) DetailRepository() CreditDetailRepository {
+	return memoryDetailRepository{s}
+}
+
+type memoryTxKey struct{}
+
+// withLock 在工作单元中时已经持有锁，直接执行 fn，否则加锁后执行
+func (s *MemoryStore) withLock(ctx context.Context, fn func() error) error {
+	if store, ok := ctx.Value(memoryTxKey{}).(*MemoryStore); ok && store == s {
+		return fn()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn()
+}
+
+type memoryUnitOfWork struct {
+	store *MemoryStore
+}
+
+func (u memoryUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if store, ok := ctx.Value(memoryTxKey{}).(*MemoryStore); ok && store == u.store {
+		return fn(ctx)
+	}
+
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+
+	// 分配关系只会追加，复制切片的头部即可；明细的剩余积分会被修改，需要复制所有明细
+	details := append([]CreditDetailEntity(nil), u.store.details...)
+	allocations := u.store.allocations[:len(u.store.allocations):len(u.store.allocations)]
+	defer func() {
+		if p := recover(); p != nil {
+			u.store.details, u.store.allocations = details, allocations
+			panic(p)
+		}
+		if err != nil {
+			u.store.details, u.store.allocations = details, allocations
+		}
+	}()
+	return fn(context.WithValue(ctx, memoryTxKey{}, u.store))
+}
+
+type memoryDetailRepository struct {
+	store *MemoryStore
+}
+
+func (r memoryDetailRepository) SaveDetail(ctx context.Context, entity *CreditDetailEntity) error {
+	return r.store.withLock(ctx, func() error {
+		for _, detail := range r.store.details {
+			if detail.channelId == entity.channelId && detail.eventId == entity.eventId {
+				return fmt.Errorf("duplicate event %s/%s", entity.channelId, entity.eventId)
+			}
+		}
+		entity.id = int64(len(r.store.details) + 1)
+		r.store.details = append(r.store.details, *entity)
+		return nil
+	})
+}
+
+func (r memoryDetailRepository) GetDetailByEvent(ctx context.Context, channelId, eventId string) (*CreditDetailEntity, error) {
+	var found *CreditDetailEntity
+	err := r.store.withLock(ctx, func() error {
+		for _, detail := range r.store.details {
+			if detail.channelId == channelId && detail.eventId == eventId {
+				found = &detail
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s/%s", ErrDetailNotFound, channelId, eventId)
+	})
+	return found, err
+}
+
+func (r memoryDetailRepository) GetDetailForUpdate(ctx context.Context, id int64) (*CreditDetailEntity, error) {
+	var found *CreditDetailEntity
+	err := r.store.withLock(ctx, func() error {
+		if id <= 0 || id > int64(len(r.store.details)) {
+			return fmt.Errorf("%w: %d", ErrDetailNotFound, id)
+		}
+		detail := r.store.details[id-1]
+		found = &detail
+		return nil
+	})
+	return found, err
+}
+
+func (r memoryDetailRepository) GetTotalCredit(ctx context.Context, userId string, now time.Time) (int64, error) {
+	var total int64
+	err := r.store.withLock(ctx, func() error {
+		for _, detail := range r.filter(func(detail *CreditDetailEntity) bool {
+			return detail.userId == userId && detail.remaining > 0 && !expired(detail, now)
+		}) {
+			total += detail.remaining
+		}
+		return nil
+	})
+	return total, err
+}
+
+func (r memoryDetailRepository) GetChannelCreditForUpdate(ctx context.Context, userId, channelId string,
+	detailType int, since, until time.Time) (int64, error) {
+	sign, undoChannel := int64(1), ReverseChannel
+	switch detailType {
+	case EARN:
+	case CONSUME:
+		sign, undoChannel = -1, RefundChannel
+	default:
+		return 0, fmt.Errorf("%w: detail type %d", ErrInvalidRequest, detailType)
+	}
+
+	var total int64
+	err := r.store.withLock(ctx, func() error {
+		undone := make(map[string]bool)
+		for _, detail := range r.store.details {
+			if detail.channelId == undoChannel {
+				undone[detail.eventId] = true
+			}
+		}
+		for _, detail := range r.store.details {
+			if detail.userId == userId && detail.channelId == channelId && detail.credit*sign > 0 &&
+				!undone[strconv.FormatInt(detail.id, 10)] && !detail.createTime.Before(since) &&
+				(until.IsZero() || detail.createTime.Before(until)) {
+				total += detail.credit * sign
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+func (r memoryDetailRepository) GetAvailableDetailsForUpdate(ctx context.Context, userId string,
+	now time.Time) ([]*CreditDetailEntity, error) {
+	var found []*CreditDetailEntity
+	err := r.store.withLock(ctx, func() error {
+		found = r.filter(func(detail *CreditDetailEntity) bool {
+			return detail.userId == userId && detail.remaining > 0 && !expired(detail, now)
+		})
+		return nil
+	})
+	return found, err
+}
+
+func (r memoryDetailRepository) GetExpiringDetails(ctx context.Context, userId string,
+	now, until time.Time) ([]*CreditDetailEntity, error) {
+	var found []*CreditDetailEntity
+	err := r.store.withLock(ctx, func() error {
+		found = r.filter(func(detail *CreditDetailEntity) bool {
+			return detail.userId == userId && detail.remaining > 0 && !expired(detail, now) &&
+				!detail.expiredTime.IsZero() && !detail.expiredTime.After(until)
+		})
+		return nil
+	})
+	return found, err
+}
+
+func (r memoryDetailRepository) GetExpiredDetails(ctx context.Context, now time.Time, limit int) ([]*CreditDetailEntity, error) {
+	var found []*CreditDetailEntity
+	err := r.store.withLock(ctx, func() error {
+		found = r.filter(func(detail *CreditDetailEntity) bool {
+			return detail.remaining > 0 && expired(detail, now)
+		})
+		if len(found) > limit {
+			found = found[:limit]
+		}
+		return nil
+	})
+	return found, err
+}
+
+// filter 返回 matches 的明细的副本，与 SQL 一样按照过期时间排序，永不过期的排在最后；调用时需要持有锁
+func (r memoryDetailRepository) filter(matches func(detail *CreditDetailEntity) bool) []*CreditDetailEntity {
+	var found []*CreditDetailEntity
+	for _, detail := range r.store.details {
+		if detail := detail; matches(&detail) {
+			found = append(found, &detail)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].expiredTime.IsZero() || found[j].expiredTime.IsZero() {
+			return !found[i].expiredTime.IsZero() && found[j].expiredTime.IsZero()
+		}
+		return found[i].expiredTime.Before(found[j].expiredTime)
+	})
+	return found
+}
+
+func (r memoryDetailRepository) SaveAllocations(ctx context.Context, allocations []CreditAllocation) error {
+	return r.store.withLock(ctx, func() error {
+		for _, allocation := range allocations {
+			if allocation.EarnDetailId <= 0 || allocation.EarnDetailId > int64(len(r.store.details)) {
+				return fmt.Errorf("%w: %d", ErrDetailNotFound, allocation.EarnDetailId)
+			}
+			r.store.details[allocation.EarnDetailId-1].remaining -= allocation.Credit
+		}
+		r.store.allocations = append(r.store.allocations, allocations...)
+		return nil
+	})
+}
+
+func (r memoryDetailRepository) GetAllocations(ctx context.Context, detailId int64) ([]CreditAllocation, error) {
+	var found []CreditAllocation
+	err := r.store.withLock(ctx, func() error {
+		for _, allocation := range r.store.allocations {
+			if allocation.DetailId == detailId {
+				found = append(found, allocation)
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r memoryDetailRepository) QueryDetails(ctx context.Context, filter CreditDetailFilter) ([]*CreditDetailEntity, error) {
+	var found []*CreditDetailEntity
+	err := r.store.withLock(ctx, func() error {
+		for i := len(r.store.details) - 1; i >= 0 && len(found) < filter.Limit; i-- {
+			if detail := r.store.details[i]; filter.matches(&detail) {
+				found = append(found, &detail)
+			}
+		}
+		return nil
+	})
+	return found, err
+}
@@ -0,0 +1,51 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+// creditMigrations 按顺序执行的建表语句（MySQL），已经发布的语句不能修改，表结构变更时在末尾追加。
+// 执行过的版本记录在 credit_schema_migrations 表中，与钱包共用一个数据库时版本号互不影响。
+// MySQL 的 DDL 不能回滚，执行到一半退出时需要人工处理，见 sqlstore.Migrate。
+var creditMigrations = []string{
+	// 积分明细：赚取为正，消费为负；expired_time 为 NULL 表示永不过期，
+	// 相同渠道的相同事件（如同一个订单）只能增减一次积分
+	`CREATE TABLE IF NOT EXISTS credit_detail (
+		id           BIGINT      NOT NULL AUTO_INCREMENT,
+		user_id      VARCHAR(64) NOT NULL,
+		channel_id   VARCHAR(64) NOT NULL,
+		event_id     VARCHAR(64) NOT NULL,
+		credit       BIGINT      NOT NULL,
+		create_time  TIMESTAMP   NOT NULL,
+		expired_time TIMESTAMP   NULL,
+		PRIMARY KEY (id),
+		UNIQUE KEY uk_event (channel_id, event_id),
+		KEY idx_user (user_id, id)
+	)`,
+	// remaining 赚取明细中还没有被消费或者过期的积分，其他明细为 0；可用积分是没有过期的赚取明细的 remaining 之和。
+	// 积分系统还没有上线，不需要为历史的消费明细回填分配关系
+	`ALTER TABLE credit_detail
+		ADD COLUMN remaining BIGINT NOT NULL DEFAULT 0,
+		ADD KEY idx_user_expired (user_id, expired_time),
+		ADD KEY idx_expired (expired_time)`,
+	// 分配关系：消费、过期、退还明细 detail_id 从赚取明细 earn_detail_id 中扣除的积分，退还时为负数
+	`CREATE TABLE IF NOT EXISTS credit_allocation (
+		id             BIGINT NOT NULL AUTO_INCREMENT,
+		detail_id      BIGINT NOT NULL,
+		earn_detail_id BIGINT NOT NULL,
+		credit         BIGINT NOT NULL,
+		PRIMARY KEY (id),
+		KEY idx_detail (detail_id),
+		KEY idx_earn_detail (earn_detail_id)
+	)`,
+	// 积分规则的每日上限和每个用户的上限按照用户和渠道统计
+	`ALTER TABLE credit_detail ADD KEY idx_user_channel (user_id, channel_id, create_time)`,
+}
+
+// Migrate 执行还没有执行过的建表语句，返回本次执行的语句数量
+func Migrate(ctx context.Context, db *sql.DB) (int, error) {
+	return sqlstore.Migrate(ctx, db, "credit_schema_migrations", creditMigrations)
+}
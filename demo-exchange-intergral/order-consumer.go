@@ -0,0 +1,291 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 订单系统在订单交易成功和退款时发布消息，积分系统订阅这些消息：交易成功时按照规则赚取积分，退款时撤销这笔订单赚取的积分。
+// OrderEventConsumer 作为消费组的成员消费消息，一条消息处理完成或者发送到死信队列之后才标记它的 offset，
+// 重启或者分区重新分配之后从最后提交的位置继续，只会重复处理还没有提交的消息：
+//   - 订单 ID 作为积分明细的 event_id，(channel_id, event_id) 的唯一索引保证赚取了积分的订单不会重复赚取；
+//   - 规则、每日上限和过期时间都按照订单交易成功的时间 EventTime 计算，没有赚取积分的订单重复处理时仍然按照原来的时间计算。
+// 处理失败的消息重试之后发送到死信队列，由人工处理后重新发布；死信也发送失败时停止消费，offset 不会越过这条消息。
+
+// OrderEvent 的类型
+const (
+	OrderSucceeded = "ORDER_SUCCEEDED"
+	OrderRefunded  = "ORDER_REFUNDED"
+)
+
+const (
+	defaultOrderChannel       = "ORDER"
+	defaultOrderMaxRetries    = 3
+	defaultOrderRetryInterval = 100 * time.Millisecond
+)
+
+// OrderEvent 订单消息，Amount 是订单金额（分），退款消息不需要
+type OrderEvent struct {
+	Type      string    `json:"type"`
+	OrderId   string    `json:"orderId"`
+	UserId    string    `json:"userId"`
+	Amount    int64     `json:"amount"`
+	EventTime time.Time `json:"eventTime"`
+}
+
+// OrderConsumerConfig 订单消息的消费配置，零值的字段使用默认值
+type OrderConsumerConfig struct {
+	Topic string
+	// DeadLetterTopic 死信队列，消息头中记录原来的位置和失败的原因
+	DeadLetterTopic string
+	// ChannelId 订单赚取积分的渠道，默认 ORDER
+	ChannelId string
+	// MaxRetries 处理失败或者发送死信失败之后的重试次数，默认 3 次，第 n 次重试前等待 n 倍的 RetryInterval，默认 100ms
+	MaxRetries    int
+	RetryInterval time.Duration
+}
+
+// NewOrderConsumerGroupConfig OrderEventConsumer 使用的消费组配置：
+// 消费组第一次消费时从最早的消息开始，之后从提交的 offset 开始；标记的 offset 定时自动提交，会话结束时也会提交。
+func NewOrderConsumerGroupConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_11_0_0
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Offsets.AutoCommit.Enable = true
+	return config
+}
+
+var _ sarama.ConsumerGroupHandler = (*OrderEventConsumer)(nil)
+
+// OrderEventConsumer 实现 sarama.ConsumerGroupHandler，每个分区按照顺序处理。
+// 订单消息以订单 ID 作为 key，同一个订单的交易成功和退款消息在同一个分区中，退款总是在交易成功之后处理
+type OrderEventConsumer struct {
+	group      sarama.ConsumerGroup
+	producer   sarama.SyncProducer
+	controller *CreditController
+	config     OrderConsumerConfig
+
+	mu          sync.Mutex
+	processed   int64
+	deadLetters int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewOrderEventConsumer group 建议使用 NewOrderConsumerGroupConfig 创建；
+// producer 用于发送死信，它的 Config.Version 至少为 sarama.V0_11_0_0 才能发送消息头
+func NewOrderEventConsumer(group sarama.ConsumerGroup, producer sarama.SyncProducer, controller *CreditController,
+	config OrderConsumerConfig) *OrderEventConsumer {
+	if config.ChannelId == "" {
+		config.ChannelId = defaultOrderChannel
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = defaultOrderMaxRetries
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultOrderRetryInterval
+	}
+	return &OrderEventConsumer{group: group, producer: producer, controller: controller, config: config}
+}
+
+// Start 在后台加入消费组消费 topic。会话因为分区重新分配或者死信发送失败而结束时，等待 RetryInterval 之后重新加入
+func (c *OrderEventConsumer) Start() error {
+	if c.done != nil {
+		return errors.New("order event consumer already started")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel, c.done = cancel, make(chan struct{})
+	go c.run(ctx, c.done)
+	return nil
+}
+
+func (c *OrderEventConsumer) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for {
+		err := c.group.Consume(ctx, []string{c.config.Topic}, c)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			return
+		}
+		if err != nil {
+			log.Println("consume order events:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.config.RetryInterval):
+		}
+	}
+}
+
+// Stop 离开消费组，等待正在处理的消息完成；处理到一半的消息不标记 offset，下次重新处理。
+// 消费组由调用方关闭
+func (c *OrderEventConsumer) Stop() {
+	if c.done == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+	c.cancel, c.done = nil, nil
+}
+
+// Processed 返回处理成功的消息数，包括重复的消息
+func (c *OrderEventConsumer) Processed() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.processed
+}
+
+// DeadLetters 返回发送到死信队列的消息数
+func (c *OrderEventConsumer) DeadLetters() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.deadLetters
+}
+
+func (c *OrderEventConsumer) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *OrderEventConsumer) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim 按照顺序处理一个分区的消息，处理完成或者发送到死信队列之后标记 offset。
+// 死信发送失败时返回错误，sarama 会结束整个会话，这条消息在下一个会话中重新处理
+func (c *OrderEventConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := c.handle(ctx, msg); err != nil {
+				return err
+			}
+			session.MarkMessage(msg, "")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// handle 返回 nil 时消息已经处理完成或者发送到了死信队列
+func (c *OrderEventConsumer) handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var event OrderEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		return c.deadLetter(ctx, msg, err)
+	}
+
+	err := c.process(ctx, &event)
+	for retry := 1; err != nil && retryable(err) && retry <= c.config.MaxRetries; retry++ {
+		if waitErr := c.wait(ctx, retry); waitErr != nil {
+			return waitErr
+		}
+		err = c.process(ctx, &event)
+	}
+	if ctx.Err() != nil {
+		// 会话结束时中断的处理不是消息的问题，不发送到死信队列
+		return ctx.Err()
+	}
+	if err != nil {
+		return c.deadLetter(ctx, msg, err)
+	}
+
+	c.mu.Lock()
+	c.processed++
+	c.mu.Unlock()
+	return nil
+}
+
+// wait 第 retry 次重试之前等待，会话结束时返回 ctx 的错误
+func (c *OrderEventConsumer) wait(ctx context.Context, retry int) error {
+	timer := time.NewTimer(time.Duration(retry) * c.config.RetryInterval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *OrderEventConsumer) process(ctx context.Context, event *OrderEvent) error {
+	switch event.Type {
+	case OrderSucceeded:
+		_, err := c.controller.EarnByRuleAt(ctx, event.UserId, c.config.ChannelId, event.OrderId, event.Amount,
+			event.EventTime)
+		if errors.Is(err, ErrCreditLimitExceeded) || errors.Is(err, ErrNoCreditEarned) {
+			// 达到规则的上限、金额太小或者积分已经过期，这笔订单不赚取积分
+			return nil
+		}
+		return err
+	case OrderRefunded:
+		_, err := c.controller.Reverse(ctx, event.UserId, c.config.ChannelId, event.OrderId)
+		if errors.Is(err, ErrDetailNotFound) {
+			// 订单没有赚取积分，没有需要撤销的积分
+			return nil
+		}
+		return err
+	default:
+		return fmt.Errorf("%w: unknown order event type %q", ErrInvalidRequest, event.Type)
+	}
+}
+
+// retryable 业务规则导致的失败重试也不会成功，直接发送到死信队列，其他的失败（如数据库不可用）可以重试
+func retryable(err error) bool {
+	for _, target := range []error{ErrInvalidRequest, ErrInvalidCredit, ErrEventConflict, ErrDetailNotFound,
+		ErrRuleNotFound, ErrRulesUnavailable} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+	return true
+}
+
+// deadLetter 把消息原样发送到死信队列，发送失败时和处理失败一样重试，重试之后仍然失败时返回错误，不会丢弃消息
+func (c *OrderEventConsumer) deadLetter(ctx context.Context, msg *sarama.ConsumerMessage, cause error) error {
+	deadLetter := &sarama.ProducerMessage{
+		Topic: c.config.DeadLetterTopic,
+		Value: sarama.ByteEncoder(msg.Value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("original-topic"), Value: []byte(msg.Topic)},
+			{Key: []byte("original-partition"), Value: []byte(strconv.FormatInt(int64(msg.Partition), 10))},
+			{Key: []byte("original-offset"), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+			{Key: []byte("error"), Value: []byte(cause.Error())},
+		},
+	}
+	if msg.Key != nil {
+		deadLetter.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	_, _, err := c.producer.SendMessage(deadLetter)
+	for retry := 1; err != nil && retry <= c.config.MaxRetries; retry++ {
+		log.Printf("dead letter %s/%d/%d: %v, retry %d", msg.Topic, msg.Partition, msg.Offset, err, retry)
+		if waitErr := c.wait(ctx, retry); waitErr != nil {
+			return waitErr
+		}
+		_, _, err = c.producer.SendMessage(deadLetter)
+	}
+	if err != nil {
+		return fmt.Errorf("dead letter %s/%d/%d: %w, cause: %v", msg.Topic, msg.Partition, msg.Offset, err, cause)
+	}
+	log.Printf("dead letter %s/%d/%d: %v", msg.Topic, msg.Partition, msg.Offset, cause)
+
+	c.mu.Lock()
+	c.deadLetters++
+	c.mu.Unlock()
+	return nil
+}
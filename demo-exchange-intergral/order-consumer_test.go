@@ -0,0 +1,238 @@
+package demo_exchange_intergral
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// flakyDetailRepository 保存 failures 中的事件时失败，每失败一次计数减一
+type flakyDetailRepository struct {
+	CreditDetailRepository
+	failures map[string]int
+}
+
+func (r *flakyDetailRepository) SaveDetail(ctx context.Context, entity *CreditDetailEntity) error {
+	if r.failures[entity.GetEventId()] > 0 {
+		r.failures[entity.GetEventId()]--
+		return errors.New("connection reset by peer")
+	}
+	return r.CreditDetailRepository.SaveDetail(ctx, entity)
+}
+
+// recordingProducer 记录发送成功的消息，failures 大于 0 时发送失败，每失败一次计数减一
+type recordingProducer struct {
+	sarama.SyncProducer
+	failures int
+	messages []*sarama.ProducerMessage
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if p.failures > 0 {
+		p.failures--
+		return 0, 0, errors.New("kafka: not enough in-sync replicas")
+	}
+	p.messages = append(p.messages, msg)
+	return p.SyncProducer.SendMessage(msg)
+}
+
+// fakeConsumerGroup 只有一个分区的消费组，每个会话从提交的 offset 开始消费，标记的 offset 立即提交
+type fakeConsumerGroup struct {
+	sarama.ConsumerGroup
+	topic    string
+	messages []*sarama.ConsumerMessage
+
+	mu        sync.Mutex
+	committed int64
+	sessions  int
+}
+
+func newFakeConsumerGroup(topic string, messages ...*sarama.ConsumerMessage) *fakeConsumerGroup {
+	for i, msg := range messages {
+		msg.Topic, msg.Offset = topic, int64(i)
+	}
+	return &fakeConsumerGroup{topic: topic, messages: messages}
+}
+
+func (g *fakeConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	g.mu.Lock()
+	g.sessions++
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, len(g.messages))}
+	for _, msg := range g.messages[g.committed:] {
+		claim.messages <- msg
+	}
+	g.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	session := &fakeSession{ctx: ctx, group: g}
+	if err := handler.Setup(session); err != nil {
+		return err
+	}
+	err := handler.ConsumeClaim(session, claim)
+	if cleanupErr := handler.Cleanup(session); err == nil {
+		err = cleanupErr
+	}
+	return err
+}
+
+func (g *fakeConsumerGroup) Committed() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.committed
+}
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx   context.Context
+	group *fakeConsumerGroup
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.group.mu.Lock()
+	defer s.group.mu.Unlock()
+	s.group.committed = msg.Offset + 1
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func orderMessage(t *testing.T, event OrderEvent) *sarama.ConsumerMessage {
+	t.Helper()
+	value, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sarama.ConsumerMessage{Key: []byte(event.OrderId), Value: value}
+}
+
+// consumeAll 启动 consumer 直到提交了所有的消息
+func consumeAll(t *testing.T, consumer *OrderEventConsumer, group *fakeConsumerGroup) {
+	t.Helper()
+	if err := consumer.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer consumer.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for group.Committed() < int64(len(group.messages)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("committed offset got = %d, want %d", group.Committed(), len(group.messages))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOrderEventConsumer(t *testing.T) {
+	rules, err := NewCreditRules(time.UTC, []CreditRule{{ChannelId: "ORDER", Ratio: "0.1", DailyLimit: 150,
+		ValidDays: 30}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	// order-1 失败一次后重试成功，order-3 一直失败；u1 当天赚取 order-1 和 order-2 的 150 积分之后达到每日上限
+	detailRepo := &flakyDetailRepository{CreditDetailRepository: store.DetailRepository(),
+		failures: map[string]int{"order-1": 1, "order-3": 100}}
+	controller := NewCreditController(NewCreditService(store.UnitOfWork(), detailRepo, WithRuleProvider(rules),
+		WithClock(func() time.Time { return testNow })))
+
+	group := newFakeConsumerGroup("order-events",
+		orderMessage(t, OrderEvent{Type: OrderSucceeded, OrderId: "order-1", UserId: "u1", Amount: 1000}),
+		orderMessage(t, OrderEvent{Type: OrderSucceeded, OrderId: "order-1", UserId: "u1", Amount: 1000}),
+		&sarama.ConsumerMessage{Value: []byte("garbage")},
+		orderMessage(t, OrderEvent{Type: OrderSucceeded, OrderId: "order-2", UserId: "u1", Amount: 500}),
+		orderMessage(t, OrderEvent{Type: OrderSucceeded, OrderId: "order-3", UserId: "u2", Amount: 800}),
+		orderMessage(t, OrderEvent{Type: OrderRefunded, OrderId: "order-2", UserId: "u1"}),
+		orderMessage(t, OrderEvent{Type: OrderRefunded, OrderId: "order-9", UserId: "u1"}),
+		// order-4 达到每日上限、order-5 金额太小，都不赚取积分，它们的退款也不需要撤销积分
+		orderMessage(t, OrderEvent{Type: OrderSucceeded, OrderId: "order-4", UserId: "u1", Amount: 1000}),
+		orderMessage(t, OrderEvent{Type: OrderSucceeded, OrderId: "order-5", UserId: "u1", Amount: 5}),
+		orderMessage(t, OrderEvent{Type: OrderRefunded, OrderId: "order-4", UserId: "u1"}),
+		orderMessage(t, OrderEvent{Type: OrderRefunded, OrderId: "order-5", UserId: "u1"}),
+		orderMessage(t, OrderEvent{Type: "ORDER_SHIPPED", OrderId: "order-1", UserId: "u1"}),
+		// 按照订单的时间计算：前一天的订单不受当天上限的限制，40 天前的订单赚取的积分已经过期
+		orderMessage(t, OrderEvent{Type: OrderSucceeded, OrderId: "order-6", UserId: "u1", Amount: 1000,
+			EventTime: testNow.AddDate(0, 0, -1)}),
+		orderMessage(t, OrderEvent{Type: OrderSucceeded, OrderId: "order-7", UserId: "u1", Amount: 1000,
+			EventTime: testNow.AddDate(0, 0, -40)}),
+	)
+
+	mock := mocks.NewSyncProducer(t, nil)
+	for _, want := range []string{"garbage", "order-3", "ORDER_SHIPPED"} {
+		want := want
+		mock.ExpectSendMessageWithCheckerFunctionAndSucceed(func(value []byte) error {
+			if !strings.Contains(string(value), want) {
+				return fmt.Errorf("dead letter got = %s, want %s", value, want)
+			}
+			return nil
+		})
+	}
+	// 第一条死信发送失败超过重试次数，会话结束，下一个会话从这条消息重新开始
+	producer := &recordingProducer{SyncProducer: mock, failures: defaultOrderMaxRetries + 1}
+
+	config := OrderConsumerConfig{Topic: "order-events", DeadLetterTopic: "order-events-dlq", RetryInterval: time.Millisecond}
+	orderConsumer := NewOrderEventConsumer(group, producer, controller, config)
+	consumeAll(t, orderConsumer, group)
+
+	if got := orderConsumer.Processed(); got != 11 {
+		t.Errorf("Processed() got = %d, want 11", got)
+	}
+	if got := orderConsumer.DeadLetters(); got != 3 {
+		t.Errorf("DeadLetters() got = %d, want 3", got)
+	}
+	if group.sessions < 2 {
+		t.Errorf("sessions got = %d, want at least 2", group.sessions)
+	}
+	// order-1 和 order-6 各赚取 100 积分，重复的消息不会重复赚取；order-2 赚取的 50 积分已经撤销；
+	// 没有赚取积分的 order-9 的退款直接跳过
+	if total, err := controller.GetTotalCredit(context.Background(), "u1"); total != 200 || err != nil {
+		t.Errorf("GetTotalCredit() got = %d, %v, want 200", total, err)
+	}
+	if detailRepo.failures["order-1"] != 0 || detailRepo.failures["order-3"] != 100-1-defaultOrderMaxRetries {
+		t.Errorf("failures got = %v", detailRepo.failures)
+	}
+
+	deadLetter := producer.messages[1]
+	headers := make(map[string]string)
+	for _, header := range deadLetter.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	if key, _ := deadLetter.Key.Encode(); deadLetter.Topic != "order-events-dlq" || string(key) != "order-3" ||
+		headers["original-topic"] != "order-events" || headers["original-offset"] != "4" ||
+		!strings.Contains(headers["error"], "connection reset by peer") {
+		t.Errorf("dead letter got = %+v, headers %v", deadLetter, headers)
+	}
+	if producer.messages[0].Key != nil {
+		t.Errorf("dead letter of message without key got key %v", producer.messages[0].Key)
+	}
+
+	// 重启之后从提交的 offset 继续，已经处理的消息不会重新处理，死信也不会重新发送
+	restarted := NewOrderEventConsumer(group, producer, controller, config)
+	if err := restarted.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	restarted.Stop()
+	if err := mock.Close(); err != nil {
+		t.Fatalf("producer.Close() error = %v", err)
+	}
+	if got := restarted.Processed() + restarted.DeadLetters(); got != 0 {
+		t.Errorf("messages handled after restart got = %d, want 0", got)
+	}
+}
@@ -0,0 +1,18 @@
+package demo_exchange_intergral
+
+import (
+	"database/sql"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+// UnitOfWork 工作单元，保证一次业务操作中的多次数据读写（如查询可用积分和保存消费明细）要么全部提交，要么全部回滚，
+// 见 sqlstore.UnitOfWork
+type UnitOfWork = sqlstore.UnitOfWork
+
+// SQLUnitOfWork 基于数据库事务的工作单元
+type SQLUnitOfWork = sqlstore.SQLUnitOfWork
+
+func NewSQLUnitOfWork(db *sql.DB) *SQLUnitOfWork {
+	return sqlstore.NewSQLUnitOfWork(db)
+}
@@ -0,0 +1,98 @@
+package demo_id_generator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Base62Generator 生成用于 URL 的短 ID：把递增的数字 ID（默认由 SnowflakeGenerator 生成）
+// 编码为 11 个由数字和大小写字母组成的字符。
+// 字母表按 ASCII 码排序，并且左侧补 0 到固定长度，所以编码后的字符串仍然按数字 ID 的大小排序。
+
+const (
+	base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// base62Length 62^11 > 2^63，11 个字符足够表示所有的 int64
+	base62Length = 11
+)
+
+// EncodeBase62 把非负整数编码为固定长度的 base62 字符串
+func EncodeBase62(n int64) string {
+	var buf [base62Length]byte
+	u := uint64(n)
+	for i := base62Length - 1; i >= 0; i-- {
+		buf[i] = base62Alphabet[u%62]
+		u /= 62
+	}
+	return string(buf[:])
+}
+
+// DecodeBase62 解码 EncodeBase62 生成的字符串
+func DecodeBase62(s string) (int64, error) {
+	if len(s) != base62Length {
+		return 0, fmt.Errorf("%w: base62 id %q should have %d characters", ErrInvalidId, s, base62Length)
+	}
+
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(base62Alphabet, s[i])
+		if v < 0 {
+			return 0, fmt.Errorf("%w: base62 id %q has invalid character %q", ErrInvalidId, s, s[i])
+		}
+		// 62^11 超出了 uint64 的范围，需要在乘法之前判断是否溢出
+		if n > (1<<63-1-uint64(v))/62 {
+			return 0, fmt.Errorf("%w: base62 id %q overflows int64", ErrInvalidId, s)
+		}
+		n = n*62 + uint64(v)
+	}
+	return int64(n), nil
+}
+
+// ValidateBase62 校验字符串是否是合法的 base62 短 ID
+func ValidateBase62(s string) error {
+	_, err := DecodeBase62(s)
+	return err
+}
+
+// IdSequence 生成递增的数字 ID，SnowflakeGenerator 实现了该接口
+type IdSequence interface {
+	NextId(ctx context.Context) (int64, error)
+}
+
+var _ Generator = (*Base62Generator)(nil)
+
+type Base62Generator struct {
+	*zap.Logger
+	sequence IdSequence
+}
+
+// NewBase62Generator sequence 生成的 ID 必须是非负数
+func NewBase62Generator(logger *zap.Logger, sequence IdSequence) *Base62Generator {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Base62Generator{Logger: logger, sequence: sequence}
+}
+
+// ClockRollbacks 返回底层数字 ID 生成器发现时钟回拨的次数
+func (g *Base62Generator) ClockRollbacks() int64 {
+	if counter, ok := g.sequence.(ClockRollbackCounter); ok {
+		return counter.ClockRollbacks()
+	}
+	return 0
+}
+
+// Generate 生成 base62 编码的短 ID
+func (g *Base62Generator) Generate(ctx context.Context) (string, error) {
+	id, err := g.sequence.NextId(ctx)
+	if err != nil {
+		return "", err
+	}
+	if id < 0 {
+		g.Error("negative id from sequence", zap.Int64("id", id))
+		return "", fmt.Errorf("%w: negative id %d", ErrInvalidId, id)
+	}
+	return EncodeBase62(id), nil
+}
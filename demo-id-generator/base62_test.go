@@ -0,0 +1,54 @@
+package demo_id_generator
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestEncodeBase62(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "00000000000"},
+		{61, "0000000000z"},
+		{62, "00000000010"},
+		{math.MaxInt64, "AzL8n0Y58m7"},
+	}
+
+	for _, tt := range tests {
+		got := EncodeBase62(tt.n)
+		if got != tt.want {
+			t.Errorf("EncodeBase62(%d) got = %v, want %v", tt.n, got, tt.want)
+		}
+		if n, err := DecodeBase62(got); err != nil || n != tt.n {
+			t.Errorf("DecodeBase62(%v) got = %v, %v, want %v", got, n, err, tt.n)
+		}
+	}
+
+	for _, id := range []string{"AzL8n0Y58m8", "0000000000-", "0"} {
+		if err := ValidateBase62(id); !errors.Is(err, ErrInvalidId) {
+			t.Errorf("ValidateBase62(%q) error = %v, want %v", id, err, ErrInvalidId)
+		}
+	}
+}
+
+func TestBase62Generator_Generate(t *testing.T) {
+	snowflake, _ := NewSnowflakeGenerator(nil, 1)
+	g := NewBase62Generator(nil, snowflake)
+
+	last := ""
+	for i := 0; i < 1000; i++ {
+		id := mustGenerate(t, g)
+		if id <= last {
+			t.Fatalf("Generate() got %v after %v", id, last)
+		}
+		last = id
+	}
+
+	n, _ := DecodeBase62(last)
+	if got := snowflake.Layout().Parse(n).WorkerId; got != 1 {
+		t.Errorf("Parse() worker id = %v, want 1", got)
+	}
+}
@@ -0,0 +1,212 @@
+// idserver ID 生成服务和压测工具：
+//
+//	idserver serve -addr :8080 -config tags.json -segment-tags order,address
+//	idserver bench -url http://127.0.0.1:8080 -tag order -c 16 -n 10000 -batch 10
+//	idserver bench -format ulid -c 16 -d 10m -track 0
+//
+// serve 按照配置文件为每个业务标识创建生成器，配置文件的格式为：
+//
+//	{"order": {"format": "snowflake", "worker_id": 1}, "event": {"format": "ksuid", "monotonic": true}}
+//
+// 没有指定配置文件时，每种内置的 ID 格式都以自己的名字作为业务标识。
+// -segment-tags 中的业务标识使用号段模式，号段的申请情况见 /stats。指定 -segment-dsn 时号段保存在数据库的 id_segment 表中
+// （需要在编译时导入 -segment-driver 对应的驱动，并为每个业务标识插入一行），否则保存在内存中，重启之后 ID 会重复。
+// bench 指定 -url 时压测远程的服务，否则压测进程内 -format 指定的生成器，
+// 输出吞吐量、p99 延迟，发现重复的 ID 时以非 0 状态码退出；-track 限制校验重复时记录的 ID 数量，为负数时不校验。
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	idgen "github.com/promacanthus/design-patterns/demo-id-generator"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "bench":
+		err = runBench(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: idserver <serve|bench> [flags]")
+}
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "listen address")
+	config := fs.String("config", "", "json file mapping tags to generator configs")
+	segmentTags := fs.String("segment-tags", "", "comma separated tags served by the segment allocator")
+	segmentStep := fs.Int64("segment-step", 1000, "default segment step of the in-memory segment repository")
+	segmentDriver := fs.String("segment-driver", "mysql", "database/sql driver of the segment table")
+	segmentDSN := fs.String("segment-dsn", "", "data source of the segment table, keep segments in memory if empty")
+	_ = fs.Parse(args)
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+
+	configs, err := loadConfigs(*config)
+	if err != nil {
+		return err
+	}
+
+	registry := idgen.NewDefaultRegistry()
+	server := idgen.NewIdServer(logger)
+	for tag, config := range configs {
+		g, err := registry.New(logger, config)
+		if err != nil {
+			return fmt.Errorf("tag %s: %w", tag, err)
+		}
+		server.Handle(tag, g)
+	}
+	if tags := splitTags(*segmentTags); len(tags) > 0 {
+		repo, err := newSegmentRepository(*segmentDriver, *segmentDSN, *segmentStep, tags)
+		if err != nil {
+			return err
+		}
+		if *segmentDSN == "" {
+			logger.Warn("segments are kept in memory, ids restart from 1 after restart", zap.Strings("tags", tags))
+		}
+		server.HandleSegments(idgen.NewSegmentAllocator(logger, repo), tags...)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	httpServer := &http.Server{Addr: *addr, Handler: server}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("id server started", zap.String("addr", *addr), zap.Strings("tags", server.Tags()))
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func newSegmentRepository(driver, dsn string, step int64, tags []string) (idgen.SegmentRepository, error) {
+	if dsn == "" {
+		steps := make(map[string]int64, len(tags))
+		for _, tag := range tags {
+			steps[tag] = step
+		}
+		return idgen.NewMemorySegmentRepository(steps), nil
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	repo := idgen.NewSQLSegmentRepository(db)
+	if err := repo.Migrate(context.Background()); err != nil {
+		return nil, fmt.Errorf("migrate segment table: %w", err)
+	}
+	return repo, nil
+}
+
+func loadConfigs(path string) (map[string]idgen.GeneratorConfig, error) {
+	configs := make(map[string]idgen.GeneratorConfig)
+	if path == "" {
+		for _, name := range idgen.NewDefaultRegistry().Names() {
+			configs[name] = idgen.GeneratorConfig{Format: name}
+		}
+		return configs, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	target := fs.String("url", "", "id server address, benchmark the in-process generator if empty")
+	tag := fs.String("tag", "snowflake", "tag to request from the id server")
+	format := fs.String("format", "snowflake", "in-process generator format")
+	monotonic := fs.Bool("monotonic", false, "strict monotonic mode for ulid and ksuid")
+	concurrency := fs.Int("c", 8, "number of concurrent workers")
+	requests := fs.Int("n", 10000, "total requests, 0 means unlimited within -d")
+	duration := fs.Duration("d", 0, "benchmark duration")
+	batch := fs.Int("batch", 1, "ids per request")
+	track := fs.Int("track", 0, "max ids remembered for duplicate checking, 0 means the default limit, negative disables it")
+	_ = fs.Parse(args)
+
+	var g idgen.BatchGenerator
+	if *target != "" {
+		g = idgen.NewIdClient(*target, *tag, &http.Client{Timeout: 5 * time.Second})
+	} else {
+		generator, err := idgen.NewDefaultRegistry().New(nil, idgen.GeneratorConfig{Format: *format, Monotonic: *monotonic})
+		if err != nil {
+			return err
+		}
+		g = idgen.Batch(generator)
+	}
+
+	result, err := idgen.LoadTest(context.Background(), g, idgen.LoadTestConfig{
+		Concurrency:   *concurrency,
+		Requests:      *requests,
+		Duration:      *duration,
+		BatchSize:     *batch,
+		MaxTrackedIds: *track,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(result)
+	if result.Duplicates > 0 {
+		return fmt.Errorf("found %d duplicated ids", result.Duplicates)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package demo_id_generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// 旧版的 Generate() string 在出错时返回空字符串，调用方无法区分“生成失败”和“生成了空 ID”，
+// 错误也没有记录到日志中。按照“返回错误而不是返回空对象”的原则，
+// 生成器统一实现 Generator 接口，把错误返回给调用方，由调用方决定如何处理。
+// 仍然依赖旧接口的代码，通过 IdGeneratorAdapter 适配，并配置出错时的降级策略。
+
+// Generator generates IDs and reports the failure instead of returning an empty ID
+type Generator interface {
+	Generate(ctx context.Context) (string, error)
+}
+
+// GeneratorFunc adapts an ordinary function to the Generator interface
+type GeneratorFunc func(ctx context.Context) (string, error)
+
+func (f GeneratorFunc) Generate(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// ClockRegressionError 时钟回拨，并且没有在允许的时间内追上
+type ClockRegressionError struct {
+	Behind time.Duration
+}
+
+func (e *ClockRegressionError) Error() string {
+	return fmt.Sprintf("%s: %s behind", ErrClockMovedBackwards, e.Behind)
+}
+
+func (e *ClockRegressionError) Is(target error) bool {
+	return target == ErrClockMovedBackwards
+}
+
+// EntropyError 读取随机数失败
+type EntropyError struct {
+	Err error
+}
+
+func (e *EntropyError) Error() string {
+	return "read entropy: " + e.Err.Error()
+}
+
+func (e *EntropyError) Unwrap() error {
+	return e.Err
+}
+
+// SequenceExhaustedError 可分配的 ID 已经用完，如序列号或者时间戳超出了位分配方案的范围，
+// Err 是具体的原因（如 ErrTimestampOverflow），可以为 nil
+type SequenceExhaustedError struct {
+	Reason string
+	Err    error
+}
+
+func (e *SequenceExhaustedError) Error() string {
+	return "id sequence exhausted: " + e.Reason
+}
+
+func (e *SequenceExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// Fallback 降级策略，根据生成失败的错误，返回替代的 ID
+type Fallback func(err error) string
+
+// EmptyFallback 返回空字符串，与旧版的行为一致
+func EmptyFallback(error) string {
+	return ""
+}
+
+// RandomUUIDFallback 返回一个随机的 UUID（version 4），读取随机数也失败时返回空字符串
+func RandomUUIDFallback(error) string {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return ""
+	}
+	return id.String()
+}
+
+// GeneratorFallback 使用备用的生成器，备用的生成器也失败时返回空字符串
+func GeneratorFallback(generator Generator) Fallback {
+	return func(error) string {
+		id, err := generator.Generate(context.Background())
+		if err != nil {
+			return ""
+		}
+		return id
+	}
+}
+
+var _ IdGenerator = (*IdGeneratorAdapter)(nil)
+
+// IdGeneratorAdapter 把 Generator 适配为旧版的 IdGenerator 接口，
+// 生成失败时记录日志，并按照降级策略返回替代的 ID。
+type IdGeneratorAdapter struct {
+	*zap.Logger
+
+	generator Generator
+	fallback  Fallback
+}
+
+func NewIdGeneratorAdapter(logger *zap.Logger, generator Generator, fallback Fallback) *IdGeneratorAdapter {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	if fallback == nil {
+		fallback = EmptyFallback
+	}
+
+	return &IdGeneratorAdapter{Logger: logger, generator: generator, fallback: fallback}
+}
+
+func (a *IdGeneratorAdapter) Generate() string {
+	id, err := a.generator.Generate(context.Background())
+	if err == nil {
+		return id
+	}
+
+	id = a.fallback(err)
+	a.Warn("id generation degraded, using fallback", zap.Error(err), zap.String("fallback_id", id))
+	return id
+}
+
+// isCanceled 判断等待过程中上下文是否已经结束
+func isCanceled(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
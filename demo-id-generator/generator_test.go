@@ -0,0 +1,57 @@
+package demo_id_generator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestIdGeneratorAdapter_Generate(t *testing.T) {
+	failing := GeneratorFunc(func(ctx context.Context) (string, error) {
+		return "", &EntropyError{Err: errors.New("no entropy")}
+	})
+	working := GeneratorFunc(func(ctx context.Context) (string, error) {
+		return "backup-id", nil
+	})
+
+	tests := []struct {
+		name      string
+		generator Generator
+		fallback  Fallback
+		want      string
+		wantWarn  bool
+	}{
+		{"success", working, nil, "backup-id", false},
+		{"empty fallback", failing, EmptyFallback, "", true},
+		{"generator fallback", failing, GeneratorFallback(working), "backup-id", true},
+		{"fallback also failed", failing, GeneratorFallback(failing), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			adapter := NewIdGeneratorAdapter(zap.New(core), tt.generator, tt.fallback)
+
+			if got := adapter.Generate(); got != tt.want {
+				t.Errorf("Generate() got = %v, want %v", got, tt.want)
+			}
+			if got := logs.Len() > 0; got != tt.wantWarn {
+				t.Errorf("degraded generation logged = %v, want %v", got, tt.wantWarn)
+			}
+		})
+	}
+
+	adapter := NewIdGeneratorAdapter(nil, failing, RandomUUIDFallback)
+	if got := adapter.Generate(); len(got) != 36 {
+		t.Errorf("Generate() with uuid fallback got = %v", got)
+	}
+
+	// 旧版的 RandomGenerator 通过适配器继续满足 IdGenerator
+	var legacy IdGenerator = NewIdGeneratorAdapter(nil, NewRandomGenerator(nil), nil)
+	if got := legacy.Generate(); len(got) != 36 {
+		t.Errorf("Generate() with RandomGenerator got = %v", got)
+	}
+}
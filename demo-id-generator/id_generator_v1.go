@@ -1,23 +1,31 @@
 package demo_id_generator
 
 import (
+	"context"
+
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+var _ Generator = (*IdGeneratorV1)(nil)
+
 type IdGeneratorV1 struct {
 	*zap.Logger
 }
 
 func NewIdGeneratorV1(logger *zap.Logger) *IdGeneratorV1 {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
 	return &IdGeneratorV1{Logger: logger}
 }
 
-func (g *IdGeneratorV1) Generate() string {
+func (g *IdGeneratorV1) Generate(ctx context.Context) (string, error) {
 	id, err := uuid.NewUUID()
 	if err != nil {
-		return ""
+		g.Error("generate uuid", zap.Error(err))
+		return "", &EntropyError{Err: err}
 	}
 
-	return id.String()
+	return id.String(), nil
 }
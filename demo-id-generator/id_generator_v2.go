@@ -1,38 +1,50 @@
 package demo_id_generator
 
 import (
+	"context"
+
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
-// IdGenerator used to generate random IDs
+// IdGenerator used to generate random IDs, returns an empty string on failure.
+// All generators in this package, including RandomGenerator and IdGeneratorV1, implement Generator instead,
+// wrap them with IdGeneratorAdapter to keep this contract.
 type IdGenerator interface {
 	Generate() string
 }
 
+// LogTraceGenerator used to generate IDs for tracing the logs of a request
 type LogTraceGenerator interface {
-	IdGenerator
-	Log()
+	Generator
+	// Log returns a logger carrying the trace ID stored in ctx
+	Log(ctx context.Context) *zap.Logger
 }
 
+var _ Generator = (*RandomGenerator)(nil)
+
 type RandomGenerator struct {
 	*zap.Logger
 }
 
 func NewRandomGenerator(logger *zap.Logger) *RandomGenerator {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
 	return &RandomGenerator{Logger: logger}
 }
 
 // Generate an random ID
-func (g *RandomGenerator) Generate() string {
+func (g *RandomGenerator) Generate(ctx context.Context) (string, error) {
 	id, err := uuid.NewUUID()
 	if err != nil {
-		return ""
+		g.Error("generate uuid", zap.Error(err))
+		return "", &EntropyError{Err: err}
 	}
 
-	return id.String()
+	return id.String(), nil
 }
 
-func (g *RandomGenerator) Log() {
-	panic("implement me")
+func (g *RandomGenerator) Log(ctx context.Context) *zap.Logger {
+	return LoggerWithTraceId(ctx, g.Logger)
 }
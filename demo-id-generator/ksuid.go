@@ -0,0 +1,129 @@
+package demo_id_generator
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// KsuidGenerator 生成 KSUID（K-Sortable Unique Identifier）：
+// 32 位秒级时间戳（相对于 2014-05-13 16:53:20 UTC）+ 128 位随机数，使用 base62 编码为 27 个字符，
+// 随机部分比 ULID 更长，适合作为事件流中的事件 ID。
+// 严格单调模式下，同一秒内生成的 KSUID 严格递增。
+
+const (
+	ksuidLength       = 27
+	ksuidEpoch        = 1400000000
+	ksuidMaxTimestamp = int64(1)<<32 - 1
+)
+
+var ksuidMax = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 160), big.NewInt(1))
+
+// Ksuid 20 字节的 KSUID，前 4 个字节是时间戳，后 16 个字节是随机数
+type Ksuid [20]byte
+
+// Time 返回 KSUID 的生成时间，精确到秒
+func (k Ksuid) Time() time.Time {
+	var seconds int64
+	for _, b := range k[:4] {
+		seconds = seconds<<8 | int64(b)
+	}
+	return time.Unix(seconds+ksuidEpoch, 0)
+}
+
+// Payload 返回 KSUID 的随机部分
+func (k Ksuid) Payload() []byte {
+	return append([]byte(nil), k[4:]...)
+}
+
+// String 编码为 27 个字符的 base62 字符串，左侧补 0
+func (k Ksuid) String() string {
+	n := new(big.Int).SetBytes(k[:])
+	base := big.NewInt(62)
+	mod := new(big.Int)
+
+	var buf [ksuidLength]byte
+	for i := ksuidLength - 1; i >= 0; i-- {
+		n.DivMod(n, base, mod)
+		buf[i] = base62Alphabet[mod.Int64()]
+	}
+	return string(buf[:])
+}
+
+// ParseKsuid 解码字符串形式的 KSUID
+func ParseKsuid(s string) (Ksuid, error) {
+	var k Ksuid
+	if len(s) != ksuidLength {
+		return k, fmt.Errorf("%w: ksuid %q should have %d characters", ErrInvalidId, s, ksuidLength)
+	}
+
+	n := new(big.Int)
+	base := big.NewInt(62)
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(base62Alphabet, s[i])
+		if v < 0 {
+			return k, fmt.Errorf("%w: ksuid %q has invalid character %q", ErrInvalidId, s, s[i])
+		}
+		n.Mul(n, base).Add(n, big.NewInt(int64(v)))
+	}
+	if n.Cmp(ksuidMax) > 0 {
+		return k, fmt.Errorf("%w: ksuid %q overflows 160 bits", ErrInvalidId, s)
+	}
+
+	n.FillBytes(k[:])
+	return k, nil
+}
+
+// ValidateKsuid 校验字符串是否是合法的 KSUID
+func ValidateKsuid(s string) error {
+	_, err := ParseKsuid(s)
+	return err
+}
+
+var _ Generator = (*KsuidGenerator)(nil)
+
+type KsuidGenerator struct {
+	*zap.Logger
+	clock *sortableClock
+}
+
+func NewKsuidGenerator(logger *zap.Logger, opts ...SortableOption) *KsuidGenerator {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &KsuidGenerator{Logger: logger, clock: newSortableClock(opts)}
+}
+
+// Generate 生成字符串形式的 KSUID
+func (g *KsuidGenerator) Generate(ctx context.Context) (string, error) {
+	id, err := g.NextKsuid(ctx)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// NextKsuid 生成一个新的 KSUID
+func (g *KsuidGenerator) NextKsuid(ctx context.Context) (Ksuid, error) {
+	var k Ksuid
+	seconds := g.clock.now().Unix() - ksuidEpoch
+	if seconds < 0 || seconds > ksuidMaxTimestamp {
+		return k, &SequenceExhaustedError{Reason: "timestamp overflows 32 bits"}
+	}
+
+	seconds, err := g.clock.next(seconds, k[4:])
+	if err != nil {
+		g.Error("generate ksuid", zap.Error(err))
+		return Ksuid{}, err
+	}
+
+	for i := 3; i >= 0; i-- {
+		k[i] = byte(seconds)
+		seconds >>= 8
+	}
+	return k, nil
+}
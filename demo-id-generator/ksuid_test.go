@@ -0,0 +1,54 @@
+package demo_id_generator
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKsuid(t *testing.T) {
+	k, err := ParseKsuid("0ujtsYcgvSTl8PAuAdqWYSMnLOv")
+	if err != nil {
+		t.Fatalf("ParseKsuid() error = %v", err)
+	}
+
+	if want := time.Date(2017, 10, 10, 4, 0, 47, 0, time.UTC); !k.Time().Equal(want) {
+		t.Errorf("Time() got = %v, want %v", k.Time().UTC(), want)
+	}
+	if got, want := strings.ToUpper(hex.EncodeToString(k.Payload())), "B5A1CD34B5F99D1154FB6853345C9735"; got != want {
+		t.Errorf("Payload() got = %v, want %v", got, want)
+	}
+	if k.String() != "0ujtsYcgvSTl8PAuAdqWYSMnLOv" {
+		t.Errorf("String() got = %v", k)
+	}
+
+	for _, id := range []string{"0ujtsYcgvSTl8PAuAdqWYSMnLO", "0ujtsYcgvSTl8PAuAdqWYSMnLO-", "zzzzzzzzzzzzzzzzzzzzzzzzzzz"} {
+		if err := ValidateKsuid(id); !errors.Is(err, ErrInvalidId) {
+			t.Errorf("ValidateKsuid(%q) error = %v, want %v", id, err, ErrInvalidId)
+		}
+	}
+}
+
+func TestKsuidGenerator_StrictMonotonic(t *testing.T) {
+	now := time.Now()
+	g := NewKsuidGenerator(nil, WithStrictMonotonic(), WithSortableClock(func() time.Time { return now }))
+
+	last := ""
+	for i := 0; i < 1000; i++ {
+		id := mustGenerate(t, g)
+		if id <= last {
+			t.Fatalf("Generate() got %v after %v", id, last)
+		}
+		if err := ValidateKsuid(id); err != nil {
+			t.Fatalf("ValidateKsuid() error = %v", err)
+		}
+		last = id
+	}
+
+	k, _ := ParseKsuid(last)
+	if !k.Time().Equal(now.Truncate(time.Second)) {
+		t.Errorf("Time() got = %v, want %v", k.Time(), now.Truncate(time.Second))
+	}
+}
@@ -0,0 +1,200 @@
+package demo_id_generator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LoadTest 并发地调用 BatchGenerator 生成 ID，校验 ID 是否唯一，并统计吞吐量和延迟。
+// BatchGenerator 可以是进程内的生成器（Batch），也可以是远程的 IdServer（IdClient）。
+
+var _ BatchGenerator = (*IdClient)(nil)
+
+// IdClient IdServer 的 HTTP 客户端
+type IdClient struct {
+	baseURL string
+	tag     string
+	client  *http.Client
+}
+
+// NewIdClient baseURL 是 IdServer 的地址，如 http://127.0.0.1:8080；client 为 nil 时使用 http.DefaultClient
+func NewIdClient(baseURL, tag string, client *http.Client) *IdClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &IdClient{baseURL: baseURL, tag: tag, client: client}
+}
+
+func (c *IdClient) GenerateBatch(ctx context.Context, count int) ([]string, error) {
+	query := url.Values{"tag": {c.tag}, "count": {strconv.Itoa(count)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/id?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body errorResponse
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body)
+		return nil, fmt.Errorf("id server returns %s: %s", resp.Status, body.Error)
+	}
+
+	var batch IdBatch
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return nil, err
+	}
+	return batch.Ids, nil
+}
+
+// defaultMaxTrackedIds 默认最多记录的 ID 数量，按照每个 ID 约 100 字节估算，占用 1GB 左右的内存
+const defaultMaxTrackedIds = 10000000
+
+// LoadTestConfig 压测的配置，Requests 和 Duration 至少需要设置一个，都设置时先达到的为准
+type LoadTestConfig struct {
+	Concurrency int
+	Requests    int           // 总的请求次数
+	Duration    time.Duration // 压测时长
+	BatchSize   int           // 每次请求生成的 ID 数量
+	// MaxTrackedIds 校验重复时最多记录的 ID 数量，为 0 时使用默认值，小于 0 时不校验重复。
+	// 记满之后的 ID 只和已经记录的 ID 比较，不再记录，长时间的压测不会耗尽内存
+	MaxTrackedIds int
+}
+
+// LoadTestResult 压测的结果
+type LoadTestResult struct {
+	Requests   int
+	Errors     int
+	Ids        int
+	Checked    int // 校验过是否重复的 ID 数量
+	Duplicates int
+	Elapsed    time.Duration
+	Throughput float64 // 每秒生成的 ID 数量
+	P50        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+func (r *LoadTestResult) String() string {
+	return fmt.Sprintf("requests=%d errors=%d ids=%d checked=%d duplicates=%d elapsed=%s throughput=%.0f/s p50=%s p99=%s max=%s",
+		r.Requests, r.Errors, r.Ids, r.Checked, r.Duplicates, r.Elapsed, r.Throughput, r.P50, r.P99, r.Max)
+}
+
+// LoadTest 执行压测，ctx 结束时提前停止并返回已经完成的部分
+func LoadTest(ctx context.Context, g BatchGenerator, config LoadTestConfig) (*LoadTestResult, error) {
+	if config.Concurrency < 1 || config.BatchSize < 1 || (config.Requests < 1 && config.Duration <= 0) {
+		return nil, errors.New("concurrency and batch size should be positive, and requests or duration is required")
+	}
+
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+	maxTracked := config.MaxTrackedIds
+	if maxTracked == 0 {
+		maxTracked = defaultMaxTrackedIds
+	}
+
+	var (
+		mu        sync.Mutex
+		seen      = make(map[string]struct{})
+		latencies []time.Duration
+		result    LoadTestResult
+		remaining = config.Requests
+		wg        sync.WaitGroup
+	)
+
+	// take 领取一次请求的配额，只设置了 Duration 时不限次数
+	take := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if config.Requests < 1 {
+			return true
+		}
+		if remaining == 0 {
+			return false
+		}
+		remaining--
+		return true
+	}
+
+	start := time.Now()
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && take() {
+				begin := time.Now()
+				ids, err := g.GenerateBatch(ctx, config.BatchSize)
+				latency := time.Since(begin)
+
+				mu.Lock()
+				if err != nil {
+					// 到达压测时长而中断的请求不算作错误
+					if ctx.Err() == nil {
+						result.Requests++
+						result.Errors++
+					}
+					mu.Unlock()
+					continue
+				}
+				result.Requests++
+				latencies = append(latencies, latency)
+				for _, id := range ids {
+					if maxTracked < 0 {
+						break
+					}
+					result.Checked++
+					if _, ok := seen[id]; ok {
+						result.Duplicates++
+					} else if len(seen) < maxTracked {
+						seen[id] = struct{}{}
+					}
+				}
+				result.Ids += len(ids)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	result.Elapsed = time.Since(start)
+	if result.Elapsed > 0 {
+		result.Throughput = float64(result.Ids) / result.Elapsed.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.P50 = latencyPercentile(latencies, 0.5)
+	result.P99 = latencyPercentile(latencies, 0.99)
+	result.Max = latencyPercentile(latencies, 1)
+	return &result, nil
+}
+
+// latencyPercentile 按照 nearest-rank 方法从升序排列的延迟中取百分位数
+func latencyPercentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,68 @@
+package demo_id_generator
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadTest(t *testing.T) {
+	server := NewIdServer(nil)
+	server.Handle("ulid", NewUlidGenerator(nil, WithStrictMonotonic()))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	result, err := LoadTest(context.Background(), NewIdClient(ts.URL, "ulid", ts.Client()),
+		LoadTestConfig{Concurrency: 4, Requests: 100, BatchSize: 10})
+	if err != nil {
+		t.Fatalf("LoadTest() error = %v", err)
+	}
+	if result.Requests != 100 || result.Ids != 1000 || result.Errors != 0 || result.Duplicates != 0 {
+		t.Errorf("LoadTest() got = %v", result)
+	}
+	if result.P99 <= 0 || result.P99 > result.Max || result.Throughput <= 0 {
+		t.Errorf("LoadTest() got latency = %v", result)
+	}
+}
+
+func TestLoadTest_Duplicates(t *testing.T) {
+	var n int64
+	// 每个 ID 重复出现两次
+	g := GeneratorFunc(func(ctx context.Context) (string, error) {
+		return strconv.FormatInt(atomic.AddInt64(&n, 1)/2, 10), nil
+	})
+
+	result, err := LoadTest(context.Background(), Batch(g), LoadTestConfig{Concurrency: 2, Duration: 10 * time.Millisecond, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("LoadTest() error = %v", err)
+	}
+	if result.Duplicates == 0 {
+		t.Errorf("LoadTest() should find duplicates, got = %v", result)
+	}
+
+	// ID 依次为 0 1 1 2 2 3 3 4 4 5，只记录前 4 个不同的 ID，之后的 ID 仍然和它们比较，重复的 4 发现不了
+	n = 0
+	result, err = LoadTest(context.Background(), Batch(g), LoadTestConfig{Concurrency: 1, Requests: 10, BatchSize: 1,
+		MaxTrackedIds: 4})
+	if err != nil {
+		t.Fatalf("LoadTest() error = %v", err)
+	}
+	if result.Checked != 10 || result.Duplicates != 3 {
+		t.Errorf("LoadTest() with MaxTrackedIds got = %v", result)
+	}
+	result, err = LoadTest(context.Background(), Batch(g), LoadTestConfig{Concurrency: 1, Requests: 10, BatchSize: 1,
+		MaxTrackedIds: -1})
+	if err != nil {
+		t.Fatalf("LoadTest() error = %v", err)
+	}
+	if result.Checked != 0 || result.Duplicates != 0 {
+		t.Errorf("LoadTest() without duplicate checking got = %v", result)
+	}
+
+	if _, err := LoadTest(context.Background(), Batch(g), LoadTestConfig{Concurrency: 1, BatchSize: 1}); err == nil {
+		t.Error("LoadTest() without requests or duration should fail")
+	}
+}
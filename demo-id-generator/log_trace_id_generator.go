@@ -0,0 +1,161 @@
+package demo_id_generator
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LogTraceIdGenerator 生成用于服务内调用链追踪的日志 ID，格式为：
+//
+//	{主机名 substring}-{毫秒时间戳}-{8 位随机字符}
+//
+// 主机名 substring 是主机名按 "." 切分后的最后一段，主机名获取失败时使用 "null"。
+// 获取主机名、当前时间、随机数的函数都可以替换，方便编写单元测试。
+
+const (
+	traceIdRandomLength = 8
+	traceIdAlphabet     = base62Alphabet
+	traceIdField        = "trace_id"
+)
+
+type LogTraceOption func(*LogTraceIdGenerator)
+
+// WithHostnameFunc 替换获取主机名的函数
+func WithHostnameFunc(hostname func() (string, error)) LogTraceOption {
+	return func(g *LogTraceIdGenerator) {
+		g.hostname = hostname
+	}
+}
+
+// WithNowFunc 替换获取当前时间的函数
+func WithNowFunc(now func() time.Time) LogTraceOption {
+	return func(g *LogTraceIdGenerator) {
+		g.now = now
+	}
+}
+
+// WithRandReader 替换随机数的来源
+func WithRandReader(random io.Reader) LogTraceOption {
+	return func(g *LogTraceIdGenerator) {
+		g.random = random
+	}
+}
+
+var _ LogTraceGenerator = (*LogTraceIdGenerator)(nil)
+
+type LogTraceIdGenerator struct {
+	*zap.Logger
+
+	hostname func() (string, error)
+	now      func() time.Time
+	random   io.Reader
+}
+
+func NewLogTraceIdGenerator(logger *zap.Logger, opts ...LogTraceOption) *LogTraceIdGenerator {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	g := &LogTraceIdGenerator{
+		Logger:   logger,
+		hostname: os.Hostname,
+		now:      time.Now,
+		random:   rand.Reader,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return g
+}
+
+// Generate 生成一个日志 ID
+func (g *LogTraceIdGenerator) Generate(ctx context.Context) (string, error) {
+	randomChars, err := g.randomChars(traceIdRandomLength)
+	if err != nil {
+		g.Error("generate log trace id", zap.Error(err))
+		return "", &EntropyError{Err: err}
+	}
+
+	millis := g.now().UnixNano() / int64(time.Millisecond)
+	return g.lastFieldOfHostname() + "-" + strconv.FormatInt(millis, 10) + "-" + randomChars, nil
+}
+
+// NewContext 生成一个日志 ID 并保存到请求的上下文中
+func (g *LogTraceIdGenerator) NewContext(ctx context.Context) (context.Context, string, error) {
+	id, err := g.Generate(ctx)
+	if err != nil {
+		return ctx, "", err
+	}
+	return WithTraceId(ctx, id), id, nil
+}
+
+// Log 返回带有上下文中日志 ID 字段的 logger，同一个请求打印的日志都会带上相同的 ID
+func (g *LogTraceIdGenerator) Log(ctx context.Context) *zap.Logger {
+	return LoggerWithTraceId(ctx, g.Logger)
+}
+
+func (g *LogTraceIdGenerator) lastFieldOfHostname() string {
+	hostname, err := g.hostname()
+	if err != nil || hostname == "" {
+		g.Warn("get hostname failed, using null instead", zap.Error(err))
+		return "null"
+	}
+
+	tokens := strings.Split(hostname, ".")
+	return tokens[len(tokens)-1]
+}
+
+// randomChars 生成由数字和大小写字母组成的随机字符串，
+// 丢弃大于等于 248（62 的整数倍）的字节，保证每个字符出现的概率相同。
+func (g *LogTraceIdGenerator) randomChars(length int) (string, error) {
+	var b strings.Builder
+	buf := make([]byte, length)
+
+	for b.Len() < length {
+		n := length - b.Len()
+		if _, err := io.ReadFull(g.random, buf[:n]); err != nil {
+			return "", err
+		}
+		for _, c := range buf[:n] {
+			if c < 248 {
+				b.WriteByte(traceIdAlphabet[int(c)%len(traceIdAlphabet)])
+			}
+		}
+	}
+
+	return b.String(), nil
+}
+
+type traceIdKey struct{}
+
+// WithTraceId 把日志 ID 保存到上下文中
+func WithTraceId(ctx context.Context, traceId string) context.Context {
+	return context.WithValue(ctx, traceIdKey{}, traceId)
+}
+
+// TraceIdFromContext 从上下文中取出日志 ID
+func TraceIdFromContext(ctx context.Context) (string, bool) {
+	traceId, ok := ctx.Value(traceIdKey{}).(string)
+	return traceId, ok
+}
+
+// LoggerWithTraceId 如果上下文中有日志 ID，返回带有该字段的 logger，否则原样返回
+func LoggerWithTraceId(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	if traceId, ok := TraceIdFromContext(ctx); ok {
+		return logger.With(zap.String(traceIdField, traceId))
+	}
+	return logger
+}
@@ -0,0 +1,83 @@
+package demo_id_generator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogTraceIdGenerator_Generate(t *testing.T) {
+	now := func() time.Time { return time.Unix(1621468800, 123000000) }
+	// 0 -> '0'，61 -> 'z'，248 超出范围会被丢弃
+	random := bytes.NewReader([]byte{0, 61, 248, 10, 36, 1, 2, 3, 4, 5, 6, 7})
+
+	tests := []struct {
+		name     string
+		hostname func() (string, error)
+		want     string
+	}{
+		{"hostname with domain", func() (string, error) { return "web.example.host-1", nil }, "host-1-1621468800123-0zAa1234"},
+		{"hostname lookup failed", func() (string, error) { return "", errors.New("no hostname") }, "null-1621468800123-0zAa1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			random.Reset([]byte{0, 61, 248, 10, 36, 1, 2, 3, 4, 5, 6, 7})
+			g := NewLogTraceIdGenerator(nil, WithHostnameFunc(tt.hostname), WithNowFunc(now), WithRandReader(random))
+			if got := mustGenerate(t, g); got != tt.want {
+				t.Errorf("Generate() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogTraceIdGenerator_Unique(t *testing.T) {
+	// 使用固定的主机名，真实的主机名可能包含 -，如 ip-10-0-0-1
+	g := NewLogTraceIdGenerator(nil, WithHostnameFunc(func() (string, error) { return "ip-10-0-0-1", nil }))
+	format := regexp.MustCompile(`^ip-10-0-0-1-\d+-[0-9A-Za-z]{8}$`)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := mustGenerate(t, g)
+		if seen[id] {
+			t.Fatalf("Generate() got duplicated id %s", id)
+		}
+		if !format.MatchString(id) {
+			t.Fatalf("Generate() got malformed id %s", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestLogTraceIdGenerator_Log(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	g := NewLogTraceIdGenerator(zap.New(core))
+
+	ctx, id, err := g.NewContext(context.Background())
+	if err != nil {
+		t.Fatalf("NewContext() error = %v", err)
+	}
+	if got, ok := TraceIdFromContext(ctx); !ok || got != id {
+		t.Fatalf("TraceIdFromContext() got = %v, %v, want %v", got, ok, id)
+	}
+
+	g.Log(ctx).Info("first")
+	g.Log(ctx).Info("second")
+	g.Log(context.Background()).Info("no trace")
+
+	entries := logs.AllUntimed()
+	for _, entry := range entries[:2] {
+		if got := entry.ContextMap()[traceIdField]; got != id {
+			t.Errorf("%s got trace id = %v, want %v", entry.Message, got, id)
+		}
+	}
+	if _, ok := entries[2].ContextMap()[traceIdField]; ok {
+		t.Errorf("log without trace id in context should not carry the field")
+	}
+}
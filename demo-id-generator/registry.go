@@ -0,0 +1,202 @@
+package demo_id_generator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// 不同的团队需要不同形式的 ID：日志使用 ULID、事件流使用 KSUID、URL 使用 base62 短 ID。
+// IdFormat 把生成器的构造函数和 ID 的校验函数注册到 Registry 中，按照配置中的名字选择生成器，
+// 新增一种 ID 格式只需要注册一个 IdFormat，不需要修改使用方的代码。
+// 仍然依赖旧接口 IdGenerator 的代码，使用 NewIdGeneratorAdapter 包装 Registry 返回的生成器。
+
+var (
+	ErrUnknownIdFormat   = errors.New("unknown id format")
+	ErrDuplicateIdFormat = errors.New("duplicate id format")
+)
+
+// GeneratorConfig 选择生成器的配置
+type GeneratorConfig struct {
+	Format    string `json:"format"`
+	WorkerId  int64  `json:"worker_id"` // snowflake、base62 使用的机器 ID
+	Monotonic bool   `json:"monotonic"` // ulid、ksuid 是否开启严格单调模式
+}
+
+// IdFormat 一种 ID 格式
+type IdFormat struct {
+	Name     string
+	New      func(logger *zap.Logger, config GeneratorConfig) (Generator, error)
+	Validate func(id string) error
+}
+
+type Registry struct {
+	mu      sync.RWMutex
+	formats map[string]IdFormat
+}
+
+func NewRegistry() *Registry {
+	return &Registry{formats: make(map[string]IdFormat)}
+}
+
+// NewDefaultRegistry 返回注册了内置 ID 格式的 Registry：uuid、snowflake、ulid、ksuid、base62、log-trace
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, format := range builtinIdFormats() {
+		_ = r.Register(format)
+	}
+	return r
+}
+
+// Register 注册一种 ID 格式，名字不区分大小写
+func (r *Registry) Register(format IdFormat) error {
+	name := strings.ToLower(format.Name)
+	if name == "" || format.New == nil || format.Validate == nil {
+		return fmt.Errorf("invalid id format %q: name, New and Validate are required", format.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.formats[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateIdFormat, name)
+	}
+	r.formats[name] = format
+	return nil
+}
+
+// Lookup 按名字查找 ID 格式
+func (r *Registry) Lookup(name string) (IdFormat, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	format, ok := r.formats[strings.ToLower(name)]
+	if !ok {
+		return IdFormat{}, fmt.Errorf("%w: %s", ErrUnknownIdFormat, name)
+	}
+	return format, nil
+}
+
+// New 按照配置创建生成器
+func (r *Registry) New(logger *zap.Logger, config GeneratorConfig) (Generator, error) {
+	format, err := r.Lookup(config.Format)
+	if err != nil {
+		return nil, err
+	}
+	return format.New(logger, config)
+}
+
+// Validate 按照格式校验 ID
+func (r *Registry) Validate(name, id string) error {
+	format, err := r.Lookup(name)
+	if err != nil {
+		return err
+	}
+	return format.Validate(id)
+}
+
+// Names 返回已经注册的 ID 格式的名字，按字母排序
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.formats))
+	for name := range r.formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func builtinIdFormats() []IdFormat {
+	sortableOptions := func(config GeneratorConfig) []SortableOption {
+		if config.Monotonic {
+			return []SortableOption{WithStrictMonotonic()}
+		}
+		return nil
+	}
+
+	return []IdFormat{
+		{
+			Name: "uuid",
+			New: func(logger *zap.Logger, config GeneratorConfig) (Generator, error) {
+				return NewRandomGenerator(logger), nil
+			},
+			Validate: func(id string) error {
+				if _, err := uuid.Parse(id); err != nil {
+					return fmt.Errorf("%w: uuid %q: %v", ErrInvalidId, id, err)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "snowflake",
+			New: func(logger *zap.Logger, config GeneratorConfig) (Generator, error) {
+				return NewSnowflakeGenerator(logger, config.WorkerId)
+			},
+			Validate: func(id string) error {
+				if _, err := DefaultSnowflakeLayout.ParseString(id); err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalidId, err)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "ulid",
+			New: func(logger *zap.Logger, config GeneratorConfig) (Generator, error) {
+				return NewUlidGenerator(logger, sortableOptions(config)...), nil
+			},
+			Validate: ValidateUlid,
+		},
+		{
+			Name: "ksuid",
+			New: func(logger *zap.Logger, config GeneratorConfig) (Generator, error) {
+				return NewKsuidGenerator(logger, sortableOptions(config)...), nil
+			},
+			Validate: ValidateKsuid,
+		},
+		{
+			Name: "base62",
+			New: func(logger *zap.Logger, config GeneratorConfig) (Generator, error) {
+				snowflake, err := NewSnowflakeGenerator(logger, config.WorkerId)
+				if err != nil {
+					return nil, err
+				}
+				return NewBase62Generator(logger, snowflake), nil
+			},
+			Validate: ValidateBase62,
+		},
+		{
+			Name: "log-trace",
+			New: func(logger *zap.Logger, config GeneratorConfig) (Generator, error) {
+				return NewLogTraceIdGenerator(logger), nil
+			},
+			Validate: validateLogTraceId,
+		},
+	}
+}
+
+// validateLogTraceId 校验 {主机名 substring}-{毫秒时间戳}-{8 位随机字符} 格式的日志 ID，
+// 主机名中可能包含 "-"，所以从右侧切分
+func validateLogTraceId(id string) error {
+	last := strings.LastIndexByte(id, '-')
+	if last < 0 {
+		return fmt.Errorf("%w: log trace id %q", ErrInvalidId, id)
+	}
+	middle := strings.LastIndexByte(id[:last], '-')
+	if middle < 0 {
+		return fmt.Errorf("%w: log trace id %q", ErrInvalidId, id)
+	}
+
+	millis, randomChars := id[middle+1:last], id[last+1:]
+	if millis == "" || strings.Trim(millis, "0123456789") != "" ||
+		len(randomChars) != traceIdRandomLength || strings.Trim(randomChars, traceIdAlphabet) != "" {
+		return fmt.Errorf("%w: log trace id %q", ErrInvalidId, id)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package demo_id_generator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegistry_New(t *testing.T) {
+	r := NewDefaultRegistry()
+
+	for _, name := range r.Names() {
+		t.Run(name, func(t *testing.T) {
+			g, err := r.New(nil, GeneratorConfig{Format: name, WorkerId: 3, Monotonic: true})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			id := mustGenerate(t, g)
+			if err := r.Validate(name, id); err != nil {
+				t.Errorf("Validate(%q) error = %v", id, err)
+			}
+			if err := r.Validate(name, "not an id"); !errors.Is(err, ErrInvalidId) {
+				t.Errorf("Validate() error = %v, want %v", err, ErrInvalidId)
+			}
+		})
+	}
+
+	if _, err := r.New(nil, GeneratorConfig{Format: "unknown"}); !errors.Is(err, ErrUnknownIdFormat) {
+		t.Errorf("New() error = %v, want %v", err, ErrUnknownIdFormat)
+	}
+	if _, err := r.New(nil, GeneratorConfig{Format: "snowflake", WorkerId: -1}); !errors.Is(err, ErrInvalidWorkerId) {
+		t.Errorf("New() error = %v, want %v", err, ErrInvalidWorkerId)
+	}
+	if err := r.Register(IdFormat{Name: "ULID", New: builtinIdFormats()[0].New, Validate: ValidateUlid}); !errors.Is(err, ErrDuplicateIdFormat) {
+		t.Errorf("Register() error = %v, want %v", err, ErrDuplicateIdFormat)
+	}
+}
@@ -0,0 +1,282 @@
+package demo_id_generator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SegmentAllocator 号段模式（Leaf-segment）的 ID 分配器，生成稠密、单调递增的数字 ID，
+// 适合作为订单表、地址表的主键。
+//
+//   - 每次从数据库中申请一段 ID（号段），之后在内存中分配，大大减少了数据库的访问次数。
+//   - 双 buffer：当前号段消耗到一定比例时，异步预取下一个号段，号段切换时不需要等待数据库。
+//   - 动态调整号段长度：号段消耗得太快（小于 SegmentDuration）时长度加倍，太慢（大于两倍 SegmentDuration）时长度减半，
+//     让每个号段大约可以使用 SegmentDuration 这么长的时间。号段的使用时长从切换到这个号段时开始计算，
+//     预取时号段还没有用完，按照已经消耗的比例估算整个号段的使用时长。
+
+const (
+	defaultPrefetchThreshold = 0.1
+	defaultSegmentDuration   = 15 * time.Minute
+	defaultMaxStep           = 1000000
+	defaultLoadTimeout       = 3 * time.Second
+)
+
+type SegmentOption func(*SegmentAllocator)
+
+// WithPrefetchThreshold 当前号段消耗的比例达到 threshold 时，开始预取下一个号段
+func WithPrefetchThreshold(threshold float64) SegmentOption {
+	return func(a *SegmentAllocator) {
+		a.prefetchThreshold = threshold
+	}
+}
+
+// WithStepRange 动态调整号段长度时，长度的上下限
+func WithStepRange(minStep, maxStep int64) SegmentOption {
+	return func(a *SegmentAllocator) {
+		a.minStep = minStep
+		a.maxStep = maxStep
+	}
+}
+
+// WithSegmentDuration 期望每个号段使用的时长
+func WithSegmentDuration(duration time.Duration) SegmentOption {
+	return func(a *SegmentAllocator) {
+		a.segmentDuration = duration
+	}
+}
+
+// WithSegmentClock 替换获取当前时间的函数，方便测试号段长度的调整
+func WithSegmentClock(now func() time.Time) SegmentOption {
+	return func(a *SegmentAllocator) {
+		a.now = now
+	}
+}
+
+// SegmentStats 一个业务标识的号段使用情况
+type SegmentStats struct {
+	Step    int64 `json:"step"`    // 最近一次申请的号段长度
+	Refills int64 `json:"refills"` // 从数据库申请号段的次数
+	Failed  int64 `json:"failed"`  // 申请号段失败的次数
+}
+
+type SegmentAllocator struct {
+	*zap.Logger
+
+	repo              SegmentRepository
+	prefetchThreshold float64
+	minStep           int64
+	maxStep           int64
+	segmentDuration   time.Duration
+	now               func() time.Time
+
+	mu      sync.Mutex
+	buffers map[string]*segmentBuffer
+}
+
+func NewSegmentAllocator(logger *zap.Logger, repo SegmentRepository, opts ...SegmentOption) *SegmentAllocator {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	a := &SegmentAllocator{
+		Logger:            logger,
+		repo:              repo,
+		prefetchThreshold: defaultPrefetchThreshold,
+		minStep:           1,
+		maxStep:           defaultMaxStep,
+		segmentDuration:   defaultSegmentDuration,
+		now:               time.Now,
+		buffers:           make(map[string]*segmentBuffer),
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// segment 内存中的号段，next 是下一个要分配的 ID
+type segment struct {
+	next int64
+	max  int64
+	step int64
+}
+
+func (s *segment) remaining() int64 {
+	return s.max - s.next + 1
+}
+
+// segmentBuffer 一个业务标识的双 buffer
+type segmentBuffer struct {
+	mu       sync.Mutex
+	bizTag   string
+	segments [2]*segment
+	current  int
+
+	nextReady  bool          // 另一个 buffer 是否已经装好了号段
+	loading    chan struct{} // 正在预取时不为 nil，预取结束后关闭
+	loadErr    error
+	step       int64     // 0 表示还没有申请过，使用数据库中配置的默认长度
+	switchTime time.Time // 切换到当前号段的时间
+	refills    int64
+	failed     int64
+}
+
+// NextId 为业务标识分配一个 ID
+func (a *SegmentAllocator) NextId(ctx context.Context, bizTag string) (int64, error) {
+	buf := a.buffer(bizTag)
+
+	buf.mu.Lock()
+	for {
+		cur := buf.segments[buf.current]
+
+		if !buf.nextReady && buf.loading == nil &&
+			float64(cur.step-cur.remaining()) >= a.prefetchThreshold*float64(cur.step) {
+			a.startLoading(buf)
+		}
+
+		if cur.remaining() > 0 {
+			id := cur.next
+			cur.next++
+			buf.mu.Unlock()
+			return id, nil
+		}
+
+		// 当前号段用完了，切换到另一个 buffer
+		if buf.nextReady {
+			buf.current = 1 - buf.current
+			buf.nextReady = false
+			buf.switchTime = a.now()
+			continue
+		}
+
+		loading := buf.loading
+		if loading == nil {
+			// 上一次预取失败了，重新申请
+			loading = a.startLoading(buf)
+		}
+		buf.mu.Unlock()
+
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+
+		buf.mu.Lock()
+		if !buf.nextReady && buf.loadErr != nil {
+			err := buf.loadErr
+			buf.mu.Unlock()
+			return 0, fmt.Errorf("load segment for %s: %w", bizTag, err)
+		}
+	}
+}
+
+// Generator 返回为指定业务标识生成 ID 的 Generator
+func (a *SegmentAllocator) Generator(bizTag string) Generator {
+	return GeneratorFunc(func(ctx context.Context) (string, error) {
+		id, err := a.NextId(ctx, bizTag)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(id), nil
+	})
+}
+
+// Stats 返回各个业务标识的号段使用情况
+func (a *SegmentAllocator) Stats() map[string]SegmentStats {
+	a.mu.Lock()
+	buffers := make([]*segmentBuffer, 0, len(a.buffers))
+	for _, buf := range a.buffers {
+		buffers = append(buffers, buf)
+	}
+	a.mu.Unlock()
+
+	stats := make(map[string]SegmentStats, len(buffers))
+	for _, buf := range buffers {
+		buf.mu.Lock()
+		stats[buf.bizTag] = SegmentStats{Step: buf.step, Refills: buf.refills, Failed: buf.failed}
+		buf.mu.Unlock()
+	}
+	return stats
+}
+
+func (a *SegmentAllocator) buffer(bizTag string) *segmentBuffer {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	buf, ok := a.buffers[bizTag]
+	if !ok {
+		// 两个 buffer 初始都是空的号段，第一次分配时会同步等待号段申请完成
+		buf = &segmentBuffer{
+			bizTag:   bizTag,
+			segments: [2]*segment{{next: 1}, {next: 1}},
+		}
+		a.buffers[bizTag] = buf
+	}
+	return buf
+}
+
+// startLoading 异步申请下一个号段，调用方需要持有 buf.mu
+func (a *SegmentAllocator) startLoading(buf *segmentBuffer) chan struct{} {
+	loading := make(chan struct{})
+	buf.loading = loading
+	buf.loadErr = nil
+	step := a.nextStep(buf)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultLoadTimeout)
+		defer cancel()
+
+		seg, err := a.repo.AllocSegment(ctx, buf.bizTag, step)
+
+		buf.mu.Lock()
+		defer buf.mu.Unlock()
+		defer close(loading)
+		buf.loading = nil
+
+		if err != nil {
+			buf.failed++
+			buf.loadErr = err
+			a.Error("alloc segment", zap.String("biz_tag", buf.bizTag), zap.Error(err))
+			return
+		}
+
+		buf.refills++
+		buf.step = seg.Step
+		buf.segments[1-buf.current] = &segment{next: seg.MaxId - seg.Step + 1, max: seg.MaxId, step: seg.Step}
+		buf.nextReady = true
+	}()
+
+	return loading
+}
+
+// nextStep 根据当前号段的使用时长调整号段长度，调用方需要持有 buf.mu。
+// 号段装好之后可能要等很久才切换过去，所以从切换时开始计时，而不是从申请到号段时开始
+func (a *SegmentAllocator) nextStep(buf *segmentBuffer) int64 {
+	cur := buf.segments[buf.current]
+	consumed := cur.step - cur.remaining()
+	if buf.step == 0 || consumed <= 0 {
+		return buf.step
+	}
+
+	step := buf.step
+	estimated := time.Duration(float64(a.now().Sub(buf.switchTime)) * float64(cur.step) / float64(consumed))
+	switch {
+	case estimated < a.segmentDuration && step*2 <= a.maxStep:
+		step *= 2
+	case estimated >= 2*a.segmentDuration && step/2 >= a.minStep:
+		step /= 2
+	}
+
+	if step != buf.step {
+		a.Info("adjust segment step", zap.String("biz_tag", buf.bizTag),
+			zap.Int64("from", buf.step), zap.Int64("to", step), zap.Duration("estimated", estimated))
+	}
+	return step
+}
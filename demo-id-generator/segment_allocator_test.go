@@ -0,0 +1,254 @@
+package demo_id_generator
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// memorySegmentRepository 记录每次申请的号段长度，err 不为 nil 时申请失败
+type memorySegmentRepository struct {
+	*MemorySegmentRepository
+
+	mu    sync.Mutex
+	calls []int64
+	err   error
+}
+
+func newMemorySegmentRepository(bizTag string, step int64) *memorySegmentRepository {
+	return &memorySegmentRepository{MemorySegmentRepository: NewMemorySegmentRepository(map[string]int64{bizTag: step})}
+}
+
+func (r *memorySegmentRepository) AllocSegment(ctx context.Context, bizTag string, step int64) (SegmentRange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return SegmentRange{}, r.err
+	}
+	segment, err := r.MemorySegmentRepository.AllocSegment(ctx, bizTag, step)
+	if err != nil {
+		return SegmentRange{}, err
+	}
+	r.calls = append(r.calls, segment.Step)
+	return segment, nil
+}
+
+func TestSegmentAllocator_NextId(t *testing.T) {
+	repo := newMemorySegmentRepository("order", 100)
+	allocator := NewSegmentAllocator(nil, repo, WithSegmentDuration(time.Hour), WithStepRange(10, 100))
+
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id, err := allocator.NextId(context.Background(), "order")
+				if err != nil {
+					t.Errorf("NextId() error = %v", err)
+					return
+				}
+				mu.Lock()
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// ID 是稠密的：1000 个 ID 恰好是 1 ~ 1000
+	for id := int64(1); id <= 1000; id++ {
+		if !seen[id] {
+			t.Fatalf("NextId() missing id %d, got %d ids", id, len(seen))
+		}
+	}
+
+	if got := allocator.Stats()["order"].Refills; got < 10 {
+		t.Errorf("Stats() refills = %d, want at least 10", got)
+	}
+}
+
+func TestSegmentAllocator_Prefetch(t *testing.T) {
+	repo := newMemorySegmentRepository("order", 10)
+	allocator := NewSegmentAllocator(nil, repo, WithPrefetchThreshold(0.5))
+
+	for i := 0; i < 6; i++ {
+		if _, err := allocator.NextId(context.Background(), "order"); err != nil {
+			t.Fatalf("NextId() error = %v", err)
+		}
+	}
+
+	// 当前号段消耗过半之后，下一个号段会在后台申请好
+	deadline := time.Now().Add(time.Second)
+	for allocator.Stats()["order"].Refills < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expect next segment to be prefetched")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSegmentAllocator_AdaptiveStep(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+
+	repo := newMemorySegmentRepository("order", 10)
+	allocator := NewSegmentAllocator(nil, repo,
+		WithPrefetchThreshold(1), WithSegmentDuration(time.Minute), WithStepRange(5, 20), WithSegmentClock(clock))
+
+	drain := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := allocator.NextId(context.Background(), "order"); err != nil {
+				t.Fatalf("NextId() error = %v", err)
+			}
+		}
+	}
+
+	drain(10) // 第一个号段使用数据库中的默认长度 10
+	drain(1)  // 1 分钟内用完，长度加倍为 20
+	now = now.Add(time.Hour)
+	drain(20) // 用了 1 小时，长度减半为 10
+	drain(1)
+
+	want := []int64{0, 20, 10}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if len(repo.calls) != len(want) {
+		t.Fatalf("AllocSegment() steps = %v, want %v", repo.calls, want)
+	}
+	for i := 1; i < len(want); i++ {
+		if repo.calls[i] != want[i] {
+			t.Errorf("AllocSegment() steps = %v, want %v", repo.calls, want)
+		}
+	}
+}
+
+func TestSegmentAllocator_AdaptiveStepAfterIdle(t *testing.T) {
+	now := time.Unix(0, 0)
+	var mu sync.Mutex
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	repo := newMemorySegmentRepository("order", 10)
+	allocator := NewSegmentAllocator(nil, repo,
+		WithPrefetchThreshold(0.5), WithSegmentDuration(time.Minute), WithStepRange(5, 100), WithSegmentClock(clock))
+	drain := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := allocator.NextId(context.Background(), "order"); err != nil {
+				t.Fatalf("NextId() error = %v", err)
+			}
+		}
+	}
+	waitRefills := func(n int64) {
+		deadline := time.Now().Add(time.Second)
+		for allocator.Stats()["order"].Refills < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("Stats() refills = %d, want %d", allocator.Stats()["order"].Refills, n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	drain(6) // 消耗过半，预取长度为 20 的号段
+	waitRefills(2)
+	// 预取的号段空闲了 1 小时才切换过去，切换之后很快消耗过半，长度继续加倍
+	mu.Lock()
+	now = now.Add(time.Hour)
+	mu.Unlock()
+	drain(4 + 11)
+	waitRefills(3)
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if want := []int64{10, 20, 40}; !reflect.DeepEqual(repo.calls, want) {
+		t.Errorf("AllocSegment() steps = %v, want %v", repo.calls, want)
+	}
+}
+
+func TestSegmentAllocator_LoadError(t *testing.T) {
+	repo := newMemorySegmentRepository("order", 10)
+	repo.err = errors.New("db down")
+	allocator := NewSegmentAllocator(nil, repo)
+
+	if _, err := allocator.NextId(context.Background(), "order"); !errors.Is(err, repo.err) {
+		t.Fatalf("NextId() error = %v, want %v", err, repo.err)
+	}
+
+	repo.mu.Lock()
+	repo.err = nil
+	repo.mu.Unlock()
+
+	if id, err := allocator.NextId(context.Background(), "order"); err != nil || id != 1 {
+		t.Errorf("NextId() after recovery = %v, %v, want 1", id, err)
+	}
+	if _, err := allocator.NextId(context.Background(), "address"); !errors.Is(err, ErrUnknownBizTag) {
+		t.Errorf("NextId() error = %v, want %v", err, ErrUnknownBizTag)
+	}
+}
+
+func TestSQLSegmentRepository_AllocSegment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	update := regexp.QuoteMeta("UPDATE id_segment SET max_id = max_id + step, update_time = ? WHERE biz_tag = ?")
+	updateStep := regexp.QuoteMeta("UPDATE id_segment SET max_id = max_id + ?, update_time = ? WHERE biz_tag = ?")
+	query := regexp.QuoteMeta("SELECT max_id, step FROM id_segment WHERE biz_tag = ?")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(update).WithArgs(sqlmock.AnyArg(), "order").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(query).WithArgs("order").WillReturnRows(sqlmock.NewRows([]string{"max_id", "step"}).AddRow(1000, 1000))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(updateStep).WithArgs(2000, sqlmock.AnyArg(), "order").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(query).WithArgs("order").WillReturnRows(sqlmock.NewRows([]string{"max_id", "step"}).AddRow(3000, 1000))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(update).WithArgs(sqlmock.AnyArg(), "address").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	repo := NewSQLSegmentRepository(db)
+	tests := []struct {
+		name    string
+		bizTag  string
+		step    int64
+		want    SegmentRange
+		wantErr error
+	}{
+		{"default step", "order", 0, SegmentRange{MaxId: 1000, Step: 1000}, nil},
+		{"custom step", "order", 2000, SegmentRange{MaxId: 3000, Step: 2000}, nil},
+		{"unknown tag", "address", 0, SegmentRange{}, ErrUnknownBizTag},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.AllocSegment(context.Background(), tt.bizTag, tt.step)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AllocSegment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("AllocSegment() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,132 @@
+package demo_id_generator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SegmentTableSchema 号段表的建表语句（MySQL），每个业务标识（如 order、address）一行，
+// max_id 是已经分配出去的最大 ID，新的业务标识从 0 开始，第一个号段是 [1, step]；step 是默认的号段长度。
+const SegmentTableSchema = `CREATE TABLE IF NOT EXISTS id_segment (
+	biz_tag     VARCHAR(128) NOT NULL,
+	max_id      BIGINT       NOT NULL DEFAULT 0,
+	step        INT          NOT NULL,
+	update_time TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (biz_tag)
+)`
+
+var ErrUnknownBizTag = errors.New("unknown biz tag")
+
+// SegmentRange 从数据库中申请到的号段 (MaxId-Step, MaxId]
+type SegmentRange struct {
+	MaxId int64
+	Step  int64
+}
+
+// SegmentRepository 号段的持久化，SegmentAllocator 基于接口编程，方便替换存储和编写单元测试
+type SegmentRepository interface {
+	// AllocSegment 为业务标识申请一个新的号段，step 小于等于 0 时使用数据库中配置的默认长度
+	AllocSegment(ctx context.Context, bizTag string, step int64) (SegmentRange, error)
+}
+
+var (
+	_ SegmentRepository = (*SQLSegmentRepository)(nil)
+	_ SegmentRepository = (*MemorySegmentRepository)(nil)
+)
+
+// SQLSegmentRepository 基于 database/sql 的号段存储。
+// 在同一个事务中先更新 max_id 再读取，依靠数据库的行锁保证多个实例申请到的号段不重叠。
+type SQLSegmentRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+func NewSQLSegmentRepository(db *sql.DB) *SQLSegmentRepository {
+	return &SQLSegmentRepository{db: db, now: time.Now}
+}
+
+// Migrate 创建号段表
+func (r *SQLSegmentRepository) Migrate(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, SegmentTableSchema)
+	return err
+}
+
+func (r *SQLSegmentRepository) AllocSegment(ctx context.Context, bizTag string, step int64) (segment SegmentRange, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return SegmentRange{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var result sql.Result
+	if step > 0 {
+		result, err = tx.ExecContext(ctx,
+			"UPDATE id_segment SET max_id = max_id + ?, update_time = ? WHERE biz_tag = ?", step, r.now(), bizTag)
+	} else {
+		result, err = tx.ExecContext(ctx,
+			"UPDATE id_segment SET max_id = max_id + step, update_time = ? WHERE biz_tag = ?", r.now(), bizTag)
+	}
+	if err != nil {
+		return SegmentRange{}, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return SegmentRange{}, err
+	}
+	if affected == 0 {
+		return SegmentRange{}, fmt.Errorf("%w: %s", ErrUnknownBizTag, bizTag)
+	}
+
+	var defaultStep int64
+	err = tx.QueryRowContext(ctx, "SELECT max_id, step FROM id_segment WHERE biz_tag = ?", bizTag).
+		Scan(&segment.MaxId, &defaultStep)
+	if err != nil {
+		return SegmentRange{}, err
+	}
+
+	segment.Step = step
+	if step <= 0 {
+		segment.Step = defaultStep
+	}
+
+	return segment, tx.Commit()
+}
+
+// MemorySegmentRepository 内存中的号段存储，进程重启之后 ID 从 1 开始重新分配，只能用于演示和测试
+type MemorySegmentRepository struct {
+	mu     sync.Mutex
+	maxIds map[string]int64
+	steps  map[string]int64
+}
+
+// NewMemorySegmentRepository steps 是每个业务标识默认的号段长度
+func NewMemorySegmentRepository(steps map[string]int64) *MemorySegmentRepository {
+	r := &MemorySegmentRepository{maxIds: make(map[string]int64, len(steps)), steps: make(map[string]int64, len(steps))}
+	for bizTag, step := range steps {
+		r.maxIds[bizTag], r.steps[bizTag] = 0, step
+	}
+	return r
+}
+
+func (r *MemorySegmentRepository) AllocSegment(ctx context.Context, bizTag string, step int64) (SegmentRange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.maxIds[bizTag]; !ok {
+		return SegmentRange{}, fmt.Errorf("%w: %s", ErrUnknownBizTag, bizTag)
+	}
+	if step <= 0 {
+		step = r.steps[bizTag]
+	}
+	r.maxIds[bizTag] += step
+	return SegmentRange{MaxId: r.maxIds[bizTag], Step: step}, nil
+}
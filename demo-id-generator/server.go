@@ -0,0 +1,228 @@
+package demo_id_generator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// IdServer 把 ID 生成器暴露为 HTTP 服务：
+//
+//	GET /id?tag=order&count=N  按业务标识生成一批 ID
+//	GET /health                健康检查
+//	GET /stats                 各个业务标识生成、失败的 ID 数量，时钟回拨次数，号段申请次数
+
+const (
+	defaultBatchCount = 1
+	// MaxBatchCount 一次请求最多生成的 ID 数量
+	MaxBatchCount = 1000
+)
+
+// ClockRollbackCounter 依赖机器时钟的生成器实现该接口，在 /stats 中输出时钟回拨的次数
+type ClockRollbackCounter interface {
+	ClockRollbacks() int64
+}
+
+// BatchGenerator 一次生成一批 ID
+type BatchGenerator interface {
+	GenerateBatch(ctx context.Context, count int) ([]string, error)
+}
+
+// Batch 把 Generator 适配为 BatchGenerator，任何一个 ID 生成失败时返回错误
+func Batch(g Generator) BatchGenerator {
+	return batchGenerator{g}
+}
+
+type batchGenerator struct {
+	Generator
+}
+
+func (b batchGenerator) GenerateBatch(ctx context.Context, count int) ([]string, error) {
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		id, err := b.Generate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// IdBatch /id 接口的响应
+type IdBatch struct {
+	Tag string   `json:"tag"`
+	Ids []string `json:"ids"`
+}
+
+// ServerStats /stats 接口的响应
+type ServerStats struct {
+	Issued         map[string]int64        `json:"issued"`
+	Failed         map[string]int64        `json:"failed"`
+	ClockRollbacks map[string]int64        `json:"clock_rollbacks"`
+	Segments       map[string]SegmentStats `json:"segments"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// tagEntry 一个业务标识对应的生成器和计数
+type tagEntry struct {
+	generator Generator
+	issued    int64
+	failed    int64
+}
+
+var _ http.Handler = (*IdServer)(nil)
+
+type IdServer struct {
+	*zap.Logger
+
+	mu         sync.RWMutex
+	tags       map[string]*tagEntry
+	allocators []*SegmentAllocator
+	mux        *http.ServeMux
+}
+
+func NewIdServer(logger *zap.Logger) *IdServer {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	s := &IdServer{Logger: logger, tags: make(map[string]*tagEntry), mux: http.NewServeMux()}
+	s.mux.HandleFunc("/id", s.handleId)
+	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.HandleFunc("/stats", s.handleStats)
+	return s
+}
+
+// Handle 为业务标识注册生成器，重复注册时替换原来的生成器
+func (s *IdServer) Handle(tag string, g Generator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags[tag] = &tagEntry{generator: g}
+}
+
+// HandleSegments 为多个业务标识注册号段模式的生成器，并在 /stats 中输出号段的申请情况
+func (s *IdServer) HandleSegments(allocator *SegmentAllocator, tags ...string) {
+	for _, tag := range tags {
+		s.Handle(tag, allocator.Generator(tag))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allocators = append(s.allocators, allocator)
+}
+
+// Tags 返回已经注册的业务标识，按字母排序
+func (s *IdServer) Tags() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tags := make([]string, 0, len(s.tags))
+	for tag := range s.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Stats 返回服务的统计信息
+func (s *IdServer) Stats() ServerStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := ServerStats{
+		Issued:         make(map[string]int64, len(s.tags)),
+		Failed:         make(map[string]int64, len(s.tags)),
+		ClockRollbacks: make(map[string]int64),
+		Segments:       make(map[string]SegmentStats),
+	}
+	for tag, entry := range s.tags {
+		stats.Issued[tag] = atomic.LoadInt64(&entry.issued)
+		stats.Failed[tag] = atomic.LoadInt64(&entry.failed)
+		if counter, ok := entry.generator.(ClockRollbackCounter); ok {
+			stats.ClockRollbacks[tag] = counter.ClockRollbacks()
+		}
+	}
+	for _, allocator := range s.allocators {
+		for tag, segment := range allocator.Stats() {
+			stats.Segments[tag] = segment
+		}
+	}
+	return stats
+}
+
+func (s *IdServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *IdServer) handleId(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+
+	query := r.URL.Query()
+	tag := query.Get("tag")
+	count, err := parseBatchCount(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	s.mu.RLock()
+	entry, ok := s.tags[tag]
+	s.mu.RUnlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown tag: " + tag})
+		return
+	}
+
+	ids, err := Batch(entry.generator).GenerateBatch(r.Context(), count)
+	if err != nil {
+		atomic.AddInt64(&entry.failed, 1)
+		s.Error("generate ids", zap.String("tag", tag), zap.Int("count", count), zap.Error(err))
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+		return
+	}
+
+	atomic.AddInt64(&entry.issued, int64(len(ids)))
+	writeJSON(w, http.StatusOK, IdBatch{Tag: tag, Ids: ids})
+}
+
+func (s *IdServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *IdServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Stats())
+}
+
+func parseBatchCount(query url.Values) (int, error) {
+	value := query.Get("count")
+	if value == "" {
+		return defaultBatchCount, nil
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 || count > MaxBatchCount {
+		return 0, errors.New("count should be an integer between 1 and " + strconv.Itoa(MaxBatchCount))
+	}
+	return count, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,80 @@
+package demo_id_generator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIdServer_Id(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	snowflake, _ := NewSnowflakeGenerator(nil, 1, WithClock(clock.Now, clock.Add), WithClockRollbackPolicy(RollbackFail, 0))
+	failing := GeneratorFunc(func(ctx context.Context) (string, error) { return "", errors.New("broken") })
+
+	server := NewIdServer(nil)
+	server.Handle("order", snowflake)
+	server.Handle("broken", failing)
+	server.HandleSegments(NewSegmentAllocator(nil, newMemorySegmentRepository("address", 10)), "address")
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantIds    int
+	}{
+		{"default count", "/id?tag=order", http.StatusOK, 1},
+		{"batch", "/id?tag=order&count=5", http.StatusOK, 5},
+		{"segment", "/id?tag=address&count=15", http.StatusOK, 15},
+		{"unknown tag", "/id?tag=user", http.StatusNotFound, 0},
+		{"invalid count", "/id?tag=order&count=0", http.StatusBadRequest, 0},
+		{"count too large", "/id?tag=order&count=1001", http.StatusBadRequest, 0},
+		{"generator error", "/id?tag=broken", http.StatusServiceUnavailable, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v, body = %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			var batch IdBatch
+			_ = json.NewDecoder(w.Body).Decode(&batch)
+			if len(batch.Ids) != tt.wantIds {
+				t.Errorf("got %d ids, want %d", len(batch.Ids), tt.wantIds)
+			}
+		})
+	}
+
+	clock.Add(-time.Second)
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/id?tag=order", nil))
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var stats ServerStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode stats error = %v", err)
+	}
+	if stats.Issued["order"] != 6 || stats.Failed["order"] != 1 || stats.Failed["broken"] != 1 {
+		t.Errorf("stats issued = %v, failed = %v", stats.Issued, stats.Failed)
+	}
+	if stats.ClockRollbacks["order"] != 1 {
+		t.Errorf("stats clock rollbacks = %v, want 1", stats.ClockRollbacks)
+	}
+	if stats.Segments["address"].Refills < 2 {
+		t.Errorf("stats segments = %v, want at least 2 refills", stats.Segments)
+	}
+}
+
+func TestIdServer_Health(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewIdServer(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+}
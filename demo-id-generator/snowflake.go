@@ -0,0 +1,252 @@
+package demo_id_generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrClockMovedBackwards = errors.New("clock moved backwards")
+	ErrInvalidLayout       = errors.New("invalid snowflake layout")
+	ErrInvalidWorkerId     = errors.New("worker id out of range")
+	// ErrTimestampOverflow 时间戳超出了位分配方案的范围，包装在 SequenceExhaustedError 中返回
+	ErrTimestampOverflow = errors.New("timestamp overflows the snowflake layout")
+)
+
+// SnowflakeLayout ID 的位分配方案，三部分加起来不能超过 63 位
+type SnowflakeLayout struct {
+	Epoch         time.Time
+	TimestampBits uint
+	WorkerBits    uint
+	SequenceBits  uint
+}
+
+// DefaultSnowflakeLayout 41 位时间戳可以使用约 69 年，10 位机器 ID 支持 1024 个实例，
+// 12 位序列号每毫秒可以生成 4096 个 ID。
+var DefaultSnowflakeLayout = SnowflakeLayout{
+	Epoch:         time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	TimestampBits: 41,
+	WorkerBits:    10,
+	SequenceBits:  12,
+}
+
+func (l SnowflakeLayout) validate() error {
+	if l.TimestampBits == 0 || l.SequenceBits == 0 || l.TimestampBits+l.WorkerBits+l.SequenceBits > 63 {
+		return ErrInvalidLayout
+	}
+	return nil
+}
+
+func (l SnowflakeLayout) maxTimestamp() int64 { return int64(1)<<l.TimestampBits - 1 }
+func (l SnowflakeLayout) maxWorkerId() int64  { return int64(1)<<l.WorkerBits - 1 }
+func (l SnowflakeLayout) maxSequence() int64  { return int64(1)<<l.SequenceBits - 1 }
+
+// SnowflakeId 解码后的 ID 各个组成部分
+type SnowflakeId struct {
+	Time     time.Time
+	WorkerId int64
+	Sequence int64
+}
+
+// Parse 按照位分配方案解码 ID
+func (l SnowflakeLayout) Parse(id int64) SnowflakeId {
+	millis := id >> (l.WorkerBits + l.SequenceBits)
+	return SnowflakeId{
+		Time:     l.Epoch.Add(time.Duration(millis) * time.Millisecond),
+		WorkerId: (id >> l.SequenceBits) & l.maxWorkerId(),
+		Sequence: id & l.maxSequence(),
+	}
+}
+
+// ParseString 解码 Generate 返回的十进制字符串形式的 ID
+func (l SnowflakeLayout) ParseString(id string) (SnowflakeId, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n < 0 {
+		return SnowflakeId{}, fmt.Errorf("invalid snowflake id %q", id)
+	}
+	return l.Parse(n), nil
+}
+
+// ClockRollbackPolicy 时钟回拨时的处理策略
+type ClockRollbackPolicy int
+
+const (
+	// RollbackWait 回拨幅度不超过 maxWait 时，等待时钟追上，否则返回错误
+	RollbackWait ClockRollbackPolicy = iota
+	// RollbackFail 直接返回错误
+	RollbackFail
+)
+
+type SnowflakeOption func(*SnowflakeGenerator)
+
+func WithSnowflakeLayout(layout SnowflakeLayout) SnowflakeOption {
+	return func(g *SnowflakeGenerator) {
+		g.layout = layout
+	}
+}
+
+func WithClockRollbackPolicy(policy ClockRollbackPolicy, maxWait time.Duration) SnowflakeOption {
+	return func(g *SnowflakeGenerator) {
+		g.rollbackPolicy = policy
+		g.maxRollbackWait = maxWait
+	}
+}
+
+// WithClock 替换获取当前时间和等待的函数，方便在单元测试中模拟时钟回拨
+func WithClock(now func() time.Time, sleep func(time.Duration)) SnowflakeOption {
+	return func(g *SnowflakeGenerator) {
+		g.now = now
+		g.sleep = sleep
+	}
+}
+
+var _ Generator = (*SnowflakeGenerator)(nil)
+
+// SnowflakeGenerator 雪花算法的分布式 ID 生成器。
+// 生成的 ID 是一个 63 位的正整数，从高位到低位依次是：时间戳（相对于 Epoch 的毫秒数）、机器 ID、序列号。
+// 与 UUID 相比，ID 更短、按生成时间递增，适合作为数据库的主键。
+//
+// 同一毫秒内序列号用完时，自旋等待到下一毫秒；
+// 发现时钟回拨时，按照 ClockRollbackPolicy 的配置等待时钟追上，或者直接返回错误。
+type SnowflakeGenerator struct {
+	*zap.Logger
+
+	layout          SnowflakeLayout
+	workerId        int64
+	rollbackPolicy  ClockRollbackPolicy
+	maxRollbackWait time.Duration
+	now             func() time.Time
+	sleep           func(time.Duration)
+
+	mu             sync.Mutex
+	lastTimestamp  int64
+	sequence       int64
+	clockRollbacks int64
+}
+
+func NewSnowflakeGenerator(logger *zap.Logger, workerId int64, opts ...SnowflakeOption) (*SnowflakeGenerator, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	g := &SnowflakeGenerator{
+		Logger:          logger,
+		layout:          DefaultSnowflakeLayout,
+		workerId:        workerId,
+		rollbackPolicy:  RollbackWait,
+		maxRollbackWait: 5 * time.Millisecond,
+		now:             time.Now,
+		sleep:           time.Sleep,
+		lastTimestamp:   -1,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	if err := g.layout.validate(); err != nil {
+		return nil, err
+	}
+	if workerId < 0 || workerId > g.layout.maxWorkerId() {
+		return nil, ErrInvalidWorkerId
+	}
+
+	return g, nil
+}
+
+// Layout 返回生成器使用的位分配方案，用于解码 ID
+func (g *SnowflakeGenerator) Layout() SnowflakeLayout {
+	return g.layout
+}
+
+// ClockRollbacks 返回发现时钟回拨的次数，包括等待时钟追上之后成功生成 ID 的情况
+func (g *SnowflakeGenerator) ClockRollbacks() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.clockRollbacks
+}
+
+// Generate 生成十进制字符串形式的 ID
+func (g *SnowflakeGenerator) Generate(ctx context.Context) (string, error) {
+	id, err := g.NextId(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(id, 10), nil
+}
+
+// NextId 生成一个新的 ID，等待时钟追上或者等待下一毫秒的过程中，ctx 结束时返回错误
+func (g *SnowflakeGenerator) NextId(ctx context.Context) (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	timestamp := g.currentMillis()
+	if timestamp < g.lastTimestamp {
+		g.clockRollbacks++
+		var err error
+		if timestamp, err = g.waitForRollback(ctx, timestamp); err != nil {
+			g.Error("clock moved backwards", zap.Error(err))
+			return 0, err
+		}
+	}
+
+	if timestamp == g.lastTimestamp {
+		g.sequence = (g.sequence + 1) & g.layout.maxSequence()
+		if g.sequence == 0 {
+			// 当前毫秒的序列号用完了，自旋等待到下一毫秒；自旋过程中时钟回拨时按照回拨策略处理，不在回拨期间一直自旋
+			for timestamp <= g.lastTimestamp {
+				if err := isCanceled(ctx); err != nil {
+					// 保持序列号为已用完的状态，避免下次在同一毫秒内生成重复的 ID
+					g.sequence = g.layout.maxSequence()
+					return 0, &SequenceExhaustedError{Reason: "no sequence left in current millisecond: " + err.Error()}
+				}
+				if timestamp = g.currentMillis(); timestamp < g.lastTimestamp {
+					g.clockRollbacks++
+					var err error
+					if timestamp, err = g.waitForRollback(ctx, timestamp); err != nil {
+						g.sequence = g.layout.maxSequence()
+						g.Error("clock moved backwards", zap.Error(err))
+						return 0, err
+					}
+				}
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+
+	if timestamp < 0 || timestamp > g.layout.maxTimestamp() {
+		return 0, &SequenceExhaustedError{Reason: ErrTimestampOverflow.Error(), Err: ErrTimestampOverflow}
+	}
+
+	g.lastTimestamp = timestamp
+	return timestamp<<(g.layout.WorkerBits+g.layout.SequenceBits) |
+		g.workerId<<g.layout.SequenceBits |
+		g.sequence, nil
+}
+
+func (g *SnowflakeGenerator) waitForRollback(ctx context.Context, timestamp int64) (int64, error) {
+	behind := time.Duration(g.lastTimestamp-timestamp) * time.Millisecond
+	if g.rollbackPolicy == RollbackFail || behind > g.maxRollbackWait || isCanceled(ctx) != nil {
+		return 0, &ClockRegressionError{Behind: behind}
+	}
+
+	g.Warn("clock moved backwards, waiting for it to catch up", zap.Duration("behind", behind))
+	g.sleep(behind)
+	timestamp = g.currentMillis()
+	if timestamp < g.lastTimestamp {
+		return 0, &ClockRegressionError{Behind: time.Duration(g.lastTimestamp-timestamp) * time.Millisecond}
+	}
+	return timestamp, nil
+}
+
+func (g *SnowflakeGenerator) currentMillis() int64 {
+	return int64(g.now().Sub(g.layout.Epoch) / time.Millisecond)
+}
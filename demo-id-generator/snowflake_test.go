@@ -0,0 +1,202 @@
+package demo_id_generator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock 可以手动拨动的时钟，sleep 会直接把时钟往前拨
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestSnowflakeGenerator_NextId(t *testing.T) {
+	clock := &fakeClock{now: DefaultSnowflakeLayout.Epoch.Add(time.Hour)}
+	g, err := NewSnowflakeGenerator(nil, 7, WithClock(clock.Now, clock.Add))
+	if err != nil {
+		t.Fatalf("NewSnowflakeGenerator() error = %v", err)
+	}
+
+	first, _ := g.NextId(context.Background())
+	second, _ := g.NextId(context.Background())
+	if second <= first {
+		t.Errorf("ids should be increasing, got %d then %d", first, second)
+	}
+
+	got := g.Layout().Parse(second)
+	want := SnowflakeId{Time: clock.Now(), WorkerId: 7, Sequence: 1}
+	if !got.Time.Equal(want.Time) || got.WorkerId != want.WorkerId || got.Sequence != want.Sequence {
+		t.Errorf("Parse() got = %+v, want %+v", got, want)
+	}
+
+	parsed, err := g.Layout().ParseString(mustGenerate(t, g))
+	if err != nil || parsed.Sequence != 2 {
+		t.Errorf("ParseString() got = %+v, %v", parsed, err)
+	}
+}
+
+func mustGenerate(t *testing.T, g Generator) string {
+	id, err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	return id
+}
+
+func TestSnowflakeGenerator_SequenceOverflow(t *testing.T) {
+	layout := SnowflakeLayout{Epoch: time.Unix(0, 0), TimestampBits: 41, WorkerBits: 2, SequenceBits: 2}
+	start := time.Unix(100, 0)
+	calls := 0
+	// 每调用 10 次时钟才前进 1 毫秒，模拟同一毫秒内的大量请求
+	now := func() time.Time {
+		calls++
+		return start.Add(time.Duration(calls/10) * time.Millisecond)
+	}
+
+	g, err := NewSnowflakeGenerator(nil, 1, WithSnowflakeLayout(layout), WithClock(now, func(time.Duration) {}))
+	if err != nil {
+		t.Fatalf("NewSnowflakeGenerator() error = %v", err)
+	}
+
+	seen := make(map[int64]bool)
+	last := int64(-1)
+	for i := 0; i < 20; i++ {
+		id, err := g.NextId(context.Background())
+		if err != nil {
+			t.Fatalf("NextId() error = %v", err)
+		}
+		if seen[id] || id <= last {
+			t.Fatalf("NextId() got duplicated or decreasing id %d", id)
+		}
+		if seq := layout.Parse(id).Sequence; seq > 3 {
+			t.Fatalf("sequence %d overflows the layout", seq)
+		}
+		seen[id] = true
+		last = id
+	}
+}
+
+func TestSnowflakeGenerator_ClockRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ClockRollbackPolicy
+		maxWait  time.Duration
+		rollback time.Duration
+		wantErr  bool
+	}{
+		{"wait within limit", RollbackWait, 10 * time.Millisecond, 5 * time.Millisecond, false},
+		{"wait exceeds limit", RollbackWait, 10 * time.Millisecond, time.Second, true},
+		{"fail", RollbackFail, 10 * time.Millisecond, time.Millisecond, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Now()}
+			g, err := NewSnowflakeGenerator(nil, 1,
+				WithClock(clock.Now, clock.Add),
+				WithClockRollbackPolicy(tt.policy, tt.maxWait))
+			if err != nil {
+				t.Fatalf("NewSnowflakeGenerator() error = %v", err)
+			}
+
+			before, _ := g.NextId(context.Background())
+			clock.Add(-tt.rollback)
+			after, err := g.NextId(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NextId() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var regression *ClockRegressionError
+			if err != nil && (!errors.Is(err, ErrClockMovedBackwards) || !errors.As(err, &regression)) {
+				t.Errorf("NextId() error = %v, want %v", err, ErrClockMovedBackwards)
+			}
+			if err == nil && after <= before {
+				t.Errorf("NextId() got %d after %d", after, before)
+			}
+		})
+	}
+}
+
+func TestNewSnowflakeGenerator(t *testing.T) {
+	if _, err := NewSnowflakeGenerator(nil, 1024); err != ErrInvalidWorkerId {
+		t.Errorf("NewSnowflakeGenerator() error = %v, want %v", err, ErrInvalidWorkerId)
+	}
+
+	layout := SnowflakeLayout{TimestampBits: 41, WorkerBits: 12, SequenceBits: 12}
+	if _, err := NewSnowflakeGenerator(nil, 1, WithSnowflakeLayout(layout)); err != ErrInvalidLayout {
+		t.Errorf("NewSnowflakeGenerator() error = %v, want %v", err, ErrInvalidLayout)
+	}
+}
+
+func TestSnowflakeGenerator_SequenceExhausted(t *testing.T) {
+	layout := SnowflakeLayout{Epoch: time.Unix(0, 0), TimestampBits: 41, WorkerBits: 2, SequenceBits: 1}
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	g, _ := NewSnowflakeGenerator(nil, 1, WithSnowflakeLayout(layout), WithClock(clock.Now, clock.Add))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// 时钟不走，第 3 次生成时序列号用完，ctx 已经结束，不会一直自旋
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		_, err = g.NextId(ctx)
+	}
+
+	var exhausted *SequenceExhaustedError
+	if !errors.As(err, &exhausted) {
+		t.Errorf("NextId() error = %v, want SequenceExhaustedError", err)
+	}
+}
+
+func TestSnowflakeGenerator_RollbackWhileSpinning(t *testing.T) {
+	layout := SnowflakeLayout{Epoch: time.Unix(0, 0), TimestampBits: 41, WorkerBits: 2, SequenceBits: 1}
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	calls := 0
+	// 前 3 次生成都在同一毫秒，第 3 次序列号用完之后自旋时时钟回拨了 1 秒
+	now := func() time.Time {
+		calls++
+		if calls == 4 {
+			clock.Add(-time.Second)
+		}
+		return clock.Now()
+	}
+	g, _ := NewSnowflakeGenerator(nil, 1, WithSnowflakeLayout(layout), WithClock(now, clock.Add),
+		WithClockRollbackPolicy(RollbackWait, 5*time.Millisecond))
+
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		_, err = g.NextId(context.Background())
+	}
+	if !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("NextId() error = %v, want %v", err, ErrClockMovedBackwards)
+	}
+	if got := g.ClockRollbacks(); got != 1 {
+		t.Errorf("ClockRollbacks() got = %d, want 1", got)
+	}
+}
+
+func TestSnowflakeGenerator_TimestampOverflow(t *testing.T) {
+	layout := SnowflakeLayout{Epoch: time.Unix(0, 0), TimestampBits: 8, WorkerBits: 2, SequenceBits: 2}
+	clock := &fakeClock{now: time.Unix(1, 0)}
+	g, _ := NewSnowflakeGenerator(nil, 1, WithSnowflakeLayout(layout), WithClock(clock.Now, clock.Add))
+
+	var exhausted *SequenceExhaustedError
+	if _, err := g.NextId(context.Background()); !errors.Is(err, ErrTimestampOverflow) || !errors.As(err, &exhausted) {
+		t.Errorf("NextId() error = %v, want %v", err, ErrTimestampOverflow)
+	}
+}
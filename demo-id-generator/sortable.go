@@ -0,0 +1,94 @@
+package demo_id_generator
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ULID、KSUID 都是“时间戳 + 随机数”的结构，编码后的字符串按字典序排序即按生成时间排序。
+// 默认情况下同一时间单位内的随机部分互相独立，之间的顺序是不确定的；
+// 开启严格单调模式后，同一时间单位内在上一个 ID 的随机部分上加 1，保证 ID 严格递增。
+
+// ErrInvalidId ID 的格式不正确
+var ErrInvalidId = errors.New("invalid id")
+
+type SortableOption func(*sortableClock)
+
+// WithStrictMonotonic 开启严格单调模式
+func WithStrictMonotonic() SortableOption {
+	return func(c *sortableClock) {
+		c.monotonic = true
+	}
+}
+
+// WithSortableClock 替换获取当前时间的函数
+func WithSortableClock(now func() time.Time) SortableOption {
+	return func(c *sortableClock) {
+		c.now = now
+	}
+}
+
+// WithSortableEntropy 替换随机数的来源
+func WithSortableEntropy(random io.Reader) SortableOption {
+	return func(c *sortableClock) {
+		c.random = random
+	}
+}
+
+// sortableClock 生成时间戳和随机部分，记录上一个 ID 以支持严格单调模式
+type sortableClock struct {
+	monotonic bool
+	now       func() time.Time
+	random    io.Reader
+
+	mu          sync.Mutex
+	lastUnit    int64
+	lastEntropy []byte
+}
+
+func newSortableClock(opts []SortableOption) *sortableClock {
+	c := &sortableClock{now: time.Now, random: rand.Reader, lastUnit: -1}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// next 根据当前的时间单位 unit 填充随机部分 entropy，返回 ID 实际使用的时间单位。
+// 严格单调模式下，时钟回拨时沿用上一个 ID 的时间单位，保证不会生成更小的 ID。
+func (c *sortableClock) next(unit int64, entropy []byte) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.monotonic && unit <= c.lastUnit {
+		copy(entropy, c.lastEntropy)
+		if !incrementBytes(entropy) {
+			return 0, &SequenceExhaustedError{Reason: "random part overflows in current time unit"}
+		}
+		copy(c.lastEntropy, entropy)
+		return c.lastUnit, nil
+	}
+
+	if _, err := io.ReadFull(c.random, entropy); err != nil {
+		return 0, &EntropyError{Err: err}
+	}
+	if c.monotonic {
+		c.lastUnit = unit
+		c.lastEntropy = append(c.lastEntropy[:0], entropy...)
+	}
+	return unit, nil
+}
+
+// incrementBytes 把大端序的字节数组当作一个整数加 1，溢出时返回 false
+func incrementBytes(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
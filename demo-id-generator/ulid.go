@@ -0,0 +1,148 @@
+package demo_id_generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// UlidGenerator 生成 ULID（Universally Unique Lexicographically Sortable Identifier）：
+// 48 位毫秒时间戳 + 80 位随机数，使用 Crockford Base32 编码为 26 个字符，
+// 按字典序排序即按生成时间排序，适合作为日志的 ID。
+
+const (
+	ulidLength       = 26
+	ulidAlphabet     = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	ulidMaxTimestamp = int64(1)<<48 - 1
+)
+
+// ulidDecoding Crockford Base32 的解码表，不区分大小写，非法字符为 0xFF
+var ulidDecoding = func() [256]byte {
+	var table [256]byte
+	for i := range table {
+		table[i] = 0xFF
+	}
+	for i := 0; i < len(ulidAlphabet); i++ {
+		table[ulidAlphabet[i]] = byte(i)
+		table[ulidAlphabet[i]|0x20] = byte(i)
+	}
+	return table
+}()
+
+// Ulid 16 字节的 ULID，前 6 个字节是时间戳，后 10 个字节是随机数
+type Ulid [16]byte
+
+// Time 返回 ULID 的生成时间
+func (u Ulid) Time() time.Time {
+	var millis int64
+	for _, b := range u[:6] {
+		millis = millis<<8 | int64(b)
+	}
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+// Entropy 返回 ULID 的随机部分
+func (u Ulid) Entropy() []byte {
+	return append([]byte(nil), u[6:]...)
+}
+
+// String 编码为 26 个字符，从最低位开始每 5 位编码为一个字符，最高位的字符只有 3 位
+func (u Ulid) String() string {
+	var buf [ulidLength]byte
+	var acc, bits uint
+	i := ulidLength - 1
+	for j := len(u) - 1; j >= 0; j-- {
+		acc |= uint(u[j]) << bits
+		bits += 8
+		for bits >= 5 {
+			buf[i] = ulidAlphabet[acc&31]
+			acc >>= 5
+			bits -= 5
+			i--
+		}
+	}
+	buf[0] = ulidAlphabet[acc&31]
+	return string(buf[:])
+}
+
+// ParseUlid 解码字符串形式的 ULID
+func ParseUlid(s string) (Ulid, error) {
+	var u Ulid
+	if len(s) != ulidLength {
+		return u, fmt.Errorf("%w: ulid %q should have %d characters", ErrInvalidId, s, ulidLength)
+	}
+	// 26 个字符可以表示 130 位，ULID 只有 128 位，所以第一个字符最大为 '7'
+	if v := ulidDecoding[s[0]]; v != 0xFF && v > 7 {
+		return u, fmt.Errorf("%w: ulid %q overflows 128 bits", ErrInvalidId, s)
+	}
+
+	var acc, bits uint
+	j := len(u) - 1
+	for i := ulidLength - 1; i >= 0; i-- {
+		v := ulidDecoding[s[i]]
+		if v == 0xFF {
+			return Ulid{}, fmt.Errorf("%w: ulid %q has invalid character %q", ErrInvalidId, s, s[i])
+		}
+		acc |= uint(v) << bits
+		bits += 5
+		for bits >= 8 && j >= 0 {
+			u[j] = byte(acc)
+			acc >>= 8
+			bits -= 8
+			j--
+		}
+	}
+	return u, nil
+}
+
+// ValidateUlid 校验字符串是否是合法的 ULID
+func ValidateUlid(s string) error {
+	_, err := ParseUlid(s)
+	return err
+}
+
+var _ Generator = (*UlidGenerator)(nil)
+
+type UlidGenerator struct {
+	*zap.Logger
+	clock *sortableClock
+}
+
+func NewUlidGenerator(logger *zap.Logger, opts ...SortableOption) *UlidGenerator {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &UlidGenerator{Logger: logger, clock: newSortableClock(opts)}
+}
+
+// Generate 生成字符串形式的 ULID
+func (g *UlidGenerator) Generate(ctx context.Context) (string, error) {
+	id, err := g.NextUlid(ctx)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// NextUlid 生成一个新的 ULID
+func (g *UlidGenerator) NextUlid(ctx context.Context) (Ulid, error) {
+	var u Ulid
+	millis := g.clock.now().UnixNano() / int64(time.Millisecond)
+	if millis < 0 || millis > ulidMaxTimestamp {
+		return u, &SequenceExhaustedError{Reason: "timestamp overflows 48 bits"}
+	}
+
+	millis, err := g.clock.next(millis, u[6:])
+	if err != nil {
+		g.Error("generate ulid", zap.Error(err))
+		return Ulid{}, err
+	}
+
+	for i := 5; i >= 0; i-- {
+		u[i] = byte(millis)
+		millis >>= 8
+	}
+	return u, nil
+}
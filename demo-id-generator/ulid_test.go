@@ -0,0 +1,81 @@
+package demo_id_generator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUlid_String(t *testing.T) {
+	clock := func() time.Time { return time.Unix(0, 1469918176385*int64(time.Millisecond)) }
+	g := NewUlidGenerator(nil, WithSortableClock(clock), WithSortableEntropy(bytes.NewReader(make([]byte, 10))))
+
+	id := mustGenerate(t, g)
+	if want := "01ARYZ6S410000000000000000"; id != want {
+		t.Fatalf("Generate() got = %v, want %v", id, want)
+	}
+
+	u, err := ParseUlid(id)
+	if err != nil {
+		t.Fatalf("ParseUlid() error = %v", err)
+	}
+	if !u.Time().Equal(clock()) || u.String() != id {
+		t.Errorf("ParseUlid() got time = %v, string = %v", u.Time(), u)
+	}
+}
+
+func TestUlidGenerator_StrictMonotonic(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	g := NewUlidGenerator(nil, WithStrictMonotonic(), WithSortableClock(clock))
+
+	last := ""
+	for i := 0; i < 1000; i++ {
+		// 时钟回拨时仍然保持递增
+		if i == 500 {
+			now = now.Add(-time.Second)
+		}
+		id := mustGenerate(t, g)
+		if id <= last {
+			t.Fatalf("Generate() got %v after %v", id, last)
+		}
+		last = id
+	}
+}
+
+func TestUlidGenerator_Overflow(t *testing.T) {
+	entropy := bytes.NewReader(bytes.Repeat([]byte{0xFF}, 10))
+	g := NewUlidGenerator(nil, WithStrictMonotonic(), WithSortableEntropy(entropy),
+		WithSortableClock(func() time.Time { return time.Unix(100, 0) }))
+
+	mustGenerate(t, g)
+	var exhausted *SequenceExhaustedError
+	if _, err := g.Generate(context.Background()); !errors.As(err, &exhausted) {
+		t.Errorf("Generate() error = %v, want SequenceExhaustedError", err)
+	}
+}
+
+func TestValidateUlid(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{"valid", "01ARYZ6S41TSV4RRFFQ69G5FAV", false},
+		{"lower case", "01aryz6s41tsv4rrffq69g5fav", false},
+		{"too short", "01ARYZ6S41", true},
+		{"invalid character", "01ARYZ6S41TSV4RRFFQ69G5FAU", true},
+		{"overflow", "81ARYZ6S41TSV4RRFFQ69G5FAV", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUlid(tt.id)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidId)) {
+				t.Errorf("ValidateUlid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -43,23 +43,26 @@ func (a *Aggregator) Aggregate(requestInfos []RequestInfo, duration time.Duratio
 
 	if count != 0 {
 		avgRespTime = time.Duration(int64(sumRespTime) / count)
+	} else {
+		// 没有数据时最大、最小响应时间没有意义，不把初始值输出到报表中
+		maxRespTime, minRespTime = 0, 0
 	}
 
-	tps := count / int64(duration)
+	var tps int64
+	if duration > 0 {
+		tps = count * int64(time.Second) / int64(duration)
+	}
 
-	sort.Slice(requestInfos, func(i, j int) bool {
-		if requestInfos[i].ResponseTime() >= requestInfos[j].ResponseTime() {
-			return true
-		}
-		return false
+	// 按响应时间升序排列后取百分位值，复制一份避免打乱调用方的数据
+	sorted := make([]RequestInfo, len(requestInfos))
+	copy(sorted, requestInfos)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ResponseTime() < sorted[j].ResponseTime()
 	})
 
-	idx999 := float64(count) * 0.999
-	idx99 := float64(count) * 0.99
-
 	if count != 0 {
-		p999RespTime = requestInfos[int(idx999)].ResponseTime()
-		p99RespTime = requestInfos[int(idx99)].ResponseTime()
+		p999RespTime = sorted[percentileIndex(count, 0.999)].ResponseTime()
+		p99RespTime = sorted[percentileIndex(count, 0.99)].ResponseTime()
 	}
 
 	stat := &RequestStat{
@@ -75,6 +78,15 @@ func (a *Aggregator) Aggregate(requestInfos []RequestInfo, duration time.Duratio
 	return stat
 }
 
+// percentileIndex 返回升序排列的 count 个数据中，百分位 p 对应的下标（nearest-rank 方法）
+func percentileIndex(count int64, p float64) int {
+	idx := int(math.Ceil(float64(count)*p)) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return idx
+}
+
 type RequestStat struct {
 	MaxResponseTime  time.Duration
 	MinResponseTime  time.Duration
@@ -0,0 +1,206 @@
+// perfcounter 性能计数器的离线工具：
+//
+//	perfcounter export -data metrics.jsonl -api register -start 2021-05-20T00:00:00Z -end 2021-05-21T00:00:00Z -format csv -o register.csv
+//	perfcounter import -data metrics.jsonl -in register.csv -format csv
+//	perfcounter replay -in register.csv -format csv
+//
+// export 从 FileMetricsStorage 中导出指定接口、时间区间的原始数据，
+// import 把导出的数据导入 FileMetricsStorage，
+// replay 对导出的数据做离线统计，输出格式与 ConsoleReporter 相同。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	counter "github.com/promacanthus/design-patterns/demo-performance-counter"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "replay":
+		err = runReplay(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: perfcounter <export|import|replay> [flags]")
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	data := fs.String("data", "metrics.jsonl", "FileMetricsStorage data file")
+	api := fs.String("api", "", "api name, export all apis if empty")
+	start := fs.String("start", "", "start time in RFC3339, inclusive")
+	end := fs.String("end", "", "end time in RFC3339, exclusive, defaults to now")
+	format := fs.String("format", string(counter.FormatCSV), "output format: csv or jsonl")
+	out := fs.String("o", "", "output file, defaults to stdout")
+	_ = fs.Parse(args)
+
+	exportFormat, err := counter.ParseExportFormat(*format)
+	if err != nil {
+		return err
+	}
+	startTime, endTime, err := parseTimeRange(*start, *end)
+	if err != nil {
+		return err
+	}
+
+	storage, err := counter.NewFileMetricsStorage(*data)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	var infos []counter.RequestInfo
+	if *api != "" {
+		infos = storage.GetRequestInfo(*api, startTime, endTime)
+	} else {
+		requestInfos := storage.GetRequestInfos(startTime, endTime)
+		apiNames := make([]string, 0, len(requestInfos))
+		for apiName := range requestInfos {
+			apiNames = append(apiNames, apiName)
+		}
+		sort.Strings(apiNames)
+		for _, apiName := range apiNames {
+			infos = append(infos, requestInfos[apiName]...)
+		}
+	}
+
+	w, closeFn, err := openOutput(*out)
+	if err != nil {
+		return err
+	}
+	if err := counter.ExportRequestInfos(w, exportFormat, infos); err != nil {
+		closeFn()
+		return err
+	}
+	// 关闭文件时才会发现写入失败，不能忽略
+	return closeFn()
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	data := fs.String("data", "metrics.jsonl", "FileMetricsStorage data file")
+	in := fs.String("in", "", "input file, defaults to stdin")
+	format := fs.String("format", string(counter.FormatCSV), "input format: csv or jsonl")
+	_ = fs.Parse(args)
+
+	exportFormat, err := counter.ParseExportFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	r, closeFn, err := openInput(*in)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	storage, err := counter.NewFileMetricsStorage(*data)
+	if err != nil {
+		return err
+	}
+	count, err := counter.ImportRequestInfos(r, exportFormat, storage)
+	if err != nil {
+		storage.Close()
+		return err
+	}
+	if err := storage.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "imported %d request infos into %s\n", count, *data)
+	return nil
+}
+
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	in := fs.String("in", "", "input file, defaults to stdin")
+	format := fs.String("format", string(counter.FormatCSV), "input format: csv or jsonl")
+	_ = fs.Parse(args)
+
+	exportFormat, err := counter.ParseExportFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	r, closeFn, err := openInput(*in)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	infos, err := counter.ReadRequestInfos(r, exportFormat)
+	if err != nil {
+		return err
+	}
+
+	return counter.Replay(os.Stdout, infos, counter.NewAggregator())
+}
+
+func parseTimeRange(start, end string) (time.Time, time.Time, error) {
+	startTime, endTime := time.Unix(0, 0), time.Now()
+
+	var err error
+	if start != "" {
+		if startTime, err = time.Parse(time.RFC3339, start); err != nil {
+			return startTime, endTime, fmt.Errorf("invalid start time: %w", err)
+		}
+	}
+	if end != "" {
+		if endTime, err = time.Parse(time.RFC3339, end); err != nil {
+			return startTime, endTime, fmt.Errorf("invalid end time: %w", err)
+		}
+	}
+
+	return startTime, endTime, nil
+}
+
+// openInput 只读打开文件，关闭时的错误不影响已经读到的数据，调用方可以忽略
+func openInput(path string) (io.Reader, func() error, error) {
+	if path == "" {
+		return os.Stdin, func() error { return nil }, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, file.Close, nil
+}
+
+// openOutput 返回的关闭函数的错误需要检查，写入的数据可能在关闭时才落盘失败
+func openOutput(path string) (io.Writer, func() error, error) {
+	if path == "" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, file.Close, nil
+}
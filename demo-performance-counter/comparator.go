@@ -0,0 +1,319 @@
+package demo_performance_counter
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Baseline 对比的基准时间窗口
+type Baseline int
+
+const (
+	PreviousWindow    Baseline = iota // 上一个统计周期
+	SameHourYesterday                 // 昨天的同一时段
+	SameDayLastWeek                   // 上周的同一时段
+)
+
+func (b Baseline) String() string {
+	switch b {
+	case PreviousWindow:
+		return "previous window"
+	case SameHourYesterday:
+		return "same hour yesterday"
+	case SameDayLastWeek:
+		return "same day last week"
+	default:
+		return "unknown baseline"
+	}
+}
+
+// offset 基准窗口相对于当前窗口向前偏移的时长
+func (b Baseline) offset(duration time.Duration) time.Duration {
+	switch b {
+	case SameHourYesterday:
+		return 24 * time.Hour
+	case SameDayLastWeek:
+		return 7 * 24 * time.Hour
+	default:
+		return duration
+	}
+}
+
+// Thresholds 判定性能退化的阈值。
+// 只有变化幅度超过阈值，并且这项指标自己的检验显著（p 值小于 Significance）时，才会被判定为退化，
+// 避免因为样本太少或者正常的抖动产生误报。
+type Thresholds struct {
+	LatencyIncrease    float64 // 响应时间上涨的比例，如 0.2 表示上涨 20%
+	ThroughputDecrease float64 // 吞吐量下降的比例
+	Significance       float64 // 显著性水平
+	MinSamples         int64   // 两个窗口都至少要有这么多样本，才判定响应时间退化
+}
+
+var DefaultThresholds = Thresholds{
+	LatencyIncrease:    0.2,
+	ThroughputDecrease: 0.2,
+	Significance:       0.05,
+	MinSamples:         30,
+}
+
+// MetricDelta 一项统计指标在两个窗口之间的变化。
+// 响应时间类指标的值单位为纳秒，吞吐量的值单位为每秒请求数。
+type MetricDelta struct {
+	Metric     string
+	Baseline   float64
+	Current    float64
+	Change     float64 // 相对变化比例，基准值为 0 时为 0
+	PValue     float64
+	Regression bool
+}
+
+// ApiComparison 一个接口在两个窗口之间的对比结果
+type ApiComparison struct {
+	ApiName  string
+	Baseline *RequestStat
+	Current  *RequestStat
+	Deltas   []MetricDelta
+}
+
+// Regressed 是否有任意一项指标退化
+func (c *ApiComparison) Regressed() bool {
+	for _, delta := range c.Deltas {
+		if delta.Regression {
+			return true
+		}
+	}
+	return false
+}
+
+// Comparison 当前窗口与基准窗口的对比结果，Apis 按接口名称排序
+type Comparison struct {
+	Baseline      Baseline
+	CurrentStart  time.Time
+	CurrentEnd    time.Time
+	BaselineStart time.Time
+	BaselineEnd   time.Time
+	Apis          []*ApiComparison
+}
+
+// Regressions 返回存在性能退化的接口
+func (c *Comparison) Regressions() []*ApiComparison {
+	var res []*ApiComparison
+	for _, api := range c.Apis {
+		if api.Regressed() {
+			res = append(res, api)
+		}
+	}
+	return res
+}
+
+// Comparator 负责对比当前窗口和基准窗口的统计数据，与 Reporter 一样，
+// 通过 MetricsStorage 拉取原始数据，通过 Aggregator 计算统计数据。
+type Comparator struct {
+	metricsStorage MetricsStorage
+	aggregator     *Aggregator
+	thresholds     Thresholds
+}
+
+func NewComparator(metricsStorage MetricsStorage, aggregator *Aggregator, thresholds Thresholds) *Comparator {
+	return &Comparator{
+		metricsStorage: metricsStorage,
+		aggregator:     aggregator,
+		thresholds:     thresholds,
+	}
+}
+
+// Compare 对比 [endTime-duration, endTime] 窗口和基准窗口中各个接口的统计数据
+func (c *Comparator) Compare(endTime time.Time, duration time.Duration, baseline Baseline) *Comparison {
+	startTime := endTime.Add(-duration)
+	baselineEnd := endTime.Add(-baseline.offset(duration))
+	baselineStart := baselineEnd.Add(-duration)
+
+	current := c.metricsStorage.GetRequestInfos(startTime, endTime)
+	previous := c.metricsStorage.GetRequestInfos(baselineStart, baselineEnd)
+
+	apiNames := make(map[string]struct{})
+	for apiName := range current {
+		apiNames[apiName] = struct{}{}
+	}
+	for apiName := range previous {
+		apiNames[apiName] = struct{}{}
+	}
+
+	comparison := &Comparison{
+		Baseline:      baseline,
+		CurrentStart:  startTime,
+		CurrentEnd:    endTime,
+		BaselineStart: baselineStart,
+		BaselineEnd:   baselineEnd,
+	}
+
+	for apiName := range apiNames {
+		comparison.Apis = append(comparison.Apis, c.compareApi(apiName, previous[apiName], current[apiName], duration))
+	}
+	sort.Slice(comparison.Apis, func(i, j int) bool {
+		return comparison.Apis[i].ApiName < comparison.Apis[j].ApiName
+	})
+
+	return comparison
+}
+
+func (c *Comparator) compareApi(apiName string, baseline, current []RequestInfo, duration time.Duration) *ApiComparison {
+	baselineStat := c.aggregator.Aggregate(baseline, duration)
+	currentStat := c.aggregator.Aggregate(current, duration)
+
+	// 响应时间的每一项指标分别检验：平均值用 Welch t 检验，百分位值用以合并后的百分位值为界的比例检验
+	enoughSamples := baselineStat.Count >= c.thresholds.MinSamples && currentStat.Count >= c.thresholds.MinSamples
+	latency := func(metric string, b, cur time.Duration, pValue func() float64) MetricDelta {
+		p := 1.0
+		if enoughSamples {
+			p = pValue()
+		}
+		delta := newMetricDelta(metric, float64(b), float64(cur), p)
+		delta.Regression = enoughSamples && delta.Change > c.thresholds.LatencyIncrease && p < c.thresholds.Significance
+		return delta
+	}
+	percentile := func(p float64) func() float64 {
+		return func() float64 { return quantileGreaterPValue(current, baseline, p) }
+	}
+
+	seconds := duration.Seconds()
+	throughput := newMetricDelta("tps",
+		float64(baselineStat.Count)/seconds, float64(currentStat.Count)/seconds,
+		poissonDecreasePValue(baselineStat.Count, currentStat.Count))
+	throughput.Regression = -throughput.Change > c.thresholds.ThroughputDecrease && throughput.PValue < c.thresholds.Significance
+
+	return &ApiComparison{
+		ApiName:  apiName,
+		Baseline: baselineStat,
+		Current:  currentStat,
+		Deltas: []MetricDelta{
+			latency("avg", baselineStat.AvgResponseTime, currentStat.AvgResponseTime, func() float64 {
+				return welchGreaterPValue(current, baseline)
+			}),
+			latency("p99", baselineStat.P99ResponseTime, currentStat.P99ResponseTime, percentile(0.99)),
+			latency("p999", baselineStat.P999ResponseTime, currentStat.P999ResponseTime, percentile(0.999)),
+			throughput,
+		},
+	}
+}
+
+func newMetricDelta(metric string, baseline, current, pValue float64) MetricDelta {
+	delta := MetricDelta{Metric: metric, Baseline: baseline, Current: current, PValue: pValue}
+	if baseline > 0 {
+		delta.Change = (current - baseline) / baseline
+	}
+	return delta
+}
+
+// welchGreaterPValue 单侧 Welch t 检验（样本足够多时用正态近似），
+// 返回“current 的平均响应时间大于 baseline”这一结论的 p 值。
+func welchGreaterPValue(current, baseline []RequestInfo) float64 {
+	if len(current) < 2 || len(baseline) < 2 {
+		return 1
+	}
+
+	m1, v1 := meanVariance(current)
+	m2, v2 := meanVariance(baseline)
+	se := math.Sqrt(v1/float64(len(current)) + v2/float64(len(baseline)))
+	if se == 0 {
+		// 两个窗口的响应时间都没有波动，平均值不同就是确定的差异
+		if m1 > m2 {
+			return 0
+		}
+		return 1
+	}
+	return upperTailPValue((m1 - m2) / se)
+}
+
+// meanVariance 响应时间（秒）的平均值和样本方差
+func meanVariance(infos []RequestInfo) (float64, float64) {
+	var sum float64
+	for _, info := range infos {
+		sum += info.ResponseTime().Seconds()
+	}
+	mean := sum / float64(len(infos))
+
+	var squares float64
+	for _, info := range infos {
+		d := info.ResponseTime().Seconds() - mean
+		squares += d * d
+	}
+	return mean, squares / float64(len(infos)-1)
+}
+
+// quantileGreaterPValue 百分位值的检验（广义的 Mood 中位数检验）：
+// 以两个窗口合并之后的百分位值 p 为界，用单侧 Fisher 精确检验判断 current 中不小于这个值的请求比例是否大于 baseline，
+// 返回“current 的百分位值大于 baseline”这一结论的 p 值。只比较尾部的请求，不受平均值变化的影响。
+func quantileGreaterPValue(current, baseline []RequestInfo, p float64) float64 {
+	n1, n2 := len(current), len(baseline)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	pooled := make([]time.Duration, 0, n1+n2)
+	for _, info := range current {
+		pooled = append(pooled, info.ResponseTime())
+	}
+	for _, info := range baseline {
+		pooled = append(pooled, info.ResponseTime())
+	}
+	sort.Slice(pooled, func(i, j int) bool {
+		return pooled[i] < pooled[j]
+	})
+	bound := pooled[percentileIndex(int64(len(pooled)), p)]
+
+	// 与界限相等的请求也计入尾部，否则尾部的值相同时没有请求可以比较
+	var tail, currentTail int
+	for _, value := range pooled {
+		if value >= bound {
+			tail++
+		}
+	}
+	for _, info := range current {
+		if info.ResponseTime() >= bound {
+			currentTail++
+		}
+	}
+	return hypergeometricUpperTail(n1+n2, tail, n1, currentTail)
+}
+
+// hypergeometricUpperTail 从 total 个请求（其中 tail 个在尾部）中不放回地取 drawn 个，
+// 取到的尾部请求不少于 observed 个的概率
+func hypergeometricUpperTail(total, tail, drawn, observed int) float64 {
+	logChoose := func(n, k int) float64 {
+		a, _ := math.Lgamma(float64(n + 1))
+		b, _ := math.Lgamma(float64(k + 1))
+		c, _ := math.Lgamma(float64(n - k + 1))
+		return a - b - c
+	}
+
+	max := tail
+	if drawn < max {
+		max = drawn
+	}
+	var p float64
+	for k := observed; k <= max; k++ {
+		if drawn-k > total-tail {
+			continue
+		}
+		p += math.Exp(logChoose(tail, k) + logChoose(total-tail, drawn-k) - logChoose(total, drawn))
+	}
+	return math.Min(p, 1)
+}
+
+// poissonDecreasePValue 把两个等长窗口的请求数看作泊松分布，
+// 返回“current 的请求数小于 baseline”这一结论的 p 值。
+func poissonDecreasePValue(baseline, current int64) float64 {
+	if baseline+current == 0 {
+		return 1
+	}
+
+	z := float64(baseline-current) / math.Sqrt(float64(baseline+current))
+	return upperTailPValue(z)
+}
+
+// upperTailPValue 标准正态分布的上侧概率 P(Z > z)
+func upperTailPValue(z float64) float64 {
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
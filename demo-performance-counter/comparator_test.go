@@ -0,0 +1,155 @@
+package demo_performance_counter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fillWindow 在 [end-duration, end) 区间内均匀写入 count 条数据，响应时间在 respTime 附近抖动
+func fillWindow(storage MetricsStorage, apiName string, end time.Time, duration time.Duration, count int, respTime time.Duration) {
+	step := duration / time.Duration(count)
+	for i := 0; i < count; i++ {
+		timestamp := end.Add(-duration).Add(time.Duration(i) * step)
+		jitter := time.Duration(i%10) * respTime / 100
+		storage.SaveRequestInfo(NewRequestInfo(apiName, respTime+jitter, toMillis(timestamp)))
+	}
+}
+
+func TestAggregator_Aggregate(t *testing.T) {
+	var infos []RequestInfo
+	for i := 1000; i >= 1; i-- {
+		infos = append(infos, *NewRequestInfo("login", time.Duration(i)*time.Millisecond, 0))
+	}
+
+	got := NewAggregator().Aggregate(infos, 10*time.Second)
+	want := &RequestStat{
+		MaxResponseTime:  1000 * time.Millisecond,
+		MinResponseTime:  1 * time.Millisecond,
+		AvgResponseTime:  500500 * time.Microsecond,
+		P999ResponseTime: 999 * time.Millisecond,
+		P99ResponseTime:  990 * time.Millisecond,
+		Count:            1000,
+		Tps:              100,
+	}
+	if *got != *want {
+		t.Errorf("Aggregate() got = %+v, want %+v", got, want)
+	}
+	if infos[0].ResponseTime() != 1000*time.Millisecond {
+		t.Error("Aggregate() should not reorder the given request infos")
+	}
+}
+
+func TestComparator_Compare(t *testing.T) {
+	end := time.Date(2021, 5, 20, 12, 0, 0, 0, time.UTC)
+	duration := time.Minute
+
+	storage := NewMemoryMetricsStorage()
+	// register：响应时间翻倍
+	fillWindow(storage, "register", end.Add(-duration), duration, 100, 10*time.Millisecond)
+	fillWindow(storage, "register", end, duration, 100, 20*time.Millisecond)
+	// login：请求量下降一半
+	fillWindow(storage, "login", end.Add(-duration), duration, 200, 10*time.Millisecond)
+	fillWindow(storage, "login", end, duration, 100, 10*time.Millisecond)
+	// logout：与昨天同一时段相比没有变化，与上一个周期相比样本太少
+	fillWindow(storage, "logout", end.Add(-24*time.Hour), duration, 50, 10*time.Millisecond)
+	fillWindow(storage, "logout", end, duration, 50, 10*time.Millisecond)
+
+	comparator := NewComparator(storage, NewAggregator(), DefaultThresholds)
+
+	tests := []struct {
+		name     string
+		baseline Baseline
+		want     map[string][]string // key 是接口名称，value 是退化的指标
+	}{
+		{"previous window", PreviousWindow, map[string][]string{
+			"register": {"avg", "p99", "p999"},
+			"login":    {"tps"},
+			"logout":   {},
+		}},
+		{"same hour yesterday", SameHourYesterday, map[string][]string{
+			"register": {},
+			"login":    {},
+			"logout":   {},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comparison := comparator.Compare(end, duration, tt.baseline)
+			if len(comparison.Apis) != len(tt.want) {
+				t.Fatalf("Compare() got %d apis, want %d", len(comparison.Apis), len(tt.want))
+			}
+
+			for _, api := range comparison.Apis {
+				var regressed []string
+				for _, delta := range api.Deltas {
+					if delta.Regression {
+						regressed = append(regressed, delta.Metric)
+					}
+				}
+				if strings.Join(regressed, ",") != strings.Join(tt.want[api.ApiName], ",") {
+					t.Errorf("%s regressions got = %v, want %v", api.ApiName, regressed, tt.want[api.ApiName])
+				}
+			}
+		})
+	}
+}
+
+func TestFormatComparison(t *testing.T) {
+	end := time.Date(2021, 5, 20, 12, 0, 0, 0, time.UTC)
+	storage := NewMemoryMetricsStorage()
+	fillWindow(storage, "register", end.Add(-time.Minute), time.Minute, 100, 10*time.Millisecond)
+	fillWindow(storage, "register", end, time.Minute, 100, 20*time.Millisecond)
+
+	comparison := NewComparator(storage, NewAggregator(), DefaultThresholds).Compare(end, time.Minute, PreviousWindow)
+
+	text := FormatComparisonText(comparison)
+	if !strings.Contains(text, "Compare with previous window") || !strings.Contains(text, "REGRESSION: register") {
+		t.Errorf("FormatComparisonText() got = %s", text)
+	}
+
+	html := FormatComparisonHTML(comparison)
+	if !strings.Contains(html, `<tr style="color:red"><td>register</td><td>p99</td>`) {
+		t.Errorf("FormatComparisonHTML() got = %s", html)
+	}
+}
+
+func TestAggregator_AggregateWindow(t *testing.T) {
+	infos := []RequestInfo{*NewRequestInfo("login", time.Millisecond, 0), *NewRequestInfo("login", time.Millisecond, 1),
+		*NewRequestInfo("login", time.Millisecond, 2)}
+	if got := NewAggregator().Aggregate(infos, 1500*time.Millisecond).Tps; got != 2 {
+		t.Errorf("Aggregate() tps in 1.5s got = %v, want 2", got)
+	}
+	if got := NewAggregator().Aggregate(infos, 500*time.Millisecond).Tps; got != 6 {
+		t.Errorf("Aggregate() tps in 0.5s got = %v, want 6", got)
+	}
+
+	empty := NewAggregator().Aggregate(nil, time.Second)
+	if empty.MaxResponseTime != 0 || empty.MinResponseTime != 0 || empty.Count != 0 || empty.Tps != 0 {
+		t.Errorf("Aggregate() empty window got = %+v", empty)
+	}
+}
+
+func TestQuantileGreaterPValue(t *testing.T) {
+	// 当前窗口只有最慢的 5% 请求变慢了 100ms
+	var baseline, current []RequestInfo
+	for i := 0; i < 1000; i++ {
+		respTime := time.Duration(i%100) * time.Millisecond
+		baseline = append(baseline, *NewRequestInfo("login", respTime, 0))
+		if i%100 >= 95 {
+			respTime += 100 * time.Millisecond
+		}
+		current = append(current, *NewRequestInfo("login", respTime, 0))
+	}
+
+	if p := quantileGreaterPValue(current, baseline, 0.99); p >= 0.001 {
+		t.Errorf("quantileGreaterPValue() slower tail got = %v, want < 0.001", p)
+	}
+	if p := quantileGreaterPValue(baseline, current, 0.99); p < 0.5 {
+		t.Errorf("quantileGreaterPValue() faster tail got = %v, want >= 0.5", p)
+	}
+	if p := quantileGreaterPValue(baseline, baseline, 0.99); p < 0.5 {
+		t.Errorf("quantileGreaterPValue() same tail got = %v, want >= 0.5", p)
+	}
+}
@@ -0,0 +1,76 @@
+package demo_performance_counter
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// 对比结果的显示逻辑与 Reporter 拆开，ConsoleReporter 使用文本格式，EmailReporter 使用 HTML 格式。
+
+// FormatComparisonText 将对比结果格式化为命令行显示的文本，退化的指标以 "!" 标记
+func FormatComparisonText(c *Comparison) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Compare with %s: [ %s, %s ] vs [ %s, %s ]\n",
+		c.Baseline, c.CurrentStart, c.CurrentEnd, c.BaselineStart, c.BaselineEnd)
+
+	for _, api := range c.Apis {
+		fmt.Fprintf(&b, "  %s\n", api.ApiName)
+		for _, delta := range api.Deltas {
+			mark := " "
+			if delta.Regression {
+				mark = "!"
+			}
+			fmt.Fprintf(&b, "  %s %-5s %12s -> %-12s %+7.1f%%  p=%.3f\n", mark, delta.Metric,
+				formatMetricValue(delta.Metric, delta.Baseline), formatMetricValue(delta.Metric, delta.Current),
+				delta.Change*100, delta.PValue)
+		}
+	}
+
+	if regressions := c.Regressions(); len(regressions) > 0 {
+		names := make([]string, 0, len(regressions))
+		for _, api := range regressions {
+			names = append(names, api.ApiName)
+		}
+		fmt.Fprintf(&b, "REGRESSION: %s\n", strings.Join(names, ", "))
+	}
+
+	return b.String()
+}
+
+// FormatComparisonHTML 将对比结果格式化为邮件中的 HTML 表格，退化的指标所在行标红
+func FormatComparisonHTML(c *Comparison) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h3>Compare with %s</h3>\n", html.EscapeString(c.Baseline.String()))
+	fmt.Fprintf(&b, "<p>[ %s, %s ] vs [ %s, %s ]</p>\n",
+		c.CurrentStart.Format(time.RFC3339), c.CurrentEnd.Format(time.RFC3339),
+		c.BaselineStart.Format(time.RFC3339), c.BaselineEnd.Format(time.RFC3339))
+
+	b.WriteString("<table>\n<tr><th>API</th><th>Metric</th><th>Baseline</th><th>Current</th><th>Change</th><th>p</th></tr>\n")
+	for _, api := range c.Apis {
+		for _, delta := range api.Deltas {
+			style := ""
+			if delta.Regression {
+				style = ` style="color:red"`
+			}
+			fmt.Fprintf(&b, "<tr%s><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%+.1f%%</td><td>%.3f</td></tr>\n",
+				style, html.EscapeString(api.ApiName), delta.Metric,
+				formatMetricValue(delta.Metric, delta.Baseline), formatMetricValue(delta.Metric, delta.Current),
+				delta.Change*100, delta.PValue)
+		}
+	}
+	b.WriteString("</table>\n")
+
+	return b.String()
+}
+
+func formatMetricValue(metric string, value float64) string {
+	if metric == "tps" {
+		return fmt.Sprintf("%.2f", value)
+	}
+	if value < 0 {
+		return "-"
+	}
+	return time.Duration(value).String()
+}
@@ -0,0 +1,185 @@
+package demo_performance_counter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 原始数据的导入导出，支持 CSV 和 JSON Lines 两种格式，方便事后分析线上问题，
+// 或者把线上的请求数据沉淀为回归测试的数据集。
+// 响应时间以纳秒、时间戳以毫秒的整数形式保存，导出再导入不会丢失精度。
+// CSV 的第一行总是表头 csvHeader，导入时必须有，这样名为 api_name 的接口也不会被当作表头跳过。
+
+type ExportFormat string
+
+const (
+	FormatCSV       ExportFormat = "csv"
+	FormatJSONLines ExportFormat = "jsonl"
+)
+
+var csvHeader = []string{"api_name", "response_time_ns", "timestamp_ms"}
+
+func ParseExportFormat(format string) (ExportFormat, error) {
+	switch f := ExportFormat(format); f {
+	case FormatCSV, FormatJSONLines:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// requestInfoRecord RequestInfo 的字段都是私有的，导入导出时借助它完成 JSON 的序列化
+type requestInfoRecord struct {
+	ApiName        string `json:"api_name"`
+	ResponseTimeNs int64  `json:"response_time_ns"`
+	TimestampMs    int64  `json:"timestamp_ms"`
+}
+
+// ExportRequestInfos 以指定格式将原始数据写入 w
+func ExportRequestInfos(w io.Writer, format ExportFormat, infos []RequestInfo) error {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, info := range infos {
+			record := []string{
+				info.ApiName(),
+				strconv.FormatInt(int64(info.ResponseTime()), 10),
+				strconv.FormatInt(info.Timestamp(), 10),
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatJSONLines:
+		encoder := json.NewEncoder(w)
+		for _, info := range infos {
+			record := requestInfoRecord{
+				ApiName:        info.ApiName(),
+				ResponseTimeNs: int64(info.ResponseTime()),
+				TimestampMs:    info.Timestamp(),
+			}
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// ReadRequestInfos 读取 ExportRequestInfos 导出的数据
+func ReadRequestInfos(r io.Reader, format ExportFormat) ([]RequestInfo, error) {
+	var infos []RequestInfo
+	err := scanRequestInfos(r, format, func(info *RequestInfo) {
+		infos = append(infos, *info)
+	})
+	return infos, err
+}
+
+// ImportRequestInfos 将导出的数据逐条保存到任意的 MetricsStorage 中，返回导入的条数
+func ImportRequestInfos(r io.Reader, format ExportFormat, storage MetricsStorage) (int, error) {
+	count := 0
+	err := scanRequestInfos(r, format, func(info *RequestInfo) {
+		storage.SaveRequestInfo(info)
+		count++
+	})
+	return count, err
+}
+
+func scanRequestInfos(r io.Reader, format ExportFormat, handle func(info *RequestInfo)) error {
+	switch format {
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(csvHeader)
+		header, err := reader.Read()
+		if err == io.EOF || (err == nil && !reflect.DeepEqual(header, csvHeader)) {
+			return fmt.Errorf("line 1: csv header %q is required", strings.Join(csvHeader, ","))
+		}
+		if err != nil {
+			return err
+		}
+		for line := 2; ; line++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			responseTime, err := strconv.ParseInt(record[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid response time: %w", line, err)
+			}
+			timestamp, err := strconv.ParseInt(record[2], 10, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid timestamp: %w", line, err)
+			}
+			handle(NewRequestInfo(record[0], time.Duration(responseTime), timestamp))
+		}
+	case FormatJSONLines:
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			var record requestInfoRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			handle(NewRequestInfo(record.ApiName, time.Duration(record.ResponseTimeNs), record.TimestampMs))
+		}
+		return scanner.Err()
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// Replay 离线回放原始数据：以数据中最早和最晚的时间戳作为统计区间，
+// 按接口分组后用 Aggregator 计算统计数据，输出格式与 ConsoleReporter 相同。
+func Replay(w io.Writer, infos []RequestInfo, aggregator *Aggregator) error {
+	if len(infos) == 0 {
+		return WriteConsoleReport(w, time.Time{}, time.Time{}, map[string]*RequestStat{})
+	}
+
+	sorted := make([]RequestInfo, len(infos))
+	copy(sorted, infos)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp() < sorted[j].Timestamp()
+	})
+
+	startTime := fromMillis(sorted[0].Timestamp())
+	endTime := fromMillis(sorted[len(sorted)-1].Timestamp() + 1)
+
+	requestInfos := make(map[string][]RequestInfo)
+	for _, info := range sorted {
+		requestInfos[info.ApiName()] = append(requestInfos[info.ApiName()], info)
+	}
+
+	duration := endTime.Sub(startTime)
+	stats := make(map[string]*RequestStat)
+	for apiName, infos := range requestInfos {
+		stats[apiName] = aggregator.Aggregate(infos, duration)
+	}
+
+	return WriteConsoleReport(w, startTime, endTime, stats)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,94 @@
+package demo_performance_counter
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportRequestInfos(t *testing.T) {
+	infos := []RequestInfo{
+		*NewRequestInfo("register", 111*time.Millisecond, 1621468800000),
+		*NewRequestInfo("login, v2", 1, 1621468800001),
+	}
+
+	for _, format := range []ExportFormat{FormatCSV, FormatJSONLines} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ExportRequestInfos(&buf, format, infos); err != nil {
+				t.Fatalf("ExportRequestInfos() error = %v", err)
+			}
+
+			got, err := ReadRequestInfos(&buf, format)
+			if err != nil {
+				t.Fatalf("ReadRequestInfos() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, infos) {
+				t.Errorf("ReadRequestInfos() got = %v, want %v", got, infos)
+			}
+		})
+	}
+
+	if _, err := ReadRequestInfos(strings.NewReader("api_name,response_time_ns,timestamp_ms\nregister,abc,1\n"), FormatCSV); err == nil {
+		t.Error("ReadRequestInfos() expect error for invalid response time")
+	}
+	// 没有表头时不猜测第一行是不是数据
+	for _, data := range []string{"", "register,100,1\n", "api,response_time,timestamp\nregister,100,1\n"} {
+		if _, err := ReadRequestInfos(strings.NewReader(data), FormatCSV); err == nil {
+			t.Errorf("ReadRequestInfos(%q) expect error for missing header", data)
+		}
+	}
+	// 表头之后名为 api_name 的接口是数据
+	got, err := ReadRequestInfos(strings.NewReader("api_name,response_time_ns,timestamp_ms\napi_name,100,1\n"), FormatCSV)
+	if want := []RequestInfo{*NewRequestInfo("api_name", 100, 1)}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadRequestInfos() got = %v, %v, want %v", got, err, want)
+	}
+	if _, err := ParseExportFormat("xml"); err == nil {
+		t.Error("ParseExportFormat() expect error for unsupported format")
+	}
+}
+
+func TestFileMetricsStorage(t *testing.T) {
+	storage, err := NewFileMetricsStorage(filepath.Join(t.TempDir(), "metrics.jsonl"))
+	if err != nil {
+		t.Fatalf("NewFileMetricsStorage() error = %v", err)
+	}
+	defer storage.Close()
+
+	csvData := "api_name,response_time_ns,timestamp_ms\nregister,100,1000\nregister,200,2000\nlogin,300,3000\n"
+	count, err := ImportRequestInfos(strings.NewReader(csvData), FormatCSV, storage)
+	if err != nil || count != 3 {
+		t.Fatalf("ImportRequestInfos() = %v, %v, want 3", count, err)
+	}
+
+	got := storage.GetRequestInfo("register", fromMillis(1500), fromMillis(5000))
+	want := []RequestInfo{*NewRequestInfo("register", 200, 2000)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRequestInfo() got = %v, want %v", got, want)
+	}
+	if got := storage.GetRequestInfos(fromMillis(0), fromMillis(5000)); len(got) != 2 {
+		t.Errorf("GetRequestInfos() got = %v, want 2 apis", got)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var infos []RequestInfo
+	for i := 0; i < 20; i++ {
+		infos = append(infos, *NewRequestInfo("register", time.Duration(i+1)*time.Millisecond, int64(i)*100))
+	}
+
+	var buf bytes.Buffer
+	if err := Replay(&buf, infos, NewAggregator()); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	stats := map[string]*RequestStat{"register": NewAggregator().Aggregate(infos, 1901*time.Millisecond)}
+	var want bytes.Buffer
+	_ = WriteConsoleReport(&want, fromMillis(0), fromMillis(1901), stats)
+	if buf.String() != want.String() {
+		t.Errorf("Replay() got = %s, want %s", buf.String(), want.String())
+	}
+}
@@ -0,0 +1,129 @@
+package demo_performance_counter
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// MetricsAggregatorService 消费端的汇总服务。
+// 订阅 topic 的所有分区，把各个实例通过 KafkaMetricsStorage 发送过来的数据解码后，
+// 合并保存到中心的 MetricsStorage 中，中心的 Reporter 基于它做全局的统计。
+type MetricsAggregatorService struct {
+	consumer sarama.Consumer
+	topic    string
+	offset   int64
+	storage  MetricsStorage
+
+	mu        sync.Mutex
+	received  map[string]int64 // key 是实例标识，value 是收到的数据条数
+	malformed int64
+
+	partitions []sarama.PartitionConsumer
+	wg         sync.WaitGroup
+}
+
+// NewMetricsAggregatorService 新建汇总服务，offset 是各分区开始消费的位置，
+// 如 sarama.OffsetOldest 或 sarama.OffsetNewest。
+func NewMetricsAggregatorService(consumer sarama.Consumer, topic string, offset int64, storage MetricsStorage) *MetricsAggregatorService {
+	return &MetricsAggregatorService{
+		consumer: consumer,
+		topic:    topic,
+		offset:   offset,
+		storage:  storage,
+		received: make(map[string]int64),
+	}
+}
+
+// Start 为 topic 的每个分区启动一个消费协程
+func (s *MetricsAggregatorService) Start() error {
+	if s.partitions != nil {
+		return errors.New("aggregator service already started")
+	}
+
+	partitions, err := s.consumer.Partitions(s.topic)
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range partitions {
+		pc, err := s.consumer.ConsumePartition(s.topic, partition, s.offset)
+		if err != nil {
+			s.Stop()
+			return err
+		}
+
+		s.partitions = append(s.partitions, pc)
+		s.wg.Add(2)
+		go s.consume(pc)
+		go s.logErrors(pc)
+	}
+
+	return nil
+}
+
+// Stop 关闭所有分区的消费，并等待已经收到的消息处理完成
+func (s *MetricsAggregatorService) Stop() {
+	for _, pc := range s.partitions {
+		pc.AsyncClose()
+	}
+	s.wg.Wait()
+	s.partitions = nil
+}
+
+// Received 返回每个实例已汇总的数据条数
+func (s *MetricsAggregatorService) Received() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make(map[string]int64, len(s.received))
+	for source, count := range s.received {
+		res[source] = count
+	}
+	return res
+}
+
+// Malformed 返回无法解码而被丢弃的消息数
+func (s *MetricsAggregatorService) Malformed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.malformed
+}
+
+func (s *MetricsAggregatorService) consume(pc sarama.PartitionConsumer) {
+	defer s.wg.Done()
+
+	for msg := range pc.Messages() {
+		s.handle(msg)
+	}
+}
+
+func (s *MetricsAggregatorService) logErrors(pc sarama.PartitionConsumer) {
+	defer s.wg.Done()
+
+	for err := range pc.Errors() {
+		log.Println(err)
+	}
+}
+
+func (s *MetricsAggregatorService) handle(msg *sarama.ConsumerMessage) {
+	batch, err := DecodeRequestInfoBatch(msg.Value)
+	if err != nil {
+		log.Printf("drop message %s/%d/%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+		s.mu.Lock()
+		s.malformed++
+		s.mu.Unlock()
+		return
+	}
+
+	for i := range batch.RequestInfos {
+		s.storage.SaveRequestInfo(&batch.RequestInfos[i])
+	}
+
+	s.mu.Lock()
+	s.received[batch.Source] += int64(len(batch.RequestInfos))
+	s.mu.Unlock()
+}
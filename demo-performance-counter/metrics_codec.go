@@ -0,0 +1,138 @@
+package demo_performance_counter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 多进程汇总时，各实例把原始数据按批次编码后发送到 Kafka。
+// 编码格式带版本号，方便以后在不影响旧消费者的前提下升级格式：
+//
+//	version(1 byte) | source(uvarint 长度 + 字节) | count(uvarint) | count * RequestInfo
+//	RequestInfo: apiName(uvarint 长度 + 字节) | responseTime(varint，纳秒) | timestamp(varint，毫秒)
+const (
+	requestInfoBatchV1 byte = 1
+
+	// CurrentRequestInfoBatchVersion 当前使用的编码版本
+	CurrentRequestInfoBatchVersion = requestInfoBatchV1
+)
+
+var (
+	ErrUnsupportedBatchVersion = errors.New("unsupported request info batch version")
+	ErrMalformedBatch          = errors.New("malformed request info batch")
+)
+
+// RequestInfoBatch 一个实例在一段时间内采集到的原始数据
+type RequestInfoBatch struct {
+	Source       string // 产生数据的实例标识，如 hostname:pid
+	RequestInfos []RequestInfo
+}
+
+// EncodeRequestInfoBatch 将批次数据编码为当前版本的二进制格式
+func EncodeRequestInfoBatch(batch *RequestInfoBatch) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(CurrentRequestInfoBatchVersion)
+	writeString(&buf, batch.Source)
+	writeUvarint(&buf, uint64(len(batch.RequestInfos)))
+
+	for _, info := range batch.RequestInfos {
+		writeString(&buf, info.ApiName())
+		writeVarint(&buf, int64(info.ResponseTime()))
+		writeVarint(&buf, info.Timestamp())
+	}
+
+	return buf.Bytes()
+}
+
+// DecodeRequestInfoBatch 解码二进制格式的批次数据，根据版本号选择对应的解码方式
+func DecodeRequestInfoBatch(data []byte) (*RequestInfoBatch, error) {
+	if len(data) == 0 {
+		return nil, ErrMalformedBatch
+	}
+
+	switch version := data[0]; version {
+	case requestInfoBatchV1:
+		return decodeRequestInfoBatchV1(bytes.NewReader(data[1:]))
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedBatchVersion, version)
+	}
+}
+
+func decodeRequestInfoBatchV1(r *bytes.Reader) (*RequestInfoBatch, error) {
+	source, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrMalformedBatch
+	}
+	// 每条数据至少占 3 个字节，提前校验避免恶意的 count 导致分配过多内存
+	if count > uint64(r.Len()/3) {
+		return nil, ErrMalformedBatch
+	}
+
+	batch := &RequestInfoBatch{
+		Source:       source,
+		RequestInfos: make([]RequestInfo, 0, count),
+	}
+
+	for i := uint64(0); i < count; i++ {
+		apiName, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		responseTime, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, ErrMalformedBatch
+		}
+
+		timestamp, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, ErrMalformedBatch
+		}
+
+		batch.RequestInfos = append(batch.RequestInfos, *NewRequestInfo(apiName, time.Duration(responseTime), timestamp))
+	}
+
+	if r.Len() != 0 {
+		return nil, ErrMalformedBatch
+	}
+
+	return batch, nil
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return "", ErrMalformedBatch
+	}
+
+	b := make([]byte, n)
+	if _, err := r.Read(b); err != nil && n > 0 {
+		return "", ErrMalformedBatch
+	}
+	return string(b), nil
+}
@@ -0,0 +1,79 @@
+package demo_performance_counter
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileMetricsStorage 基于本地文件的 MetricsStorage 实现，以 JSON Lines 格式追加写入原始数据。
+// 读取时需要扫描整个文件，适合数据量不大的单机场景，以及配合 perfcounter 工具做离线分析。
+type FileMetricsStorage struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileMetricsStorage(path string) (*FileMetricsStorage, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileMetricsStorage{path: path, file: file}, nil
+}
+
+func (f *FileMetricsStorage) SaveRequestInfo(info *RequestInfo) {
+	if info == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := ExportRequestInfos(f.file, FormatJSONLines, []RequestInfo{*info}); err != nil {
+		log.Println(err)
+	}
+}
+
+func (f *FileMetricsStorage) GetRequestInfo(apiName string, startTime, endTime time.Time) []RequestInfo {
+	return filterRequestInfos(f.readAll()[apiName], startTime, endTime)
+}
+
+func (f *FileMetricsStorage) GetRequestInfos(startTime, endTime time.Time) map[string][]RequestInfo {
+	res := make(map[string][]RequestInfo)
+	for apiName, infos := range f.readAll() {
+		if filtered := filterRequestInfos(infos, startTime, endTime); len(filtered) > 0 {
+			res[apiName] = filtered
+		}
+	}
+	return res
+}
+
+func (f *FileMetricsStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+func (f *FileMetricsStorage) readAll() map[string][]RequestInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer file.Close()
+
+	res := make(map[string][]RequestInfo)
+	if err := scanRequestInfos(file, FormatJSONLines, func(info *RequestInfo) {
+		res[info.ApiName()] = append(res[info.ApiName()], *info)
+	}); err != nil {
+		log.Println(err)
+	}
+	return res
+}
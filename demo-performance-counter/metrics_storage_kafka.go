@@ -0,0 +1,182 @@
+package demo_performance_counter
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const defaultKafkaBatchSize = 100
+
+// ErrKafkaStorageClosed Close 之后 producer 已经关闭，不能再发送
+var ErrKafkaStorageClosed = errors.New("kafka metrics storage closed")
+
+// KafkaMetricsStorage 在本地存储的基础上，把采集到的原始数据按批次发送到 Kafka，
+// 由中心的 MetricsAggregatorService 汇总多个实例的数据。
+// 它同样实现了 MetricsStorage 接口，对 MetricsCollector 和 Reporter 来说是透明的，
+// 查询操作委托给本地存储，因此本实例的 Reporter 依旧可以统计本实例的数据。
+//
+// 遵循容错性的要求，发送失败只记录日志，不会影响业务接口。
+type KafkaMetricsStorage struct {
+	local     MetricsStorage
+	producer  sarama.SyncProducer
+	topic     string
+	source    string
+	batchSize int
+
+	// mu 保护 buffer 和 closed，closed 之后不再缓存新的数据
+	mu     sync.Mutex
+	buffer []RequestInfo
+	closed bool
+	// sending 串行化发送，Close 等正在发送的批次结束之后才关闭 producer
+	sending sync.Mutex
+
+	// state 保护 flushing 和 stopped，stop 关闭之后定时发送的协程退出，退出时关闭 done
+	state    sync.Mutex
+	flushing bool
+	stopped  bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewKafkaMetricsStorage 新建 KafkaMetricsStorage，source 是当前实例的标识，同时作为消息的 key，
+// 保证同一个实例的数据落在同一个分区，batchSize 小于等于 0 时使用默认值。
+func NewKafkaMetricsStorage(local MetricsStorage, producer sarama.SyncProducer, topic, source string, batchSize int) *KafkaMetricsStorage {
+	if batchSize <= 0 {
+		batchSize = defaultKafkaBatchSize
+	}
+
+	return &KafkaMetricsStorage{
+		local:     local,
+		producer:  producer,
+		topic:     topic,
+		source:    source,
+		batchSize: batchSize,
+		buffer:    make([]RequestInfo, 0, batchSize),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (k *KafkaMetricsStorage) SaveRequestInfo(info *RequestInfo) {
+	if info == nil {
+		return
+	}
+
+	k.local.SaveRequestInfo(info)
+
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return
+	}
+	k.buffer = append(k.buffer, *info)
+	full := len(k.buffer) >= k.batchSize
+	k.mu.Unlock()
+
+	if full {
+		if err := k.Flush(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (k *KafkaMetricsStorage) GetRequestInfo(apiName string, startTime, endTime time.Time) []RequestInfo {
+	return k.local.GetRequestInfo(apiName, startTime, endTime)
+}
+
+func (k *KafkaMetricsStorage) GetRequestInfos(startTime, endTime time.Time) map[string][]RequestInfo {
+	return k.local.GetRequestInfos(startTime, endTime)
+}
+
+// Flush 把缓冲区中的数据作为一个批次发送出去，发送失败的数据会被丢弃，Close 之后返回 ErrKafkaStorageClosed
+func (k *KafkaMetricsStorage) Flush() error {
+	k.sending.Lock()
+	defer k.sending.Unlock()
+
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return ErrKafkaStorageClosed
+	}
+	infos := k.buffer
+	k.buffer = make([]RequestInfo, 0, k.batchSize)
+	k.mu.Unlock()
+
+	return k.send(infos)
+}
+
+// send 调用方持有 sending
+func (k *KafkaMetricsStorage) send(infos []RequestInfo) error {
+	if len(infos) == 0 {
+		return nil
+	}
+	batch := &RequestInfoBatch{Source: k.source, RequestInfos: infos}
+	_, _, err := k.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: k.topic,
+		Key:   sarama.StringEncoder(k.source),
+		Value: sarama.ByteEncoder(EncodeRequestInfoBatch(batch)),
+	})
+	return err
+}
+
+// StartPeriodicFlush 定时发送缓冲区中的数据，避免低流量的实例迟迟凑不满一个批次。
+// 只会启动一个后台协程，重复调用和 Close 之后调用都直接返回
+func (k *KafkaMetricsStorage) StartPeriodicFlush(period time.Duration) {
+	k.state.Lock()
+	defer k.state.Unlock()
+	if k.flushing || k.stopped {
+		return
+	}
+	k.flushing = true
+
+	go k.run(time.NewTicker(period))
+}
+
+func (k *KafkaMetricsStorage) run(ticker *time.Ticker) {
+	defer close(k.done)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := k.Flush(); err != nil {
+				log.Println(err)
+			}
+		case <-k.stop:
+			return
+		}
+	}
+}
+
+// Close 停止定时发送，等后台协程退出之后发送剩余的数据并关闭 producer。
+// 之后的 SaveRequestInfo 只保存到本地存储，重复调用 Close 直接返回
+func (k *KafkaMetricsStorage) Close() error {
+	k.state.Lock()
+	if k.stopped {
+		k.state.Unlock()
+		return nil
+	}
+	k.stopped = true
+	close(k.stop)
+	if k.flushing {
+		<-k.done
+	}
+	k.state.Unlock()
+
+	k.sending.Lock()
+	defer k.sending.Unlock()
+	k.mu.Lock()
+	k.closed = true
+	infos := k.buffer
+	k.buffer = nil
+	k.mu.Unlock()
+
+	flushErr := k.send(infos)
+	if err := k.producer.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
@@ -0,0 +1,164 @@
+package demo_performance_counter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestRequestInfoBatchCodec(t *testing.T) {
+	batch := &RequestInfoBatch{
+		Source: "host-1:1234",
+		RequestInfos: []RequestInfo{
+			*NewRequestInfo("register", 111*time.Millisecond, 1620000000000),
+			*NewRequestInfo("login", 0, 0),
+		},
+	}
+	encoded := EncodeRequestInfoBatch(batch)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *RequestInfoBatch
+		wantErr error
+	}{
+		{"round trip", encoded, batch, nil},
+		{"empty", nil, nil, ErrMalformedBatch},
+		{"unknown version", append([]byte{99}, encoded[1:]...), nil, ErrUnsupportedBatchVersion},
+		{"truncated", encoded[:len(encoded)-1], nil, ErrMalformedBatch},
+		{"trailing bytes", append(append([]byte{}, encoded...), 0), nil, ErrMalformedBatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeRequestInfoBatch(tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecodeRequestInfoBatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeRequestInfoBatch() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKafkaMetricsStorage_SaveRequestInfo(t *testing.T) {
+	var sent []*RequestInfoBatch
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+		batch, err := DecodeRequestInfoBatch(val)
+		sent = append(sent, batch)
+		return err
+	})
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+		batch, err := DecodeRequestInfoBatch(val)
+		sent = append(sent, batch)
+		return err
+	})
+
+	local := NewMemoryMetricsStorage()
+	storage := NewKafkaMetricsStorage(local, producer, "metrics", "host-1", 2)
+	storage.SaveRequestInfo(NewRequestInfo("register", 111, 1000))
+	storage.SaveRequestInfo(NewRequestInfo("register", 222, 2000))
+	storage.SaveRequestInfo(NewRequestInfo("login", 333, 3000))
+
+	if len(sent) != 1 || len(sent[0].RequestInfos) != 2 {
+		t.Fatalf("expect one full batch to be sent, got %v", sent)
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(sent) != 2 || len(sent[1].RequestInfos) != 1 || sent[1].Source != "host-1" {
+		t.Fatalf("expect remaining data to be flushed on close, got %v", sent)
+	}
+
+	// Close 之后只保存到本地存储，不再使用已经关闭的 producer
+	storage.SaveRequestInfo(NewRequestInfo("login", 444, 4000))
+	storage.SaveRequestInfo(NewRequestInfo("login", 555, 5000))
+	if err := storage.Flush(); !errors.Is(err, ErrKafkaStorageClosed) {
+		t.Errorf("Flush() error = %v, want %v", err, ErrKafkaStorageClosed)
+	}
+	if err := storage.Close(); err != nil {
+		t.Errorf("Close() again error = %v", err)
+	}
+
+	got := storage.GetRequestInfos(time.Unix(0, 0), time.Unix(10, 0))
+	if len(got["register"]) != 2 || len(got["login"]) != 3 {
+		t.Errorf("GetRequestInfos() got = %v, want data from local storage", got)
+	}
+}
+
+func TestKafkaMetricsStorage_PeriodicFlush(t *testing.T) {
+	sent := make(chan *RequestInfoBatch, 2)
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+		batch, err := DecodeRequestInfoBatch(val)
+		sent <- batch
+		return err
+	})
+
+	storage := NewKafkaMetricsStorage(NewMemoryMetricsStorage(), producer, "metrics", "host-1", 10)
+	storage.StartPeriodicFlush(time.Millisecond)
+	storage.StartPeriodicFlush(time.Millisecond)
+	storage.SaveRequestInfo(NewRequestInfo("register", 111, 1000))
+	select {
+	case batch := <-sent:
+		if len(batch.RequestInfos) != 1 {
+			t.Errorf("periodic flush got = %v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("periodic flush timed out")
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	storage.StartPeriodicFlush(time.Millisecond)
+}
+
+func TestMetricsAggregatorService(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{"metrics": {0, 1}})
+	p0 := consumer.ExpectConsumePartition("metrics", 0, sarama.OffsetOldest)
+	p1 := consumer.ExpectConsumePartition("metrics", 1, sarama.OffsetOldest)
+
+	p0.YieldMessage(&sarama.ConsumerMessage{Value: EncodeRequestInfoBatch(&RequestInfoBatch{
+		Source: "host-1",
+		RequestInfos: []RequestInfo{
+			*NewRequestInfo("register", 111, 1000),
+			*NewRequestInfo("login", 222, 1000),
+		},
+	})})
+	p1.YieldMessage(&sarama.ConsumerMessage{Value: EncodeRequestInfoBatch(&RequestInfoBatch{
+		Source:       "host-2",
+		RequestInfos: []RequestInfo{*NewRequestInfo("register", 333, 2000)},
+	})})
+	p1.YieldMessage(&sarama.ConsumerMessage{Value: []byte("garbage")})
+
+	central := NewMemoryMetricsStorage()
+	service := NewMetricsAggregatorService(consumer, "metrics", sarama.OffsetOldest, central)
+	if err := service.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	service.Stop()
+
+	if err := consumer.Close(); err != nil {
+		t.Fatalf("consumer.Close() error = %v", err)
+	}
+
+	wantReceived := map[string]int64{"host-1": 2, "host-2": 1}
+	if got := service.Received(); !reflect.DeepEqual(got, wantReceived) {
+		t.Errorf("Received() got = %v, want %v", got, wantReceived)
+	}
+	if got := service.Malformed(); got != 1 {
+		t.Errorf("Malformed() got = %v, want 1", got)
+	}
+	if got := central.GetRequestInfo("register", time.Unix(0, 0), time.Unix(10, 0)); len(got) != 2 {
+		t.Errorf("GetRequestInfo() got = %v, want merged data from both instances", got)
+	}
+}
@@ -0,0 +1,66 @@
+package demo_performance_counter
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryMetricsStorage 基于内存的 MetricsStorage 实现，线程安全。
+// 适用于单元测试、单机场景，或者作为多进程汇总时的中心存储。
+// RequestInfo 中的 timestamp 按毫秒级 Unix 时间戳处理。
+type MemoryMetricsStorage struct {
+	mu    sync.RWMutex
+	infos map[string][]RequestInfo // key 是接口名称，value 是该接口的原始请求数据
+}
+
+func NewMemoryMetricsStorage() *MemoryMetricsStorage {
+	return &MemoryMetricsStorage{infos: make(map[string][]RequestInfo)}
+}
+
+func (m *MemoryMetricsStorage) SaveRequestInfo(info *RequestInfo) {
+	if info == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.infos[info.ApiName()] = append(m.infos[info.ApiName()], *info)
+}
+
+func (m *MemoryMetricsStorage) GetRequestInfo(apiName string, startTime, endTime time.Time) []RequestInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return filterRequestInfos(m.infos[apiName], startTime, endTime)
+}
+
+func (m *MemoryMetricsStorage) GetRequestInfos(startTime, endTime time.Time) map[string][]RequestInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make(map[string][]RequestInfo)
+	for apiName, infos := range m.infos {
+		if filtered := filterRequestInfos(infos, startTime, endTime); len(filtered) > 0 {
+			res[apiName] = filtered
+		}
+	}
+	return res
+}
+
+// filterRequestInfos 返回时间戳落在 [startTime, endTime) 区间内的数据副本，相邻的统计窗口不会重复计算边界上的数据
+func filterRequestInfos(infos []RequestInfo, startTime, endTime time.Time) []RequestInfo {
+	start, end := toMillis(startTime), toMillis(endTime)
+
+	var res []RequestInfo
+	for _, info := range infos {
+		if info.Timestamp() >= start && info.Timestamp() < end {
+			res = append(res, info)
+		}
+	}
+	return res
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -2,69 +2,277 @@ package demo_performance_counter
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Metric 轻量级计数器的接口，Metrics 是它的实现
 type Metric interface {
 	RecordResponseTime(apiName string, responseTime time.Duration)
 	RecordTimestamp(apiName string, timestamp int64)
 	StartRepeatedReport(period time.Duration) error
+	Stop()
 }
 
+var (
+	ErrInvalidReportPeriod = errors.New("report period must be positive")
+	ErrReportStarted       = errors.New("repeated report already started")
+)
+
+// ApiStat 一个接口在一个统计周期内的统计数据
+type ApiStat struct {
+	Max   time.Duration `json:"max"`
+	Avg   time.Duration `json:"avg"`
+	Count int64         `json:"count"`
+}
+
+// Report 一个统计周期的统计结果，key 是接口名称
+type Report struct {
+	Start time.Time          `json:"start"`
+	End   time.Time          `json:"end"`
+	Stats map[string]ApiStat `json:"stats"`
+}
+
+// ReportOutput 统计结果的输出终端
+type ReportOutput interface {
+	Output(report *Report) error
+}
+
+// ReportOutputFunc 让普通函数也可以作为输出终端使用
+type ReportOutputFunc func(report *Report) error
+
+func (f ReportOutputFunc) Output(report *Report) error {
+	return f(report)
+}
+
+// NewJSONOutput 以 JSON 格式将统计结果写入 w，每个统计周期一行
+func NewJSONOutput(w io.Writer) ReportOutput {
+	return ReportOutputFunc(func(report *Report) error {
+		marshal, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(marshal))
+		return err
+	})
+}
+
+// NewStdoutJSONOutput 以 JSON 格式将统计结果输出到命令行，也是 Metrics 默认的输出终端
+func NewStdoutJSONOutput() ReportOutput {
+	return NewJSONOutput(os.Stdout)
+}
+
+// NewLoggerOutput 将统计结果输出到日志，每个接口一行
+func NewLoggerOutput(logger *log.Logger) ReportOutput {
+	return ReportOutputFunc(func(report *Report) error {
+		for apiName, stat := range report.Stats {
+			logger.Printf("[%s, %s] api=%s max=%s avg=%s count=%d",
+				report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339),
+				apiName, stat.Max, stat.Avg, stat.Count)
+		}
+		return nil
+	})
+}
+
+type MetricsOption func(*Metrics)
+
+// WithReportOutput 设置统计结果的输出终端
+func WithReportOutput(output ReportOutput) MetricsOption {
+	return func(m *Metrics) {
+		m.output = output
+	}
+}
+
+// Metrics 是最小原型（MVP）演进出来的轻量级计数器，所有功能都在一个结构体中，不依赖任何第三方库。
+// 不需要完整的 collector/storage/reporter 框架时，可以直接使用它：
+// 按接口记录响应时间和访问时间，每隔一个统计周期输出一次统计结果，并清空该周期的数据。
+//
+// 零值的 Metrics 也可以使用，第一次记录或者输出时开始统计周期，统计结果输出到命令行。
 type Metrics struct {
+	mu            sync.Mutex
 	responseTimes map[string][]time.Duration // key 是接口名称，value 是对应接口请求的响应时间
 	timestamps    map[string][]int64         // key 是接口名称，value 是对应接口请求的时间戳
-	ticker        *time.Ticker
+	windowStart   time.Time                  // 当前统计周期的开始时间
+
+	output ReportOutput
+	ticker *time.Ticker
+	stop   chan struct{}
+	done   chan struct{}
+	// reporter 定时输出协程的 ID，输出终端在这个协程中调用 Stop 时不等待协程退出
+	reporter int64
+}
+
+func NewMetrics(opts ...MetricsOption) *Metrics {
+	m := &Metrics{
+		responseTimes: make(map[string][]time.Duration),
+		timestamps:    make(map[string][]int64),
+		windowStart:   time.Now(),
+		output:        NewStdoutJSONOutput(),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
 }
 
 // RecordResponseTime 记录接口请求的响应时间
 func (m *Metrics) RecordResponseTime(apiName string, responseTime time.Duration) {
-	if _, ok := m.responseTimes[apiName]; !ok {
-		m.responseTimes[apiName] = make([]time.Duration, 0)
-	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	m.lazyInit()
 	m.responseTimes[apiName] = append(m.responseTimes[apiName], responseTime)
 }
 
 // RecordTimestamp 记录接口请求的访问时间
 func (m *Metrics) RecordTimestamp(apiName string, timestamp int64) {
-	if _, ok := m.timestamps[apiName]; !ok {
-		m.timestamps[apiName] = make([]int64, 0)
-	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	m.lazyInit()
 	m.timestamps[apiName] = append(m.timestamps[apiName], timestamp)
 }
 
+// lazyInit 初始化零值 Metrics 的字段，调用时需要持有锁
+func (m *Metrics) lazyInit() {
+	if m.responseTimes == nil {
+		m.responseTimes = make(map[string][]time.Duration)
+	}
+	if m.timestamps == nil {
+		m.timestamps = make(map[string][]int64)
+	}
+	if m.windowStart.IsZero() {
+		m.windowStart = time.Now()
+	}
+	if m.output == nil {
+		m.output = NewStdoutJSONOutput()
+	}
+}
+
+// StartRepeatedReport 每隔 period 输出一次统计结果，直到调用 Stop
 func (m *Metrics) StartRepeatedReport(period time.Duration) error {
+	if period <= 0 {
+		return ErrInvalidReportPeriod
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ticker != nil {
+		return ErrReportStarted
+	}
+
 	m.ticker = time.NewTicker(period)
-	stats := make(map[string]map[string]int64)
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
 
-	for apiName, durations := range m.responseTimes {
-		if _, ok := stats[apiName]; !ok {
-			stats[apiName] = make(map[string]int64)
+	go func(ticker *time.Ticker, stop, done chan struct{}) {
+		defer close(done)
+		m.setReporter(done)
+		for {
+			select {
+			case <-ticker.C:
+				// 已经停止时不再输出，ticker 和 stop 同时就绪时 select 可能选中 ticker
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := m.Report(); err != nil {
+					log.Println(err)
+				}
+			case <-stop:
+				return
+			}
 		}
+	}(m.ticker, m.stop, m.done)
 
-		stats[apiName]["max"] = int64(maxTimeDuration(durations))
-		stats[apiName]["avg"] = int64(avgTimeDuration(durations))
+	return nil
+}
+
+// setReporter 记录定时输出协程的 ID，done 不是当前的定时输出时说明已经 Stop
+func (m *Metrics) setReporter(done chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done == done {
+		m.reporter = goroutineId()
 	}
+}
 
-	for apiName, timestamps := range m.timestamps {
-		if _, ok := stats[apiName]; !ok {
-			stats[apiName] = make(map[string]int64)
-		}
+// goroutineId 从调用栈的第一行 "goroutine 18 [running]:" 中解析当前协程的 ID
+func goroutineId() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	fields := strings.Fields(strings.TrimPrefix(string(buf), "goroutine "))
+	if len(fields) == 0 {
+		return 0
+	}
+	id, _ := strconv.ParseInt(fields[0], 10, 64)
+	return id
+}
 
-		stats[apiName]["count"] = int64(len(timestamps))
+// Stop 停止定时输出并等待定时输出的协程退出，已经采集但还没有输出的数据会保留，可以通过 Report 手动输出。
+// 输出终端可能在定时输出的协程中调用 Stop，等待自己退出会死锁，只有这时不等待
+func (m *Metrics) Stop() {
+	m.mu.Lock()
+	if m.ticker == nil {
+		m.mu.Unlock()
+		return
 	}
 
-	marshal, err := json.Marshal(stats)
-	if err != nil {
-		return err
+	m.ticker.Stop()
+	close(m.stop)
+	done, reporter := m.done, m.reporter
+	m.ticker, m.stop, m.done, m.reporter = nil, nil, nil, 0
+	m.mu.Unlock()
+
+	if reporter != goroutineId() {
+		<-done
 	}
+}
 
-	fmt.Println(string(marshal))
+// Report 统计当前周期的数据并输出，然后开始一个新的统计周期
+func (m *Metrics) Report() error {
+	report, output := m.snapshot(time.Now())
+	return output.Output(report)
+}
 
-	return nil
+// snapshot 计算当前周期的统计数据，并清空当前周期采集的数据
+func (m *Metrics) snapshot(now time.Time) (*Report, ReportOutput) {
+	m.mu.Lock()
+	m.lazyInit()
+	responseTimes, timestamps, start, output := m.responseTimes, m.timestamps, m.windowStart, m.output
+	m.responseTimes = make(map[string][]time.Duration)
+	m.timestamps = make(map[string][]int64)
+	m.windowStart = now
+	m.mu.Unlock()
+
+	stats := make(map[string]ApiStat)
+	for apiName, durations := range responseTimes {
+		stat := stats[apiName]
+		stat.Max = maxTimeDuration(durations)
+		stat.Avg = avgTimeDuration(durations)
+		stats[apiName] = stat
+	}
+
+	for apiName, timestamps := range timestamps {
+		stat := stats[apiName]
+		stat.Count = int64(len(timestamps))
+		stats[apiName] = stat
+	}
+
+	return &Report{Start: start, End: now, Stats: stats}, output
 }
 
 func maxTimeDuration(dataset []time.Duration) time.Duration {
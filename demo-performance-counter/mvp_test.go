@@ -0,0 +1,183 @@
+package demo_performance_counter
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Report(t *testing.T) {
+	var reports []*Report
+	m := NewMetrics(WithReportOutput(ReportOutputFunc(func(report *Report) error {
+		reports = append(reports, report)
+		return nil
+	})))
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.RecordTimestamp("register", int64(i))
+			m.RecordResponseTime("register", time.Duration(i)*time.Millisecond)
+		}(i)
+	}
+	wg.Wait()
+
+	if err := m.Report(); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if err := m.Report(); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+
+	want := ApiStat{Max: 100 * time.Millisecond, Avg: 50500 * time.Microsecond, Count: 100}
+	if got := reports[0].Stats["register"]; got != want {
+		t.Errorf("first window got = %v, want %v", got, want)
+	}
+	if got := len(reports[1].Stats); got != 0 {
+		t.Errorf("second window should be reset, got %v stats", got)
+	}
+	if !reports[1].Start.Equal(reports[0].End) {
+		t.Errorf("windows should be contiguous, got %v and %v", reports[0].End, reports[1].Start)
+	}
+}
+
+func TestMetrics_StartRepeatedReport(t *testing.T) {
+	reported := make(chan *Report, 10)
+	m := NewMetrics(WithReportOutput(ReportOutputFunc(func(report *Report) error {
+		reported <- report
+		return nil
+	})))
+
+	if err := m.StartRepeatedReport(0); err != ErrInvalidReportPeriod {
+		t.Fatalf("StartRepeatedReport(0) error = %v, want %v", err, ErrInvalidReportPeriod)
+	}
+	if err := m.StartRepeatedReport(10 * time.Millisecond); err != nil {
+		t.Fatalf("StartRepeatedReport() error = %v", err)
+	}
+	if err := m.StartRepeatedReport(10 * time.Millisecond); err != ErrReportStarted {
+		t.Fatalf("StartRepeatedReport() error = %v, want %v", err, ErrReportStarted)
+	}
+
+	m.RecordTimestamp("login", 1)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-reported:
+		case <-time.After(time.Second):
+			t.Fatal("expect report to tick repeatedly")
+		}
+	}
+
+	m.Stop()
+	m.Stop()
+	for len(reported) > 0 {
+		<-reported
+	}
+	time.Sleep(30 * time.Millisecond)
+	if len(reported) != 0 {
+		t.Error("expect no more report after Stop")
+	}
+}
+
+func TestMetrics_StopInOutput(t *testing.T) {
+	stopped := make(chan struct{})
+	var once sync.Once
+	var m *Metrics
+	m = NewMetrics(WithReportOutput(ReportOutputFunc(func(report *Report) error {
+		// 输出终端中调用 Stop 不会死锁
+		m.Stop()
+		once.Do(func() { close(stopped) })
+		return nil
+	})))
+	if err := m.StartRepeatedReport(time.Millisecond); err != nil {
+		t.Fatalf("StartRepeatedReport() error = %v", err)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop() in output deadlocked")
+	}
+	if err := m.StartRepeatedReport(time.Millisecond); err != nil {
+		t.Errorf("StartRepeatedReport() after Stop error = %v", err)
+	}
+	m.Stop()
+}
+
+func TestMetrics_StopDuringReport(t *testing.T) {
+	reporting, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	var reported int32
+	m := NewMetrics(WithReportOutput(ReportOutputFunc(func(report *Report) error {
+		once.Do(func() { close(reporting) })
+		<-release
+		atomic.StoreInt32(&reported, 1)
+		return nil
+	})))
+	if err := m.StartRepeatedReport(time.Millisecond); err != nil {
+		t.Fatalf("StartRepeatedReport() error = %v", err)
+	}
+
+	// 其他协程在定时输出进行中调用 Stop，等待这次输出完成后才返回
+	<-reporting
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	m.Stop()
+	if atomic.LoadInt32(&reported) != 1 {
+		t.Error("Stop() returned before the running report finished")
+	}
+}
+
+func TestMetrics_ZeroValue(t *testing.T) {
+	var m Metrics
+	var reports []*Report
+	m.output = ReportOutputFunc(func(report *Report) error {
+		reports = append(reports, report)
+		return nil
+	})
+
+	m.RecordTimestamp("login", 1)
+	m.RecordResponseTime("login", time.Millisecond)
+	if err := m.Report(); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if got := reports[0].Stats["login"]; got.Count != 1 || got.Max != time.Millisecond || reports[0].Start.IsZero() {
+		t.Errorf("Report() got = %+v", reports[0])
+	}
+}
+
+func TestReportOutputs(t *testing.T) {
+	report := &Report{
+		Start: time.Unix(0, 0).UTC(),
+		End:   time.Unix(60, 0).UTC(),
+		Stats: map[string]ApiStat{"login": {Max: 2, Avg: 1, Count: 3}},
+	}
+
+	var jsonBuf bytes.Buffer
+	if err := NewJSONOutput(&jsonBuf).Output(report); err != nil {
+		t.Fatalf("JSON Output() error = %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(jsonBuf.Bytes(), &decoded); err != nil {
+		t.Fatalf("JSON output is not valid: %v", err)
+	}
+	if decoded.Stats["login"] != report.Stats["login"] {
+		t.Errorf("JSON output got = %v, want %v", decoded.Stats, report.Stats)
+	}
+
+	var logBuf bytes.Buffer
+	if err := NewLoggerOutput(log.New(&logBuf, "", 0)).Output(report); err != nil {
+		t.Fatalf("logger Output() error = %v", err)
+	}
+	if !strings.Contains(logBuf.String(), "api=login max=2ns avg=1ns count=3") {
+		t.Errorf("logger output got = %q", logBuf.String())
+	}
+}
@@ -23,17 +23,15 @@ func NewUserController(metric Metric) *UserController {
 }
 
 func (c *UserController) Register(user UserVO) {
-	startTimestamp := time.Now().Unix()
-	c.RecordTimestamp("register", startTimestamp)
+	start := time.Now()
+	c.RecordTimestamp("register", toMillis(start))
 
-	respTime := time.Now().Unix() - startTimestamp
-	c.RecordResponseTime("register", time.Duration(respTime))
+	c.RecordResponseTime("register", time.Since(start))
 }
 
 func (c *UserController) Login(telephone, password string) {
-	startTimestamp := time.Now().Unix()
-	c.RecordTimestamp("login", startTimestamp)
+	start := time.Now()
+	c.RecordTimestamp("login", toMillis(start))
 
-	respTime := time.Now().Unix() - startTimestamp
-	c.RecordResponseTime("login", time.Duration(respTime))
+	c.RecordResponseTime("login", time.Since(start))
 }
@@ -3,7 +3,13 @@ package demo_performance_counter
 import (
 	"encoding/json"
 	"fmt"
+	"html"
+	"io"
+	"log"
 	"net/smtp"
+	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -22,6 +28,8 @@ type ConsoleReporter struct {
 	metricsStorage MetricsStorage
 	ticker         *time.Ticker
 	aggregator     *Aggregator
+	comparator     *Comparator
+	baselines      []Baseline
 }
 
 func NewConsoleReporter(metricsStorage MetricsStorage, aggregator *Aggregator) *ConsoleReporter {
@@ -31,6 +39,12 @@ func NewConsoleReporter(metricsStorage MetricsStorage, aggregator *Aggregator) *
 	}
 }
 
+// EnableComparison 每次统计时，额外显示与各个基准窗口的对比结果
+func (r *ConsoleReporter) EnableComparison(comparator *Comparator, baselines ...Baseline) {
+	r.comparator = comparator
+	r.baselines = baselines
+}
+
 func (r *ConsoleReporter) StartRepeatedReport(period, duration time.Duration) {
 	r.ticker = time.NewTicker(period)
 
@@ -49,13 +63,27 @@ func (r *ConsoleReporter) StartRepeatedReport(period, duration time.Duration) {
 		}
 
 		// 将统计数据显示在终端（命令行/邮件）
-		fmt.Println(fmt.Sprintf("Time span: [ %s, %s ]", startTime, endTime))
-		marshal, err := json.Marshal(stats)
-		if err != nil {
+		if err := WriteConsoleReport(os.Stdout, startTime, endTime, stats); err != nil {
 			return
 		}
-		fmt.Println(string(marshal))
+
+		if r.comparator != nil {
+			for _, baseline := range r.baselines {
+				fmt.Print(FormatComparisonText(r.comparator.Compare(endTime, duration, baseline)))
+			}
+		}
+	}
+}
+
+// WriteConsoleReport 以命令行的显示格式输出统计数据，离线回放工具也使用这个格式
+func WriteConsoleReport(w io.Writer, startTime, endTime time.Time, stats map[string]*RequestStat) error {
+	marshal, err := json.Marshal(stats)
+	if err != nil {
+		return err
 	}
+
+	_, err = fmt.Fprintf(w, "Time span: [ %s, %s ]\n%s\n", startTime, endTime, marshal)
+	return err
 }
 
 type EmailReporter struct {
@@ -63,6 +91,8 @@ type EmailReporter struct {
 	aggregator     *Aggregator
 	emailSender    *EmailSender
 	toAddress      []string
+	comparator     *Comparator
+	baselines      []Baseline
 }
 
 func NewEmailReporter(metricsStorage MetricsStorage, aggregator *Aggregator) *EmailReporter {
@@ -77,6 +107,12 @@ func (r *EmailReporter) AddToAddress(addr ...string) {
 	r.emailSender.AddReceiver(addr...)
 }
 
+// EnableComparison 每日的统计邮件中，额外附上与各个基准窗口的对比结果
+func (r *EmailReporter) EnableComparison(comparator *Comparator, baselines ...Baseline) {
+	r.comparator = comparator
+	r.baselines = baselines
+}
+
 func (r *EmailReporter) StartDailyReport() {
 	duration := 24 * time.Hour
 	ticker := time.NewTicker(duration)
@@ -92,9 +128,47 @@ func (r *EmailReporter) StartDailyReport() {
 			requestStat := r.aggregator.Aggregate(infos, duration)
 			stats[apiName] = requestStat
 		}
+
+		var comparisons []*Comparison
+		if r.comparator != nil {
+			for _, baseline := range r.baselines {
+				comparisons = append(comparisons, r.comparator.Compare(endTime, duration, baseline))
+			}
+		}
+
+		// 格式化为 HTML，并发送邮件
+		msg := "Subject: Daily performance report\r\n" +
+			"MIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n" +
+			formatEmailHTML(startTime, endTime, stats, comparisons)
+		if err := r.emailSender.SendMail([]byte(msg)); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func formatEmailHTML(startTime, endTime time.Time, stats map[string]*RequestStat, comparisons []*Comparison) string {
+	apiNames := make([]string, 0, len(stats))
+	for apiName := range stats {
+		apiNames = append(apiNames, apiName)
+	}
+	sort.Strings(apiNames)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h3>Time span: [ %s, %s ]</h3>\n", startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+	b.WriteString("<table>\n<tr><th>API</th><th>Max</th><th>Min</th><th>Avg</th><th>P999</th><th>P99</th><th>Count</th><th>TPS</th></tr>\n")
+	for _, apiName := range apiNames {
+		stat := stats[apiName]
+		fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%d</td><td>%d</td></tr>\n",
+			html.EscapeString(apiName), stat.MaxResponseTime, stat.MinResponseTime, stat.AvgResponseTime,
+			stat.P999ResponseTime, stat.P99ResponseTime, stat.Count, stat.Tps)
+	}
+	b.WriteString("</table>\n")
+
+	for _, comparison := range comparisons {
+		b.WriteString(FormatComparisonHTML(comparison))
 	}
 
-	// TODO: 格式化为 HTML，并发送邮件
+	return b.String()
 }
 
 type EmailSender struct {
@@ -4,9 +4,17 @@ package demo_wallet
 // 负责暴露接口，将 Service 中获取的 BO 转换为 VO 并返回给前端
 
 type UserVo struct {
-	Id        string
-	Name      string
+	Id string
+	// Name 脱敏之后的姓名，如 张**
+	Name string
+	// Cellphone 脱敏之后的手机号，如 138****5678
 	Cellphone string
+	Address   AddressVo
+}
+
+// AddressVo 前端只展示省市，不展示详细地址
+type AddressVo struct {
+	Region string
 }
 
 type UserController struct {
@@ -16,12 +24,7 @@ type UserController struct {
 func (c UserController) GetUserById(userId string) UserVo {
 	userBo := c.userService.GetUserById(userId)
 
-	convertUserBoToUserVo := func(bo UserBo) UserVo {
-		return UserVo{}
-	}
-
-	userVo := convertUserBoToUserVo(userBo)
-	return userVo
+	return mapper.UserBoToVo(&userBo)
 }
 
 // ------------------------------
@@ -32,6 +35,13 @@ type UserEntity struct {
 	Id        string
 	Name      string
 	Cellphone string
+	Address   AddressEntity
+}
+
+type AddressEntity struct {
+	Province string
+	City     string
+	Street   string
 }
 
 type UserRepository struct{}
@@ -48,6 +58,13 @@ type UserBo struct {
 	Id        string
 	Name      string
 	Cellphone string
+	Address   AddressBo
+}
+
+type AddressBo struct {
+	Province string
+	City     string
+	Street   string
 }
 
 type UserService struct {
@@ -57,10 +74,17 @@ type UserService struct {
 func (s UserService) GetUserById(userId string) UserBo {
 	userEntity := s.userRepository.GetUserById(userId)
 
-	convertUserEntityToUserBo := func(entity UserEntity) UserBo {
-		return UserBo{}
-	}
+	return mapper.UserEntityToBo(&userEntity)
+}
+
+// ------------------------------
+// 三层之间的转换函数由 mappergen 生成，嵌套的地址使用各自的转换函数，VO 中的姓名和手机号脱敏，只展示省市
+
+//mapper:func UserEntityToBo UserEntity UserBo
+//mapper:func AddressEntityToBo AddressEntity AddressBo
+//mapper:func UserBoToVo UserBo UserVo Name=mask.Name(Name) Cellphone=mask.Cellphone(Cellphone)
+//mapper:func AddressBoToVo AddressBo AddressVo Region=addressRegion(src)
 
-	userBo := convertUserEntityToUserBo(userEntity)
-	return userBo
+func addressRegion(bo *AddressBo) string {
+	return bo.Province + bo.City
 }
@@ -0,0 +1,337 @@
+// mappergen 根据 //mapper:func 指令生成 Entity、BO、VO 之间的转换函数，在包目录下通过 go generate 运行：
+//
+//	//go:generate go run ./cmd/mappergen -output mapper_gen.go
+//	//mapper:func <函数名> <源类型> <目标类型> [<目标字段>=<规则> ...]
+//
+// 每条指令生成一个 Mapper 的方法 func (m Mapper) <函数名>(src *<源类型>) <目标类型>，目标类型可以带 *，返回指针。
+// 目标字段按照声明顺序逐个赋值，没有写规则的字段取源类型中同名的字段（首字母大小写可以不同，如 Id 对应 id）：
+// 类型相同时直接赋值，类型不同时使用另一条指令生成的 <字段的源类型> 到 <字段的目标类型> 的转换函数，用于嵌套的结构体。
+// 规则有以下几种：
+//
+//   - 不赋值，保持零值
+//     field              改名，取源类型的 field 字段
+//     Method()           调用源类型的方法
+//     mask.Rule(field)   按照 Masking 中的 Rule 脱敏 field 字段
+//     fn(field)          调用包中的函数 fn 处理 field 字段
+//     fn(src)            调用包中的函数 fn 处理整个源对象，用于合并多个字段或者有条件的赋值
+//
+// 找不到源字段、类型不同又没有对应的转换函数、引用了不存在的字段或方法时生成失败，不会生成无法编译或者漏掉字段的代码。
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const directive = "//mapper:func "
+
+func main() {
+	output := flag.String("output", "mapper_gen.go", "generated file")
+	flag.Parse()
+
+	if err := run(".", *output); err != nil {
+		fmt.Fprintln(os.Stderr, "mappergen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, output string) error {
+	pkg, err := parsePackage(dir, output)
+	if err != nil {
+		return err
+	}
+	src, err := pkg.generate()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, output), src, 0644)
+}
+
+type field struct {
+	name string
+	typ  string
+}
+
+// mapping 一条 //mapper:func 指令
+type mapping struct {
+	pos   token.Position
+	name  string
+	src   string
+	dst   string
+	ptr   bool
+	rules map[string]string
+}
+
+type pkgInfo struct {
+	name     string
+	structs  map[string][]field
+	methods  map[string]map[string]bool
+	embedded map[string]bool
+	mappings []*mapping
+}
+
+func parsePackage(dir, output string) (*pkgInfo, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go") && info.Name() != output
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expect exactly one package in %s, got %d", dir, len(pkgs))
+	}
+
+	p := &pkgInfo{structs: map[string][]field{}, methods: map[string]map[string]bool{},
+		embedded: map[string]bool{}}
+	for name, pkg := range pkgs {
+		p.name = name
+		// 按照文件名的顺序收集指令，生成的代码是确定的
+		filenames := make([]string, 0, len(pkg.Files))
+		for filename := range pkg.Files {
+			filenames = append(filenames, filename)
+		}
+		sort.Strings(filenames)
+		for _, filename := range filenames {
+			if err := p.collect(fset, pkg.Files[filename]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+func (p *pkgInfo) collect(fset *token.FileSet, file *ast.File) error {
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if !strings.HasPrefix(comment.Text, directive) {
+				continue
+			}
+			m, err := parseDirective(strings.TrimPrefix(comment.Text, directive))
+			if err != nil {
+				return fmt.Errorf("%s: %w", fset.Position(comment.Pos()), err)
+			}
+			m.pos = fset.Position(comment.Pos())
+			p.mappings = append(p.mappings, m)
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				typeSpec, ok := spec.(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+				structType, ok := typeSpec.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				var fields []field
+				for _, f := range structType.Fields.List {
+					if len(f.Names) == 0 {
+						p.embedded[typeSpec.Name.Name] = true
+						continue
+					}
+					typ := typeString(fset, f.Type)
+					for _, name := range f.Names {
+						fields = append(fields, field{name: name.Name, typ: typ})
+					}
+				}
+				p.structs[typeSpec.Name.Name] = fields
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil || len(decl.Recv.List) != 1 {
+				continue
+			}
+			recv := decl.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			if ident, ok := recv.(*ast.Ident); ok {
+				if p.methods[ident.Name] == nil {
+					p.methods[ident.Name] = map[string]bool{}
+				}
+				p.methods[ident.Name][decl.Name.Name] = true
+			}
+		}
+	}
+	return nil
+}
+
+func typeString(fset *token.FileSet, expr ast.Expr) string {
+	var buf bytes.Buffer
+	_ = format.Node(&buf, fset, expr)
+	return buf.String()
+}
+
+func parseDirective(text string) (*mapping, error) {
+	args := strings.Fields(text)
+	if len(args) < 3 {
+		return nil, fmt.Errorf("usage: %s<name> <src> <dst> [<field>=<rule> ...]", directive)
+	}
+	m := &mapping{name: args[0], src: args[1], dst: strings.TrimPrefix(args[2], "*"),
+		ptr: strings.HasPrefix(args[2], "*"), rules: map[string]string{}}
+	for _, arg := range args[3:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid rule %q, want <field>=<rule>", arg)
+		}
+		m.rules[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+func (p *pkgInfo) generate() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by mappergen. DO NOT EDIT.\n\npackage %s\n", p.name)
+	for _, m := range p.mappings {
+		if err := p.generateFunc(&buf, m); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", m.pos, m.name, err)
+		}
+	}
+	return format.Source(buf.Bytes())
+}
+
+func (p *pkgInfo) generateFunc(buf *bytes.Buffer, m *mapping) error {
+	srcFields, ok := p.structs[m.src]
+	if !ok {
+		return fmt.Errorf("source type %s is not a struct in package %s", m.src, p.name)
+	}
+	dstFields, ok := p.structs[m.dst]
+	if !ok {
+		return fmt.Errorf("target type %s is not a struct in package %s", m.dst, p.name)
+	}
+	if p.embedded[m.src] || p.embedded[m.dst] {
+		return fmt.Errorf("embedded fields in %s or %s are not supported", m.src, m.dst)
+	}
+
+	var assigns []string
+	used := map[string]bool{}
+	for _, dst := range dstFields {
+		rule, hasRule := m.rules[dst.name]
+		used[dst.name] = hasRule
+		var expr string
+		var err error
+		if hasRule {
+			expr, err = p.ruleExpr(m, rule)
+		} else {
+			expr, err = p.fieldExpr(m, srcFields, dst)
+		}
+		if err != nil {
+			return fmt.Errorf("field %s: %w", dst.name, err)
+		}
+		if expr != "" {
+			assigns = append(assigns, fmt.Sprintf("%s: %s,", dst.name, expr))
+		}
+	}
+	for name := range m.rules {
+		if !used[name] {
+			return fmt.Errorf("rule for unknown field %s.%s", m.dst, name)
+		}
+	}
+
+	result, amp := m.dst, ""
+	if m.ptr {
+		result, amp = "*"+m.dst, "&"
+	}
+	fmt.Fprintf(buf, "\n// %s %s 转换为 %s\nfunc (m Mapper) %s(src *%s) %s {\n\treturn %s%s{\n\t\t%s\n\t}\n}\n",
+		m.name, m.src, m.dst, m.name, m.src, result, amp, m.dst, strings.Join(assigns, "\n\t\t"))
+	return nil
+}
+
+// fieldExpr 没有规则的字段取同名的源字段，类型不同时使用嵌套结构体的转换函数
+func (p *pkgInfo) fieldExpr(m *mapping, srcFields []field, dst field) (string, error) {
+	src, ok := findField(srcFields, dst.name)
+	if !ok {
+		return "", fmt.Errorf("no field in %s matches, add a rule", m.src)
+	}
+	if src.typ == dst.typ {
+		return "src." + src.name, nil
+	}
+	for _, nested := range p.mappings {
+		if nested.src == src.typ && nested.dst == dst.typ && !nested.ptr {
+			return fmt.Sprintf("m.%s(&src.%s)", nested.name, src.name), nil
+		}
+	}
+	return "", fmt.Errorf("%s.%s is %s, want %s, and no mapper:func converts them", m.src, src.name, src.typ, dst.typ)
+}
+
+func (p *pkgInfo) ruleExpr(m *mapping, rule string) (string, error) {
+	if rule == "-" {
+		return "", nil
+	}
+	open := strings.Index(rule, "(")
+	if open < 0 {
+		if err := p.checkField(m, rule); err != nil {
+			return "", err
+		}
+		return "src." + rule, nil
+	}
+	if !strings.HasSuffix(rule, ")") {
+		return "", fmt.Errorf("invalid rule %q", rule)
+	}
+	fn, arg := rule[:open], rule[open+1:len(rule)-1]
+
+	if arg == "" {
+		if !p.methods[m.src][fn] {
+			return "", fmt.Errorf("%s has no method %s", m.src, fn)
+		}
+		return "src." + fn + "()", nil
+	}
+	if arg == "src" {
+		return fn + "(src)", nil
+	}
+	if err := p.checkField(m, arg); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(fn, "mask.") {
+		return fmt.Sprintf("mask(m.Masking.%s, src.%s)", strings.TrimPrefix(fn, "mask."), arg), nil
+	}
+	return fmt.Sprintf("%s(src.%s)", fn, arg), nil
+}
+
+func (p *pkgInfo) checkField(m *mapping, name string) error {
+	for _, f := range p.structs[m.src] {
+		if f.name == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s has no field %s", m.src, name)
+}
+
+// findField 优先取同名的字段，其次取只有首字母大小写不同的字段
+func findField(fields []field, name string) (field, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if swapFirst(f.name) == name {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+func swapFirst(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	if unicode.IsUpper(r) {
+		return string(unicode.ToLower(r)) + name[size:]
+	}
+	return string(unicode.ToUpper(r)) + name[size:]
+}
@@ -0,0 +1,244 @@
+package demo_wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 多币种钱包在跨币种转账时按照汇率兑换：出账钱包扣除源币种的金额，先按照费率扣除手续费，
+// 剩余的金额按照汇率兑换为目标币种后入账，使用的汇率和手续费记录在交易流水中，便于对账。
+
+var (
+	ErrRateNotFound = errors.New("exchange rate not found")
+	// ErrExchangeUnavailable Service 没有配置 CurrencyExchange，不支持跨币种的操作
+	ErrExchangeUnavailable = errors.New("currency exchange unavailable")
+)
+
+// RateProvider 汇率的来源
+type RateProvider interface {
+	// Rate 1 单位 from 兑换多少单位 to，没有对应的汇率时返回 ErrRateNotFound
+	Rate(ctx context.Context, from, to Currency) (*big.Rat, error)
+}
+
+var _ RateProvider = (*StaticRateProvider)(nil)
+
+// StaticRateProvider 以一个基准币种表示的固定汇率，任意两个币种之间的汇率通过基准币种换算
+type StaticRateProvider struct {
+	base  Currency
+	rates map[Currency]*big.Rat
+}
+
+// NewStaticRateProvider rates 是 1 单位 base 兑换的各币种数量，如 {"CNY": "6.4536"}
+func NewStaticRateProvider(base Currency, rates map[Currency]string) (*StaticRateProvider, error) {
+	p := &StaticRateProvider{base: base, rates: map[Currency]*big.Rat{base: big.NewRat(1, 1)}}
+	for currency, text := range rates {
+		rate, ok := new(big.Rat).SetString(text)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid exchange rate %s/%s: %q", base, currency, text)
+		}
+		p.rates[currency] = rate
+	}
+	return p, nil
+}
+
+func (p *StaticRateProvider) Rate(ctx context.Context, from, to Currency) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	fromRate, ok := p.rates[from]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", ErrRateNotFound, from, to)
+	}
+	toRate, ok := p.rates[to]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", ErrRateNotFound, from, to)
+	}
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+var _ RateProvider = (*FileRateProvider)(nil)
+
+// rateFile 汇率文件的格式，如 {"base": "USD", "rates": {"CNY": "6.4536", "EUR": "0.8412"}}，
+// 汇率使用字符串，避免 JSON 的 number 被解析为浮点数
+type rateFile struct {
+	Base  Currency            `json:"base"`
+	Rates map[Currency]string `json:"rates"`
+}
+
+// FileRateProvider 从 JSON 文件中读取汇率，文件修改之后下一次查询时重新加载，
+// 重新加载失败时继续使用上一次加载成功的汇率
+type FileRateProvider struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	rates   *StaticRateProvider
+}
+
+func NewFileRateProvider(path string) (*FileRateProvider, error) {
+	p := &FileRateProvider{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload 重新加载汇率文件
+func (p *FileRateProvider) Reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	var file rateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parse exchange rates %s: %w", p.path, err)
+	}
+	rates, err := NewStaticRateProvider(file.Base, file.Rates)
+	if err != nil {
+		return fmt.Errorf("parse exchange rates %s: %w", p.path, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rates, p.modTime = rates, info.ModTime()
+	return nil
+}
+
+func (p *FileRateProvider) Rate(ctx context.Context, from, to Currency) (*big.Rat, error) {
+	if info, err := os.Stat(p.path); err == nil && !info.ModTime().Equal(p.loadedAt()) {
+		_ = p.Reload()
+	}
+
+	p.mu.Lock()
+	rates := p.rates
+	p.mu.Unlock()
+	return rates.Rate(ctx, from, to)
+}
+
+func (p *FileRateProvider) loadedAt() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.modTime
+}
+
+// Conversion 一次兑换的明细
+type Conversion struct {
+	// Source 出账的金额，包含手续费
+	Source Money
+	// Fee 手续费，与 Source 的币种相同
+	Fee  Money
+	Rate *big.Rat
+	// Target 扣除手续费之后兑换得到的金额
+	Target Money
+}
+
+// CurrencyExchange 按照汇率和手续费率兑换金额
+type CurrencyExchange struct {
+	rates   RateProvider
+	feeRate *big.Rat
+}
+
+// NewCurrencyExchange feeRate 是手续费率，如 big.NewRat(3, 1000) 表示千分之三，nil 表示不收手续费
+func NewCurrencyExchange(rates RateProvider, feeRate *big.Rat) *CurrencyExchange {
+	if feeRate == nil {
+		feeRate = new(big.Rat)
+	}
+	return &CurrencyExchange{rates: rates, feeRate: feeRate}
+}
+
+// Convert 把 amount 兑换为 to 币种，手续费向上取整，兑换得到的金额向下取整，舍入的误差都由用户承担
+func (e *CurrencyExchange) Convert(ctx context.Context, amount Money, to Currency) (Conversion, error) {
+	rate, err := e.rates.Rate(ctx, amount.Currency(), to)
+	if err != nil {
+		return Conversion{}, err
+	}
+	fee, err := amount.MulRat(e.feeRate, RoundUp)
+	if err != nil {
+		return Conversion{}, err
+	}
+	net, err := amount.Sub(fee)
+	if err != nil {
+		return Conversion{}, err
+	}
+	target, err := net.Exchange(to, rate, RoundDown)
+	if err != nil {
+		return Conversion{}, err
+	}
+	return Conversion{Source: amount, Fee: fee, Rate: rate, Target: target}, nil
+}
+
+// TotalBalance 把各币种的余额按照汇率折算为 base 币种后求和，使用银行家舍入
+func (e *CurrencyExchange) TotalBalance(ctx context.Context, balances []Money, base Currency) (Money, error) {
+	total := NewMoney(0, base)
+	for _, balance := range balances {
+		rate, err := e.rates.Rate(ctx, balance.Currency(), base)
+		if err != nil {
+			return Money{}, err
+		}
+		converted, err := balance.Exchange(base, rate, RoundHalfEven)
+		if err != nil {
+			return Money{}, err
+		}
+		if total, err = total.Add(converted); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// ServiceOption 两种开发模式的 Service 共用的可选配置
+type ServiceOption func(*serviceOptions)
+
+type serviceOptions struct {
+	exchange *CurrencyExchange
+	// ledger 只有 DDDVirtualWalletService 使用，见 WithWalletLedger
+	ledger *WalletLedger
+	outbox OutboxRepository
+}
+
+// WithCurrencyExchange 支持跨币种转账和折算总余额
+func WithCurrencyExchange(exchange *CurrencyExchange) ServiceOption {
+	return func(o *serviceOptions) {
+		o.exchange = exchange
+	}
+}
+
+func newServiceOptions(opts []ServiceOption) serviceOptions {
+	var o serviceOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// totalBalance 两种开发模式的 Service 共用的折算总余额
+func (o serviceOptions) totalBalance(ctx context.Context, walletRepo VirtualWalletRepository, walletId string,
+	base Currency) (Money, error) {
+	if o.exchange == nil {
+		return Money{}, ErrExchangeUnavailable
+	}
+	entity, err := walletRepo.GetWalletEntity(ctx, walletId)
+	if err != nil {
+		return Money{}, err
+	}
+	return o.exchange.TotalBalance(ctx, entity.GetBalances(), base)
+}
+
+// formatRate 汇率的十进制形式，最多保留 10 位小数，用于记录在交易流水中
+func formatRate(rate *big.Rat) string {
+	text := rate.FloatString(10)
+	text = strings.TrimRight(text, "0")
+	return strings.TrimSuffix(text, ".")
+}
@@ -0,0 +1,194 @@
+package demo_wallet
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestRates(t *testing.T) *StaticRateProvider {
+	t.Helper()
+	rates, err := NewStaticRateProvider(USD, map[Currency]string{CNY: "6.4", EUR: "0.8", JPY: "110"})
+	if err != nil {
+		t.Fatalf("NewStaticRateProvider() error = %v", err)
+	}
+	return rates
+}
+
+func TestStaticRateProvider_Rate(t *testing.T) {
+	rates := newTestRates(t)
+	tests := []struct {
+		from, to Currency
+		want     string
+	}{
+		{USD, CNY, "6.4"},
+		{CNY, USD, "0.15625"},
+		{EUR, CNY, "8"},
+		{CNY, CNY, "1"},
+	}
+	for _, tt := range tests {
+		got, err := rates.Rate(context.Background(), tt.from, tt.to)
+		if err != nil || formatRate(got) != tt.want {
+			t.Errorf("Rate(%s, %s) got = %v, %v, want %s", tt.from, tt.to, got, err, tt.want)
+		}
+	}
+	if _, err := rates.Rate(context.Background(), CNY, "GBP"); !errors.Is(err, ErrRateNotFound) {
+		t.Errorf("Rate() error = %v, want %v", err, ErrRateNotFound)
+	}
+	if _, err := NewStaticRateProvider(USD, map[Currency]string{CNY: "-1"}); err == nil {
+		t.Error("NewStaticRateProvider() error = nil, want invalid rate")
+	}
+}
+
+func TestFileRateProvider_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modTime := time.Now().Add(-time.Hour)
+	write(`{"base": "USD", "rates": {"CNY": "6.4"}}`, modTime)
+	provider, err := NewFileRateProvider(path)
+	if err != nil {
+		t.Fatalf("NewFileRateProvider() error = %v", err)
+	}
+	assertRate := func(want string) {
+		t.Helper()
+		got, err := provider.Rate(context.Background(), USD, CNY)
+		if err != nil || formatRate(got) != want {
+			t.Errorf("Rate() got = %v, %v, want %s", got, err, want)
+		}
+	}
+	assertRate("6.4")
+
+	// 文件修改之后重新加载
+	write(`{"base": "USD", "rates": {"CNY": "6.5"}}`, modTime.Add(time.Minute))
+	assertRate("6.5")
+
+	// 文件格式错误时继续使用上一次加载成功的汇率
+	write(`{"base": "USD", "rates": {"CNY": "abc"}}`, modTime.Add(2*time.Minute))
+	assertRate("6.5")
+
+	if _, err := NewFileRateProvider(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("NewFileRateProvider() error = nil, want missing file")
+	}
+}
+
+func TestCurrencyExchange_Convert(t *testing.T) {
+	exchange := NewCurrencyExchange(newTestRates(t), big.NewRat(3, 1000))
+	tests := []struct {
+		amount Money
+		to     Currency
+		fee    Money
+		target Money
+	}{
+		// 手续费 0.30 USD，兑换 99.70 USD
+		{MustParseMoney("100", USD), CNY, MustParseMoney("0.30", USD), cny("638.08")},
+		// 手续费 0.003 向上取整为 0.01
+		{MustParseMoney("1", USD), JPY, MustParseMoney("0.01", USD), MustParseMoney("108", JPY)},
+		{MustParseMoney("1000", JPY), USD, MustParseMoney("3", JPY), MustParseMoney("9.06", USD)},
+	}
+	for _, tt := range tests {
+		got, err := exchange.Convert(context.Background(), tt.amount, tt.to)
+		if err != nil || got.Fee != tt.fee || got.Target != tt.target || got.Source != tt.amount {
+			t.Errorf("Convert(%v, %s) got = %+v, %v, want fee %v target %v", tt.amount, tt.to, got, err, tt.fee, tt.target)
+		}
+	}
+}
+
+func TestCurrencyExchange_TotalBalance(t *testing.T) {
+	exchange := NewCurrencyExchange(newTestRates(t), nil)
+	balances := []Money{cny("64"), MustParseMoney("10", USD), MustParseMoney("8", EUR), MustParseMoney("-110", JPY)}
+	if got, err := exchange.TotalBalance(context.Background(), balances, USD); err != nil || got != MustParseMoney("29", USD) {
+		t.Errorf("TotalBalance() got = %v, %v, want 29 USD", got, err)
+	}
+	if got, err := exchange.TotalBalance(context.Background(), nil, CNY); err != nil || got != cny("0") {
+		t.Errorf("TotalBalance() empty got = %v, %v", got, err)
+	}
+}
+
+func TestExchangeTransfer(t *testing.T) {
+	for name := range newTransferServices(NewMemoryStore(), nil) {
+		t.Run(name, func(t *testing.T) {
+			store := newMemoryStoreWithWallets(t, map[string]Money{"a": MustParseMoney("100", USD), "b": cny("0")})
+			rates := newTestRates(t)
+			exchange := NewCurrencyExchange(rates, big.NewRat(3, 1000))
+			services := map[string]interface {
+				transferService
+				ExchangeTransfer(ctx context.Context, idempotencyKey, fromWalletId, toWalletId string,
+					amount Money, toCurrency Currency) (*VirtualWalletTransactionEntity, error)
+				GetTotalBalance(ctx context.Context, walletId string, base Currency) (Money, error)
+			}{
+				"anaemic": NewVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository(),
+					WithCurrencyExchange(exchange)),
+				"rich": NewDDDVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository(),
+					WithCurrencyExchange(exchange)),
+			}
+			service := services[name]
+			ctx := context.Background()
+
+			transaction, err := service.ExchangeTransfer(ctx, "key-1", "a", "b", MustParseMoney("50", USD), CNY)
+			if err != nil || transaction.GetStatus() != SUCCEEDED {
+				t.Fatalf("ExchangeTransfer() got = %+v, %v", transaction, err)
+			}
+			if transaction.GetToAmount() != cny("319.04") || transaction.GetFee() != MustParseMoney("0.15", USD) ||
+				transaction.GetExchangeRate() != "6.4" {
+				t.Errorf("ExchangeTransfer() got to %v, fee %v, rate %s", transaction.GetToAmount(), transaction.GetFee(),
+					transaction.GetExchangeRate())
+			}
+
+			// 汇率变化之后重试，仍然使用第一次的入账金额
+			rates.rates[CNY] = big.NewRat(7, 1)
+			retry, err := service.ExchangeTransfer(ctx, "key-1", "a", "b", MustParseMoney("50", USD), CNY)
+			if err != nil || retry.GetToAmount() != cny("319.04") {
+				t.Fatalf("ExchangeTransfer() retry got = %+v, %v", retry, err)
+			}
+			if _, err := service.ExchangeTransfer(ctx, "key-1", "a", "b", MustParseMoney("50", USD), EUR); !errors.Is(err, ErrIdempotencyKeyConflict) {
+				t.Errorf("ExchangeTransfer() error = %v, want %v", err, ErrIdempotencyKeyConflict)
+			}
+
+			// 钱包 b 的主币种是人民币，同时也可以持有美元
+			if _, err := service.Transfer(ctx, "key-2", "a", "b", MustParseMoney("10", USD)); err != nil {
+				t.Fatalf("Transfer() error = %v", err)
+			}
+			entity, err := store.WalletRepository().GetWalletEntity(ctx, "b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []Money{cny("319.04"), MustParseMoney("10", USD)}
+			if got := entity.GetBalances(); !reflect.DeepEqual(got, want) {
+				t.Errorf("GetBalances() got = %v, want %v", got, want)
+			}
+			assertBalances(t, store, map[string]Money{"a": MustParseMoney("40", USD)})
+
+			// 319.04 / 7 = 45.577... 美元，加上 10 美元
+			if total, err := service.GetTotalBalance(ctx, "b", USD); err != nil || total != MustParseMoney("55.58", USD) {
+				t.Errorf("GetTotalBalance() got = %v, %v", total, err)
+			}
+		})
+	}
+}
+
+func TestExchangeTransfer_Unavailable(t *testing.T) {
+	store := newMemoryStoreWithWallets(t, map[string]Money{"a": MustParseMoney("100", USD), "b": cny("0")})
+	service := NewDDDVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository())
+	ctx := context.Background()
+
+	if _, err := service.ExchangeTransfer(ctx, "key-1", "a", "b", MustParseMoney("50", USD), CNY); !errors.Is(err, ErrExchangeUnavailable) {
+		t.Errorf("ExchangeTransfer() error = %v, want %v", err, ErrExchangeUnavailable)
+	}
+	if _, err := service.GetTotalBalance(ctx, "a", CNY); !errors.Is(err, ErrExchangeUnavailable) {
+		t.Errorf("GetTotalBalance() error = %v, want %v", err, ErrExchangeUnavailable)
+	}
+	assertBalances(t, store, map[string]Money{"a": MustParseMoney("100", USD), "b": cny("0")})
+}
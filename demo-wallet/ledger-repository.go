@@ -0,0 +1,104 @@
+package demo_wallet
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+var (
+	_ WalletEventStore    = (*SQLWalletEventStore)(nil)
+	_ WalletSnapshotStore = (*SQLWalletEventStore)(nil)
+)
+
+// SQLWalletEventStore 基于 database/sql 的事件账本和快照，事件的 payload 以 JSON 保存
+type SQLWalletEventStore struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+func NewSQLWalletEventStore(db *sql.DB) *SQLWalletEventStore {
+	return &SQLWalletEventStore{db: db, now: time.Now}
+}
+
+// Append 锁住钱包的最大序号后追加，并发追加同一个钱包时后来者返回 ErrConcurrentUpdate
+func (s *SQLWalletEventStore) Append(ctx context.Context, walletId string, expectedSequence int64, events []WalletEvent) error {
+	return NewSQLUnitOfWork(s.db).Do(ctx, func(ctx context.Context) error {
+		tx := sqlstore.ExecutorFrom(ctx, s.db)
+		var current int64
+		err := tx.QueryRowContext(ctx,
+			"SELECT COALESCE(MAX(sequence), 0) FROM virtual_wallet_event WHERE wallet_id = ? FOR UPDATE", walletId).Scan(&current)
+		if err != nil {
+			return err
+		}
+		if current != expectedSequence {
+			return fmt.Errorf("%w: %s at sequence %d, expected %d", ErrConcurrentUpdate, walletId, current, expectedSequence)
+		}
+
+		for _, event := range events {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO virtual_wallet_event (wallet_id, sequence, type, payload, occurred_at) VALUES (?, ?, ?, ?, ?)",
+				event.WalletId, event.Sequence, event.Type, string(event.Payload), event.OccurredAt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLWalletEventStore) Load(ctx context.Context, walletId string, afterSequence int64) ([]WalletEvent, error) {
+	rows, err := sqlstore.ExecutorFrom(ctx, s.db).QueryContext(ctx,
+		"SELECT wallet_id, sequence, type, payload, occurred_at FROM virtual_wallet_event "+
+			"WHERE wallet_id = ? AND sequence > ? ORDER BY sequence", walletId, afterSequence)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []WalletEvent
+	for rows.Next() {
+		var event WalletEvent
+		var payload string
+		if err := rows.Scan(&event.WalletId, &event.Sequence, &event.Type, &payload, &event.OccurredAt); err != nil {
+			return nil, err
+		}
+		event.Payload = json.RawMessage(payload)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *SQLWalletEventStore) SaveSnapshot(ctx context.Context, snapshot WalletSnapshot) error {
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = sqlstore.ExecutorFrom(ctx, s.db).ExecContext(ctx,
+		"INSERT INTO virtual_wallet_snapshot (wallet_id, sequence, payload, create_time) VALUES (?, ?, ?, ?)",
+		snapshot.WalletId, snapshot.Sequence, string(payload), s.now())
+	return err
+}
+
+func (s *SQLWalletEventStore) LatestSnapshot(ctx context.Context, walletId string) (WalletSnapshot, error) {
+	var payload string
+	err := sqlstore.ExecutorFrom(ctx, s.db).QueryRowContext(ctx,
+		"SELECT payload FROM virtual_wallet_snapshot WHERE wallet_id = ? ORDER BY sequence DESC LIMIT 1", walletId).Scan(&payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WalletSnapshot{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, walletId)
+	}
+	if err != nil {
+		return WalletSnapshot{}, err
+	}
+
+	var snapshot WalletSnapshot
+	if err := json.Unmarshal([]byte(payload), &snapshot); err != nil {
+		return WalletSnapshot{}, fmt.Errorf("%w: decode snapshot of %s: %v", ErrCorruptedLedger, walletId, err)
+	}
+	return snapshot, nil
+}
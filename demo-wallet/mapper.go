@@ -0,0 +1,74 @@
+package demo_wallet
+
+import "strings"
+
+// 三层结构中数据在 Entity、BO、VO 之间转换。转换函数由 cmd/mappergen 根据 //mapper:func 指令生成到 mapper_gen.go，
+// 每一对类型对应 Mapper 的一个方法，参数和返回值都是具体类型，不使用反射和 interface{}：
+// 同名字段自动赋值，改名、合并、脱敏写在指令的规则里，嵌套的结构体使用各自的转换函数。
+// 字段对不上时 go generate 失败，类型变化或者传错类型时编译期就能发现，转换不会失败，也不需要返回错误。
+// 修改了指令或者相关的结构体之后需要重新运行 go generate。
+
+//go:generate go run ./cmd/mappergen -output mapper_gen.go
+
+// Mapper 生成的转换函数的接收者，Masking 决定 VO 中敏感字段的脱敏规则，
+// 面向客服、风控等内部系统的 VO 使用 NoMasking 得到原始数据
+type Mapper struct {
+	Masking Masking
+}
+
+// mapper 两种开发模式的 Service 和 Controller 共用，面向用户，敏感字段脱敏
+var mapper = Mapper{Masking: UserMasking}
+
+// Masker 脱敏规则
+type Masker func(value string) string
+
+// Masking VO 中敏感字段的脱敏规则，为 nil 的规则不脱敏
+type Masking struct {
+	Cellphone Masker
+	Name      Masker
+}
+
+var (
+	// UserMasking 面向用户的 VO，手机号和姓名都脱敏
+	UserMasking = Masking{Cellphone: RedactCellphone, Name: RedactName}
+	// NoMasking 面向内部系统的 VO，不脱敏
+	NoMasking = Masking{}
+)
+
+// MaskCellphone 按照手机号的脱敏规则处理 cellphone
+func (m Masking) MaskCellphone(cellphone string) string {
+	return mask(m.Cellphone, cellphone)
+}
+
+// MaskName 按照姓名的脱敏规则处理 name
+func (m Masking) MaskName(name string) string {
+	return mask(m.Name, name)
+}
+
+func mask(masker Masker, value string) string {
+	if masker == nil {
+		return value
+	}
+	return masker(value)
+}
+
+// MaskMiddle 保留开头 keepHead 个和结尾 keepTail 个字符，中间替换为 *，太短时全部替换
+func MaskMiddle(keepHead, keepTail int) Masker {
+	return func(value string) string {
+		runes := []rune(value)
+		if len(runes) <= keepHead+keepTail {
+			return strings.Repeat("*", len(runes))
+		}
+		return string(runes[:keepHead]) + strings.Repeat("*", len(runes)-keepHead-keepTail) + string(runes[len(runes)-keepTail:])
+	}
+}
+
+// RedactCellphone 手机号保留前 3 位和后 4 位，如 138****5678
+func RedactCellphone(cellphone string) string {
+	return MaskMiddle(3, 4)(cellphone)
+}
+
+// RedactName 姓名只保留第一个字，如 张**
+func RedactName(name string) string {
+	return MaskMiddle(1, 0)(name)
+}
@@ -0,0 +1,86 @@
+// Code generated by mappergen. DO NOT EDIT.
+
+package demo_wallet
+
+// UserEntityToBo UserEntity 转换为 UserBo
+func (m Mapper) UserEntityToBo(src *UserEntity) UserBo {
+	return UserBo{
+		Id:        src.Id,
+		Name:      src.Name,
+		Cellphone: src.Cellphone,
+		Address:   m.AddressEntityToBo(&src.Address),
+	}
+}
+
+// AddressEntityToBo AddressEntity 转换为 AddressBo
+func (m Mapper) AddressEntityToBo(src *AddressEntity) AddressBo {
+	return AddressBo{
+		Province: src.Province,
+		City:     src.City,
+		Street:   src.Street,
+	}
+}
+
+// UserBoToVo UserBo 转换为 UserVo
+func (m Mapper) UserBoToVo(src *UserBo) UserVo {
+	return UserVo{
+		Id:        src.Id,
+		Name:      mask(m.Masking.Name, src.Name),
+		Cellphone: mask(m.Masking.Cellphone, src.Cellphone),
+		Address:   m.AddressBoToVo(&src.Address),
+	}
+}
+
+// AddressBoToVo AddressBo 转换为 AddressVo
+func (m Mapper) AddressBoToVo(src *AddressBo) AddressVo {
+	return AddressVo{
+		Region: addressRegion(src),
+	}
+}
+
+// WalletEntityToBo VirtualWalletEntity 转换为 VirtualWalletBo
+func (m Mapper) WalletEntityToBo(src *VirtualWalletEntity) *VirtualWalletBo {
+	return &VirtualWalletBo{
+		id:         src.id,
+		createTime: src.createTime,
+		balance:    src.GetBalance(),
+		balances:   src.GetBalances(),
+	}
+}
+
+// WalletToBo VirtualWallet 转换为 VirtualWalletBo
+func (m Mapper) WalletToBo(src *VirtualWallet) *VirtualWalletBo {
+	return &VirtualWalletBo{
+		id:         src.id,
+		createTime: src.createTime,
+		balance:    src.Balance(),
+		balances:   src.Balances(),
+	}
+}
+
+// WalletBoToVo VirtualWalletBo 转换为 VirtualWalletVo
+func (m Mapper) WalletBoToVo(src *VirtualWalletBo) *VirtualWalletVo {
+	return &VirtualWalletVo{
+		Id:         src.id,
+		Balance:    src.balance,
+		Balances:   src.balances,
+		CreateTime: src.createTime,
+	}
+}
+
+// TransactionEntityToVo VirtualWalletTransactionEntity 转换为 TransactionVo
+func (m Mapper) TransactionEntityToVo(src *VirtualWalletTransactionEntity) *TransactionVo {
+	return &TransactionVo{
+		Id:           src.id,
+		Type:         TransactionTypeName(src.transactionType),
+		Status:       TransactionStatusName(src.status),
+		FromWalletId: src.fromWalletId,
+		ToWalletId:   src.toWalletId,
+		Amount:       src.amount,
+		ToAmount:     transferToAmount(src),
+		Fee:          chargedFee(src),
+		ExchangeRate: src.exchangeRate,
+		FailReason:   src.failReason,
+		CreateTime:   src.createTime,
+	}
+}
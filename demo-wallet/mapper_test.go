@@ -0,0 +1,93 @@
+package demo_wallet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMaskers(t *testing.T) {
+	tests := []struct {
+		mask  Masker
+		value string
+		want  string
+	}{
+		{RedactCellphone, "13812345678", "138****5678"},
+		{RedactCellphone, "+8613812345678", "+86*******5678"},
+		{RedactCellphone, "1234567", "*******"},
+		{RedactName, "张三丰", "张**"},
+		{RedactName, "张", "*"},
+		{RedactName, "", ""},
+		{MaskMiddle(2, 2), "abcdef", "ab**ef"},
+	}
+	for _, tt := range tests {
+		if got := tt.mask(tt.value); got != tt.want {
+			t.Errorf("mask(%q) got = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestUserMapper(t *testing.T) {
+	entity := UserEntity{Id: "u1", Name: "张三", Cellphone: "13812345678",
+		Address: AddressEntity{Province: "浙江省", City: "杭州市", Street: "文三路 1 号"}}
+
+	bo := mapper.UserEntityToBo(&entity)
+	wantBo := UserBo{Id: "u1", Name: "张三", Cellphone: "13812345678",
+		Address: AddressBo{Province: "浙江省", City: "杭州市", Street: "文三路 1 号"}}
+	if bo != wantBo {
+		t.Errorf("UserEntityToBo() got = %+v, want %+v", bo, wantBo)
+	}
+
+	wantVo := UserVo{Id: "u1", Name: "张*", Cellphone: "138****5678", Address: AddressVo{Region: "浙江省杭州市"}}
+	if vo := mapper.UserBoToVo(&bo); vo != wantVo {
+		t.Errorf("UserBoToVo() got = %+v, want %+v", vo, wantVo)
+	}
+
+	// 面向内部系统的转换得到原始的姓名和手机号
+	internal := Mapper{Masking: NoMasking}
+	if vo := internal.UserBoToVo(&bo); vo.Name != "张三" || vo.Cellphone != "13812345678" || vo.Address.Region != "浙江省杭州市" {
+		t.Errorf("UserBoToVo() without masking got = %+v", vo)
+	}
+}
+
+func TestWalletMapper(t *testing.T) {
+	createTime := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	entity := NewVirtualWalletEntity("w1", CNY, createTime)
+	entity.setBalance(cny("10"))
+	entity.setBalance(MustParseMoney("2", USD))
+	entity.version = 3
+
+	wallet := walletEntityToWallet(entity)
+	if wallet.id != "w1" || wallet.version != 3 || wallet.Balance() != cny("10") || wallet.BalanceIn(USD) != MustParseMoney("2", USD) {
+		t.Errorf("walletEntityToWallet() got = %+v", wallet)
+	}
+	if err := wallet.Debit(cny("10")); err != nil {
+		t.Errorf("Debit() error = %v", err)
+	}
+
+	vo := mapper.WalletBoToVo(mapper.WalletToBo(wallet))
+	want := &VirtualWalletVo{Id: "w1", Balance: cny("0"), Balances: []Money{cny("0"), MustParseMoney("2", USD)}, CreateTime: createTime}
+	if !reflect.DeepEqual(vo, want) {
+		t.Errorf("WalletBoToVo() got = %+v, want %+v", vo, want)
+	}
+	if bo := mapper.WalletEntityToBo(entity); bo.balance != cny("10") || len(bo.balances) != 2 {
+		t.Errorf("WalletEntityToBo() got = %+v", bo)
+	}
+}
+
+func TestTransactionMapper(t *testing.T) {
+	createTime := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	debit := &VirtualWalletTransactionEntity{id: 1, amount: cny("5"), createTime: createTime, transactionType: DEBIT,
+		fromWalletId: "w1", status: SUCCEEDED}
+	vo := mapper.TransactionEntityToVo(debit)
+	if vo.Id != 1 || vo.Status != TransactionStatusName(SUCCEEDED) || vo.FromWalletId != "w1" || vo.Amount != cny("5") || vo.ToAmount != nil || vo.Fee != nil {
+		t.Errorf("TransactionEntityToVo() got = %+v", vo)
+	}
+
+	transfer := &VirtualWalletTransactionEntity{id: 2, amount: cny("10"), transactionType: TRANSFER,
+		toAmount: MustParseMoney("1.5", USD), fee: cny("0.1"), exchangeRate: "0.15"}
+	vo = mapper.TransactionEntityToVo(transfer)
+	if vo.ToAmount == nil || *vo.ToAmount != MustParseMoney("1.5", USD) || vo.Fee == nil || *vo.Fee != cny("0.1") {
+		t.Errorf("TransactionEntityToVo() transfer got = %+v", vo)
+	}
+}
@@ -0,0 +1,352 @@
+package demo_wallet
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryStore 内存中的钱包和交易流水，用于单元测试和本地演示。
+// 工作单元持有整个存储的锁，相当于对所有的数据加了行锁；fn 返回错误时恢复到工作单元开始时的状态。
+type MemoryStore struct {
+	mu           sync.Mutex
+	wallets      map[string]VirtualWalletEntity
+	transactions []VirtualWalletTransactionEntity
+	events       map[string][]WalletEvent
+	snapshots    map[string][]WalletSnapshot
+	outbox       []memoryOutboxMessage
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{wallets: make(map[string]VirtualWalletEntity), events: make(map[string][]WalletEvent),
+		snapshots: make(map[string][]WalletSnapshot)}
+}
+
+func (s *MemoryStore) UnitOfWork() UnitOfWork {
+	return memoryUnitOfWork{s}
+}
+
+func (s *MemoryStore) WalletRepository() VirtualWalletRepository {
+	return memoryWalletRepository{s}
+}
+
+func (s *MemoryStore) TransactionRepository() VirtualWalletTransactionRepository {
+	return memoryTransactionRepository{s}
+}
+
+func (s *MemoryStore) EventStore() WalletEventStore {
+	return memoryEventStore{s}
+}
+
+func (s *MemoryStore) SnapshotStore() WalletSnapshotStore {
+	return memoryEventStore{s}
+}
+
+func (s *MemoryStore) Outbox() OutboxRepository {
+	return memoryOutbox{s}
+}
+
+type memoryTxKey struct{}
+
+// withLock 在工作单元中时已经持有锁，直接执行 fn，否则加锁后执行
+func (s *MemoryStore) withLock(ctx context.Context, fn func() error) error {
+	if store, ok := ctx.Value(memoryTxKey{}).(*MemoryStore); ok && store == s {
+		return fn()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn()
+}
+
+type memoryState struct {
+	wallets      map[string]VirtualWalletEntity
+	transactions []VirtualWalletTransactionEntity
+	events       map[string][]WalletEvent
+	snapshots    map[string][]WalletSnapshot
+	outbox       []memoryOutboxMessage
+}
+
+// snapshot 事件和快照只会追加，复制切片的头部即可
+func (s *MemoryStore) snapshot() memoryState {
+	wallets := make(map[string]VirtualWalletEntity, len(s.wallets))
+	for id, wallet := range s.wallets {
+		wallets[id] = wallet
+	}
+	events := make(map[string][]WalletEvent, len(s.events))
+	for id, walletEvents := range s.events {
+		events[id] = walletEvents[:len(walletEvents):len(walletEvents)]
+	}
+	snapshots := make(map[string][]WalletSnapshot, len(s.snapshots))
+	for id, walletSnapshots := range s.snapshots {
+		snapshots[id] = walletSnapshots[:len(walletSnapshots):len(walletSnapshots)]
+	}
+	return memoryState{wallets: wallets, transactions: append([]VirtualWalletTransactionEntity(nil), s.transactions...),
+		events: events, snapshots: snapshots, outbox: append([]memoryOutboxMessage(nil), s.outbox...)}
+}
+
+func (s *MemoryStore) restore(state memoryState) {
+	s.wallets, s.transactions, s.events, s.snapshots = state.wallets, state.transactions, state.events, state.snapshots
+	s.outbox = state.outbox
+}
+
+type memoryUnitOfWork struct {
+	store *MemoryStore
+}
+
+func (u memoryUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if store, ok := ctx.Value(memoryTxKey{}).(*MemoryStore); ok && store == u.store {
+		return fn(ctx)
+	}
+
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+
+	state := u.store.snapshot()
+	defer func() {
+		if p := recover(); p != nil {
+			u.store.restore(state)
+			panic(p)
+		}
+		if err != nil {
+			u.store.restore(state)
+		}
+	}()
+	return fn(context.WithValue(ctx, memoryTxKey{}, u.store))
+}
+
+type memoryWalletRepository struct {
+	store *MemoryStore
+}
+
+func (r memoryWalletRepository) CreateWallet(ctx context.Context, entity *VirtualWalletEntity) error {
+	return r.store.withLock(ctx, func() error {
+		if _, ok := r.store.wallets[entity.id]; ok {
+			return fmt.Errorf("wallet %s already exists", entity.id)
+		}
+		r.store.wallets[entity.id] = *entity
+		return nil
+	})
+}
+
+func (r memoryWalletRepository) GetWalletEntity(ctx context.Context, walletId string) (*VirtualWalletEntity, error) {
+	var entity VirtualWalletEntity
+	err := r.store.withLock(ctx, func() error {
+		var ok bool
+		if entity, ok = r.store.wallets[walletId]; !ok {
+			return fmt.Errorf("%w: %s", ErrWalletNotFound, walletId)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
+func (r memoryWalletRepository) GetWalletEntityForUpdate(ctx context.Context, walletId string) (*VirtualWalletEntity, error) {
+	return r.GetWalletEntity(ctx, walletId)
+}
+
+func (r memoryWalletRepository) GetBalance(ctx context.Context, walletId string) (Money, error) {
+	entity, err := r.GetWalletEntity(ctx, walletId)
+	if err != nil {
+		return Money{}, err
+	}
+	return entity.GetBalance(), nil
+}
+
+func (r memoryWalletRepository) UpdateBalance(ctx context.Context, walletId string, balance Money, version int64) error {
+	return r.store.withLock(ctx, func() error {
+		entity, ok := r.store.wallets[walletId]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrWalletNotFound, walletId)
+		}
+		if entity.version != version {
+			return fmt.Errorf("%w: %s at version %d", ErrConcurrentUpdate, walletId, version)
+		}
+		entity.setBalance(balance)
+		entity.version++
+		r.store.wallets[walletId] = entity
+		return nil
+	})
+}
+
+type memoryTransactionRepository struct {
+	store *MemoryStore
+}
+
+func (r memoryTransactionRepository) SaveTransaction(ctx context.Context, entity *VirtualWalletTransactionEntity) error {
+	return r.store.withLock(ctx, func() error {
+		for _, transaction := range r.store.transactions {
+			if entity.idempotencyKey != "" && transaction.idempotencyKey == entity.idempotencyKey {
+				return fmt.Errorf("duplicate idempotency key %s", entity.idempotencyKey)
+			}
+		}
+		entity.id = int64(len(r.store.transactions) + 1)
+		r.store.transactions = append(r.store.transactions, *entity)
+		return nil
+	})
+}
+
+func (r memoryTransactionRepository) GetTransactionByIdempotencyKey(ctx context.Context, key string) (*VirtualWalletTransactionEntity, error) {
+	var found *VirtualWalletTransactionEntity
+	err := r.store.withLock(ctx, func() error {
+		for _, transaction := range r.store.transactions {
+			if transaction.idempotencyKey == key {
+				found = &transaction
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrTransactionNotFound, key)
+	})
+	return found, err
+}
+
+func (r memoryTransactionRepository) GetTransactionForUpdate(ctx context.Context, id int64) (*VirtualWalletTransactionEntity, error) {
+	var found VirtualWalletTransactionEntity
+	err := r.store.withLock(ctx, func() error {
+		if id < 1 || id > int64(len(r.store.transactions)) {
+			return fmt.Errorf("%w: %d", ErrTransactionNotFound, id)
+		}
+		found = r.store.transactions[id-1]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &found, nil
+}
+
+func (r memoryTransactionRepository) UpdateTransactionStatus(ctx context.Context, id int64, status int, failReason string) error {
+	return r.store.withLock(ctx, func() error {
+		if id < 1 || id > int64(len(r.store.transactions)) {
+			return fmt.Errorf("%w: %d", ErrTransactionNotFound, id)
+		}
+		if transaction := &r.store.transactions[id-1]; transaction.status == PENDING {
+			transaction.status, transaction.failReason = status, failReason
+		}
+		return nil
+	})
+}
+
+func (r memoryTransactionRepository) QueryTransactions(ctx context.Context, filter TransactionFilter) ([]*VirtualWalletTransactionEntity, error) {
+	var found []*VirtualWalletTransactionEntity
+	err := r.store.withLock(ctx, func() error {
+		for i := len(r.store.transactions) - 1; i >= 0 && len(found) < filter.Limit; i-- {
+			if transaction := r.store.transactions[i]; filter.matches(&transaction) {
+				found = append(found, &transaction)
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r memoryTransactionRepository) SumMovements(ctx context.Context, walletId string, currency Currency,
+	afterId, beforeId int64) (Money, error) {
+	sum := NewMoney(0, currency)
+	err := r.store.withLock(ctx, func() error {
+		filter := TransactionFilter{WalletId: walletId, BeforeId: beforeId}
+		for i := afterId; i < int64(len(r.store.transactions)); i++ {
+			transaction := r.store.transactions[i]
+			if amount := movement(&transaction, walletId); filter.matches(&transaction) && amount.Currency() == currency {
+				var err error
+				if sum, err = sum.Add(amount); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return sum, err
+}
+
+type memoryEventStore struct {
+	store *MemoryStore
+}
+
+func (r memoryEventStore) Append(ctx context.Context, walletId string, expectedSequence int64, events []WalletEvent) error {
+	return r.store.withLock(ctx, func() error {
+		if current := int64(len(r.store.events[walletId])); current != expectedSequence {
+			return fmt.Errorf("%w: %s at sequence %d, expected %d", ErrConcurrentUpdate, walletId, current, expectedSequence)
+		}
+		r.store.events[walletId] = append(r.store.events[walletId], events...)
+		return nil
+	})
+}
+
+func (r memoryEventStore) Load(ctx context.Context, walletId string, afterSequence int64) ([]WalletEvent, error) {
+	var events []WalletEvent
+	err := r.store.withLock(ctx, func() error {
+		if walletEvents := r.store.events[walletId]; afterSequence < int64(len(walletEvents)) {
+			events = append(events, walletEvents[afterSequence:]...)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (r memoryEventStore) SaveSnapshot(ctx context.Context, snapshot WalletSnapshot) error {
+	return r.store.withLock(ctx, func() error {
+		r.store.snapshots[snapshot.WalletId] = append(r.store.snapshots[snapshot.WalletId], snapshot)
+		return nil
+	})
+}
+
+func (r memoryEventStore) LatestSnapshot(ctx context.Context, walletId string) (WalletSnapshot, error) {
+	var latest WalletSnapshot
+	err := r.store.withLock(ctx, func() error {
+		snapshots := r.store.snapshots[walletId]
+		if len(snapshots) == 0 {
+			return fmt.Errorf("%w: %s", ErrSnapshotNotFound, walletId)
+		}
+		latest = snapshots[len(snapshots)-1]
+		return nil
+	})
+	return latest, err
+}
+
+type memoryOutboxMessage struct {
+	OutboxMessage
+	published bool
+}
+
+type memoryOutbox struct {
+	store *MemoryStore
+}
+
+func (r memoryOutbox) Add(ctx context.Context, messages ...OutboxMessage) error {
+	return r.store.withLock(ctx, func() error {
+		for i := range messages {
+			messages[i].Id = int64(len(r.store.outbox) + 1)
+			r.store.outbox = append(r.store.outbox, memoryOutboxMessage{OutboxMessage: messages[i]})
+		}
+		return nil
+	})
+}
+
+func (r memoryOutbox) Unpublished(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	err := r.store.withLock(ctx, func() error {
+		for _, message := range r.store.outbox {
+			if !message.published && len(messages) < limit {
+				messages = append(messages, message.OutboxMessage)
+			}
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (r memoryOutbox) MarkPublished(ctx context.Context, ids []int64, publishTime time.Time) error {
+	return r.store.withLock(ctx, func() error {
+		for _, id := range ids {
+			if id < 1 || id > int64(len(r.store.outbox)) {
+				return fmt.Errorf("outbox message %d not found", id)
+			}
+			r.store.outbox[id-1].published = true
+		}
+		return nil
+	})
+}
@@ -0,0 +1,89 @@
+package demo_wallet
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+// walletMigrations 按顺序执行的建表语句（MySQL），已经发布的语句不能修改，表结构变更时在末尾追加。
+// 执行过的版本记录在 schema_migrations 表中。MySQL 的 DDL 不能回滚，执行到一半退出时需要人工处理，见 sqlstore.Migrate。
+var walletMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS virtual_wallet (
+		id          VARCHAR(64)   NOT NULL,
+		balance     DECIMAL(19,4) NOT NULL DEFAULT 0,
+		version     BIGINT        NOT NULL DEFAULT 0,
+		create_time TIMESTAMP     NOT NULL,
+		update_time TIMESTAMP     NOT NULL,
+		PRIMARY KEY (id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS virtual_wallet_transaction (
+		id             BIGINT        NOT NULL AUTO_INCREMENT,
+		amount         DECIMAL(19,4) NOT NULL,
+		create_time    TIMESTAMP     NOT NULL,
+		type           INT           NOT NULL,
+		from_wallet_id VARCHAR(64)   NOT NULL DEFAULT '',
+		to_wallet_id   VARCHAR(64)   NOT NULL DEFAULT '',
+		PRIMARY KEY (id),
+		KEY idx_from_wallet (from_wallet_id, create_time),
+		KEY idx_to_wallet (to_wallet_id, create_time)
+	)`,
+	`ALTER TABLE virtual_wallet_transaction
+		ADD COLUMN status          INT          NOT NULL DEFAULT 0,
+		ADD COLUMN idempotency_key VARCHAR(64)  NULL,
+		ADD COLUMN fail_reason     VARCHAR(255) NOT NULL DEFAULT '',
+		ADD UNIQUE KEY uk_idempotency_key (idempotency_key)`,
+	// 金额由 DECIMAL 元改为 BIGINT 最小货币单位，已有的数据都是人民币
+	`ALTER TABLE virtual_wallet ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'CNY' AFTER balance`,
+	`ALTER TABLE virtual_wallet_transaction ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'CNY' AFTER amount`,
+	`UPDATE virtual_wallet SET balance = ROUND(balance * 100)`,
+	`UPDATE virtual_wallet_transaction SET amount = ROUND(amount * 100)`,
+	`ALTER TABLE virtual_wallet MODIFY COLUMN balance BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE virtual_wallet_transaction MODIFY COLUMN amount BIGINT NOT NULL`,
+	// 多币种：余额按照币种拆分到单独的表中，virtual_wallet.currency 改为钱包的主币种
+	`CREATE TABLE IF NOT EXISTS virtual_wallet_balance (
+		wallet_id VARCHAR(64) NOT NULL,
+		currency  CHAR(3)     NOT NULL,
+		balance   BIGINT      NOT NULL DEFAULT 0,
+		PRIMARY KEY (wallet_id, currency)
+	)`,
+	`INSERT INTO virtual_wallet_balance (wallet_id, currency, balance) SELECT id, currency, balance FROM virtual_wallet`,
+	`ALTER TABLE virtual_wallet DROP COLUMN balance`,
+	`ALTER TABLE virtual_wallet_transaction
+		ADD COLUMN to_amount     BIGINT      NULL,
+		ADD COLUMN to_currency   CHAR(3)     NULL,
+		ADD COLUMN exchange_rate VARCHAR(32) NOT NULL DEFAULT '',
+		ADD COLUMN fee           BIGINT      NOT NULL DEFAULT 0`,
+	// 事件溯源的账本和快照
+	`CREATE TABLE IF NOT EXISTS virtual_wallet_event (
+		wallet_id   VARCHAR(64)  NOT NULL,
+		sequence    BIGINT       NOT NULL,
+		type        VARCHAR(64)  NOT NULL,
+		payload     TEXT         NOT NULL,
+		occurred_at TIMESTAMP(6) NOT NULL,
+		PRIMARY KEY (wallet_id, sequence)
+	)`,
+	`CREATE TABLE IF NOT EXISTS virtual_wallet_snapshot (
+		wallet_id   VARCHAR(64) NOT NULL,
+		sequence    BIGINT      NOT NULL,
+		payload     TEXT        NOT NULL,
+		create_time TIMESTAMP   NOT NULL,
+		PRIMARY KEY (wallet_id, sequence)
+	)`,
+	// 事务性 outbox，publish_time 为 NULL 表示还没有发布
+	`CREATE TABLE IF NOT EXISTS virtual_wallet_outbox (
+		id           BIGINT      NOT NULL AUTO_INCREMENT,
+		message_key  VARCHAR(64) NOT NULL,
+		payload      TEXT        NOT NULL,
+		create_time  TIMESTAMP   NOT NULL,
+		publish_time TIMESTAMP   NULL,
+		PRIMARY KEY (id),
+		KEY idx_unpublished (publish_time, id)
+	)`,
+}
+
+// Migrate 执行还没有执行过的建表语句，返回本次执行的语句数量
+func Migrate(ctx context.Context, db *sql.DB) (int, error) {
+	return sqlstore.Migrate(ctx, db, "schema_migrations", walletMigrations)
+}
@@ -0,0 +1,350 @@
+package demo_wallet
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money 金额值对象，使用整数保存最小货币单位（如人民币的分），避免 float64 在多次运算之后丢失精度。
+// Money 是不可变的，所有的运算都返回新的 Money；运算结果溢出、币种不一致时返回错误。
+// 零值 Money{} 表示没有币种的 0，可以与任意币种的金额运算。
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrMoneyOverflow    = errors.New("money overflow")
+	ErrInvalidMoney     = errors.New("invalid money")
+)
+
+// Currency ISO 4217 币种代码
+type Currency string
+
+const (
+	CNY Currency = "CNY"
+	USD Currency = "USD"
+	EUR Currency = "EUR"
+	JPY Currency = "JPY"
+)
+
+// DefaultCurrency 没有指定币种的钱包使用的币种
+const DefaultCurrency = CNY
+
+// currencyDigits 小数位数不是 2 的币种
+var currencyDigits = map[Currency]int{
+	JPY:   0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Digits 币种的小数位数，即最小货币单位是 1 / 10^Digits
+func (c Currency) Digits() int {
+	if digits, ok := currencyDigits[c]; ok {
+		return digits
+	}
+	return 2
+}
+
+// RoundingMode 运算结果不是整数个最小货币单位时的舍入方式
+type RoundingMode int
+
+const (
+	// RoundHalfUp 四舍五入，.5 远离 0
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven 银行家舍入，.5 舍入到偶数
+	RoundHalfEven
+	// RoundDown 向 0 舍入（截断）
+	RoundDown
+	// RoundUp 远离 0 舍入
+	RoundUp
+)
+
+type Money struct {
+	amount   int64
+	currency Currency
+}
+
+// NewMoney 以最小货币单位创建金额，如 NewMoney(1234, CNY) 表示 12.34 元
+func NewMoney(minorUnits int64, currency Currency) Money {
+	return Money{amount: minorUnits, currency: currency}
+}
+
+// ParseMoney 解析十进制字符串，如 "12.34"、"-0.5"，小数位数不能超过币种的小数位数
+func ParseMoney(s string, currency Currency) (Money, error) {
+	invalid := func(reason string) (Money, error) {
+		return Money{}, fmt.Errorf("%w: %q %s", ErrInvalidMoney, s, reason)
+	}
+
+	// 最多一个正负号，"-+5" 之类的写法不是合法的数字
+	text := strings.TrimSpace(s)
+	negative := false
+	if text != "" && (text[0] == '-' || text[0] == '+') {
+		negative = text[0] == '-'
+		text = text[1:]
+	}
+
+	integer, fraction := text, ""
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		integer, fraction = text[:i], text[i+1:]
+	}
+	if integer == "" || strings.Trim(integer+fraction, "0123456789") != "" {
+		return invalid("is not a decimal number")
+	}
+	if len(fraction) > currency.Digits() {
+		return invalid(fmt.Sprintf("has more than %d decimal places", currency.Digits()))
+	}
+	fraction += strings.Repeat("0", currency.Digits()-len(fraction))
+
+	amount, err := strconv.ParseInt(integer+fraction, 10, 64)
+	if err != nil {
+		return invalid("overflows")
+	}
+	if negative {
+		amount = -amount
+	}
+	return NewMoney(amount, currency), nil
+}
+
+// MustParseMoney 与 ParseMoney 相同，解析失败时 panic，用于常量和测试
+func MustParseMoney(s string, currency Currency) Money {
+	m, err := ParseMoney(s, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// MinorUnits 以最小货币单位表示的金额
+func (m Money) MinorUnits() int64 {
+	return m.amount
+}
+
+func (m Money) Currency() Currency {
+	return m.currency
+}
+
+func (m Money) IsZero() bool {
+	return m.amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.amount < 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.amount > 0
+}
+
+// compatible 判断两个金额能否运算，返回结果的币种
+func (m Money) compatible(other Money) (Currency, error) {
+	switch {
+	case m.currency == other.currency:
+		return m.currency, nil
+	case m.currency == "" && m.amount == 0:
+		return other.currency, nil
+	case other.currency == "" && other.amount == 0:
+		return m.currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency, other.currency)
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	currency, err := m.compatible(other)
+	if err != nil {
+		return Money{}, err
+	}
+	sum := m.amount + other.amount
+	if (other.amount > 0 && sum < m.amount) || (other.amount < 0 && sum > m.amount) {
+		return Money{}, fmt.Errorf("%w: %s + %s", ErrMoneyOverflow, m, other)
+	}
+	return NewMoney(sum, currency), nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if other.amount == math.MinInt64 {
+		return Money{}, fmt.Errorf("%w: %s - %s", ErrMoneyOverflow, m, other)
+	}
+	return m.Add(other.Neg())
+}
+
+// Neg 返回相反数，math.MinInt64 没有相反数，原样返回
+func (m Money) Neg() Money {
+	if m.amount == math.MinInt64 {
+		return m
+	}
+	return NewMoney(-m.amount, m.currency)
+}
+
+// Mul 乘以整数
+func (m Money) Mul(factor int64) (Money, error) {
+	if m.amount == 0 || factor == 0 {
+		return NewMoney(0, m.currency), nil
+	}
+	product := m.amount * factor
+	if product/factor != m.amount || (factor == -1 && m.amount == math.MinInt64) {
+		return Money{}, fmt.Errorf("%w: %s * %d", ErrMoneyOverflow, m, factor)
+	}
+	return NewMoney(product, m.currency), nil
+}
+
+// MulRat 乘以有理数（如汇率、费率），结果按照 mode 舍入到最小货币单位
+func (m Money) MulRat(factor *big.Rat, mode RoundingMode) (Money, error) {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.amount), factor)
+	amount, err := roundRat(product, mode)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %s * %s", err, m, factor.RatString())
+	}
+	return NewMoney(amount, m.currency), nil
+}
+
+// Exchange 按照汇率 rate（1 单位本币种兑换多少单位 to）兑换为 to 币种，结果按照 mode 舍入到 to 的最小货币单位
+func (m Money) Exchange(to Currency, rate *big.Rat, mode RoundingMode) (Money, error) {
+	// 最小货币单位之间的换算还要考虑两个币种小数位数的差异，如 1 USD = 100 美分，1 JPY = 1 円
+	factor := new(big.Rat).Mul(rate, pow10Rat(to.Digits()-m.currency.Digits()))
+	converted, err := m.MulRat(factor, mode)
+	if err != nil {
+		return Money{}, err
+	}
+	return NewMoney(converted.amount, to), nil
+}
+
+func pow10Rat(exp int) *big.Rat {
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exp))), nil)
+	if exp < 0 {
+		return new(big.Rat).SetFrac(big.NewInt(1), pow)
+	}
+	return new(big.Rat).SetInt(pow)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// roundRat 把有理数舍入为 int64
+func roundRat(r *big.Rat, mode RoundingMode) (int64, error) {
+	quo, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		// 余数的两倍与分母比较，判断是否超过一半
+		half := new(big.Int).Abs(rem)
+		half.Lsh(half, 1)
+		cmp := half.Cmp(r.Denom())
+
+		away := false
+		switch mode {
+		case RoundHalfUp:
+			away = cmp >= 0
+		case RoundHalfEven:
+			away = cmp > 0 || (cmp == 0 && quo.Bit(0) == 1)
+		case RoundUp:
+			away = true
+		}
+		if away {
+			quo.Add(quo, big.NewInt(int64(r.Sign())))
+		}
+	}
+
+	if !quo.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return quo.Int64(), nil
+}
+
+// Cmp 比较两个金额，币种不一致时返回错误
+func (m Money) Cmp(other Money) (int, error) {
+	if _, err := m.compatible(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.amount < other.amount:
+		return -1, nil
+	case m.amount > other.amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Decimal 十进制字符串形式的金额，如 "12.34"
+func (m Money) Decimal() string {
+	digits := m.currency.Digits()
+	text := strconv.FormatInt(m.amount, 10)
+	if digits == 0 {
+		return text
+	}
+
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	if len(text) <= digits {
+		text = strings.Repeat("0", digits-len(text)+1) + text
+	}
+	return sign + text[:len(text)-digits] + "." + text[len(text)-digits:]
+}
+
+// String 如 "12.34 CNY"
+func (m Money) String() string {
+	if m.currency == "" {
+		return m.Decimal()
+	}
+	return m.Decimal() + " " + string(m.currency)
+}
+
+type moneyJSON struct {
+	Amount   string   `json:"amount"`
+	Currency Currency `json:"currency"`
+}
+
+// MarshalJSON 金额序列化为字符串，避免 JSON 的 number 在客户端被解析为浮点数
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Currency: m.currency})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var v moneyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	parsed, err := ParseMoney(v.Amount, v.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value 以最小货币单位保存到数据库，币种保存在单独的列中
+func (m Money) Value() (driver.Value, error) {
+	return m.amount, nil
+}
+
+// Scan 从数据库中读取最小货币单位，保留 Money 原来的币种
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		m.amount = v
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return fmt.Errorf("%w: cannot scan %T into Money", ErrInvalidMoney, src)
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	amount, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMoney, err)
+	}
+	m.amount = amount
+	return nil
+}
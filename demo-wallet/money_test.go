@@ -0,0 +1,161 @@
+package demo_wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input    string
+		currency Currency
+		want     int64
+		decimal  string
+		wantErr  bool
+	}{
+		{"12.34", CNY, 1234, "12.34", false},
+		{"12.3", CNY, 1230, "12.30", false},
+		{"-0.5", CNY, -50, "-0.50", false},
+		{"+7", USD, 700, "7.00", false},
+		{"0.05", CNY, 5, "0.05", false},
+		{"100", JPY, 100, "100", false},
+		{"1.234", "KWD", 1234, "1.234", false},
+		{"12.345", CNY, 0, "", true},
+		{"1.5", JPY, 0, "", true},
+		{"", CNY, 0, "", true},
+		{".5", CNY, 0, "", true},
+		{"1e3", CNY, 0, "", true},
+		{"1.2.3", CNY, 0, "", true},
+		{"-+5", CNY, 0, "", true},
+		{"+-5", CNY, 0, "", true},
+		{"--5", CNY, 0, "", true},
+		{"92233720368547758.08", CNY, 0, "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.input, tt.currency)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMoney(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, ErrInvalidMoney) {
+				t.Errorf("ParseMoney(%q) error = %v, want %v", tt.input, err, ErrInvalidMoney)
+			}
+			continue
+		}
+		if got.MinorUnits() != tt.want || got.Currency() != tt.currency || got.Decimal() != tt.decimal {
+			t.Errorf("ParseMoney(%q) got = %v (%d), want %s (%d)", tt.input, got, got.MinorUnits(), tt.decimal, tt.want)
+		}
+	}
+}
+
+func TestMoney_Arithmetic(t *testing.T) {
+	a, b := cny("10.10"), cny("0.20")
+	if sum, err := a.Add(b); err != nil || sum != cny("10.30") {
+		t.Errorf("Add() got = %v, %v", sum, err)
+	}
+	if diff, err := b.Sub(a); err != nil || diff != cny("-9.90") || !diff.IsNegative() {
+		t.Errorf("Sub() got = %v, %v", diff, err)
+	}
+	if product, err := b.Mul(3); err != nil || product != cny("0.60") {
+		t.Errorf("Mul() got = %v, %v", product, err)
+	}
+	// 零值 Money 可以与任意币种运算
+	if sum, err := (Money{}).Add(a); err != nil || sum != a {
+		t.Errorf("Add() zero got = %v, %v", sum, err)
+	}
+
+	if _, err := a.Add(MustParseMoney("1", USD)); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add() error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if _, err := a.Cmp(MustParseMoney("1", USD)); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Cmp() error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if cmp, err := a.Cmp(b); err != nil || cmp != 1 {
+		t.Errorf("Cmp() got = %v, %v", cmp, err)
+	}
+}
+
+func TestMoney_Overflow(t *testing.T) {
+	max, min := NewMoney(math.MaxInt64, CNY), NewMoney(math.MinInt64, CNY)
+	overflows := map[string]func() (Money, error){
+		"add": func() (Money, error) { return max.Add(cny("0.01")) },
+		"sub": func() (Money, error) { return min.Sub(cny("0.01")) },
+		"neg": func() (Money, error) { return cny("0").Sub(min) },
+		"mul": func() (Money, error) { return max.Mul(2) },
+		"-1":  func() (Money, error) { return min.Mul(-1) },
+		"rat": func() (Money, error) { return max.MulRat(big.NewRat(3, 2), RoundHalfUp) },
+	}
+	for name, fn := range overflows {
+		if got, err := fn(); !errors.Is(err, ErrMoneyOverflow) {
+			t.Errorf("%s got = %v, %v, want %v", name, got, err, ErrMoneyOverflow)
+		}
+	}
+}
+
+func TestMoney_MulRat(t *testing.T) {
+	tests := []struct {
+		amount string
+		mode   RoundingMode
+		want   string
+	}{
+		// 乘以 1/2 之后正好是 .5 分
+		{"0.05", RoundHalfUp, "0.03"},
+		{"0.05", RoundHalfEven, "0.02"},
+		{"0.07", RoundHalfEven, "0.04"},
+		{"0.05", RoundDown, "0.02"},
+		{"0.05", RoundUp, "0.03"},
+		{"-0.05", RoundHalfUp, "-0.03"},
+		{"-0.05", RoundHalfEven, "-0.02"},
+		{"-0.05", RoundDown, "-0.02"},
+		{"-0.05", RoundUp, "-0.03"},
+		{"0.04", RoundUp, "0.02"},
+	}
+	for _, tt := range tests {
+		got, err := cny(tt.amount).MulRat(big.NewRat(1, 2), tt.mode)
+		if err != nil || got != cny(tt.want) {
+			t.Errorf("MulRat(%s, %v) got = %v, %v, want %s", tt.amount, tt.mode, got, err, tt.want)
+		}
+	}
+
+	// 汇率换算：100 USD * 7.1234 = 712.34 CNY
+	rate, _ := new(big.Rat).SetString("7.1234")
+	if got, err := MustParseMoney("100", USD).MulRat(rate, RoundHalfEven); err != nil || got.MinorUnits() != 71234 {
+		t.Errorf("MulRat() got = %v, %v", got, err)
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	data, err := json.Marshal(cny("-12.30"))
+	if err != nil || string(data) != `{"amount":"-12.30","currency":"CNY"}` {
+		t.Fatalf("Marshal() got = %s, %v", data, err)
+	}
+
+	var got Money
+	if err := json.Unmarshal(data, &got); err != nil || got != cny("-12.30") {
+		t.Errorf("Unmarshal() got = %v, %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(`{"amount":"0.001","currency":"CNY"}`), &got); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("Unmarshal() error = %v, want %v", err, ErrInvalidMoney)
+	}
+}
+
+func TestMoney_Scan(t *testing.T) {
+	value, err := cny("12.34").Value()
+	if err != nil || value != int64(1234) {
+		t.Fatalf("Value() got = %v, %v", value, err)
+	}
+
+	for _, src := range []interface{}{int64(1234), []byte("1234"), "1234"} {
+		got := NewMoney(0, CNY)
+		if err := got.Scan(src); err != nil || got != cny("12.34") {
+			t.Errorf("Scan(%v) got = %v, %v", src, got, err)
+		}
+	}
+	if err := new(Money).Scan(12.34); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("Scan(float64) error = %v, want %v", err, ErrInvalidMoney)
+	}
+}
@@ -0,0 +1,136 @@
+package demo_wallet
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const defaultOutboxBatchSize = 100
+
+// NewOutboxProducerConfig OutboxRelay 使用的 producer 配置：
+// 所有副本确认之后才算发送成功；开启幂等，sarama 内部重试时不会重复写入，也不会打乱同一个分区中消息的顺序。
+func NewOutboxProducerConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_11_0_0
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	config.Producer.Idempotent = true
+	config.Producer.Retry.Max = 5
+	config.Net.MaxOpenRequests = 1
+	return config
+}
+
+// OutboxRelay 把 outbox 中还没有发布的消息发布到 Kafka。
+// 读取、发布和标记在同一个工作单元中，Unpublished 锁住了这一批消息，多个实例同时运行时后来者等待，不会打乱消息的顺序。
+type OutboxRelay struct {
+	uow       UnitOfWork
+	outbox    OutboxRepository
+	producer  sarama.SyncProducer
+	topic     string
+	batchSize int
+	now       func() time.Time
+
+	// state 保护 relaying 和 stopped，stop 关闭之后后台协程退出，退出时关闭 done
+	state    sync.Mutex
+	relaying bool
+	stopped  bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewOutboxRelay batchSize 小于等于 0 时使用默认值
+func NewOutboxRelay(uow UnitOfWork, outbox OutboxRepository, producer sarama.SyncProducer, topic string,
+	batchSize int) *OutboxRelay {
+	if batchSize <= 0 {
+		batchSize = defaultOutboxBatchSize
+	}
+	return &OutboxRelay{uow: uow, outbox: outbox, producer: producer, topic: topic, batchSize: batchSize, now: time.Now,
+		stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// RelayOnce 按照顺序发布一批消息，返回发布成功的数量。
+// 一条消息发布失败时，它之前的消息标记为已发布，它和之后的消息留到下一次发布，同时返回发布的错误。
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	var published []int64
+	var sendErr error
+	err := r.uow.Do(ctx, func(ctx context.Context) error {
+		published, sendErr = nil, nil
+		messages, err := r.outbox.Unpublished(ctx, r.batchSize)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			_, _, sendErr = r.producer.SendMessage(&sarama.ProducerMessage{
+				Topic: r.topic,
+				Key:   sarama.StringEncoder(message.Key),
+				Value: sarama.ByteEncoder(message.Payload),
+			})
+			if sendErr != nil {
+				break
+			}
+			published = append(published, message.Id)
+		}
+		if len(published) == 0 {
+			return nil
+		}
+		return r.outbox.MarkPublished(ctx, published, r.now())
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(published), sendErr
+}
+
+// Start 在后台每隔 period 发布一次 outbox，一批消息满了时不等下一个周期，立即发布下一批，
+// 尽快把积压的消息发出去；发布失败只记录日志，失败的消息留在 outbox 中下一个周期重试。
+// 一个 OutboxRelay 只会启动一个后台协程，同一个实例中消息的顺序由它保证
+func (r *OutboxRelay) Start(period time.Duration) {
+	r.state.Lock()
+	defer r.state.Unlock()
+	if r.relaying || r.stopped {
+		return
+	}
+	r.relaying = true
+
+	go r.run(time.NewTicker(period))
+}
+
+func (r *OutboxRelay) run(ticker *time.Ticker) {
+	defer close(r.done)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := r.RelayOnce(context.Background())
+			if err != nil {
+				log.Println("relay outbox:", err)
+			}
+			if err != nil || n < r.batchSize {
+				break
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Close 停止发布 outbox。正在发布的一批消息已经发给 Kafka，等它标记为已发布之后再返回，避免下次启动时重复发布。
+// 没有 Start 过的 OutboxRelay 没有后台协程，直接返回
+func (r *OutboxRelay) Close() {
+	r.state.Lock()
+	defer r.state.Unlock()
+	if r.stopped {
+		return
+	}
+	r.stopped = true
+	close(r.stop)
+	if r.relaying {
+		<-r.done
+	}
+}
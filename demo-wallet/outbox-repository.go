@@ -0,0 +1,73 @@
+package demo_wallet
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+var _ OutboxRepository = (*SQLOutboxRepository)(nil)
+
+// SQLOutboxRepository 基于 database/sql 的 outbox 表
+type SQLOutboxRepository struct {
+	db *sql.DB
+}
+
+func NewSQLOutboxRepository(db *sql.DB) *SQLOutboxRepository {
+	return &SQLOutboxRepository{db: db}
+}
+
+func (r *SQLOutboxRepository) Add(ctx context.Context, messages ...OutboxMessage) error {
+	tx := sqlstore.ExecutorFrom(ctx, r.db)
+	for i := range messages {
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO virtual_wallet_outbox (message_key, payload, create_time) VALUES (?, ?, ?)",
+			messages[i].Key, string(messages[i].Payload), messages[i].CreateTime)
+		if err != nil {
+			return err
+		}
+		if messages[i].Id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLOutboxRepository) Unpublished(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	rows, err := sqlstore.ExecutorFrom(ctx, r.db).QueryContext(ctx,
+		"SELECT id, message_key, payload, create_time FROM virtual_wallet_outbox "+
+			"WHERE publish_time IS NULL ORDER BY id LIMIT ? FOR UPDATE", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var message OutboxMessage
+		var payload string
+		if err := rows.Scan(&message.Id, &message.Key, &payload, &message.CreateTime); err != nil {
+			return nil, err
+		}
+		message.Payload = []byte(payload)
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (r *SQLOutboxRepository) MarkPublished(ctx context.Context, ids []int64, publishTime time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, publishTime)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := sqlstore.ExecutorFrom(ctx, r.db).ExecContext(ctx,
+		"UPDATE virtual_wallet_outbox SET publish_time = ? WHERE id IN (?"+strings.Repeat(", ?", len(ids)-1)+")", args...)
+	return err
+}
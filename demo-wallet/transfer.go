@@ -0,0 +1,244 @@
+package demo_wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 转账涉及两个钱包，需要保证：
+//
+//  1. 原子性：出账、入账、交易流水在同一个工作单元中提交，不会出现钱扣了但没有到账的情况。
+//  2. 幂等：客户端为每一笔转账生成 idempotencyKey，网络超时重试时使用相同的 key，不会重复扣款。
+//  3. 避免死锁：A 转 B 和 B 转 A 同时发生时，按照钱包 ID 的顺序加锁，而不是按照出账、入账的顺序。
+//
+// 跨币种转账在保存流水时按照当时的汇率计算入账金额，汇率和手续费记录在流水中，重试时使用流水中的入账金额，不受汇率变化的影响。
+//
+// 交易流水先以 PENDING 状态保存，转账成功后改为 SUCCEEDED，余额不足等业务错误改为 FAILED，
+// 进程在转账过程中退出时流水停留在 PENDING 状态，使用相同的 key 重试会继续完成这笔转账。
+
+var (
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrInvalidTransfer     = errors.New("invalid transfer")
+	// ErrIdempotencyKeyConflict 相同的 idempotencyKey 用于了不同的转账
+	ErrIdempotencyKeyConflict = errors.New("idempotency key used by another transfer")
+	ErrTransferFailed         = errors.New("transfer failed")
+)
+
+// transferDomainErrors 转账失败时记录错误描述，使用相同的 key 重试时还原为原来的错误
+var transferDomainErrors = []error{ErrInsufficientBalance, ErrWalletNotFound, ErrCurrencyMismatch}
+
+// TransferFailedError 转账因为业务规则失败，流水已经记录为 FAILED 状态
+type TransferFailedError struct {
+	TransactionId int64
+	Reason        string
+	err           error
+}
+
+func newTransferFailedError(entity *VirtualWalletTransactionEntity) *TransferFailedError {
+	e := &TransferFailedError{TransactionId: entity.id, Reason: entity.failReason}
+	for _, domainErr := range transferDomainErrors {
+		if strings.HasPrefix(entity.failReason, domainErr.Error()) {
+			e.err = domainErr
+		}
+	}
+	return e
+}
+
+func (e *TransferFailedError) Error() string {
+	return fmt.Sprintf("%s: transaction %d: %s", ErrTransferFailed, e.TransactionId, e.Reason)
+}
+
+func (e *TransferFailedError) Is(target error) bool {
+	return target == ErrTransferFailed
+}
+
+func (e *TransferFailedError) Unwrap() error {
+	return e.err
+}
+
+// transferApply 根据加锁后的两个钱包计算出账 debit、入账 credit 之后的余额，违反业务规则时返回错误，
+// ctx 是转账的工作单元，需要保存其他数据（如账本）时使用
+type transferApply func(ctx context.Context, from, to *VirtualWalletEntity, debit, credit Money) (fromBalance, toBalance Money, err error)
+
+// transferRunner 两种开发模式的 Service 共用的转账流程，区别只在于 transferApply 中的业务逻辑
+type transferRunner struct {
+	uow             UnitOfWork
+	walletRepo      VirtualWalletRepository
+	transactionRepo VirtualWalletTransactionRepository
+	// exchange 跨币种转账时使用，为 nil 时只支持相同币种的转账
+	exchange *CurrencyExchange
+	outbox   OutboxRepository
+	now      func() time.Time
+}
+
+func newTransferRunner(uow UnitOfWork, walletRepo VirtualWalletRepository,
+	transactionRepo VirtualWalletTransactionRepository, options serviceOptions) *transferRunner {
+	return &transferRunner{uow: uow, walletRepo: walletRepo, transactionRepo: transactionRepo, exchange: options.exchange,
+		outbox: options.outbox, now: time.Now}
+}
+
+func (r *transferRunner) run(ctx context.Context, idempotencyKey, fromWalletId, toWalletId string,
+	amount Money, toCurrency Currency, apply transferApply) (*VirtualWalletTransactionEntity, error) {
+	switch {
+	case idempotencyKey == "":
+		return nil, fmt.Errorf("%w: idempotency key is required", ErrInvalidTransfer)
+	case fromWalletId == toWalletId:
+		return nil, fmt.Errorf("%w: transfer to the same wallet", ErrInvalidTransfer)
+	case !amount.IsPositive():
+		return nil, fmt.Errorf("%w: amount should be positive", ErrInvalidTransfer)
+	}
+
+	entity, err := r.pendingTransaction(ctx, idempotencyKey, fromWalletId, toWalletId, amount, toCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	switch entity.status {
+	case SUCCEEDED:
+		return entity, nil
+	case FAILED:
+		return entity, newTransferFailedError(entity)
+	}
+
+	err = retryOnConflict(ctx, r.uow, func(ctx context.Context) error {
+		return r.execute(ctx, entity, apply)
+	})
+	if err == nil {
+		return entity, nil
+	}
+	if !isTransferDomainError(err) {
+		// 基础设施错误，流水保持 PENDING 状态，使用相同的 key 重试
+		return entity, err
+	}
+
+	entity.status, entity.failReason = FAILED, err.Error()
+	if updateErr := r.transactionRepo.UpdateTransactionStatus(ctx, entity.id, FAILED, entity.failReason); updateErr != nil {
+		return entity, updateErr
+	}
+	return entity, newTransferFailedError(entity)
+}
+
+// pendingTransaction 查找 idempotencyKey 对应的流水，不存在时以 PENDING 状态保存一条新的流水
+func (r *transferRunner) pendingTransaction(ctx context.Context, idempotencyKey, fromWalletId, toWalletId string,
+	amount Money, toCurrency Currency) (*VirtualWalletTransactionEntity, error) {
+	entity, err := r.transactionRepo.GetTransactionByIdempotencyKey(ctx, idempotencyKey)
+	if errors.Is(err, ErrTransactionNotFound) {
+		entity = NewVirtualWalletTransactionEntity()
+		entity.SetAmount(amount)
+		if err := r.quote(ctx, entity, toCurrency); err != nil {
+			return nil, err
+		}
+		entity.SetCreateTime(r.now())
+		entity.SetType(TRANSFER)
+		entity.SetFromWalletId(fromWalletId)
+		entity.SetToWalletId(toWalletId)
+		entity.SetStatus(PENDING)
+		entity.SetIdempotencyKey(idempotencyKey)
+		if err = r.transactionRepo.SaveTransaction(ctx, entity); err == nil {
+			return entity, nil
+		}
+		// 并发的重试请求已经保存了流水，idempotency_key 的唯一索引导致保存失败
+		var lookupErr error
+		if entity, lookupErr = r.transactionRepo.GetTransactionByIdempotencyKey(ctx, idempotencyKey); lookupErr != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if entity.transactionType != TRANSFER || entity.fromWalletId != fromWalletId ||
+		entity.toWalletId != toWalletId || entity.amount != amount || entity.toAmount.Currency() != toCurrency {
+		return nil, fmt.Errorf("%w: %s", ErrIdempotencyKeyConflict, idempotencyKey)
+	}
+	return entity, nil
+}
+
+// quote 计算入账的金额，跨币种转账时按照当前的汇率兑换并扣除手续费
+func (r *transferRunner) quote(ctx context.Context, entity *VirtualWalletTransactionEntity, toCurrency Currency) error {
+	if toCurrency == entity.amount.Currency() {
+		entity.toAmount = entity.amount
+		return nil
+	}
+	if r.exchange == nil {
+		return fmt.Errorf("%w: transfer from %s to %s", ErrExchangeUnavailable, entity.amount.Currency(), toCurrency)
+	}
+
+	conversion, err := r.exchange.Convert(ctx, entity.amount, toCurrency)
+	if err != nil {
+		return err
+	}
+	if !conversion.Target.IsPositive() {
+		return fmt.Errorf("%w: %s is less than the exchange fee", ErrInvalidTransfer, entity.amount)
+	}
+	entity.toAmount, entity.fee, entity.exchangeRate = conversion.Target, conversion.Fee, formatRate(conversion.Rate)
+	return nil
+}
+
+// execute 在工作单元中完成转账
+func (r *transferRunner) execute(ctx context.Context, entity *VirtualWalletTransactionEntity, apply transferApply) error {
+	// 锁住流水，同一笔转账的并发重试在这里排队，后来者看到的是已经完成的状态
+	locked, err := r.transactionRepo.GetTransactionForUpdate(ctx, entity.id)
+	if err != nil {
+		return err
+	}
+	if locked.status != PENDING {
+		entity.status, entity.failReason = locked.status, locked.failReason
+		return nil
+	}
+
+	wallets, err := r.lockWallets(ctx, entity.fromWalletId, entity.toWalletId)
+	if err != nil {
+		return err
+	}
+	from, to := wallets[entity.fromWalletId], wallets[entity.toWalletId]
+
+	fromBalance, toBalance, err := apply(ctx, from, to, entity.amount, entity.toAmount)
+	if err != nil {
+		return err
+	}
+	if err := r.walletRepo.UpdateBalance(ctx, from.id, fromBalance, from.version); err != nil {
+		return err
+	}
+	if err := r.walletRepo.UpdateBalance(ctx, to.id, toBalance, to.version); err != nil {
+		return err
+	}
+	if err := r.transactionRepo.UpdateTransactionStatus(ctx, entity.id, SUCCEEDED, ""); err != nil {
+		return err
+	}
+	if err := addToOutbox(ctx, r.outbox, newWalletMessage(entity, from.id, fromBalance),
+		newWalletMessage(entity, to.id, toBalance)); err != nil {
+		return err
+	}
+
+	entity.status = SUCCEEDED
+	return nil
+}
+
+// lockWallets 按照钱包 ID 的顺序加锁，避免两个方向相反的转账互相等待对方持有的锁
+func (r *transferRunner) lockWallets(ctx context.Context, walletIds ...string) (map[string]*VirtualWalletEntity, error) {
+	ordered := append([]string(nil), walletIds...)
+	sort.Strings(ordered)
+
+	wallets := make(map[string]*VirtualWalletEntity, len(ordered))
+	for _, walletId := range ordered {
+		entity, err := r.walletRepo.GetWalletEntityForUpdate(ctx, walletId)
+		if err != nil {
+			return nil, err
+		}
+		wallets[walletId] = entity
+	}
+	return wallets, nil
+}
+
+func isTransferDomainError(err error) bool {
+	for _, domainErr := range transferDomainErrors {
+		if errors.Is(err, domainErr) {
+			return true
+		}
+	}
+	return false
+}
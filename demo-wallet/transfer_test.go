@@ -0,0 +1,257 @@
+package demo_wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transferService 两种开发模式的 Service 都实现了相同的转账接口
+type transferService interface {
+	Transfer(ctx context.Context, idempotencyKey, fromWalletId, toWalletId string, amount Money) (*VirtualWalletTransactionEntity, error)
+}
+
+func newTransferServices(store *MemoryStore, walletRepo VirtualWalletRepository) map[string]transferService {
+	return map[string]transferService{
+		"anaemic": NewVirtualWalletService(store.UnitOfWork(), walletRepo, store.TransactionRepository()),
+		"rich":    NewDDDVirtualWalletService(store.UnitOfWork(), walletRepo, store.TransactionRepository()),
+	}
+}
+
+func cny(amount string) Money {
+	return MustParseMoney(amount, CNY)
+}
+
+func newMemoryStoreWithWallets(t *testing.T, balances map[string]Money) *MemoryStore {
+	t.Helper()
+	store := NewMemoryStore()
+	for id, balance := range balances {
+		entity := NewVirtualWalletEntity(id, balance.Currency(), time.Now())
+		entity.setBalance(balance)
+		if err := store.WalletRepository().CreateWallet(context.Background(), entity); err != nil {
+			t.Fatalf("CreateWallet() error = %v", err)
+		}
+	}
+	return store
+}
+
+func assertBalances(t *testing.T, store *MemoryStore, want map[string]Money) {
+	t.Helper()
+	for id, balance := range want {
+		got, err := store.WalletRepository().GetBalance(context.Background(), id)
+		if err != nil || got != balance {
+			t.Errorf("GetBalance(%s) got = %v, %v, want %v", id, got, err, balance)
+		}
+	}
+}
+
+func TestTransfer_Idempotent(t *testing.T) {
+	for name := range newTransferServices(NewMemoryStore(), nil) {
+		t.Run(name, func(t *testing.T) {
+			store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("100"), "b": cny("0")})
+			service := newTransferServices(store, store.WalletRepository())[name]
+			ctx := context.Background()
+
+			first, err := service.Transfer(ctx, "key-1", "a", "b", cny("30"))
+			if err != nil || first.GetStatus() != SUCCEEDED {
+				t.Fatalf("Transfer() got = %+v, %v", first, err)
+			}
+			// 超时重试，使用相同的 key 不会重复扣款
+			retry, err := service.Transfer(ctx, "key-1", "a", "b", cny("30"))
+			if err != nil || retry.GetId() != first.GetId() {
+				t.Fatalf("Transfer() retry got = %+v, %v", retry, err)
+			}
+			assertBalances(t, store, map[string]Money{"a": cny("70"), "b": cny("30")})
+
+			if _, err := service.Transfer(ctx, "key-1", "a", "b", cny("40")); !errors.Is(err, ErrIdempotencyKeyConflict) {
+				t.Errorf("Transfer() error = %v, want %v", err, ErrIdempotencyKeyConflict)
+			}
+		})
+	}
+}
+
+// keyedService 两种开发模式的 Service 都支持带幂等 key 的出账和入账
+type keyedService interface {
+	DebitWithKey(ctx context.Context, idempotencyKey, walletId string, amount Money) (*VirtualWalletTransactionEntity, error)
+	CreditWithKey(ctx context.Context, idempotencyKey, walletId string, amount Money) (*VirtualWalletTransactionEntity, error)
+}
+
+func TestDebitCreditWithKey(t *testing.T) {
+	for name := range newTransferServices(NewMemoryStore(), nil) {
+		t.Run(name, func(t *testing.T) {
+			store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("100")})
+			service := newTransferServices(store, store.WalletRepository())[name].(keyedService)
+			ctx := context.Background()
+
+			debit, err := service.DebitWithKey(ctx, "debit-1", "a", cny("30"))
+			if err != nil || debit.GetType() != DEBIT {
+				t.Fatalf("DebitWithKey() got = %+v, %v", debit, err)
+			}
+			if retry, err := service.DebitWithKey(ctx, "debit-1", "a", cny("30")); err != nil || retry.GetId() != debit.GetId() {
+				t.Fatalf("DebitWithKey() retry got = %+v, %v", retry, err)
+			}
+			credit, err := service.CreditWithKey(ctx, "credit-1", "a", cny("5"))
+			if err != nil || credit.GetType() != CREDIT {
+				t.Fatalf("CreditWithKey() got = %+v, %v", credit, err)
+			}
+			if retry, err := service.CreditWithKey(ctx, "credit-1", "a", cny("5")); err != nil || retry.GetId() != credit.GetId() {
+				t.Fatalf("CreditWithKey() retry got = %+v, %v", retry, err)
+			}
+			assertBalances(t, store, map[string]Money{"a": cny("75")})
+
+			// key 用于了其他操作
+			if _, err := service.CreditWithKey(ctx, "debit-1", "a", cny("30")); !errors.Is(err, ErrIdempotencyKeyConflict) {
+				t.Errorf("CreditWithKey() error = %v, want %v", err, ErrIdempotencyKeyConflict)
+			}
+			if _, err := service.DebitWithKey(ctx, "debit-1", "a", cny("31")); !errors.Is(err, ErrIdempotencyKeyConflict) {
+				t.Errorf("DebitWithKey() error = %v, want %v", err, ErrIdempotencyKeyConflict)
+			}
+		})
+	}
+}
+
+// racingTransactionRepository 模拟相同 idempotencyKey 的请求并发：先查找的请求看不到另一个请求刚保存的流水
+type racingTransactionRepository struct {
+	VirtualWalletTransactionRepository
+	misses int
+}
+
+func (r *racingTransactionRepository) GetTransactionByIdempotencyKey(ctx context.Context, key string) (*VirtualWalletTransactionEntity, error) {
+	if r.misses > 0 {
+		r.misses--
+		return nil, ErrTransactionNotFound
+	}
+	return r.VirtualWalletTransactionRepository.GetTransactionByIdempotencyKey(ctx, key)
+}
+
+func TestDebitCreditWithKey_Concurrent(t *testing.T) {
+	for name := range newTransferServices(NewMemoryStore(), nil) {
+		t.Run(name, func(t *testing.T) {
+			store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("100")})
+			repo := &racingTransactionRepository{VirtualWalletTransactionRepository: store.TransactionRepository()}
+			services := map[string]keyedService{
+				"anaemic": NewVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), repo),
+				"rich":    NewDDDVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), repo),
+			}
+			service := services[name]
+			ctx := context.Background()
+
+			debit, err := service.DebitWithKey(ctx, "debit-1", "a", cny("30"))
+			if err != nil {
+				t.Fatalf("DebitWithKey() error = %v", err)
+			}
+			credit, err := service.CreditWithKey(ctx, "credit-1", "a", cny("5"))
+			if err != nil {
+				t.Fatalf("CreditWithKey() error = %v", err)
+			}
+
+			// 唯一索引拒绝了重复的流水，返回先保存的流水而不是数据库的错误
+			repo.misses = 1
+			if retry, err := service.DebitWithKey(ctx, "debit-1", "a", cny("30")); err != nil || retry.GetId() != debit.GetId() {
+				t.Errorf("DebitWithKey() concurrent got = %+v, %v", retry, err)
+			}
+			repo.misses = 1
+			if retry, err := service.CreditWithKey(ctx, "credit-1", "a", cny("5")); err != nil || retry.GetId() != credit.GetId() {
+				t.Errorf("CreditWithKey() concurrent got = %+v, %v", retry, err)
+			}
+			repo.misses = 1
+			if _, err := service.CreditWithKey(ctx, "debit-1", "a", cny("30")); !errors.Is(err, ErrIdempotencyKeyConflict) {
+				t.Errorf("CreditWithKey() error = %v, want %v", err, ErrIdempotencyKeyConflict)
+			}
+			assertBalances(t, store, map[string]Money{"a": cny("75")})
+		})
+	}
+}
+
+func TestTransfer_Failed(t *testing.T) {
+	for name := range newTransferServices(NewMemoryStore(), nil) {
+		t.Run(name, func(t *testing.T) {
+			store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("10"), "b": cny("0")})
+			service := newTransferServices(store, store.WalletRepository())[name]
+			ctx := context.Background()
+
+			for i := 0; i < 2; i++ {
+				transaction, err := service.Transfer(ctx, "key-1", "a", "b", cny("30"))
+				if !errors.Is(err, ErrTransferFailed) || !errors.Is(err, ErrInsufficientBalance) {
+					t.Fatalf("Transfer() error = %v, want %v", err, ErrInsufficientBalance)
+				}
+				if transaction.GetStatus() != FAILED {
+					t.Errorf("Transfer() status = %v, want FAILED", transaction.GetStatus())
+				}
+			}
+			assertBalances(t, store, map[string]Money{"a": cny("10"), "b": cny("0")})
+
+			invalid := []struct{ key, from, to string }{{"", "a", "b"}, {"key-2", "a", "a"}}
+			for _, tt := range invalid {
+				if _, err := service.Transfer(ctx, tt.key, tt.from, tt.to, cny("1")); !errors.Is(err, ErrInvalidTransfer) {
+					t.Errorf("Transfer(%+v) error = %v, want %v", tt, err, ErrInvalidTransfer)
+				}
+			}
+		})
+	}
+}
+
+// failingWalletRepository 第 failAt 次更新余额时失败，模拟入账时数据库出错
+type failingWalletRepository struct {
+	VirtualWalletRepository
+	mu      sync.Mutex
+	updates int
+	failAt  int
+}
+
+func (r *failingWalletRepository) UpdateBalance(ctx context.Context, walletId string, balance Money, version int64) error {
+	r.mu.Lock()
+	r.updates++
+	fail := r.updates == r.failAt
+	r.mu.Unlock()
+	if fail {
+		return errors.New("connection reset")
+	}
+	return r.VirtualWalletRepository.UpdateBalance(ctx, walletId, balance, version)
+}
+
+func TestTransfer_Atomic(t *testing.T) {
+	store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("100"), "b": cny("0")})
+	walletRepo := &failingWalletRepository{VirtualWalletRepository: store.WalletRepository(), failAt: 2}
+	service := newTransferServices(store, walletRepo)["anaemic"]
+	ctx := context.Background()
+
+	// 出账成功、入账失败，整个工作单元回滚，流水保持 PENDING
+	transaction, err := service.Transfer(ctx, "key-1", "a", "b", cny("30"))
+	if err == nil || transaction.GetStatus() != PENDING {
+		t.Fatalf("Transfer() got = %+v, %v, want pending with error", transaction, err)
+	}
+	assertBalances(t, store, map[string]Money{"a": cny("100"), "b": cny("0")})
+
+	// 使用相同的 key 重试，继续完成这笔转账
+	if transaction, err = service.Transfer(ctx, "key-1", "a", "b", cny("30")); err != nil || transaction.GetStatus() != SUCCEEDED {
+		t.Fatalf("Transfer() retry got = %+v, %v", transaction, err)
+	}
+	assertBalances(t, store, map[string]Money{"a": cny("70"), "b": cny("30")})
+}
+
+func TestTransfer_Concurrent(t *testing.T) {
+	store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("1000"), "b": cny("1000")})
+	service := newTransferServices(store, store.WalletRepository())["rich"]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			from, to := "a", "b"
+			if i%2 == 1 {
+				from, to = to, from
+			}
+			if _, err := service.Transfer(context.Background(), fmt.Sprint("key-", i), from, to, cny("10")); err != nil {
+				t.Errorf("Transfer() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assertBalances(t, store, map[string]Money{"a": cny("1000"), "b": cny("1000")})
+}
@@ -0,0 +1,33 @@
+package demo_wallet
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+// UnitOfWork 工作单元，保证一次业务操作中的多次数据修改（如交易流水和余额）要么全部提交，要么全部回滚，见 sqlstore.UnitOfWork
+type UnitOfWork = sqlstore.UnitOfWork
+
+// SQLUnitOfWork 基于数据库事务的工作单元
+type SQLUnitOfWork = sqlstore.SQLUnitOfWork
+
+func NewSQLUnitOfWork(db *sql.DB) *SQLUnitOfWork {
+	return sqlstore.NewSQLUnitOfWork(db)
+}
+
+// maxConflictRetries 乐观锁冲突时最多重试的次数
+const maxConflictRetries = 3
+
+// retryOnConflict 在工作单元中执行 fn，乐观锁冲突时重新读取数据并重试
+func retryOnConflict(ctx context.Context, uow UnitOfWork, fn func(ctx context.Context) error) error {
+	var err error
+	for i := 0; i <= maxConflictRetries; i++ {
+		if err = uow.Do(ctx, fn); !errors.Is(err, ErrConcurrentUpdate) {
+			return err
+		}
+	}
+	return err
+}
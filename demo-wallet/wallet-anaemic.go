@@ -1,27 +1,97 @@
 package demo_wallet
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 )
 
 // 典型的三层结构，Controller 和 VO 负责暴露接口
 
 type VirtualWalletController struct {
-	walletService VirtualWalletService
+	walletService *VirtualWalletService
+}
+
+func NewVirtualWalletController(walletService *VirtualWalletService) *VirtualWalletController {
+	return &VirtualWalletController{walletService: walletService}
+}
+
+// VirtualWalletVo 钱包的余额，Balance 是主币种的余额
+type VirtualWalletVo struct {
+	Id         string    `json:"id"`
+	Balance    Money     `json:"balance"`
+	Balances   []Money   `json:"balances"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+// TransactionVo 出账、入账和转账的流水
+type TransactionVo struct {
+	Id           int64     `json:"id"`
+	Type         string    `json:"type"`
+	Status       string    `json:"status"`
+	FromWalletId string    `json:"fromWalletId,omitempty"`
+	ToWalletId   string    `json:"toWalletId,omitempty"`
+	Amount       Money     `json:"amount"`
+	ToAmount     *Money    `json:"toAmount,omitempty"`
+	Fee          *Money    `json:"fee,omitempty"`
+	ExchangeRate string    `json:"exchangeRate,omitempty"`
+	FailReason   string    `json:"failReason,omitempty"`
+	CreateTime   time.Time `json:"createTime"`
 }
 
 // GetBalance 查询余额
-func (c *VirtualWalletController) GetBalance(walletId string) {}
+func (c *VirtualWalletController) GetBalance(ctx context.Context, walletId string) (*VirtualWalletVo, error) {
+	if err := validateWalletId(walletId); err != nil {
+		return nil, err
+	}
+	walletBo, err := c.walletService.GetVirtualWallet(ctx, walletId)
+	if err != nil {
+		return nil, err
+	}
+	return mapper.WalletBoToVo(walletBo), nil
+}
 
 // Debit 出账
-func (c *VirtualWalletController) Debit(walletId string, amount float64) {}
+func (c *VirtualWalletController) Debit(ctx context.Context, idempotencyKey, walletId string, amount Money) (*TransactionVo, error) {
+	if err := validateRequest(idempotencyKey, amount, walletId); err != nil {
+		return nil, err
+	}
+	entity, err := c.walletService.DebitWithKey(ctx, idempotencyKey, walletId, amount)
+	if err != nil {
+		return nil, err
+	}
+	return mapper.TransactionEntityToVo(entity), nil
+}
 
 // Credit 入账
-func (c *VirtualWalletController) Credit(walletId string, amount float64) {}
+func (c *VirtualWalletController) Credit(ctx context.Context, idempotencyKey, walletId string, amount Money) (*TransactionVo, error) {
+	if err := validateRequest(idempotencyKey, amount, walletId); err != nil {
+		return nil, err
+	}
+	entity, err := c.walletService.CreditWithKey(ctx, idempotencyKey, walletId, amount)
+	if err != nil {
+		return nil, err
+	}
+	return mapper.TransactionEntityToVo(entity), nil
+}
 
-// Transfer 转账
-func (c *VirtualWalletController) Transfer(fromWalletId, toWalletId string, amount float64) {}
+// Transfer 转账，idempotencyKey 必填；转账失败时同时返回 FAILED 状态的流水
+func (c *VirtualWalletController) Transfer(ctx context.Context, idempotencyKey, fromWalletId, toWalletId string,
+	amount Money) (*TransactionVo, error) {
+	if idempotencyKey == "" {
+		return nil, fmt.Errorf("%w: idempotency key is required", ErrInvalidRequest)
+	}
+	if err := validateRequest(idempotencyKey, amount, fromWalletId, toWalletId); err != nil {
+		return nil, err
+	}
+	entity, err := c.walletService.Transfer(ctx, idempotencyKey, fromWalletId, toWalletId, amount)
+	if entity == nil {
+		return nil, err
+	}
+	return mapper.TransactionEntityToVo(entity), err
+}
 
 //------------------------------
 // Service 和 BO 负责核心业务逻辑
@@ -33,121 +103,382 @@ const (
 	TRANSFER
 )
 
+// TransactionStatus 交易流水的状态，只有转账会经历 PENDING 状态
+const (
+	SUCCEEDED = iota
+	PENDING
+	FAILED
+)
+
 type VirtualWalletBo struct {
 	id         string
 	createTime time.Time
-	balance    float64
+	balance    Money
+	// balances 所有币种的余额，balance 是其中主币种的余额
+	balances []Money
 }
 
 type VirtualWalletService struct {
+	uow             UnitOfWork
 	walletRepo      VirtualWalletRepository
 	transactionRepo VirtualWalletTransactionRepository
+	options         serviceOptions
 }
 
-func (s *VirtualWalletService) GetVirtualWallet(walletId string) *VirtualWalletBo {
-	walletEntity := s.walletRepo.GetWalletEntity(walletId)
+func NewVirtualWalletService(uow UnitOfWork, walletRepo VirtualWalletRepository,
+	transactionRepo VirtualWalletTransactionRepository, opts ...ServiceOption) *VirtualWalletService {
+	return &VirtualWalletService{uow: uow, walletRepo: walletRepo, transactionRepo: transactionRepo,
+		options: newServiceOptions(opts)}
+}
 
-	convert := func(entity *VirtualWalletEntity) *VirtualWalletBo {
-		return &VirtualWalletBo{}
+func (s *VirtualWalletService) GetVirtualWallet(ctx context.Context, walletId string) (*VirtualWalletBo, error) {
+	walletEntity, err := s.walletRepo.GetWalletEntity(ctx, walletId)
+	if err != nil {
+		return nil, err
 	}
 
-	walletBo := convert(walletEntity)
-	return walletBo
+	return mapper.WalletEntityToBo(walletEntity), nil
+}
+
+func (s *VirtualWalletService) GetBalance(ctx context.Context, walletId string) (Money, error) {
+	return s.walletRepo.GetBalance(ctx, walletId)
+}
+
+// GetTotalBalance 所有币种的余额按照汇率折算为 base 币种的合计
+func (s *VirtualWalletService) GetTotalBalance(ctx context.Context, walletId string, base Currency) (Money, error) {
+	return s.options.totalBalance(ctx, s.walletRepo, walletId, base)
 }
 
-func (s *VirtualWalletService) GetBalance(walletId string) float64 {
-	return s.walletRepo.GetBalance(walletId)
+// Debit 交易流水和余额在同一个工作单元中提交，余额被并发修改时重试
+func (s *VirtualWalletService) Debit(ctx context.Context, walletId string, amount Money) error {
+	_, err := s.DebitWithKey(ctx, "", walletId, amount)
+	return err
 }
 
-func (s *VirtualWalletService) Debit(walletId string, amount float64) error {
-	walletEntity := s.walletRepo.GetWalletEntity(walletId)
-	balance := walletEntity.GetBalance()
+// DebitWithKey 使用相同的 idempotencyKey 重试时返回第一次出账的流水，不会重复扣款，idempotencyKey 为空时不做幂等
+func (s *VirtualWalletService) DebitWithKey(ctx context.Context, idempotencyKey, walletId string,
+	amount Money) (*VirtualWalletTransactionEntity, error) {
+	if !amount.IsPositive() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
+	}
+	var transactionEntity *VirtualWalletTransactionEntity
+	err := retryOnConflict(ctx, s.uow, func(ctx context.Context) error {
+		var err error
+		if transactionEntity, err = findIdempotent(ctx, s.transactionRepo, idempotencyKey, DEBIT, walletId, amount); transactionEntity != nil || err != nil {
+			return err
+		}
+
+		walletEntity, err := s.walletRepo.GetWalletEntity(ctx, walletId)
+		if err != nil {
+			return err
+		}
+		balance, err := walletEntity.GetBalanceIn(amount.Currency()).Sub(amount)
+		if err != nil {
+			return err
+		}
+		if balance.IsNegative() {
+			return ErrInsufficientBalance
+		}
+
+		transactionEntity = NewVirtualWalletTransactionEntity()
+		transactionEntity.SetAmount(amount)
+		transactionEntity.SetCreateTime(time.Now())
+		transactionEntity.SetType(DEBIT)
+		transactionEntity.SetFromWalletId(walletId)
+		transactionEntity.SetIdempotencyKey(idempotencyKey)
+		if err := saveIdempotent(ctx, s.transactionRepo, transactionEntity); err != nil {
+			return err
+		}
+
+		if err := s.walletRepo.UpdateBalance(ctx, walletId, balance, walletEntity.GetVersion()); err != nil {
+			return err
+		}
+		return addToOutbox(ctx, s.options.outbox, newWalletMessage(transactionEntity, walletId, balance))
+	})
+	if err != nil {
+		return findConcurrentIdempotent(ctx, s.transactionRepo, err, idempotencyKey, DEBIT, walletId, amount)
+	}
+	return transactionEntity, nil
+}
+
+func (s *VirtualWalletService) Credit(ctx context.Context, walletId string, amount Money) error {
+	_, err := s.CreditWithKey(ctx, "", walletId, amount)
+	return err
+}
 
-	if balance-amount < 0 {
-		return errors.New("insufficient balance")
+// CreditWithKey 使用相同的 idempotencyKey 重试时返回第一次入账的流水，不会重复入账，idempotencyKey 为空时不做幂等
+func (s *VirtualWalletService) CreditWithKey(ctx context.Context, idempotencyKey, walletId string,
+	amount Money) (*VirtualWalletTransactionEntity, error) {
+	if !amount.IsPositive() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
 	}
+	var transactionEntity *VirtualWalletTransactionEntity
+	err := retryOnConflict(ctx, s.uow, func(ctx context.Context) error {
+		var err error
+		if transactionEntity, err = findIdempotent(ctx, s.transactionRepo, idempotencyKey, CREDIT, walletId, amount); transactionEntity != nil || err != nil {
+			return err
+		}
+
+		transactionEntity = NewVirtualWalletTransactionEntity()
+		transactionEntity.SetAmount(amount)
+		transactionEntity.SetCreateTime(time.Now())
+		transactionEntity.SetType(CREDIT)
+		transactionEntity.SetToWalletId(walletId)
+		transactionEntity.SetIdempotencyKey(idempotencyKey)
+		if err := saveIdempotent(ctx, s.transactionRepo, transactionEntity); err != nil {
+			return err
+		}
+
+		walletEntity, err := s.walletRepo.GetWalletEntity(ctx, walletId)
+		if err != nil {
+			return err
+		}
+		balance, err := walletEntity.GetBalanceIn(amount.Currency()).Add(amount)
+		if err != nil {
+			return err
+		}
+		if err := s.walletRepo.UpdateBalance(ctx, walletId, balance, walletEntity.GetVersion()); err != nil {
+			return err
+		}
+		return addToOutbox(ctx, s.options.outbox, newWalletMessage(transactionEntity, walletId, balance))
+	})
+	if err != nil {
+		return findConcurrentIdempotent(ctx, s.transactionRepo, err, idempotencyKey, CREDIT, walletId, amount)
+	}
+	return transactionEntity, nil
+}
 
-	transactionEntity := NewVirtualWalletTransactionEntity()
-	transactionEntity.SetAmount(amount)
-	transactionEntity.SetCreateTime(time.Now())
-	transactionEntity.SetType(DEBIT)
-	transactionEntity.SetFromWalletId(walletId)
-	s.transactionRepo.SaveTransaction(transactionEntity)
-	s.walletRepo.UpdateBalance(walletId, balance-amount)
+// findIdempotent 查找 idempotencyKey 对应的出账或者入账流水，key 用于了其他操作时返回 ErrIdempotencyKeyConflict。
+// 两种开发模式的 Service 共用
+func findIdempotent(ctx context.Context, transactionRepo VirtualWalletTransactionRepository, idempotencyKey string,
+	transactionType int, walletId string, amount Money) (*VirtualWalletTransactionEntity, error) {
+	if idempotencyKey == "" {
+		return nil, nil
+	}
+	entity, err := transactionRepo.GetTransactionByIdempotencyKey(ctx, idempotencyKey)
+	if errors.Is(err, ErrTransactionNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
 
-	return nil
+	wallet := entity.toWalletId
+	if transactionType == DEBIT {
+		wallet = entity.fromWalletId
+	}
+	if entity.transactionType != transactionType || wallet != walletId || entity.amount != amount {
+		return nil, fmt.Errorf("%w: %s", ErrIdempotencyKeyConflict, idempotencyKey)
+	}
+	return entity, nil
 }
 
-func (s *VirtualWalletService) Credit(walletId string, amount float64) {
-	transactionEntity := NewVirtualWalletTransactionEntity()
-	transactionEntity.SetAmount(amount)
-	transactionEntity.SetCreateTime(time.Now())
-	transactionEntity.SetType(CREDIT)
-	transactionEntity.SetFromWalletId(walletId)
-	s.transactionRepo.SaveTransaction(transactionEntity)
+// idempotentSaveError 保存带 idempotencyKey 的流水失败，可能是并发的相同请求已经保存了流水，违反了 idempotency_key 的唯一索引
+type idempotentSaveError struct {
+	err error
+}
 
-	walletEntity := s.walletRepo.GetWalletEntity(walletId)
-	balance := walletEntity.GetBalance()
-	s.walletRepo.UpdateBalance(walletId, balance+amount)
+func (e *idempotentSaveError) Error() string {
+	return e.err.Error()
 }
 
-func (s *VirtualWalletService) Transfer(fromWalletId, toWalletId string, amount float64) error {
-	transactionEntity := NewVirtualWalletTransactionEntity()
-	transactionEntity.SetAmount(amount)
-	transactionEntity.SetCreateTime(time.Now())
-	transactionEntity.SetType(TRANSFER)
-	transactionEntity.SetFromWalletId(fromWalletId)
-	transactionEntity.SetToWalletId(toWalletId)
-	s.transactionRepo.SaveTransaction(transactionEntity)
+func (e *idempotentSaveError) Unwrap() error {
+	return e.err
+}
 
-	err := s.Debit(fromWalletId, amount)
-	if err != nil {
-		return err
+// saveIdempotent 保存流水，带 idempotencyKey 的流水保存失败时标记为 idempotentSaveError，由 findConcurrentIdempotent 处理
+func saveIdempotent(ctx context.Context, transactionRepo VirtualWalletTransactionRepository, entity *VirtualWalletTransactionEntity) error {
+	err := transactionRepo.SaveTransaction(ctx, entity)
+	if err != nil && entity.idempotencyKey != "" {
+		return &idempotentSaveError{err: err}
 	}
+	return err
+}
 
-	s.Credit(toWalletId, amount)
-	return nil
+// findConcurrentIdempotent 相同 idempotencyKey 的两个请求并发时都查不到流水，后保存的请求违反唯一索引。
+// 工作单元回滚之后通过 findIdempotent 重新查找，返回先保存的流水，与 transferRunner 的处理方式相同；
+// 事务中的一致性读看不到其他事务后来提交的流水，所以不能在工作单元中重新查找。查不到时返回原来的错误
+func findConcurrentIdempotent(ctx context.Context, transactionRepo VirtualWalletTransactionRepository, err error,
+	idempotencyKey string, transactionType int, walletId string, amount Money) (*VirtualWalletTransactionEntity, error) {
+	var saveErr *idempotentSaveError
+	if !errors.As(err, &saveErr) {
+		return nil, err
+	}
+	entity, findErr := findIdempotent(ctx, transactionRepo, idempotencyKey, transactionType, walletId, amount)
+	if entity != nil || errors.Is(findErr, ErrIdempotencyKeyConflict) {
+		return entity, findErr
+	}
+	return nil, saveErr.err
+}
+
+// Transfer 转账，出账、入账和交易流水在同一个工作单元中提交，使用相同的 idempotencyKey 重试不会重复扣款
+func (s *VirtualWalletService) Transfer(ctx context.Context, idempotencyKey, fromWalletId, toWalletId string,
+	amount Money) (*VirtualWalletTransactionEntity, error) {
+	return s.ExchangeTransfer(ctx, idempotencyKey, fromWalletId, toWalletId, amount, amount.Currency())
+}
+
+// ExchangeTransfer 跨币种转账，出账 amount，按照汇率扣除手续费后以 toCurrency 入账
+func (s *VirtualWalletService) ExchangeTransfer(ctx context.Context, idempotencyKey, fromWalletId, toWalletId string,
+	amount Money, toCurrency Currency) (*VirtualWalletTransactionEntity, error) {
+	transfer := newTransferRunner(s.uow, s.walletRepo, s.transactionRepo, s.options)
+	return transfer.run(ctx, idempotencyKey, fromWalletId, toWalletId, amount, toCurrency,
+		func(ctx context.Context, from, to *VirtualWalletEntity, debit, credit Money) (Money, Money, error) {
+			fromBalance, err := from.GetBalanceIn(debit.Currency()).Sub(debit)
+			if err != nil {
+				return Money{}, Money{}, err
+			}
+			if fromBalance.IsNegative() {
+				return Money{}, Money{}, ErrInsufficientBalance
+			}
+			toBalance, err := to.GetBalanceIn(credit.Currency()).Add(credit)
+			if err != nil {
+				return Money{}, Money{}, err
+			}
+			return fromBalance, toBalance, nil
+		})
 }
 
 //------------------------------
 // Repository  和 Entity 负责数据存储
 
 type VirtualWalletEntity struct {
-	balance float64
+	id         string
+	createTime time.Time
+	// currency 钱包的主币种，GetBalance 返回主币种的余额
+	currency Currency
+	// balances 各币种的余额，写时复制：Entity 的副本之间可以共享同一个 map
+	balances map[Currency]Money
+	// version 乐观锁的版本号，每次更新余额加 1
+	version int64
+}
+
+func NewVirtualWalletEntity(id string, currency Currency, createTime time.Time) *VirtualWalletEntity {
+	return &VirtualWalletEntity{id: id, createTime: createTime, currency: currency}
+}
+
+func (e VirtualWalletEntity) GetId() string {
+	return e.id
+}
+
+func (e VirtualWalletEntity) GetCreateTime() time.Time {
+	return e.createTime
+}
+
+// GetBalance 主币种的余额
+func (e VirtualWalletEntity) GetBalance() Money {
+	return e.GetBalanceIn(e.currency)
+}
+
+// GetBalanceIn currency 币种的余额，没有这个币种的余额时返回 0
+func (e VirtualWalletEntity) GetBalanceIn(currency Currency) Money {
+	if balance, ok := e.balances[currency]; ok {
+		return balance
+	}
+	return NewMoney(0, currency)
 }
 
-func (e VirtualWalletEntity) GetBalance() float64 {
-	return e.balance
+// GetBalances 所有币种的余额，按照币种排序
+func (e VirtualWalletEntity) GetBalances() []Money {
+	balances := make([]Money, 0, len(e.balances))
+	for _, balance := range e.balances {
+		balances = append(balances, balance)
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Currency() < balances[j].Currency()
+	})
+	return balances
 }
 
-type VirtualWalletRepository struct{}
+func (e VirtualWalletEntity) GetCurrency() Currency {
+	return e.currency
+}
 
-func (r *VirtualWalletRepository) GetWalletEntity(walletId string) *VirtualWalletEntity {
-	return &VirtualWalletEntity{}
+func (e *VirtualWalletEntity) setBalance(balance Money) {
+	balances := make(map[Currency]Money, len(e.balances)+1)
+	for currency, b := range e.balances {
+		balances[currency] = b
+	}
+	balances[balance.Currency()] = balance
+	e.balances = balances
 }
 
-func (r *VirtualWalletRepository) GetBalance(walletId string) float64 {
-	return 0
+func (e VirtualWalletEntity) GetVersion() int64 {
+	return e.version
 }
 
-func (r VirtualWalletRepository) UpdateBalance(walletId string, amount float64) {}
+// VirtualWalletRepository 钱包的存储，实现见 SQLVirtualWalletRepository
+type VirtualWalletRepository interface {
+	CreateWallet(ctx context.Context, entity *VirtualWalletEntity) error
+	// GetWalletEntity 钱包不存在时返回 ErrWalletNotFound
+	GetWalletEntity(ctx context.Context, walletId string) (*VirtualWalletEntity, error)
+	// GetBalance 主币种的余额
+	GetBalance(ctx context.Context, walletId string) (Money, error)
+	// GetWalletEntityForUpdate 在工作单元中读取钱包并加行锁，直到工作单元结束
+	GetWalletEntityForUpdate(ctx context.Context, walletId string) (*VirtualWalletEntity, error)
+	// UpdateBalance 更新 balance 对应币种的余额，只有版本号等于 version 时才更新成功，否则返回 ErrConcurrentUpdate
+	UpdateBalance(ctx context.Context, walletId string, balance Money, version int64) error
+}
 
 type VirtualWalletTransactionEntity struct {
-	amount          float64
+	id              int64
+	amount          Money
 	createTime      time.Time
 	transactionType int
 	fromWalletId    string
 	toWalletId      string
+	status          int
+	idempotencyKey  string
+	failReason      string
+	// toAmount 转账入账的金额，跨币种转账时与 amount 的币种不同
+	toAmount Money
+	// exchangeRate 跨币种转账使用的汇率，fee 是按照 amount 的币种收取的手续费
+	exchangeRate string
+	fee          Money
 }
 
 func NewVirtualWalletTransactionEntity() *VirtualWalletTransactionEntity {
 	return &VirtualWalletTransactionEntity{}
 }
 
-func (e *VirtualWalletTransactionEntity) SetAmount(amount float64) {
+func (e *VirtualWalletTransactionEntity) GetId() int64 {
+	return e.id
+}
+
+func (e *VirtualWalletTransactionEntity) SetAmount(amount Money) {
 	e.amount = amount
 }
 
+func (e *VirtualWalletTransactionEntity) GetAmount() Money {
+	return e.amount
+}
+
+func (e *VirtualWalletTransactionEntity) GetToAmount() Money {
+	return e.toAmount
+}
+
+func (e *VirtualWalletTransactionEntity) GetExchangeRate() string {
+	return e.exchangeRate
+}
+
+func (e *VirtualWalletTransactionEntity) GetFee() Money {
+	return e.fee
+}
+
+func (e *VirtualWalletTransactionEntity) GetCreateTime() time.Time {
+	return e.createTime
+}
+
+func (e *VirtualWalletTransactionEntity) GetType() int {
+	return e.transactionType
+}
+
+func (e *VirtualWalletTransactionEntity) GetFromWalletId() string {
+	return e.fromWalletId
+}
+
+func (e *VirtualWalletTransactionEntity) GetToWalletId() string {
+	return e.toWalletId
+}
+
 func (e *VirtualWalletTransactionEntity) SetCreateTime(now time.Time) {
 	e.createTime = now
 }
@@ -164,6 +495,34 @@ func (e *VirtualWalletTransactionEntity) SetToWalletId(walletId string) {
 	e.toWalletId = walletId
 }
 
-type VirtualWalletTransactionRepository struct{}
+func (e *VirtualWalletTransactionEntity) SetStatus(status int) {
+	e.status = status
+}
+
+func (e *VirtualWalletTransactionEntity) GetStatus() int {
+	return e.status
+}
+
+func (e *VirtualWalletTransactionEntity) SetIdempotencyKey(key string) {
+	e.idempotencyKey = key
+}
 
-func (r VirtualWalletTransactionRepository) SaveTransaction(entity *VirtualWalletTransactionEntity) {}
+func (e *VirtualWalletTransactionEntity) GetFailReason() string {
+	return e.failReason
+}
+
+// VirtualWalletTransactionRepository 交易流水的存储，实现见 SQLVirtualWalletTransactionRepository
+type VirtualWalletTransactionRepository interface {
+	// SaveTransaction 保存交易流水，并回填流水 ID
+	SaveTransaction(ctx context.Context, entity *VirtualWalletTransactionEntity) error
+	// GetTransactionByIdempotencyKey 流水不存在时返回 ErrTransactionNotFound
+	GetTransactionByIdempotencyKey(ctx context.Context, key string) (*VirtualWalletTransactionEntity, error)
+	// GetTransactionForUpdate 在工作单元中读取流水并加行锁
+	GetTransactionForUpdate(ctx context.Context, id int64) (*VirtualWalletTransactionEntity, error)
+	// UpdateTransactionStatus 只更新处于 PENDING 状态的流水
+	UpdateTransactionStatus(ctx context.Context, id int64, status int, failReason string) error
+	// QueryTransactions 按照 ID 倒序返回满足条件的流水，最多 filter.Limit 条
+	QueryTransactions(ctx context.Context, filter TransactionFilter) ([]*VirtualWalletTransactionEntity, error)
+	// SumMovements ID 在 (afterId, beforeId) 之间的成功流水对钱包 currency 币种余额的影响之和，beforeId 为 0 时不限制
+	SumMovements(ctx context.Context, walletId string, currency Currency, afterId, beforeId int64) (Money, error)
+}
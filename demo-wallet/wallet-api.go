@@ -0,0 +1,214 @@
+package demo_wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// VirtualWalletController 暴露为 HTTP JSON 接口：
+//
+//	GET  /wallets/{id}/balance  查询余额
+//	POST /wallets/{id}/debit    出账，请求体 {"amount": {"amount": "12.34", "currency": "CNY"}}
+//	POST /wallets/{id}/credit   入账，请求体同出账
+//	POST /transfers             转账，请求体 {"fromWalletId": "a", "toWalletId": "b", "amount": {...}}
+//
+// 请求头 Idempotency-Key 用于幂等，使用相同的 key 重试时返回第一次请求的流水，转账必须提供。
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength 与 idempotency_key 列的长度一致
+	maxIdempotencyKeyLength = 64
+	maxWalletIdLength       = 64
+	maxRequestBodyBytes     = 1 << 20
+)
+
+// ErrInvalidRequest 请求的参数不合法
+var ErrInvalidRequest = errors.New("invalid request")
+
+// AmountRequest 出账和入账的请求体
+type AmountRequest struct {
+	Amount Money `json:"amount"`
+}
+
+// TransferRequest 转账的请求体
+type TransferRequest struct {
+	FromWalletId string `json:"fromWalletId"`
+	ToWalletId   string `json:"toWalletId"`
+	Amount       Money  `json:"amount"`
+}
+
+// ErrorVo 错误的响应体，Code 是稳定的错误码，转账失败时 Transaction 是 FAILED 状态的流水
+type ErrorVo struct {
+	Code        string         `json:"code"`
+	Error       string         `json:"error"`
+	Transaction *TransactionVo `json:"transaction,omitempty"`
+}
+
+// apiErrors 错误到状态码的映射，按照顺序匹配，转账失败的错误同时也是导致失败的业务错误
+var apiErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrInvalidRequest, http.StatusBadRequest, "INVALID_REQUEST"},
+	{ErrInvalidAmount, http.StatusBadRequest, "INVALID_AMOUNT"},
+	{ErrInvalidMoney, http.StatusBadRequest, "INVALID_AMOUNT"},
+	{ErrInvalidTransfer, http.StatusBadRequest, "INVALID_TRANSFER"},
+	{ErrCurrencyMismatch, http.StatusBadRequest, "CURRENCY_MISMATCH"},
+	{ErrExchangeUnavailable, http.StatusBadRequest, "EXCHANGE_UNAVAILABLE"},
+	{ErrRateNotFound, http.StatusBadRequest, "EXCHANGE_UNAVAILABLE"},
+	{ErrWalletNotFound, http.StatusNotFound, "WALLET_NOT_FOUND"},
+	{ErrInsufficientBalance, http.StatusConflict, "INSUFFICIENT_BALANCE"},
+	{ErrIdempotencyKeyConflict, http.StatusConflict, "IDEMPOTENCY_KEY_CONFLICT"},
+	{ErrConcurrentUpdate, http.StatusConflict, "CONCURRENT_UPDATE"},
+	{ErrTransferFailed, http.StatusUnprocessableEntity, "TRANSFER_FAILED"},
+}
+
+// errorStatus 没有匹配的错误是服务端的错误，不把错误的细节暴露给客户端
+func errorStatus(err error) (int, ErrorVo) {
+	for _, e := range apiErrors {
+		if errors.Is(err, e.err) {
+			return e.status, ErrorVo{Code: e.code, Error: err.Error()}
+		}
+	}
+	return http.StatusInternalServerError, ErrorVo{Code: "INTERNAL", Error: "internal error"}
+}
+
+var _ http.Handler = (*VirtualWalletController)(nil)
+
+func (c *VirtualWalletController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "transfers" {
+		c.handleTransfer(w, r)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[0] != "wallets" {
+		writeJSON(w, http.StatusNotFound, ErrorVo{Code: "NOT_FOUND", Error: "not found"})
+		return
+	}
+	walletId := parts[1]
+	switch parts[2] {
+	case "balance":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		vo, err := c.GetBalance(r.Context(), walletId)
+		writeResult(w, vo, err)
+	case "debit", "credit":
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w)
+			return
+		}
+		var req AmountRequest
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeResult(w, nil, err)
+			return
+		}
+		operation := c.Debit
+		if parts[2] == "credit" {
+			operation = c.Credit
+		}
+		vo, err := operation(r.Context(), r.Header.Get(IdempotencyKeyHeader), walletId, req.Amount)
+		writeResult(w, vo, err)
+	default:
+		writeJSON(w, http.StatusNotFound, ErrorVo{Code: "NOT_FOUND", Error: "not found"})
+	}
+}
+
+func (c *VirtualWalletController) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req TransferRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		writeResult(w, nil, err)
+		return
+	}
+
+	vo, err := c.Transfer(r.Context(), r.Header.Get(IdempotencyKeyHeader), req.FromWalletId, req.ToWalletId, req.Amount)
+	if err != nil && vo != nil {
+		status, body := errorStatus(err)
+		body.Transaction = vo
+		writeJSON(w, status, body)
+		return
+	}
+	writeResult(w, vo, err)
+}
+
+// decodeRequest 拒绝未知的字段，避免客户端拼错字段名时被当作零值处理
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, ErrInvalidMoney) {
+			return err
+		}
+		return fmt.Errorf("%w: malformed request body: %v", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// validateRequest 校验幂等 key、金额和钱包 ID 的格式，业务规则由 Service 校验
+func validateRequest(idempotencyKey string, amount Money, walletIds ...string) error {
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return fmt.Errorf("%w: idempotency key is longer than %d", ErrInvalidRequest, maxIdempotencyKeyLength)
+	}
+	if !validCurrency(amount.Currency()) {
+		return fmt.Errorf("%w: invalid currency %q", ErrInvalidRequest, amount.Currency())
+	}
+	if !amount.IsPositive() {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
+	}
+	for _, walletId := range walletIds {
+		if err := validateWalletId(walletId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateWalletId(walletId string) error {
+	if walletId == "" || len(walletId) > maxWalletIdLength {
+		return fmt.Errorf("%w: wallet id should be 1 to %d characters", ErrInvalidRequest, maxWalletIdLength)
+	}
+	return nil
+}
+
+// validCurrency ISO 4217 的币种代码是 3 个大写字母
+func validCurrency(currency Currency) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, c := range currency {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func writeResult(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		status, body := errorStatus(err)
+		writeJSON(w, status, body)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeJSON(w, http.StatusMethodNotAllowed, ErrorVo{Code: "METHOD_NOT_ALLOWED", Error: "method not allowed"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,147 @@
+package demo_wallet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestWalletServer(t *testing.T) (*httptest.Server, *MemoryStore) {
+	t.Helper()
+	store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("100"), "b": cny("0")})
+	service := NewVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository())
+	server := httptest.NewServer(NewVirtualWalletController(service))
+	t.Cleanup(server.Close)
+	return server, store
+}
+
+// doRequest 发送请求并把响应体解析到 v 中，返回状态码
+func doRequest(t *testing.T, server *httptest.Server, method, path, idempotencyKey, body string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("%s %s Content-Type = %q", method, path, got)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s decode response error = %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestVirtualWalletController_API(t *testing.T) {
+	server, store := newTestWalletServer(t)
+
+	var wallet VirtualWalletVo
+	if status := doRequest(t, server, http.MethodGet, "/wallets/a/balance", "", "", &wallet); status != http.StatusOK ||
+		wallet.Id != "a" || wallet.Balance != cny("100") || len(wallet.Balances) != 1 {
+		t.Fatalf("GetBalance got = %d %+v", status, wallet)
+	}
+
+	var debit TransactionVo
+	body := `{"amount": {"amount": "30", "currency": "CNY"}}`
+	if status := doRequest(t, server, http.MethodPost, "/wallets/a/debit", "debit-1", body, &debit); status != http.StatusOK ||
+		debit.Type != "DEBIT" || debit.Status != "SUCCEEDED" || debit.FromWalletId != "a" || debit.Amount != cny("30") {
+		t.Fatalf("Debit got = %d %+v", status, debit)
+	}
+	// 使用相同的 key 重试返回第一次的流水，不会重复扣款
+	var retry TransactionVo
+	if status := doRequest(t, server, http.MethodPost, "/wallets/a/debit", "debit-1", body, &retry); status != http.StatusOK ||
+		retry.Id != debit.Id {
+		t.Errorf("Debit retry got = %d %+v, want transaction %d", status, retry, debit.Id)
+	}
+
+	var credit TransactionVo
+	if status := doRequest(t, server, http.MethodPost, "/wallets/b/credit", "", `{"amount": {"amount": "5.5", "currency": "CNY"}}`,
+		&credit); status != http.StatusOK || credit.Type != "CREDIT" || credit.ToWalletId != "b" {
+		t.Fatalf("Credit got = %d %+v", status, credit)
+	}
+
+	var transfer TransactionVo
+	body = `{"fromWalletId": "a", "toWalletId": "b", "amount": {"amount": "20", "currency": "CNY"}}`
+	if status := doRequest(t, server, http.MethodPost, "/transfers", "transfer-1", body, &transfer); status != http.StatusOK ||
+		transfer.Type != "TRANSFER" || transfer.Status != "SUCCEEDED" || transfer.ToAmount == nil || *transfer.ToAmount != cny("20") {
+		t.Fatalf("Transfer got = %d %+v", status, transfer)
+	}
+	assertBalances(t, store, map[string]Money{"a": cny("50"), "b": cny("25.50")})
+
+	// 余额不足的转账返回 409 和 FAILED 状态的流水
+	var failed ErrorVo
+	body = `{"fromWalletId": "a", "toWalletId": "b", "amount": {"amount": "1000", "currency": "CNY"}}`
+	if status := doRequest(t, server, http.MethodPost, "/transfers", "transfer-2", body, &failed); status != http.StatusConflict ||
+		failed.Code != "INSUFFICIENT_BALANCE" || failed.Transaction == nil || failed.Transaction.Status != "FAILED" {
+		t.Errorf("Transfer insufficient got = %d %+v", status, failed)
+	}
+	assertBalances(t, store, map[string]Money{"a": cny("50"), "b": cny("25.50")})
+}
+
+func TestVirtualWalletController_Errors(t *testing.T) {
+	server, store := newTestWalletServer(t)
+	amount := `{"amount": {"amount": "1", "currency": "CNY"}}`
+	transfer := `{"fromWalletId": "a", "toWalletId": "b", "amount": {"amount": "1", "currency": "CNY"}}`
+
+	tests := []struct {
+		name           string
+		method, path   string
+		idempotencyKey string
+		body           string
+		wantStatus     int
+		wantCode       string
+	}{
+		{"unknown wallet", http.MethodGet, "/wallets/c/balance", "", "", http.StatusNotFound, "WALLET_NOT_FOUND"},
+		{"debit unknown wallet", http.MethodPost, "/wallets/c/debit", "", amount, http.StatusNotFound, "WALLET_NOT_FOUND"},
+		{"insufficient balance", http.MethodPost, "/wallets/b/debit", "", amount, http.StatusConflict, "INSUFFICIENT_BALANCE"},
+		{"zero amount", http.MethodPost, "/wallets/a/credit", "", `{"amount": {"amount": "0", "currency": "CNY"}}`,
+			http.StatusBadRequest, "INVALID_AMOUNT"},
+		{"too many decimals", http.MethodPost, "/wallets/a/credit", "", `{"amount": {"amount": "0.001", "currency": "CNY"}}`,
+			http.StatusBadRequest, "INVALID_AMOUNT"},
+		{"invalid currency", http.MethodPost, "/wallets/a/credit", "", `{"amount": {"amount": "1", "currency": "cny"}}`,
+			http.StatusBadRequest, "INVALID_REQUEST"},
+		{"missing amount", http.MethodPost, "/wallets/a/credit", "", `{}`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"unknown field", http.MethodPost, "/wallets/a/credit", "", `{"amount": {"amount": "1", "currency": "CNY"}, "to": "b"}`,
+			http.StatusBadRequest, "INVALID_REQUEST"},
+		{"malformed body", http.MethodPost, "/wallets/a/credit", "", `{`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"key too long", http.MethodPost, "/wallets/a/credit", strings.Repeat("k", 65), amount, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"transfer without key", http.MethodPost, "/transfers", "", transfer, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"transfer to itself", http.MethodPost, "/transfers", "transfer-1",
+			`{"fromWalletId": "a", "toWalletId": "a", "amount": {"amount": "1", "currency": "CNY"}}`, http.StatusBadRequest, "INVALID_TRANSFER"},
+		{"transfer without wallet", http.MethodPost, "/transfers", "transfer-1",
+			`{"toWalletId": "b", "amount": {"amount": "1", "currency": "CNY"}}`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"method not allowed", http.MethodGet, "/wallets/a/debit", "", "", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED"},
+		{"not found", http.MethodGet, "/wallets/a", "", "", http.StatusNotFound, "NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ErrorVo
+			if status := doRequest(t, server, tt.method, tt.path, tt.idempotencyKey, tt.body, &got); status != tt.wantStatus ||
+				got.Code != tt.wantCode {
+				t.Errorf("got = %d %+v, want %d %s", status, got, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+
+	// 相同的 key 用于了不同的请求
+	if status := doRequest(t, server, http.MethodPost, "/wallets/a/debit", "key-1", amount, nil); status != http.StatusOK {
+		t.Fatalf("Debit got = %d", status)
+	}
+	var conflict ErrorVo
+	if status := doRequest(t, server, http.MethodPost, "/wallets/a/credit", "key-1", amount, &conflict); status != http.StatusConflict ||
+		conflict.Code != "IDEMPOTENCY_KEY_CONFLICT" {
+		t.Errorf("Credit with used key got = %d %+v", status, conflict)
+	}
+	assertBalances(t, store, map[string]Money{"a": cny("99"), "b": cny("0")})
+}
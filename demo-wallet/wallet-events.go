@@ -0,0 +1,72 @@
+package demo_wallet
+
+import "time"
+
+// 领域事件：VirtualWallet 每一次状态变化都记录一个事件，调用方在保存钱包之后通过 PullEvents 取出并发布，
+// 对冻结、透支感兴趣的下游（风控、通知、对账）订阅事件，而不需要轮询钱包的状态。
+
+// DomainEvent 钱包的领域事件
+type DomainEvent interface {
+	Header() EventHeader
+}
+
+// EventHeader 所有事件共有的字段
+type EventHeader struct {
+	WalletId   string    `json:"walletId"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func (h EventHeader) Header() EventHeader {
+	return h
+}
+
+// WalletOpened 开户，Currency 是钱包的主币种
+type WalletOpened struct {
+	EventHeader
+	Currency Currency `json:"currency"`
+}
+
+// WalletDebited 出账，Balance 是出账之后的余额
+type WalletDebited struct {
+	EventHeader
+	Amount  Money `json:"amount"`
+	Balance Money `json:"balance"`
+}
+
+// WalletCredited 入账，Balance 是入账之后的余额
+type WalletCredited struct {
+	EventHeader
+	Amount  Money `json:"amount"`
+	Balance Money `json:"balance"`
+}
+
+// FundsFrozen 冻结一笔金额，ExpireTime 为零值时永不过期
+type FundsFrozen struct {
+	EventHeader
+	FreezeId   string    `json:"freezeId"`
+	Amount     Money     `json:"amount"`
+	Reason     string    `json:"reason"`
+	ExpireTime time.Time `json:"expireTime"`
+}
+
+// FundsUnfrozen 解冻一笔冻结中的部分或者全部金额，Expired 表示冻结到期后自动解冻
+type FundsUnfrozen struct {
+	EventHeader
+	FreezeId string `json:"freezeId"`
+	Amount   Money  `json:"amount"`
+	Expired  bool   `json:"expired"`
+}
+
+type OverdraftOpened struct {
+	EventHeader
+}
+
+type OverdraftClosed struct {
+	EventHeader
+}
+
+// OverdraftLimitChanged 透支额度调整，Limit 是调整之后的额度
+type OverdraftLimitChanged struct {
+	EventHeader
+	Limit Money `json:"limit"`
+}
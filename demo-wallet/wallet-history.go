@@ -0,0 +1,294 @@
+package demo_wallet
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+// 交易流水查询：按照钱包、类型、时间范围、金额范围过滤，按照流水 ID 倒序分页，并计算每一笔流水之后钱包的余额。
+// 分页使用游标而不是 offset，翻页期间有新的流水写入时不会出现重复或者遗漏的行。
+
+var ErrInvalidCursor = errors.New("invalid transaction cursor")
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var transactionTypeNames = map[int]string{DEBIT: "DEBIT", CREDIT: "CREDIT", TRANSFER: "TRANSFER"}
+
+// TransactionTypeName 流水类型的名称，如 DEBIT
+func TransactionTypeName(transactionType int) string {
+	if name, ok := transactionTypeNames[transactionType]; ok {
+		return name
+	}
+	return strconv.Itoa(transactionType)
+}
+
+var transactionStatusNames = map[int]string{SUCCEEDED: "SUCCEEDED", PENDING: "PENDING", FAILED: "FAILED"}
+
+// TransactionStatusName 流水状态的名称，如 SUCCEEDED
+func TransactionStatusName(status int) string {
+	if name, ok := transactionStatusNames[status]; ok {
+		return name
+	}
+	return strconv.Itoa(status)
+}
+
+// ParseTransactionType TransactionTypeName 的逆操作
+func ParseTransactionType(name string) (int, error) {
+	for transactionType, typeName := range transactionTypeNames {
+		if typeName == name {
+			return transactionType, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown transaction type %q", name)
+}
+
+// TransactionFilter 查询 WalletId 出账或者入账的成功的流水，零值的条件不过滤
+type TransactionFilter struct {
+	WalletId string
+	Types    []int
+	// Since 和 Until 是左闭右开的时间范围
+	Since time.Time
+	Until time.Time
+	// MinAmount 和 MaxAmount 是闭区间，按照出账的金额 amount 比较，同时只返回相同币种的流水
+	MinAmount *Money
+	MaxAmount *Money
+	// BeforeId 只返回 ID 小于 BeforeId 的流水，0 表示从最新的流水开始
+	BeforeId int64
+	Limit    int
+}
+
+// matches 内存中的过滤，与 SQL 的查询条件一致
+func (f TransactionFilter) matches(entity *VirtualWalletTransactionEntity) bool {
+	if entity.status != SUCCEEDED || (entity.fromWalletId != f.WalletId && entity.toWalletId != f.WalletId) {
+		return false
+	}
+	if f.BeforeId > 0 && entity.id >= f.BeforeId {
+		return false
+	}
+	if len(f.Types) > 0 {
+		found := false
+		for _, transactionType := range f.Types {
+			found = found || entity.transactionType == transactionType
+		}
+		if !found {
+			return false
+		}
+	}
+	if (!f.Since.IsZero() && entity.createTime.Before(f.Since)) || (!f.Until.IsZero() && !entity.createTime.Before(f.Until)) {
+		return false
+	}
+	if f.MinAmount != nil {
+		if cmp, err := entity.amount.Cmp(*f.MinAmount); err != nil || cmp < 0 {
+			return false
+		}
+	}
+	if f.MaxAmount != nil {
+		if cmp, err := entity.amount.Cmp(*f.MaxAmount); err != nil || cmp > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// movement 流水对 walletId 余额的影响，出账为负数，入账为正数
+func movement(entity *VirtualWalletTransactionEntity, walletId string) Money {
+	switch {
+	case entity.transactionType == TRANSFER && entity.toWalletId == walletId:
+		return entity.toAmount
+	case entity.fromWalletId == walletId:
+		return entity.amount.Neg()
+	default:
+		return entity.amount
+	}
+}
+
+// TransactionQuery 分页查询的条件，Cursor 是上一页返回的 NextCursor
+type TransactionQuery struct {
+	WalletId  string
+	Types     []int
+	Since     time.Time
+	Until     time.Time
+	MinAmount *Money
+	MaxAmount *Money
+	Cursor    string
+	// PageSize 默认 20，最大 100
+	PageSize int
+}
+
+// TransactionRow 流水以及流水之后钱包的余额
+type TransactionRow struct {
+	Transaction *VirtualWalletTransactionEntity
+	// Amount 流水对钱包余额的影响，出账为负数
+	Amount Money
+	// Balance 这笔流水之后钱包在 Amount 币种的余额
+	Balance Money
+}
+
+// TransactionPage 一页流水，NextCursor 为空表示没有下一页
+type TransactionPage struct {
+	Rows       []TransactionRow
+	NextCursor string
+}
+
+// TransactionHistory 两种开发模式共用的流水查询，查询不涉及业务规则
+type TransactionHistory struct {
+	uow             UnitOfWork
+	walletRepo      VirtualWalletRepository
+	transactionRepo VirtualWalletTransactionRepository
+}
+
+func NewTransactionHistory(uow UnitOfWork, walletRepo VirtualWalletRepository,
+	transactionRepo VirtualWalletTransactionRepository) *TransactionHistory {
+	return &TransactionHistory{uow: uow, walletRepo: walletRepo, transactionRepo: transactionRepo}
+}
+
+// Query 按照流水 ID 倒序返回一页流水
+func (h *TransactionHistory) Query(ctx context.Context, query TransactionQuery) (TransactionPage, error) {
+	return h.query(ctx, query, make(map[Currency]*balanceCursor))
+}
+
+// query cursors 是上一页每个币种的最后一行，见 withBalances
+func (h *TransactionHistory) query(ctx context.Context, query TransactionQuery, cursors map[Currency]*balanceCursor) (TransactionPage, error) {
+	beforeId, err := sqlstore.DecodeCursor(query.Cursor)
+	if err != nil {
+		return TransactionPage{}, fmt.Errorf("%w: %q", ErrInvalidCursor, query.Cursor)
+	}
+	pageSize := query.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	filter := TransactionFilter{WalletId: query.WalletId, Types: query.Types, Since: query.Since, Until: query.Until,
+		MinAmount: query.MinAmount, MaxAmount: query.MaxAmount, BeforeId: beforeId, Limit: pageSize + 1}
+	var page TransactionPage
+	// 余额和流水在同一个工作单元中读取，避免读取期间写入的流水导致余额对不上
+	err = h.uow.Do(ctx, func(ctx context.Context) error {
+		transactions, err := h.transactionRepo.QueryTransactions(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if len(transactions) > pageSize {
+			transactions = transactions[:pageSize]
+			page.NextCursor = sqlstore.EncodeCursor(transactions[pageSize-1].id)
+		}
+		page.Rows, err = h.withBalances(ctx, query.WalletId, transactions, cursors)
+		return err
+	})
+	return page, err
+}
+
+// balanceCursor 按照 ID 倒序计算余额时，一个币种的上一行流水
+type balanceCursor struct {
+	id      int64
+	amount  Money
+	balance Money
+}
+
+// withBalances 流水之后的余额 = 当前的余额 - 这笔流水之后所有成功流水的影响。
+// 每个币种只有第一行需要汇总之后所有的流水，之后的行 = 上一行的余额 - 上一行的影响 - 两行之间被过滤掉的流水的影响，
+// 两行的 ID 相邻时不需要查询。cursors 记录每个币种的上一行，翻页时传入上一页的 cursors 可以接着计算
+func (h *TransactionHistory) withBalances(ctx context.Context, walletId string,
+	transactions []*VirtualWalletTransactionEntity, cursors map[Currency]*balanceCursor) ([]TransactionRow, error) {
+	var wallet *VirtualWalletEntity
+	rows := make([]TransactionRow, 0, len(transactions))
+	for _, transaction := range transactions {
+		amount := movement(transaction, walletId)
+		currency := amount.Currency()
+		var balance Money
+		var err error
+		if cursor, ok := cursors[currency]; !ok {
+			if wallet == nil {
+				if wallet, err = h.walletRepo.GetWalletEntity(ctx, walletId); err != nil {
+					return nil, err
+				}
+			}
+			later, err := h.transactionRepo.SumMovements(ctx, walletId, currency, transaction.id, 0)
+			if err != nil {
+				return nil, err
+			}
+			if balance, err = wallet.GetBalanceIn(currency).Sub(later); err != nil {
+				return nil, err
+			}
+		} else {
+			if balance, err = cursor.balance.Sub(cursor.amount); err != nil {
+				return nil, err
+			}
+			if cursor.id-transaction.id > 1 {
+				between, err := h.transactionRepo.SumMovements(ctx, walletId, currency, transaction.id, cursor.id)
+				if err != nil {
+					return nil, err
+				}
+				if balance, err = balance.Sub(between); err != nil {
+					return nil, err
+				}
+			}
+		}
+		cursors[currency] = &balanceCursor{id: transaction.id, amount: amount, balance: balance}
+		rows = append(rows, TransactionRow{Transaction: transaction, Amount: amount, Balance: balance})
+	}
+	return rows, nil
+}
+
+var statementHeader = []string{"id", "create_time", "type", "counterparty", "amount", "currency", "balance", "fee", "exchange_rate"}
+
+// ExportStatement 以 CSV 格式导出 walletId 在 month 所在月份（按照 month 的时区）的对账单，按照时间正序排列。
+// 所有的页在同一个工作单元中读取，后面的页接着前一页的余额计算，不需要再汇总前一页之后的流水
+func (h *TransactionHistory) ExportStatement(ctx context.Context, w io.Writer, walletId string, month time.Time) error {
+	since := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	query := TransactionQuery{WalletId: walletId, Since: since, Until: since.AddDate(0, 1, 0), PageSize: maxPageSize}
+
+	var rows []TransactionRow
+	cursors := make(map[Currency]*balanceCursor)
+	err := h.uow.Do(ctx, func(ctx context.Context) error {
+		for {
+			page, err := h.query(ctx, query, cursors)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, page.Rows...)
+			if page.NextCursor == "" {
+				return nil
+			}
+			query.Cursor = page.NextCursor
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(statementHeader); err != nil {
+		return err
+	}
+	for i := len(rows) - 1; i >= 0; i-- {
+		row := rows[i]
+		transaction := row.Transaction
+		counterparty := transaction.toWalletId
+		if counterparty == walletId {
+			counterparty = transaction.fromWalletId
+		}
+		record := []string{strconv.FormatInt(transaction.id, 10), transaction.createTime.In(month.Location()).Format(time.RFC3339),
+			TransactionTypeName(transaction.transactionType), counterparty, row.Amount.Decimal(), string(row.Amount.Currency()),
+			row.Balance.Decimal(), "", transaction.exchangeRate}
+		if transaction.fromWalletId == walletId && transaction.fee.IsPositive() {
+			record[7] = transaction.fee.Decimal()
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,191 @@
+package demo_wallet
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/promacanthus/design-patterns/internal/sqlstore/sqltest"
+)
+
+// newHistoryStore 钱包 a 的流水，最终余额是 81 CNY 和 10 USD
+func newHistoryStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("81"), "b": cny("0")})
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2021, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	transactions := []VirtualWalletTransactionEntity{
+		{amount: cny("100"), createTime: at(5, 31, 23), transactionType: CREDIT, toWalletId: "a"},
+		{amount: cny("30"), createTime: at(6, 1, 10), transactionType: DEBIT, fromWalletId: "a"},
+		{amount: cny("20"), toAmount: cny("20"), createTime: at(6, 2, 0), transactionType: TRANSFER, fromWalletId: "a", toWalletId: "b"},
+		{amount: MustParseMoney("5", USD), toAmount: cny("32"), fee: MustParseMoney("0.01", USD), exchangeRate: "6.4",
+			createTime: at(6, 3, 0), transactionType: TRANSFER, fromWalletId: "b", toWalletId: "a"},
+		{amount: cny("1000"), toAmount: cny("1000"), createTime: at(6, 4, 0), transactionType: TRANSFER, fromWalletId: "a",
+			toWalletId: "b", status: FAILED},
+		{amount: MustParseMoney("10", USD), createTime: at(6, 5, 0), transactionType: CREDIT, toWalletId: "a"},
+		{amount: cny("1"), createTime: at(6, 6, 0), transactionType: CREDIT, toWalletId: "b"},
+		{amount: cny("1"), createTime: at(7, 1, 0), transactionType: DEBIT, fromWalletId: "a"},
+	}
+	for i := range transactions {
+		if err := store.TransactionRepository().SaveTransaction(context.Background(), &transactions[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entity, _ := store.WalletRepository().GetWalletEntity(context.Background(), "a")
+	if err := store.WalletRepository().UpdateBalance(context.Background(), "a", MustParseMoney("10", USD), entity.GetVersion()); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// historyRow 流水 ID、对余额的影响和之后的余额
+type historyRow struct {
+	id      int64
+	amount  Money
+	balance Money
+}
+
+func toHistoryRows(rows []TransactionRow) []historyRow {
+	var got []historyRow
+	for _, row := range rows {
+		got = append(got, historyRow{row.Transaction.GetId(), row.Amount, row.Balance})
+	}
+	return got
+}
+
+func TestTransactionHistory_Query(t *testing.T) {
+	store := newHistoryStore(t)
+	history := NewTransactionHistory(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository())
+	ctx := context.Background()
+
+	// 游标分页，失败的流水和其他钱包的流水不出现在结果中
+	var pages [][]historyRow
+	query := TransactionQuery{WalletId: "a", PageSize: 2}
+	for {
+		page, err := history.Query(ctx, query)
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		pages = append(pages, toHistoryRows(page.Rows))
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	usd := func(s string) Money { return MustParseMoney(s, USD) }
+	want := [][]historyRow{
+		{{8, cny("-1"), cny("81")}, {6, usd("10"), usd("10")}},
+		{{4, cny("32"), cny("82")}, {3, cny("-20"), cny("50")}},
+		{{2, cny("-30"), cny("70")}, {1, cny("100"), cny("100")}},
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("Query() pages got = %+v, want %+v", pages, want)
+	}
+
+	min, max := cny("20"), cny("30")
+	tests := []struct {
+		name  string
+		query TransactionQuery
+		want  []int64
+	}{
+		{"type", TransactionQuery{WalletId: "a", Types: []int{TRANSFER}}, []int64{4, 3}},
+		{"time range", TransactionQuery{WalletId: "a", Since: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+			Until: time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)}, []int64{6, 4, 3, 2}},
+		{"amount range", TransactionQuery{WalletId: "a", MinAmount: &min, MaxAmount: &max}, []int64{3, 2}},
+		{"types and amount", TransactionQuery{WalletId: "a", Types: []int{DEBIT, CREDIT}, MinAmount: &max}, []int64{2, 1}},
+	}
+	for _, tt := range tests {
+		page, err := history.Query(ctx, tt.query)
+		if err != nil {
+			t.Fatalf("Query(%s) error = %v", tt.name, err)
+		}
+		var got []int64
+		for _, row := range page.Rows {
+			got = append(got, row.Transaction.GetId())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Query(%s) got = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := history.Query(ctx, TransactionQuery{WalletId: "a", Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Query() error = %v, want %v", err, ErrInvalidCursor)
+	}
+	if _, err := history.Query(ctx, TransactionQuery{WalletId: "c"}); err != nil {
+		t.Errorf("Query() unknown wallet error = %v, want empty page", err)
+	}
+}
+
+// countingTransactionRepository 记录 SumMovements 的调用次数
+type countingTransactionRepository struct {
+	VirtualWalletTransactionRepository
+	sums int
+}
+
+func (r *countingTransactionRepository) SumMovements(ctx context.Context, walletId string, currency Currency,
+	afterId, beforeId int64) (Money, error) {
+	r.sums++
+	return r.VirtualWalletTransactionRepository.SumMovements(ctx, walletId, currency, afterId, beforeId)
+}
+
+func TestTransactionHistory_QuerySums(t *testing.T) {
+	store := newHistoryStore(t)
+	repo := &countingTransactionRepository{VirtualWalletTransactionRepository: store.TransactionRepository()}
+	history := NewTransactionHistory(store.UnitOfWork(), store.WalletRepository(), repo)
+
+	page, err := history.Query(context.Background(), TransactionQuery{WalletId: "a"})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	// 两个币种各汇总一次之后的流水，流水 8 和 4 之间有其他钱包和失败的流水，其余的行 ID 相邻
+	if got := toHistoryRows(page.Rows); len(got) != 6 || got[2] != (historyRow{4, cny("32"), cny("82")}) || repo.sums != 3 {
+		t.Errorf("Query() got = %+v with %d sums, want 3 sums", got, repo.sums)
+	}
+}
+
+func TestTransactionHistory_ExportStatement(t *testing.T) {
+	store := newHistoryStore(t)
+	history := NewTransactionHistory(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository())
+
+	// 按照东八区划分月份，5 月 31 日 23 点（UTC）是 6 月 1 日 7 点
+	var buf strings.Builder
+	month := time.Date(2021, 6, 15, 0, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	if err := history.ExportStatement(context.Background(), &buf, "a", month); err != nil {
+		t.Fatalf("ExportStatement() error = %v", err)
+	}
+	want := `id,create_time,type,counterparty,amount,currency,balance,fee,exchange_rate
+1,2021-06-01T07:00:00+08:00,CREDIT,,100.00,CNY,100.00,,
+2,2021-06-01T18:00:00+08:00,DEBIT,,-30.00,CNY,70.00,,
+3,2021-06-02T08:00:00+08:00,TRANSFER,b,-20.00,CNY,50.00,,
+4,2021-06-03T08:00:00+08:00,TRANSFER,b,32.00,CNY,82.00,,6.4
+6,2021-06-05T08:00:00+08:00,CREDIT,,10.00,USD,10.00,,
+`
+	if got := buf.String(); got != want {
+		t.Errorf("ExportStatement() got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSQLVirtualWalletTransactionRepository_QueryTransactions(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	repo := NewSQLVirtualWalletTransactionRepository(db)
+	since := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	min := cny("20")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+transactionColumns+" FROM virtual_wallet_transaction "+
+		"WHERE (from_wallet_id = ? OR to_wallet_id = ?) AND status = ? AND type IN (?, ?) AND create_time >= ? "+
+		"AND currency = ? AND amount >= ? AND id < ? ORDER BY id DESC LIMIT ?")).
+		WithArgs("a", "a", SUCCEEDED, DEBIT, TRANSFER, since, CNY, 2000, 10, 3).
+		WillReturnRows(sqlmock.NewRows(strings.Split(transactionColumns, ", ")).
+			AddRow(9, 3000, "CNY", since, TRANSFER, "a", "b", SUCCEEDED, nil, "", 3000, "CNY", "", 0))
+	transactions, err := repo.QueryTransactions(context.Background(), TransactionFilter{WalletId: "a", Types: []int{DEBIT, TRANSFER},
+		Since: since, MinAmount: &min, BeforeId: 10, Limit: 3})
+	if err != nil || len(transactions) != 1 || transactions[0].GetId() != 9 || transactions[0].GetToAmount() != cny("30") {
+		t.Errorf("QueryTransactions() got = %+v, %v", transactions, err)
+	}
+}
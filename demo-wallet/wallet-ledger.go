@@ -0,0 +1,283 @@
+package demo_wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// 事件溯源：钱包的每一次状态变化都以 WalletEvent 的形式追加到账本中，账本只追加、不修改，
+// 钱包的状态（余额、冻结、透支）通过按顺序重放事件得到。事件多了之后重放变慢，每追加 N 个事件保存一次快照，
+// 加载时从最近的快照开始重放之后的事件。
+//
+// virtual_wallet_balance 中的余额是账本的投影，便于查询；LedgerChecker 从头重放账本，
+// 与投影的余额比较，发现因为 bug 或者手工修改数据导致的不一致。
+
+var (
+	ErrSnapshotNotFound = errors.New("wallet snapshot not found")
+	// ErrCorruptedLedger 账本中的事件序号不连续或者无法应用到钱包上
+	ErrCorruptedLedger = errors.New("wallet ledger corrupted")
+)
+
+// WalletEvent 账本中保存的一个事件，Sequence 是事件在钱包内的序号，从 1 开始连续递增
+type WalletEvent struct {
+	WalletId   string
+	Sequence   int64
+	Type       string
+	Payload    json.RawMessage
+	OccurredAt time.Time
+}
+
+// walletEventTypes 账本中的事件类型，事件的类型名称会持久化，不能修改
+var walletEventTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, event := range []DomainEvent{WalletOpened{}, WalletDebited{}, WalletCredited{}, FundsFrozen{}, FundsUnfrozen{},
+		OverdraftOpened{}, OverdraftClosed{}, OverdraftLimitChanged{}} {
+		t := reflect.TypeOf(event)
+		walletEventTypes[t.Name()] = t
+	}
+}
+
+func encodeWalletEvent(sequence int64, event DomainEvent) (WalletEvent, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return WalletEvent{}, err
+	}
+	header := event.Header()
+	return WalletEvent{WalletId: header.WalletId, Sequence: sequence, Type: reflect.TypeOf(event).Name(),
+		Payload: payload, OccurredAt: header.OccurredAt}, nil
+}
+
+func decodeWalletEvent(record WalletEvent) (DomainEvent, error) {
+	t, ok := walletEventTypes[record.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown event type %q at %s/%d", ErrCorruptedLedger, record.Type, record.WalletId, record.Sequence)
+	}
+	event := reflect.New(t)
+	if err := json.Unmarshal(record.Payload, event.Interface()); err != nil {
+		return nil, fmt.Errorf("%w: decode %s/%d: %v", ErrCorruptedLedger, record.WalletId, record.Sequence, err)
+	}
+	return event.Elem().Interface().(DomainEvent), nil
+}
+
+// WalletEventStore 只追加的事件账本
+type WalletEventStore interface {
+	// Append 追加事件，钱包当前的最大序号不等于 expectedSequence 时返回 ErrConcurrentUpdate
+	Append(ctx context.Context, walletId string, expectedSequence int64, events []WalletEvent) error
+	// Load 按照序号返回 afterSequence 之后的事件
+	Load(ctx context.Context, walletId string, afterSequence int64) ([]WalletEvent, error)
+}
+
+// WalletSnapshotStore 钱包的快照
+type WalletSnapshotStore interface {
+	SaveSnapshot(ctx context.Context, snapshot WalletSnapshot) error
+	// LatestSnapshot 序号最大的快照，没有快照时返回 ErrSnapshotNotFound
+	LatestSnapshot(ctx context.Context, walletId string) (WalletSnapshot, error)
+}
+
+// WalletSnapshot 钱包在应用了 Sequence 个事件之后的状态
+type WalletSnapshot struct {
+	WalletId        string         `json:"walletId"`
+	Sequence        int64          `json:"sequence"`
+	Currency        Currency       `json:"currency"`
+	CreateTime      time.Time      `json:"createTime"`
+	Balances        []Money        `json:"balances"`
+	AllowOverdraft  bool           `json:"allowOverdraft"`
+	OverdraftAmount Money          `json:"overdraftAmount"`
+	Freezes         []FreezeRecord `json:"freezes"`
+}
+
+// Snapshot 钱包当前的状态，还没有取出的事件也包含在内
+func (w *VirtualWallet) Snapshot() WalletSnapshot {
+	return WalletSnapshot{WalletId: w.id, Sequence: w.sequence, Currency: w.currency, CreateTime: w.createTime,
+		Balances: w.Balances(), AllowOverdraft: w.isAllowedOverdraft, OverdraftAmount: w.overdraftAmount, Freezes: w.Freezes()}
+}
+
+func restoreVirtualWallet(snapshot WalletSnapshot) (*VirtualWallet, error) {
+	w := newEmptyVirtualWallet()
+	w.id, w.sequence, w.currency, w.createTime = snapshot.WalletId, snapshot.Sequence, snapshot.Currency, snapshot.CreateTime
+	w.isAllowedOverdraft, w.overdraftAmount = snapshot.AllowOverdraft, snapshot.OverdraftAmount
+	for _, balance := range snapshot.Balances {
+		w.balances[balance.Currency()] = balance
+	}
+	for i := range snapshot.Freezes {
+		freeze := snapshot.Freezes[i]
+		frozenAmount, err := w.FrozenAmountIn(freeze.Amount.Currency()).Add(freeze.Amount)
+		if err != nil {
+			return nil, err
+		}
+		w.frozenAmounts[frozenAmount.Currency()] = frozenAmount
+		w.freezes[freeze.Id] = &freeze
+	}
+	return w, nil
+}
+
+// WalletLedger 基于事件账本的 VirtualWallet 存储
+type WalletLedger struct {
+	events    WalletEventStore
+	snapshots WalletSnapshotStore
+	// snapshotEvery 每追加多少个事件保存一次快照，小于等于 0 时不保存快照
+	snapshotEvery int64
+}
+
+// NewWalletLedger snapshots 为 nil 时每次都从第一个事件开始重放
+func NewWalletLedger(events WalletEventStore, snapshots WalletSnapshotStore, snapshotEvery int) *WalletLedger {
+	return &WalletLedger{events: events, snapshots: snapshots, snapshotEvery: int64(snapshotEvery)}
+}
+
+// Load 从最近的快照开始重放事件，账本中没有这个钱包时返回 ErrWalletNotFound
+func (l *WalletLedger) Load(ctx context.Context, walletId string) (*VirtualWallet, error) {
+	wallet := newEmptyVirtualWallet()
+	if l.snapshots != nil {
+		snapshot, err := l.snapshots.LatestSnapshot(ctx, walletId)
+		switch {
+		case err == nil:
+			if wallet, err = restoreVirtualWallet(snapshot); err != nil {
+				return nil, err
+			}
+		case !errors.Is(err, ErrSnapshotNotFound):
+			return nil, err
+		}
+	}
+	return l.replay(ctx, walletId, wallet)
+}
+
+// Replay 忽略快照，从第一个事件开始重放
+func (l *WalletLedger) Replay(ctx context.Context, walletId string) (*VirtualWallet, error) {
+	return l.replay(ctx, walletId, newEmptyVirtualWallet())
+}
+
+func (l *WalletLedger) replay(ctx context.Context, walletId string, wallet *VirtualWallet) (*VirtualWallet, error) {
+	records, err := l.events.Load(ctx, walletId, wallet.sequence)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Sequence != wallet.sequence+1 {
+			return nil, fmt.Errorf("%w: %s expected sequence %d, got %d", ErrCorruptedLedger, walletId, wallet.sequence+1, record.Sequence)
+		}
+		event, err := decodeWalletEvent(record)
+		if err != nil {
+			return nil, err
+		}
+		if err := wallet.apply(event); err != nil {
+			return nil, fmt.Errorf("%w: apply %s/%d: %v", ErrCorruptedLedger, walletId, record.Sequence, err)
+		}
+	}
+	if wallet.sequence == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, walletId)
+	}
+	return wallet, nil
+}
+
+// Save 把钱包还没有取出的事件追加到账本中，事件的序号跨过 snapshotEvery 的整数倍时保存快照
+func (l *WalletLedger) Save(ctx context.Context, wallet *VirtualWallet) error {
+	events := wallet.PullEvents()
+	if len(events) == 0 {
+		return nil
+	}
+
+	expected := wallet.sequence - int64(len(events))
+	records := make([]WalletEvent, 0, len(events))
+	for i, event := range events {
+		record, err := encodeWalletEvent(expected+int64(i)+1, event)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	if err := l.events.Append(ctx, wallet.id, expected, records); err != nil {
+		return err
+	}
+
+	if l.snapshots == nil || l.snapshotEvery <= 0 || expected/l.snapshotEvery == wallet.sequence/l.snapshotEvery {
+		return nil
+	}
+	return l.snapshots.SaveSnapshot(ctx, wallet.Snapshot())
+}
+
+// importWallet 把还没有账本的钱包导入账本：开户之后以当前的余额入账
+func importWallet(entity *VirtualWalletEntity, now time.Time) *VirtualWallet {
+	wallet := newEmptyVirtualWallet()
+	wallet.mustRaise(WalletOpened{EventHeader: EventHeader{WalletId: entity.GetId(), OccurredAt: entity.GetCreateTime()},
+		Currency: entity.GetCurrency()})
+	for _, balance := range entity.GetBalances() {
+		if balance.IsZero() {
+			continue
+		}
+		// 期初余额可能是透支之后的负数，不经过 Credit 的校验
+		wallet.mustRaise(WalletCredited{EventHeader: wallet.header(now), Amount: balance, Balance: balance})
+	}
+	return wallet
+}
+
+// WithWalletLedger DDDVirtualWalletService 通过账本加载和保存钱包，余额同时投影到 VirtualWalletRepository 中，
+// 还没有账本的钱包在第一次修改时以当前的余额导入账本
+func WithWalletLedger(ledger *WalletLedger) ServiceOption {
+	return func(o *serviceOptions) {
+		o.ledger = ledger
+	}
+}
+
+// BalanceDrift 账本重放得到的余额与 VirtualWalletRepository 中保存的余额不一致
+type BalanceDrift struct {
+	WalletId string
+	Currency Currency
+	Ledger   Money
+	Stored   Money
+}
+
+func (d BalanceDrift) String() string {
+	return fmt.Sprintf("wallet %s %s: ledger %s, stored %s", d.WalletId, d.Currency, d.Ledger.Decimal(), d.Stored.Decimal())
+}
+
+// LedgerChecker 对账：从第一个事件开始重放账本，与保存的余额逐个币种比较
+type LedgerChecker struct {
+	ledger     *WalletLedger
+	walletRepo VirtualWalletRepository
+}
+
+func NewLedgerChecker(events WalletEventStore, walletRepo VirtualWalletRepository) *LedgerChecker {
+	return &LedgerChecker{ledger: NewWalletLedger(events, nil, 0), walletRepo: walletRepo}
+}
+
+// Check 返回所有不一致的余额，在工作单元中调用时账本和余额是同一时刻的数据
+func (c *LedgerChecker) Check(ctx context.Context, walletIds ...string) ([]BalanceDrift, error) {
+	var drifts []BalanceDrift
+	for _, walletId := range walletIds {
+		wallet, err := c.ledger.Replay(ctx, walletId)
+		if err != nil {
+			return drifts, err
+		}
+		entity, err := c.walletRepo.GetWalletEntity(ctx, walletId)
+		if err != nil {
+			return drifts, err
+		}
+
+		currencies := make(map[Currency]bool)
+		for _, balance := range wallet.Balances() {
+			currencies[balance.Currency()] = true
+		}
+		for _, balance := range entity.GetBalances() {
+			currencies[balance.Currency()] = true
+		}
+		sorted := make([]Currency, 0, len(currencies))
+		for currency := range currencies {
+			sorted = append(sorted, currency)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		for _, currency := range sorted {
+			ledger, stored := wallet.BalanceIn(currency), entity.GetBalanceIn(currency)
+			if ledger != stored {
+				drifts = append(drifts, BalanceDrift{WalletId: walletId, Currency: currency, Ledger: ledger, Stored: stored})
+			}
+		}
+	}
+	return drifts, nil
+}
@@ -0,0 +1,192 @@
+package demo_wallet
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/promacanthus/design-patterns/internal/sqlstore/sqltest"
+)
+
+func TestWalletLedger_Replay(t *testing.T) {
+	store := NewMemoryStore()
+	ledger := NewWalletLedger(store.EventStore(), store.SnapshotStore(), 3)
+	ctx := context.Background()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	wallet := newEmptyVirtualWallet()
+	wallet.now = func() time.Time { return now }
+	wallet.mustRaise(WalletOpened{EventHeader: EventHeader{WalletId: "w1", OccurredAt: now}, Currency: CNY})
+	steps := []func() error{
+		func() error { return wallet.Credit(cny("100")) },
+		func() error { return wallet.Credit(MustParseMoney("20", USD)) },
+		func() error { return wallet.Freeze("order-1", cny("30"), "order", now.Add(time.Hour)) },
+		func() error { wallet.OpenOverdraft(); return wallet.IncreaseOverdraftAmount(cny("50")) },
+		func() error { return wallet.Debit(cny("110")) },
+		func() error { return wallet.UnFreeze("order-1", cny("10")) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d error = %v", i, err)
+		}
+		if err := ledger.Save(ctx, wallet); err != nil {
+			t.Fatalf("Save() step %d error = %v", i, err)
+		}
+	}
+
+	// 8 个事件，在第 3、6 个事件之后各保存一次快照
+	if got := len(store.snapshots["w1"]); got != 2 || store.snapshots["w1"][1].Sequence != 6 {
+		t.Errorf("snapshots got = %+v, want 2 snapshots", store.snapshots["w1"])
+	}
+	loaded, err := ledger.Load(ctx, "w1")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got, want := loaded.Snapshot(), wallet.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() got = %+v, want %+v", got, want)
+	}
+	replayed, err := ledger.Replay(ctx, "w1")
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if got, want := replayed.Snapshot(), wallet.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Replay() got = %+v, want %+v", got, want)
+	}
+	if loaded.FrozenAmount() != cny("20") || loaded.Balance() != cny("-10") {
+		t.Errorf("Load() frozen %v, balance %v", loaded.FrozenAmount(), loaded.Balance())
+	}
+
+	// 加载之后钱包已经被修改，追加事件时冲突
+	stale, _ := ledger.Load(ctx, "w1")
+	if err := loaded.Credit(cny("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Save(ctx, loaded); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := stale.Credit(cny("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Save(ctx, stale); !errors.Is(err, ErrConcurrentUpdate) {
+		t.Errorf("Save() stale error = %v, want %v", err, ErrConcurrentUpdate)
+	}
+
+	if _, err := ledger.Load(ctx, "w2"); !errors.Is(err, ErrWalletNotFound) {
+		t.Errorf("Load() error = %v, want %v", err, ErrWalletNotFound)
+	}
+}
+
+func TestWalletLedger_Corrupted(t *testing.T) {
+	store := NewMemoryStore()
+	ledger := NewWalletLedger(store.EventStore(), nil, 0)
+	ctx := context.Background()
+
+	wallet := NewVirtualWallet("w1", CNY)
+	if err := ledger.Save(ctx, wallet); err != nil {
+		t.Fatal(err)
+	}
+	store.events["w1"] = append(store.events["w1"], WalletEvent{WalletId: "w1", Sequence: 3, Type: "WalletCredited", Payload: []byte("{}")})
+	if _, err := ledger.Load(ctx, "w1"); !errors.Is(err, ErrCorruptedLedger) {
+		t.Errorf("Load() error = %v, want %v", err, ErrCorruptedLedger)
+	}
+	store.events["w1"][1] = WalletEvent{WalletId: "w1", Sequence: 2, Type: "WalletRenamed", Payload: []byte("{}")}
+	if _, err := ledger.Load(ctx, "w1"); !errors.Is(err, ErrCorruptedLedger) {
+		t.Errorf("Load() error = %v, want %v", err, ErrCorruptedLedger)
+	}
+}
+
+func TestLedgerChecker_Check(t *testing.T) {
+	store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("100"), "b": cny("0")})
+	ledger := NewWalletLedger(store.EventStore(), store.SnapshotStore(), 2)
+	service := NewDDDVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository(),
+		WithWalletLedger(ledger))
+	ctx := context.Background()
+
+	if err := service.Credit(ctx, "a", cny("50")); err != nil {
+		t.Fatalf("Credit() error = %v", err)
+	}
+	if err := service.Debit(ctx, "a", cny("30")); err != nil {
+		t.Fatalf("Debit() error = %v", err)
+	}
+	if _, err := service.Transfer(ctx, "key-1", "a", "b", cny("20")); err != nil {
+		t.Fatalf("Transfer() error = %v", err)
+	}
+	// 余额不足的转账回滚，不会追加事件
+	if _, err := service.Transfer(ctx, "key-2", "a", "b", cny("1000")); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("Transfer() error = %v, want %v", err, ErrInsufficientBalance)
+	}
+	assertBalances(t, store, map[string]Money{"a": cny("100"), "b": cny("20")})
+
+	// 导入期初余额之后，a 有开户、期初、入账、出账、转出 5 个事件
+	if got := len(store.events["a"]); got != 5 {
+		t.Errorf("events of a got = %d, want 5", got)
+	}
+	checker := NewLedgerChecker(store.EventStore(), store.WalletRepository())
+	if drifts, err := checker.Check(ctx, "a", "b"); err != nil || len(drifts) != 0 {
+		t.Errorf("Check() got = %v, %v, want no drift", drifts, err)
+	}
+
+	// 绕过账本直接修改余额
+	entity, _ := store.WalletRepository().GetWalletEntity(ctx, "b")
+	if err := store.WalletRepository().UpdateBalance(ctx, "b", cny("25"), entity.GetVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WalletRepository().UpdateBalance(ctx, "b", MustParseMoney("1", USD), entity.GetVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	drifts, err := checker.Check(ctx, "a", "b")
+	want := []BalanceDrift{
+		{WalletId: "b", Currency: CNY, Ledger: cny("20"), Stored: cny("25")},
+		{WalletId: "b", Currency: USD, Ledger: MustParseMoney("0", USD), Stored: MustParseMoney("1", USD)},
+	}
+	if err != nil || !reflect.DeepEqual(drifts, want) {
+		t.Errorf("Check() got = %v, %v, want %v", drifts, err, want)
+	}
+}
+
+func TestSQLWalletEventStore_Append(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	store := NewSQLWalletEventStore(db)
+	selectMaxSQL := regexp.QuoteMeta("SELECT COALESCE(MAX(sequence), 0) FROM virtual_wallet_event WHERE wallet_id = ? FOR UPDATE")
+	insertEventSQL := regexp.QuoteMeta("INSERT INTO virtual_wallet_event (wallet_id, sequence, type, payload, occurred_at) VALUES (?, ?, ?, ?, ?)")
+
+	wallet := NewVirtualWallet("w1", CNY)
+	if err := wallet.Credit(cny("10")); err != nil {
+		t.Fatal(err)
+	}
+	var events []WalletEvent
+	for i, event := range wallet.PullEvents() {
+		record, err := encodeWalletEvent(int64(i+1), event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, record)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectMaxSQL).WithArgs("w1").WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(0))
+	mock.ExpectExec(insertEventSQL).WithArgs("w1", 1, "WalletOpened", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(insertEventSQL).WithArgs("w1", 2, "WalletCredited", string(events[1].Payload), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := store.Append(context.Background(), "w1", 0, events); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectMaxSQL).WithArgs("w1").WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(2))
+	mock.ExpectRollback()
+	if err := store.Append(context.Background(), "w1", 0, events); !errors.Is(err, ErrConcurrentUpdate) {
+		t.Errorf("Append() error = %v, want %v", err, ErrConcurrentUpdate)
+	}
+
+	event, err := decodeWalletEvent(events[1])
+	if credited, ok := event.(WalletCredited); err != nil || !ok || credited.Amount != cny("10") || credited.WalletId != "w1" {
+		t.Errorf("decodeWalletEvent() got = %+v, %v", event, err)
+	}
+}
@@ -0,0 +1,38 @@
+package demo_wallet
+
+// 钱包在 Entity、BO、VO 之间的转换由 mappergen 生成，两种开发模式的 Service 和 Controller 共用，钱包中没有需要脱敏的字段。
+// 领域模型的余额包含还没有保存的修改，冻结和透支不属于 BO；只有转账有入账金额，只有跨币种转账有手续费。
+
+//mapper:func WalletEntityToBo VirtualWalletEntity *VirtualWalletBo balance=GetBalance() balances=GetBalances()
+//mapper:func WalletToBo VirtualWallet *VirtualWalletBo balance=Balance() balances=Balances()
+//mapper:func WalletBoToVo VirtualWalletBo *VirtualWalletVo
+//mapper:func TransactionEntityToVo VirtualWalletTransactionEntity *TransactionVo Type=TransactionTypeName(transactionType) Status=TransactionStatusName(status) ToAmount=transferToAmount(src) Fee=chargedFee(src)
+
+func transferToAmount(entity *VirtualWalletTransactionEntity) *Money {
+	if entity.transactionType != TRANSFER {
+		return nil
+	}
+	toAmount := entity.toAmount
+	return &toAmount
+}
+
+func chargedFee(entity *VirtualWalletTransactionEntity) *Money {
+	if !entity.fee.IsPositive() {
+		return nil
+	}
+	fee := entity.fee
+	return &fee
+}
+
+// walletEntityToWallet 从存储中重建聚合根，不是字段之间的转换：需要初始化领域模型内部的 map，不由 mappergen 生成。
+// 透支额度和冻结金额没有保存在 VirtualWalletEntity 中，只在没有配置账本时使用，这时钱包不能冻结和透支，见 ErrLedgerRequired
+func walletEntityToWallet(entity *VirtualWalletEntity) *VirtualWallet {
+	wallet := newEmptyVirtualWallet()
+	wallet.id, wallet.currency, wallet.createTime = entity.GetId(), entity.GetCurrency(), entity.GetCreateTime()
+	wallet.overdraftAmount = NewMoney(0, entity.GetCurrency())
+	wallet.version = entity.GetVersion()
+	for _, balance := range entity.GetBalances() {
+		wallet.balances[balance.Currency()] = balance
+	}
+	return wallet
+}
@@ -0,0 +1,79 @@
+package demo_wallet
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// 事务性 outbox：积分、通知等系统需要知道钱包的出账和入账。余额变化之后直接发送 Kafka 消息，
+// 消息可能在事务回滚之后已经发出，也可能在事务提交之后因为进程退出而丢失。
+// 因此消息先与余额、交易流水在同一个工作单元中写入 outbox 表，由 OutboxRelay 从 outbox 表中读取并发布到 Kafka：
+//
+//  1. 至少一次：消息发布成功之后才标记为已发布，发布之后、标记之前进程退出时消息会重复发布，消费方按照 (TransactionId, WalletId) 去重。
+//  2. 同一个钱包有序：消息以钱包 ID 作为 key，落在同一个分区；OutboxRelay 按照写入的顺序逐条发布，
+//     一条消息发布失败时停止本批次，下一次从这条消息开始重新发布，不会出现后面的消息先于前面的消息到达。
+
+// WalletMessage 发布到 Kafka 的钱包余额变化，转账时出账钱包和入账钱包各有一条消息
+type WalletMessage struct {
+	TransactionId int64 `json:"transactionId"`
+	// Type 交易流水的类型，如 DEBIT、TRANSFER
+	Type     string `json:"type"`
+	WalletId string `json:"walletId"`
+	// Counterparty 转账的对方钱包
+	Counterparty string `json:"counterparty,omitempty"`
+	// Amount 对余额的影响，出账为负数
+	Amount Money `json:"amount"`
+	// Balance 这笔流水之后钱包在 Amount 币种的余额
+	Balance    Money     `json:"balance"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+func newWalletMessage(entity *VirtualWalletTransactionEntity, walletId string, balance Money) WalletMessage {
+	counterparty := entity.toWalletId
+	if counterparty == walletId {
+		counterparty = entity.fromWalletId
+	}
+	return WalletMessage{TransactionId: entity.id, Type: TransactionTypeName(entity.transactionType), WalletId: walletId,
+		Counterparty: counterparty, Amount: movement(entity, walletId), Balance: balance, CreateTime: entity.createTime}
+}
+
+// OutboxMessage outbox 表中的一条消息，Key 是 Kafka 消息的 key
+type OutboxMessage struct {
+	Id         int64
+	Key        string
+	Payload    []byte
+	CreateTime time.Time
+}
+
+// OutboxRepository outbox 表
+type OutboxRepository interface {
+	// Add 保存消息，在工作单元中调用时与其他数据一起提交
+	Add(ctx context.Context, messages ...OutboxMessage) error
+	// Unpublished 按照 Id 的顺序返回最多 limit 条还没有发布的消息，在工作单元中调用时锁住这些消息
+	Unpublished(ctx context.Context, limit int) ([]OutboxMessage, error)
+	MarkPublished(ctx context.Context, ids []int64, publishTime time.Time) error
+}
+
+// WithOutbox 出账、入账和转账成功时在同一个工作单元中把 WalletMessage 写入 outbox
+func WithOutbox(outbox OutboxRepository) ServiceOption {
+	return func(o *serviceOptions) {
+		o.outbox = outbox
+	}
+}
+
+// addToOutbox 没有配置 outbox 时不记录消息
+func addToOutbox(ctx context.Context, outbox OutboxRepository, messages ...WalletMessage) error {
+	if outbox == nil {
+		return nil
+	}
+	records := make([]OutboxMessage, 0, len(messages))
+	for _, message := range messages {
+		payload, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		records = append(records, OutboxMessage{Key: message.WalletId, Payload: payload, CreateTime: message.CreateTime})
+	}
+	return outbox.Add(ctx, records...)
+}
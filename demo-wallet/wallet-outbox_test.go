@@ -0,0 +1,230 @@
+package demo_wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/promacanthus/design-patterns/internal/sqlstore/sqltest"
+)
+
+// outboxMessages outbox 中的所有消息，包括已经发布的
+func outboxMessages(t *testing.T, store *MemoryStore) []WalletMessage {
+	t.Helper()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var messages []WalletMessage
+	for _, record := range store.outbox {
+		var message WalletMessage
+		if err := json.Unmarshal(record.Payload, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.WalletId != record.Key {
+			t.Errorf("outbox message %d key = %s, want %s", record.Id, record.Key, message.WalletId)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func TestOutbox_UnitOfWork(t *testing.T) {
+	type service interface {
+		transferService
+		Debit(ctx context.Context, walletId string, amount Money) error
+		Credit(ctx context.Context, walletId string, amount Money) error
+	}
+	newServices := func(store *MemoryStore, walletRepo VirtualWalletRepository) map[string]service {
+		outbox := WithOutbox(store.Outbox())
+		return map[string]service{
+			"anaemic": NewVirtualWalletService(store.UnitOfWork(), walletRepo, store.TransactionRepository(), outbox),
+			"rich":    NewDDDVirtualWalletService(store.UnitOfWork(), walletRepo, store.TransactionRepository(), outbox),
+		}
+	}
+
+	for name := range newServices(NewMemoryStore(), nil) {
+		t.Run(name, func(t *testing.T) {
+			store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("100"), "b": cny("0")})
+			walletRepo := &failingWalletRepository{VirtualWalletRepository: store.WalletRepository(), failAt: 5}
+			service := newServices(store, walletRepo)[name]
+			ctx := context.Background()
+
+			if err := service.Debit(ctx, "a", cny("10")); err != nil {
+				t.Fatalf("Debit() error = %v", err)
+			}
+			if err := service.Credit(ctx, "b", cny("5")); err != nil {
+				t.Fatalf("Credit() error = %v", err)
+			}
+			if _, err := service.Transfer(ctx, "key-1", "a", "b", cny("20")); err != nil {
+				t.Fatalf("Transfer() error = %v", err)
+			}
+			// 余额不足和更新余额失败时工作单元回滚，不写入消息
+			if err := service.Debit(ctx, "b", cny("100")); !errors.Is(err, ErrInsufficientBalance) {
+				t.Fatalf("Debit() error = %v, want %v", err, ErrInsufficientBalance)
+			}
+			if _, err := service.Transfer(ctx, "key-2", "a", "b", cny("1")); err == nil {
+				t.Fatal("Transfer() want error when updating balance fails")
+			}
+
+			type summary struct {
+				Type, WalletId, Counterparty string
+				Amount, Balance              Money
+			}
+			var got []summary
+			for _, message := range outboxMessages(t, store) {
+				got = append(got, summary{message.Type, message.WalletId, message.Counterparty, message.Amount, message.Balance})
+			}
+			want := []summary{
+				{"DEBIT", "a", "", cny("-10"), cny("90")},
+				{"CREDIT", "b", "", cny("5"), cny("5")},
+				{"TRANSFER", "a", "b", cny("-20"), cny("70")},
+				{"TRANSFER", "b", "a", cny("20"), cny("25")},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("outbox got = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// keyRecordingProducer 记录发送的消息的 key，sarama 的 mock 只能检查消息的 value
+type keyRecordingProducer struct {
+	sarama.SyncProducer
+	keys []string
+}
+
+func (p *keyRecordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	partition, offset, err := p.SyncProducer.SendMessage(msg)
+	if err == nil {
+		key, _ := msg.Key.Encode()
+		p.keys = append(p.keys, string(key))
+	}
+	return partition, offset, err
+}
+
+func TestOutboxRelay_RelayOnce(t *testing.T) {
+	store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("100"), "b": cny("0")})
+	service := NewVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository(),
+		WithOutbox(store.Outbox()))
+	ctx := context.Background()
+	for _, walletId := range []string{"a", "b", "a"} {
+		if err := service.Credit(ctx, walletId, cny("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sent []WalletMessage
+	check := func(val []byte) error {
+		var message WalletMessage
+		err := json.Unmarshal(val, &message)
+		sent = append(sent, message)
+		return err
+	}
+	mock := mocks.NewSyncProducer(t, nil)
+	defer mock.Close()
+	producer := &keyRecordingProducer{SyncProducer: mock}
+	relay := NewOutboxRelay(store.UnitOfWork(), store.Outbox(), producer, "wallet", 2)
+
+	// 第二条消息发布失败，第一条标记为已发布，第二条留到下一次发布
+	brokerDown := errors.New("broker down")
+	mock.ExpectSendMessageWithCheckerFunctionAndSucceed(check)
+	mock.ExpectSendMessageAndFail(brokerDown)
+	if n, err := relay.RelayOnce(ctx); n != 1 || !errors.Is(err, brokerDown) {
+		t.Fatalf("RelayOnce() got = %d, %v, want 1, %v", n, err, brokerDown)
+	}
+
+	mock.ExpectSendMessageWithCheckerFunctionAndSucceed(check)
+	mock.ExpectSendMessageWithCheckerFunctionAndSucceed(check)
+	if n, err := relay.RelayOnce(ctx); n != 2 || err != nil {
+		t.Fatalf("RelayOnce() got = %d, %v, want 2", n, err)
+	}
+	if n, err := relay.RelayOnce(ctx); n != 0 || err != nil {
+		t.Fatalf("RelayOnce() without messages got = %d, %v", n, err)
+	}
+
+	var balances []Money
+	for _, message := range sent {
+		balances = append(balances, message.Balance)
+	}
+	if want := []Money{cny("101"), cny("1"), cny("102")}; !reflect.DeepEqual(balances, want) {
+		t.Errorf("sent balances = %v, want %v", balances, want)
+	}
+	if want := []string{"a", "b", "a"}; !reflect.DeepEqual(producer.keys, want) {
+		t.Errorf("sent keys = %v, want %v", producer.keys, want)
+	}
+}
+
+func TestOutboxRelay_Start(t *testing.T) {
+	store := NewMemoryStore()
+	outbox := store.Outbox()
+	messages := []OutboxMessage{{Key: "a", Payload: []byte("1")}, {Key: "a", Payload: []byte("2")}, {Key: "b", Payload: []byte("3")}}
+	if err := outbox.Add(context.Background(), messages...); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := mocks.NewSyncProducer(t, nil)
+	defer mock.Close()
+	for range messages {
+		mock.ExpectSendMessageAndSucceed()
+	}
+	// 一批满了时立即发布下一批，不等待下一个周期
+	relay := NewOutboxRelay(store.UnitOfWork(), outbox, mock, "wallet", 2)
+	relay.Start(time.Hour)
+	deadline := time.Now().Add(time.Second)
+	for {
+		unpublished, err := outbox.Unpublished(context.Background(), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(unpublished) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unpublished messages = %+v", unpublished)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	relay.Close()
+	relay.Close()
+
+	// 没有启动过的 OutboxRelay 关闭时不会阻塞，关闭之后不再启动
+	idle := NewOutboxRelay(store.UnitOfWork(), outbox, mock, "wallet", 2)
+	idle.Close()
+	idle.Start(time.Hour)
+	idle.Close()
+}
+
+func TestSQLOutboxRepository(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	outbox := NewSQLOutboxRepository(db)
+	ctx := context.Background()
+	createTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO virtual_wallet_outbox (message_key, payload, create_time) VALUES (?, ?, ?)")).
+		WithArgs("a", `{"walletId":"a"}`, createTime).WillReturnResult(sqlmock.NewResult(7, 1))
+	message := OutboxMessage{Key: "a", Payload: []byte(`{"walletId":"a"}`), CreateTime: createTime}
+	if err := outbox.Add(ctx, message); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, message_key, payload, create_time FROM virtual_wallet_outbox " +
+		"WHERE publish_time IS NULL ORDER BY id LIMIT ? FOR UPDATE")).WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "message_key", "payload", "create_time"}).
+			AddRow(7, "a", `{"walletId":"a"}`, createTime).AddRow(8, "b", `{"walletId":"b"}`, createTime))
+	messages, err := outbox.Unpublished(ctx, 10)
+	if err != nil || len(messages) != 2 || messages[0].Id != 7 || messages[1].Key != "b" {
+		t.Fatalf("Unpublished() got = %+v, %v", messages, err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE virtual_wallet_outbox SET publish_time = ? WHERE id IN (?, ?)")).
+		WithArgs(createTime, 7, 8).WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := outbox.MarkPublished(ctx, []int64{7, 8}, createTime); err != nil {
+		t.Fatalf("MarkPublished() error = %v", err)
+	}
+}
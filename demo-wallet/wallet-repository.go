@@ -0,0 +1,287 @@
+package demo_wallet
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/promacanthus/design-patterns/internal/sqlstore"
+)
+
+var (
+	ErrWalletNotFound = errors.New("wallet not found")
+	// ErrConcurrentUpdate 乐观锁冲突：读取之后，钱包已经被其他请求修改
+	ErrConcurrentUpdate = errors.New("wallet updated concurrently")
+)
+
+var _ VirtualWalletRepository = (*SQLVirtualWalletRepository)(nil)
+
+// SQLVirtualWalletRepository 基于 database/sql 的钱包存储，
+// 更新余额时比较版本号（乐观锁），避免并发的请求互相覆盖余额。
+type SQLVirtualWalletRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+func NewSQLVirtualWalletRepository(db *sql.DB) *SQLVirtualWalletRepository {
+	return &SQLVirtualWalletRepository{db: db, now: time.Now}
+}
+
+// walletColumns 余额按照币种保存在 virtual_wallet_balance 表中，以最小货币单位表示
+const walletColumns = "id, currency, version, create_time"
+
+func (r *SQLVirtualWalletRepository) CreateWallet(ctx context.Context, entity *VirtualWalletEntity) error {
+	_, err := sqlstore.ExecutorFrom(ctx, r.db).ExecContext(ctx,
+		"INSERT INTO virtual_wallet (id, currency, version, create_time, update_time) VALUES (?, ?, ?, ?, ?)",
+		entity.id, entity.currency, entity.version, entity.createTime, r.now())
+	if err != nil {
+		return err
+	}
+	for _, balance := range entity.GetBalances() {
+		if err := r.saveBalance(ctx, entity.id, balance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLVirtualWalletRepository) GetWalletEntity(ctx context.Context, walletId string) (*VirtualWalletEntity, error) {
+	return r.getWalletEntity(ctx, "SELECT "+walletColumns+" FROM virtual_wallet WHERE id = ?", walletId)
+}
+
+func (r *SQLVirtualWalletRepository) GetWalletEntityForUpdate(ctx context.Context, walletId string) (*VirtualWalletEntity, error) {
+	return r.getWalletEntity(ctx, "SELECT "+walletColumns+" FROM virtual_wallet WHERE id = ? FOR UPDATE", walletId)
+}
+
+func (r *SQLVirtualWalletRepository) getWalletEntity(ctx context.Context, query, walletId string) (*VirtualWalletEntity, error) {
+	tx := sqlstore.ExecutorFrom(ctx, r.db)
+	entity := &VirtualWalletEntity{}
+	err := tx.QueryRowContext(ctx, query, walletId).Scan(&entity.id, &entity.currency, &entity.version, &entity.createTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, walletId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 钱包行已经加锁，余额行只会在持有钱包行锁时修改，不需要再加锁
+	rows, err := tx.QueryContext(ctx, "SELECT currency, balance FROM virtual_wallet_balance WHERE wallet_id = ?", walletId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency Currency
+		var balance int64
+		if err := rows.Scan(&currency, &balance); err != nil {
+			return nil, err
+		}
+		entity.setBalance(NewMoney(balance, currency))
+	}
+	return entity, rows.Err()
+}
+
+func (r *SQLVirtualWalletRepository) GetBalance(ctx context.Context, walletId string) (Money, error) {
+	entity, err := r.GetWalletEntity(ctx, walletId)
+	if err != nil {
+		return Money{}, err
+	}
+	return entity.GetBalance(), nil
+}
+
+func (r *SQLVirtualWalletRepository) UpdateBalance(ctx context.Context, walletId string, balance Money, version int64) error {
+	result, err := sqlstore.ExecutorFrom(ctx, r.db).ExecContext(ctx,
+		"UPDATE virtual_wallet SET version = version + 1, update_time = ? WHERE id = ? AND version = ?",
+		r.now(), walletId, version)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		// 区分钱包不存在和版本号不一致
+		if _, err := r.GetWalletEntity(ctx, walletId); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s at version %d", ErrConcurrentUpdate, walletId, version)
+	}
+	return r.saveBalance(ctx, walletId, balance)
+}
+
+// saveBalance 第一次持有某个币种时插入余额行
+func (r *SQLVirtualWalletRepository) saveBalance(ctx context.Context, walletId string, balance Money) error {
+	_, err := sqlstore.ExecutorFrom(ctx, r.db).ExecContext(ctx,
+		"INSERT INTO virtual_wallet_balance (wallet_id, currency, balance) VALUES (?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE balance = VALUES(balance)",
+		walletId, balance.Currency(), balance)
+	return err
+}
+
+var _ VirtualWalletTransactionRepository = (*SQLVirtualWalletTransactionRepository)(nil)
+
+// SQLVirtualWalletTransactionRepository 基于 database/sql 的交易流水存储
+type SQLVirtualWalletTransactionRepository struct {
+	db *sql.DB
+}
+
+func NewSQLVirtualWalletTransactionRepository(db *sql.DB) *SQLVirtualWalletTransactionRepository {
+	return &SQLVirtualWalletTransactionRepository{db: db}
+}
+
+const transactionColumns = "id, amount, currency, create_time, type, from_wallet_id, to_wallet_id, status, idempotency_key, fail_reason, " +
+	"to_amount, to_currency, exchange_rate, fee"
+
+func (r *SQLVirtualWalletTransactionRepository) SaveTransaction(ctx context.Context, entity *VirtualWalletTransactionEntity) error {
+	// 没有 idempotencyKey 的流水保存为 NULL，不受唯一索引的约束
+	var idempotencyKey sql.NullString
+	if entity.idempotencyKey != "" {
+		idempotencyKey = sql.NullString{String: entity.idempotencyKey, Valid: true}
+	}
+
+	// 只有转账有入账金额
+	var toAmount sql.NullInt64
+	var toCurrency sql.NullString
+	if entity.transactionType == TRANSFER {
+		toAmount = sql.NullInt64{Int64: entity.toAmount.MinorUnits(), Valid: true}
+		toCurrency = sql.NullString{String: string(entity.toAmount.Currency()), Valid: true}
+	}
+
+	result, err := sqlstore.ExecutorFrom(ctx, r.db).ExecContext(ctx,
+		"INSERT INTO virtual_wallet_transaction (amount, currency, create_time, type, from_wallet_id, to_wallet_id, status, idempotency_key, fail_reason, "+
+			"to_amount, to_currency, exchange_rate, fee) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entity.amount, entity.amount.Currency(), entity.createTime, entity.transactionType, entity.fromWalletId, entity.toWalletId,
+		entity.status, idempotencyKey, entity.failReason, toAmount, toCurrency, entity.exchangeRate, entity.fee.MinorUnits())
+	if err != nil {
+		return err
+	}
+
+	entity.id, err = result.LastInsertId()
+	return err
+}
+
+func (r *SQLVirtualWalletTransactionRepository) GetTransactionByIdempotencyKey(ctx context.Context, key string) (*VirtualWalletTransactionEntity, error) {
+	return r.getTransaction(ctx, "SELECT "+transactionColumns+" FROM virtual_wallet_transaction WHERE idempotency_key = ?", key)
+}
+
+func (r *SQLVirtualWalletTransactionRepository) GetTransactionForUpdate(ctx context.Context, id int64) (*VirtualWalletTransactionEntity, error) {
+	return r.getTransaction(ctx, "SELECT "+transactionColumns+" FROM virtual_wallet_transaction WHERE id = ? FOR UPDATE", id)
+}
+
+func (r *SQLVirtualWalletTransactionRepository) UpdateTransactionStatus(ctx context.Context, id int64, status int, failReason string) error {
+	_, err := sqlstore.ExecutorFrom(ctx, r.db).ExecContext(ctx,
+		"UPDATE virtual_wallet_transaction SET status = ?, fail_reason = ? WHERE id = ? AND status = ?",
+		status, failReason, id, PENDING)
+	return err
+}
+
+func (r *SQLVirtualWalletTransactionRepository) QueryTransactions(ctx context.Context, filter TransactionFilter) ([]*VirtualWalletTransactionEntity, error) {
+	query := "SELECT " + transactionColumns + " FROM virtual_wallet_transaction WHERE (from_wallet_id = ? OR to_wallet_id = ?) AND status = ?"
+	args := []interface{}{filter.WalletId, filter.WalletId, SUCCEEDED}
+	if len(filter.Types) > 0 {
+		query += " AND type IN (?" + strings.Repeat(", ?", len(filter.Types)-1) + ")"
+		for _, transactionType := range filter.Types {
+			args = append(args, transactionType)
+		}
+	}
+	if !filter.Since.IsZero() {
+		query += " AND create_time >= ?"
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query += " AND create_time < ?"
+		args = append(args, filter.Until)
+	}
+	if filter.MinAmount != nil {
+		query += " AND currency = ? AND amount >= ?"
+		args = append(args, filter.MinAmount.Currency(), filter.MinAmount.MinorUnits())
+	}
+	if filter.MaxAmount != nil {
+		query += " AND currency = ? AND amount <= ?"
+		args = append(args, filter.MaxAmount.Currency(), filter.MaxAmount.MinorUnits())
+	}
+	if filter.BeforeId > 0 {
+		query += " AND id < ?"
+		args = append(args, filter.BeforeId)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := sqlstore.ExecutorFrom(ctx, r.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []*VirtualWalletTransactionEntity
+	for rows.Next() {
+		entity, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, entity)
+	}
+	return transactions, rows.Err()
+}
+
+// SumMovements 转账的入账金额和币种保存在 to_amount、to_currency 中，出账和入账的流水没有这两列
+func (r *SQLVirtualWalletTransactionRepository) SumMovements(ctx context.Context, walletId string, currency Currency,
+	afterId, beforeId int64) (Money, error) {
+	query := "SELECT COALESCE(SUM(CASE WHEN to_wallet_id = ? AND COALESCE(to_currency, currency) = ? THEN COALESCE(to_amount, amount) ELSE 0 END), 0) - " +
+		"COALESCE(SUM(CASE WHEN from_wallet_id = ? AND currency = ? THEN amount ELSE 0 END), 0) " +
+		"FROM virtual_wallet_transaction WHERE (from_wallet_id = ? OR to_wallet_id = ?) AND status = ? AND id > ?"
+	args := []interface{}{walletId, currency, walletId, currency, walletId, walletId, SUCCEEDED, afterId}
+	if beforeId > 0 {
+		query += " AND id < ?"
+		args = append(args, beforeId)
+	}
+	var sum int64
+	err := sqlstore.ExecutorFrom(ctx, r.db).QueryRowContext(ctx, query, args...).Scan(&sum)
+	if err != nil {
+		return Money{}, err
+	}
+	return NewMoney(sum, currency), nil
+}
+
+func (r *SQLVirtualWalletTransactionRepository) getTransaction(ctx context.Context, query string, arg interface{}) (*VirtualWalletTransactionEntity, error) {
+	entity, err := scanTransaction(sqlstore.ExecutorFrom(ctx, r.db).QueryRowContext(ctx, query, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %v", ErrTransactionNotFound, arg)
+	}
+	return entity, err
+}
+
+// rowScanner *sql.Row 和 *sql.Rows 的公共方法
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTransaction(row rowScanner) (*VirtualWalletTransactionEntity, error) {
+	entity := &VirtualWalletTransactionEntity{}
+	var amount, fee int64
+	var currency string
+	var idempotencyKey, toCurrency sql.NullString
+	var toAmount sql.NullInt64
+	err := row.Scan(&entity.id, &amount, &currency, &entity.createTime, &entity.transactionType,
+		&entity.fromWalletId, &entity.toWalletId, &entity.status, &idempotencyKey, &entity.failReason,
+		&toAmount, &toCurrency, &entity.exchangeRate, &fee)
+	if err != nil {
+		return nil, err
+	}
+	entity.amount = NewMoney(amount, Currency(currency))
+	entity.fee = NewMoney(fee, Currency(currency))
+	if entity.transactionType == TRANSFER {
+		// 支持多币种之前的转账没有入账金额，与出账金额相同
+		entity.toAmount = entity.amount
+		if toAmount.Valid {
+			entity.toAmount = NewMoney(toAmount.Int64, Currency(toCurrency.String))
+		}
+	}
+	entity.idempotencyKey = idempotencyKey.String
+	return entity, nil
+}
@@ -0,0 +1,104 @@
+package demo_wallet
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/promacanthus/design-patterns/internal/sqlstore/sqltest"
+)
+
+var (
+	selectWalletSQL  = regexp.QuoteMeta("SELECT id, currency, version, create_time FROM virtual_wallet WHERE id = ?")
+	selectBalanceSQL = regexp.QuoteMeta("SELECT currency, balance FROM virtual_wallet_balance WHERE wallet_id = ?")
+	updateWalletSQL  = regexp.QuoteMeta("UPDATE virtual_wallet SET version = version + 1, update_time = ? WHERE id = ? AND version = ?")
+	saveBalanceSQL   = regexp.QuoteMeta("INSERT INTO virtual_wallet_balance (wallet_id, currency, balance) VALUES (?, ?, ?)")
+	insertTxSQL      = regexp.QuoteMeta("INSERT INTO virtual_wallet_transaction")
+)
+
+var walletColumnNames = []string{"id", "currency", "version", "create_time"}
+
+// expectWallet 读取人民币钱包，余额以分为单位
+func expectWallet(mock sqlmock.Sqlmock, id string, balance int64, version int64) {
+	mock.ExpectQuery(selectWalletSQL).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(walletColumnNames).AddRow(id, "CNY", version, time.Now()))
+	mock.ExpectQuery(selectBalanceSQL).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "balance"}).AddRow("CNY", balance))
+}
+
+// expectUpdateBalance 版本号一致时更新余额
+func expectUpdateBalance(mock sqlmock.Sqlmock, id string, balance int64, version int64) {
+	mock.ExpectExec(updateWalletSQL).WithArgs(sqlmock.AnyArg(), id, version).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(saveBalanceSQL).WithArgs(id, "CNY", balance).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestMigrate(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	// 只执行最后一个还没有执行过的版本
+	sqltest.ExpectMigrate(mock, "schema_migrations", len(walletMigrations)-1, walletMigrations[len(walletMigrations)-1])
+
+	if applied, err := Migrate(context.Background(), db); applied != 1 || err != nil {
+		t.Errorf("Migrate() got = %v, %v, want 1", applied, err)
+	}
+}
+
+func TestVirtualWalletService_Debit(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	service := NewVirtualWalletService(NewSQLUnitOfWork(db), NewSQLVirtualWalletRepository(db), NewSQLVirtualWalletTransactionRepository(db))
+
+	// 交易流水和余额在同一个事务中提交
+	mock.ExpectBegin()
+	expectWallet(mock, "w1", 10000, 3)
+	mock.ExpectExec(insertTxSQL).WithArgs(int64(3000), "CNY", sqlmock.AnyArg(), DEBIT, "w1", "", SUCCEEDED, nil, "", nil, nil, "", 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUpdateBalance(mock, "w1", 7000, 3)
+	mock.ExpectCommit()
+
+	// 余额不足时回滚
+	mock.ExpectBegin()
+	expectWallet(mock, "w1", 7000, 4)
+	mock.ExpectRollback()
+
+	// 钱包不存在
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectWalletSQL).WithArgs("w2").WillReturnRows(sqlmock.NewRows(walletColumnNames))
+	mock.ExpectRollback()
+
+	ctx := context.Background()
+	if err := service.Debit(ctx, "w1", cny("30")); err != nil {
+		t.Fatalf("Debit() error = %v", err)
+	}
+	if err := service.Debit(ctx, "w1", cny("100")); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("Debit() error = %v, want %v", err, ErrInsufficientBalance)
+	}
+	if err := service.Debit(ctx, "w2", cny("1")); !errors.Is(err, ErrWalletNotFound) {
+		t.Errorf("Debit() error = %v, want %v", err, ErrWalletNotFound)
+	}
+}
+
+func TestDDDVirtualWalletService_CreditRetryOnConflict(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	service := NewDDDVirtualWalletService(NewSQLUnitOfWork(db), NewSQLVirtualWalletRepository(db), NewSQLVirtualWalletTransactionRepository(db))
+
+	// 第一次更新时版本号已经变化，回滚后重新读取钱包并重试
+	mock.ExpectBegin()
+	expectWallet(mock, "w1", 10000, 3)
+	mock.ExpectExec(insertTxSQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(updateWalletSQL).WithArgs(sqlmock.AnyArg(), "w1", 3).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectWallet(mock, "w1", 12000, 4)
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	expectWallet(mock, "w1", 12000, 4)
+	mock.ExpectExec(insertTxSQL).WithArgs(int64(5000), "CNY", sqlmock.AnyArg(), CREDIT, "", "w1", SUCCEEDED, nil, "", nil, nil, "", 0).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	expectUpdateBalance(mock, "w1", 17000, 4)
+	mock.ExpectCommit()
+
+	if err := service.Credit(context.Background(), "w1", cny("50")); err != nil {
+		t.Fatalf("Credit() error = %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package demo_wallet
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 )
 
@@ -10,132 +13,663 @@ import (
 // 将原来在 Service 类中的部分业务逻辑移动到 VirtualWallet 类中，
 // 让 Service 类的实现依赖 VirtualWallet 类。
 
+var (
+	// ErrInsufficientBalance 可用余额不足
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	// ErrInvalidAmount 出账、入账、冻结、调整透支额度的金额必须大于 0
+	ErrInvalidAmount  = errors.New("amount should be positive")
+	ErrFreezeNotFound = errors.New("freeze not found")
+	ErrInvalidFreeze  = errors.New("invalid freeze")
+	// ErrUnfreezeExceedsFrozen 解冻的金额超过了这笔冻结剩余的金额
+	ErrUnfreezeExceedsFrozen = errors.New("unfreeze amount exceeds frozen amount")
+	// ErrOverdraftInDebt 透支额度正在使用中，不能关闭透支或者把额度调低到已经透支的金额以下
+	ErrOverdraftInDebt = errors.New("overdraft in debt")
+	// ErrLedgerRequired 冻结和透支的状态只保存在账本中，没有配置账本的 DDDVirtualWalletService 不支持这些操作
+	ErrLedgerRequired = errors.New("wallet ledger required")
+)
+
 // VirtualWallet Domain 领域模型（充血模型），功能简单的时候，看起来来很淡薄。
 // 增加一些复杂的功能是，优势就明显了，如增加透支和冻结功能。
 // 功能继续演进，如增加更细化的冻结策略，透支策略，支持钱包账户ID自动生成逻辑（分布式 ID 生成算法）等，
 // 那么就值得设计为充血模型，优势就更加明显了。
+//
+// 钱包可以持有多个币种的余额，每个币种分别计算可用余额，透支只适用于主币种。
+// 不变式：可用余额 = 余额 - 冻结金额 (+ 透支额度)，任何操作之后可用余额都不能小于 0。
 type VirtualWallet struct {
 	id         string
 	createTime time.Time
-	balance    float64
+	// currency 主币种，透支额度使用主币种
+	currency Currency
+	balances map[Currency]Money
 
 	// 增加透支和冻结功能
 	isAllowedOverdraft bool
-	overdraftAmount    float64
-	frozenAmount       float64
+	overdraftAmount    Money
+	// frozenAmounts 各币种冻结中的金额，是 freezes 按照币种的合计
+	frozenAmounts map[Currency]Money
+	freezes       map[string]*FreezeRecord
+
+	// version 从存储中加载时的版本号，保存时用于乐观锁
+	version int64
+	// sequence 已经应用的领域事件的数量，事件溯源模式下追加事件时用于乐观锁
+	sequence int64
+
+	events []DomainEvent
+	now    func() time.Time
 }
 
-func NewVirtualWallet(preAllocatedId string) *VirtualWallet {
+// FreezeRecord 一笔冻结，如下单时冻结订单金额，支付或者取消订单时按照 Id 解冻
+type FreezeRecord struct {
+	Id         string    `json:"id"`
+	Amount     Money     `json:"amount"`
+	Reason     string    `json:"reason"`
+	CreateTime time.Time `json:"createTime"`
+	// ExpireTime 到期后由 ReleaseExpiredFreezes 自动解冻，零值表示永不过期
+	ExpireTime time.Time `json:"expireTime"`
+}
+
+func (r FreezeRecord) expired(now time.Time) bool {
+	return !r.ExpireTime.IsZero() && !now.Before(r.ExpireTime)
+}
+
+// newEmptyVirtualWallet 还没有任何状态的钱包，由 WalletOpened 事件或者快照初始化
+func newEmptyVirtualWallet() *VirtualWallet {
 	return &VirtualWallet{
-		id:              preAllocatedId,
-		balance:         0,
-		overdraftAmount: 0,
-		frozenAmount:    0}
+		balances:      make(map[Currency]Money),
+		frozenAmounts: make(map[Currency]Money),
+		freezes:       make(map[string]*FreezeRecord),
+		now:           time.Now}
+}
+
+func NewVirtualWallet(preAllocatedId string, currency Currency) *VirtualWallet {
+	w := newEmptyVirtualWallet()
+	w.mustRaise(WalletOpened{EventHeader: EventHeader{WalletId: preAllocatedId, OccurredAt: w.now()}, Currency: currency})
+	return w
 }
 
 // 增加透支和冻结功能
 
-func (w *VirtualWallet) Freeze(amount float64) {}
+// Freeze 冻结一笔金额，冻结的金额不能超过可用余额，freezeId 由调用方预先分配，用于之后解冻
+func (w *VirtualWallet) Freeze(freezeId string, amount Money, reason string, expireTime time.Time) error {
+	now := w.now()
+	switch {
+	case freezeId == "":
+		return fmt.Errorf("%w: freeze id is required", ErrInvalidFreeze)
+	case w.freezes[freezeId] != nil:
+		return fmt.Errorf("%w: freeze %s already exists", ErrInvalidFreeze, freezeId)
+	case !expireTime.IsZero() && !expireTime.After(now):
+		return fmt.Errorf("%w: freeze %s expires in the past", ErrInvalidFreeze, freezeId)
+	}
+	if err := w.ensureAvailable(amount); err != nil {
+		return err
+	}
+	return w.raise(FundsFrozen{EventHeader: w.header(now), FreezeId: freezeId, Amount: amount, Reason: reason, ExpireTime: expireTime})
+}
 
-func (w *VirtualWallet) UnFreeze(amount float64) {}
+// UnFreeze 解冻 freezeId 对应的冻结中的 amount，全部解冻后删除这笔冻结
+func (w *VirtualWallet) UnFreeze(freezeId string, amount Money) error {
+	freeze, ok := w.freezes[freezeId]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrFreezeNotFound, freezeId)
+	}
+	if !amount.IsPositive() {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
+	}
+	if cmp, err := amount.Cmp(freeze.Amount); err != nil {
+		return err
+	} else if cmp > 0 {
+		return fmt.Errorf("%w: unfreeze %s from freeze %s of %s", ErrUnfreezeExceedsFrozen, amount, freezeId, freeze.Amount)
+	}
+	return w.raise(FundsUnfrozen{EventHeader: w.header(w.now()), FreezeId: freezeId, Amount: amount})
+}
 
-func (w *VirtualWallet) IncreaseOverdraftAmount(amount float64) {}
+// ReleaseExpiredFreezes 解冻所有已经到期的冻结，返回解冻的冻结
+func (w *VirtualWallet) ReleaseExpiredFreezes() ([]FreezeRecord, error) {
+	now := w.now()
+	var released []FreezeRecord
+	for _, freeze := range w.Freezes() {
+		if !freeze.expired(now) {
+			continue
+		}
+		event := FundsUnfrozen{EventHeader: w.header(now), FreezeId: freeze.Id, Amount: freeze.Amount, Expired: true}
+		if err := w.raise(event); err != nil {
+			return released, err
+		}
+		released = append(released, freeze)
+	}
+	return released, nil
+}
 
-func (w *VirtualWallet) DecreaseOverdraftAmount(amount float64) {}
+// Freezes 冻结中的金额，按照冻结的时间排序
+func (w *VirtualWallet) Freezes() []FreezeRecord {
+	freezes := make([]FreezeRecord, 0, len(w.freezes))
+	for _, freeze := range w.freezes {
+		freezes = append(freezes, *freeze)
+	}
+	sort.Slice(freezes, func(i, j int) bool {
+		if !freezes[i].CreateTime.Equal(freezes[j].CreateTime) {
+			return freezes[i].CreateTime.Before(freezes[j].CreateTime)
+		}
+		return freezes[i].Id < freezes[j].Id
+	})
+	return freezes
+}
 
-func (w *VirtualWallet) CloseOverdraft() {}
+// FrozenAmount 主币种冻结中的金额
+func (w *VirtualWallet) FrozenAmount() Money {
+	return w.FrozenAmountIn(w.currency)
+}
 
-func (w *VirtualWallet) OpenOverdraft() {}
+func (w *VirtualWallet) FrozenAmountIn(currency Currency) Money {
+	if frozen, ok := w.frozenAmounts[currency]; ok {
+		return frozen
+	}
+	return NewMoney(0, currency)
+}
 
-func (w *VirtualWallet) Balance() float64 {
-	return w.balance
+func (w *VirtualWallet) IncreaseOverdraftAmount(amount Money) error {
+	if !amount.IsPositive() {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
+	}
+	limit, err := w.overdraftAmount.Add(amount)
+	if err != nil {
+		return err
+	}
+	return w.raise(OverdraftLimitChanged{EventHeader: w.header(w.now()), Limit: limit})
 }
 
-func (w *VirtualWallet) GetAvailableAmount() float64 {
-	totalAvailableBalance := w.balance - w.frozenAmount
+// DecreaseOverdraftAmount 调低透支额度，透支开启时额度不能低于已经透支的金额
+func (w *VirtualWallet) DecreaseOverdraftAmount(amount Money) error {
+	if !amount.IsPositive() {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
+	}
+	limit, err := w.overdraftAmount.Sub(amount)
+	if err != nil {
+		return err
+	}
+	if limit.IsNegative() {
+		return fmt.Errorf("%w: decrease %s exceeds overdraft limit %s", ErrInvalidAmount, amount, w.overdraftAmount)
+	}
 	if w.isAllowedOverdraft {
-		totalAvailableBalance += w.overdraftAmount
+		if err := w.ensureCovered(limit); err != nil {
+			return err
+		}
+	}
+	return w.raise(OverdraftLimitChanged{EventHeader: w.header(w.now()), Limit: limit})
+}
+
+// CloseOverdraft 关闭透支，正在透支（余额不足以覆盖冻结的金额）时不能关闭
+func (w *VirtualWallet) CloseOverdraft() error {
+	if !w.isAllowedOverdraft {
+		return nil
+	}
+	if err := w.ensureCovered(NewMoney(0, w.currency)); err != nil {
+		return err
+	}
+	return w.raise(OverdraftClosed{EventHeader: w.header(w.now())})
+}
+
+func (w *VirtualWallet) OpenOverdraft() {
+	if w.isAllowedOverdraft {
+		return
+	}
+	w.mustRaise(OverdraftOpened{EventHeader: w.header(w.now())})
+}
+
+func (w *VirtualWallet) IsAllowedOverdraft() bool {
+	return w.isAllowedOverdraft
+}
+
+func (w *VirtualWallet) OverdraftAmount() Money {
+	return w.overdraftAmount
+}
+
+// ensureCovered 透支额度调整为 limit 之后，主币种的可用余额不能小于 0
+func (w *VirtualWallet) ensureCovered(limit Money) error {
+	available, err := w.Balance().Sub(w.FrozenAmount())
+	if err != nil {
+		return err
+	}
+	if available, err = available.Add(limit); err != nil {
+		return err
+	}
+	if available.IsNegative() {
+		return fmt.Errorf("%w: wallet %s overdrawn by %s", ErrOverdraftInDebt, w.id, available.Neg())
+	}
+	return nil
+}
+
+// ensureAvailable amount 必须大于 0 且不能超过对应币种的可用余额
+func (w *VirtualWallet) ensureAvailable(amount Money) error {
+	if !amount.IsPositive() {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
+	}
+	availableAmount, err := w.GetAvailableAmountIn(amount.Currency())
+	if err != nil {
+		return err
+	}
+	if remaining, err := availableAmount.Sub(amount); err != nil {
+		return err
+	} else if remaining.IsNegative() {
+		return ErrInsufficientBalance
+	}
+	return nil
+}
+
+// Balance 主币种的余额
+func (w *VirtualWallet) Balance() Money {
+	return w.BalanceIn(w.currency)
+}
+
+func (w *VirtualWallet) BalanceIn(currency Currency) Money {
+	if balance, ok := w.balances[currency]; ok {
+		return balance
+	}
+	return NewMoney(0, currency)
+}
+
+// Balances 所有币种的余额，按照币种排序
+func (w *VirtualWallet) Balances() []Money {
+	balances := make([]Money, 0, len(w.balances))
+	for _, balance := range w.balances {
+		balances = append(balances, balance)
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Currency() < balances[j].Currency()
+	})
+	return balances
+}
+
+// GetAvailableAmount 主币种的可用余额
+func (w *VirtualWallet) GetAvailableAmount() (Money, error) {
+	return w.GetAvailableAmountIn(w.currency)
+}
+
+func (w *VirtualWallet) GetAvailableAmountIn(currency Currency) (Money, error) {
+	totalAvailableBalance, err := w.BalanceIn(currency).Sub(w.FrozenAmountIn(currency))
+	if err != nil {
+		return Money{}, err
+	}
+	if w.isAllowedOverdraft && currency == w.currency {
+		return totalAvailableBalance.Add(w.overdraftAmount)
+	}
+	return totalAvailableBalance, nil
+}
+
+func (w *VirtualWallet) Debit(amount Money) error {
+	if err := w.ensureAvailable(amount); err != nil {
+		return err
+	}
+
+	balance, err := w.BalanceIn(amount.Currency()).Sub(amount)
+	if err != nil {
+		return err
 	}
-	return totalAvailableBalance
+	return w.raise(WalletDebited{EventHeader: w.header(w.now()), Amount: amount, Balance: balance})
 }
 
-func (w *VirtualWallet) Debit(amount float64) error {
-	availableAmount := w.GetAvailableAmount()
-	if availableAmount-amount < 0 {
-		return errors.New("insufficient balance")
+// Credit 入账只需要校验入账的金额，透支中的钱包也可以入账
+func (w *VirtualWallet) Credit(amount Money) error {
+	if !amount.IsPositive() {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
 	}
 
-	w.balance -= amount
+	balance, err := w.BalanceIn(amount.Currency()).Add(amount)
+	if err != nil {
+		return err
+	}
+	return w.raise(WalletCredited{EventHeader: w.header(w.now()), Amount: amount, Balance: balance})
+}
+
+func (w *VirtualWallet) header(now time.Time) EventHeader {
+	return EventHeader{WalletId: w.id, OccurredAt: now}
+}
+
+// raise 命令方法校验完业务规则之后产生事件，应用到钱包的状态上，并记录下来等待调用方取出
+func (w *VirtualWallet) raise(event DomainEvent) error {
+	if err := w.apply(event); err != nil {
+		return err
+	}
+	w.events = append(w.events, event)
 	return nil
 }
 
-func (w *VirtualWallet) Credit(amount float64) error {
-	availableAmount := w.GetAvailableAmount()
-	if availableAmount < 0 {
-		return errors.New("invalid amount")
+// mustRaise 用于不会应用失败的事件
+func (w *VirtualWallet) mustRaise(event DomainEvent) {
+	if err := w.raise(event); err != nil {
+		panic(err)
 	}
+}
 
-	w.balance += amount
+// apply 根据事件修改钱包的状态，这是修改状态的唯一入口，重放事件时也调用 apply，
+// 所以余额由事件中的金额重新计算，而不是直接使用事件中记录的余额
+func (w *VirtualWallet) apply(event DomainEvent) error {
+	switch e := event.(type) {
+	case WalletOpened:
+		w.id, w.currency, w.createTime = e.WalletId, e.Currency, e.OccurredAt
+		w.overdraftAmount = NewMoney(0, e.Currency)
+	case WalletDebited:
+		balance, err := w.BalanceIn(e.Amount.Currency()).Sub(e.Amount)
+		if err != nil {
+			return err
+		}
+		w.balances[balance.Currency()] = balance
+	case WalletCredited:
+		balance, err := w.BalanceIn(e.Amount.Currency()).Add(e.Amount)
+		if err != nil {
+			return err
+		}
+		w.balances[balance.Currency()] = balance
+	case FundsFrozen:
+		frozenAmount, err := w.FrozenAmountIn(e.Amount.Currency()).Add(e.Amount)
+		if err != nil {
+			return err
+		}
+		w.frozenAmounts[frozenAmount.Currency()] = frozenAmount
+		w.freezes[e.FreezeId] = &FreezeRecord{Id: e.FreezeId, Amount: e.Amount, Reason: e.Reason,
+			CreateTime: e.OccurredAt, ExpireTime: e.ExpireTime}
+	case FundsUnfrozen:
+		freeze, ok := w.freezes[e.FreezeId]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrFreezeNotFound, e.FreezeId)
+		}
+		remaining, err := freeze.Amount.Sub(e.Amount)
+		if err != nil {
+			return err
+		}
+		frozenAmount, err := w.FrozenAmountIn(e.Amount.Currency()).Sub(e.Amount)
+		if err != nil {
+			return err
+		}
+		w.frozenAmounts[frozenAmount.Currency()] = frozenAmount
+		if remaining.IsZero() {
+			delete(w.freezes, e.FreezeId)
+		} else {
+			freeze.Amount = remaining
+		}
+	case OverdraftOpened:
+		w.isAllowedOverdraft = true
+	case OverdraftClosed:
+		w.isAllowedOverdraft = false
+	case OverdraftLimitChanged:
+		w.overdraftAmount = e.Limit
+	default:
+		return fmt.Errorf("unknown wallet event %T", event)
+	}
+	w.sequence++
 	return nil
 }
 
+// PullEvents 取出上次取出之后记录的领域事件
+func (w *VirtualWallet) PullEvents() []DomainEvent {
+	events := w.events
+	w.events = nil
+	return events
+}
+
 type DDDVirtualWalletService struct {
+	uow             UnitOfWork
 	walletRepo      VirtualWalletRepository
 	transactionRepo VirtualWalletTransactionRepository
+	options         serviceOptions
 }
 
-func (s *DDDVirtualWalletService) getVirtualWallet(walletId string) *VirtualWallet {
-	walletEntity := s.walletRepo.GetWalletEntity(walletId)
-	wallet := convert(walletEntity)
-	return wallet
+func NewDDDVirtualWalletService(uow UnitOfWork, walletRepo VirtualWalletRepository,
+	transactionRepo VirtualWalletTransactionRepository, opts ...ServiceOption) *DDDVirtualWalletService {
+	return &DDDVirtualWalletService{uow: uow, walletRepo: walletRepo, transactionRepo: transactionRepo,
+		options: newServiceOptions(opts)}
 }
 
-func (s *DDDVirtualWalletService) GetBalance(walletId string) float64 {
-	return s.walletRepo.GetBalance(walletId)
+func (s *DDDVirtualWalletService) getVirtualWallet(ctx context.Context, walletId string) (*VirtualWallet, error) {
+	walletEntity, err := s.walletRepo.GetWalletEntity(ctx, walletId)
+	if err != nil {
+		return nil, err
+	}
+	return s.loadVirtualWallet(ctx, walletEntity)
 }
 
-func (s *DDDVirtualWalletService) Debit(walletId string, amount float64) error {
-	walletEntity := s.walletRepo.GetWalletEntity(walletId)
-	wallet := convert(walletEntity)
-	if err := wallet.Debit(amount); err != nil {
-		return err
+// loadVirtualWallet 配置了账本时从账本中加载钱包，版本号仍然使用 walletEntity 的版本号；
+// 没有配置账本时冻结和透支的操作返回 ErrLedgerRequired，钱包只有余额，从 walletEntity 重建不会丢失状态
+func (s *DDDVirtualWalletService) loadVirtualWallet(ctx context.Context, walletEntity *VirtualWalletEntity) (*VirtualWallet, error) {
+	if s.options.ledger == nil {
+		return walletEntityToWallet(walletEntity), nil
+	}
+	wallet, err := s.options.ledger.Load(ctx, walletEntity.GetId())
+	if errors.Is(err, ErrWalletNotFound) {
+		wallet, err = importWallet(walletEntity, time.Now()), nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	wallet.version = walletEntity.GetVersion()
+	return wallet, nil
+}
 
-	transactionEntity := NewVirtualWalletTransactionEntity()
-	transactionEntity.SetAmount(amount)
-	transactionEntity.SetCreateTime(time.Now())
-	transactionEntity.SetType(DEBIT)
-	transactionEntity.SetFromWalletId(walletId)
-	s.transactionRepo.SaveTransaction(transactionEntity)
+// saveVirtualWallet 把钱包的事件追加到账本中，没有配置账本时丢弃事件
+func (s *DDDVirtualWalletService) saveVirtualWallet(ctx context.Context, wallet *VirtualWallet) error {
+	if s.options.ledger == nil {
+		wallet.PullEvents()
+		return nil
+	}
+	return s.options.ledger.Save(ctx, wallet)
+}
 
-	s.walletRepo.UpdateBalance(walletId, wallet.Balance())
+// GetVirtualWallet 配置了账本时返回账本中的余额
+func (s *DDDVirtualWalletService) GetVirtualWallet(ctx context.Context, walletId string) (*VirtualWalletBo, error) {
+	wallet, err := s.getVirtualWallet(ctx, walletId)
+	if err != nil {
+		return nil, err
+	}
+	return mapper.WalletToBo(wallet), nil
+}
 
-	return nil
+func (s *DDDVirtualWalletService) GetBalance(ctx context.Context, walletId string) (Money, error) {
+	return s.walletRepo.GetBalance(ctx, walletId)
 }
 
-func (s *DDDVirtualWalletService) Credit(walletId string, amount float64) error {
-	walletEntity := s.walletRepo.GetWalletEntity(walletId)
-	wallet := convert(walletEntity)
-	if err := wallet.Credit(amount); err != nil {
-		return err
+// GetTotalBalance 所有币种的余额按照汇率折算为 base 币种的合计
+func (s *DDDVirtualWalletService) GetTotalBalance(ctx context.Context, walletId string, base Currency) (Money, error) {
+	return s.options.totalBalance(ctx, s.walletRepo, walletId, base)
+}
+
+func (s *DDDVirtualWalletService) Debit(ctx context.Context, walletId string, amount Money) error {
+	_, err := s.DebitWithKey(ctx, "", walletId, amount)
+	return err
+}
+
+// DebitWithKey 与 VirtualWalletService.DebitWithKey 的幂等规则相同，余额是否足够由 VirtualWallet.Debit 判断
+func (s *DDDVirtualWalletService) DebitWithKey(ctx context.Context, idempotencyKey, walletId string,
+	amount Money) (*VirtualWalletTransactionEntity, error) {
+	var transactionEntity *VirtualWalletTransactionEntity
+	err := retryOnConflict(ctx, s.uow, func(ctx context.Context) error {
+		var err error
+		if transactionEntity, err = findIdempotent(ctx, s.transactionRepo, idempotencyKey, DEBIT, walletId, amount); transactionEntity != nil || err != nil {
+			return err
+		}
+
+		wallet, err := s.getVirtualWallet(ctx, walletId)
+		if err != nil {
+			return err
+		}
+		if err := wallet.Debit(amount); err != nil {
+			return err
+		}
+
+		transactionEntity = NewVirtualWalletTransactionEntity()
+		transactionEntity.SetAmount(amount)
+		transactionEntity.SetCreateTime(time.Now())
+		transactionEntity.SetType(DEBIT)
+		transactionEntity.SetFromWalletId(walletId)
+		transactionEntity.SetIdempotencyKey(idempotencyKey)
+		if err := saveIdempotent(ctx, s.transactionRepo, transactionEntity); err != nil {
+			return err
+		}
+		if err := s.saveVirtualWallet(ctx, wallet); err != nil {
+			return err
+		}
+
+		balance := wallet.BalanceIn(amount.Currency())
+		if err := s.walletRepo.UpdateBalance(ctx, walletId, balance, wallet.version); err != nil {
+			return err
+		}
+		return addToOutbox(ctx, s.options.outbox, newWalletMessage(transactionEntity, walletId, balance))
+	})
+	if err != nil {
+		return findConcurrentIdempotent(ctx, s.transactionRepo, err, idempotencyKey, DEBIT, walletId, amount)
 	}
+	return transactionEntity, nil
+}
+
+func (s *DDDVirtualWalletService) Credit(ctx context.Context, walletId string, amount Money) error {
+	_, err := s.CreditWithKey(ctx, "", walletId, amount)
+	return err
+}
 
-	transactionEntity := NewVirtualWalletTransactionEntity()
-	transactionEntity.SetAmount(amount)
-	transactionEntity.SetCreateTime(time.Now())
-	transactionEntity.SetType(DEBIT)
-	transactionEntity.SetFromWalletId(walletId)
-	s.transactionRepo.SaveTransaction(transactionEntity)
+// CreditWithKey 与 VirtualWalletService.CreditWithKey 的幂等规则相同
+func (s *DDDVirtualWalletService) CreditWithKey(ctx context.Context, idempotencyKey, walletId string,
+	amount Money) (*VirtualWalletTransactionEntity, error) {
+	var transactionEntity *VirtualWalletTransactionEntity
+	err := retryOnConflict(ctx, s.uow, func(ctx context.Context) error {
+		var err error
+		if transactionEntity, err = findIdempotent(ctx, s.transactionRepo, idempotencyKey, CREDIT, walletId, amount); transactionEntity != nil || err != nil {
+			return err
+		}
 
-	s.walletRepo.UpdateBalance(walletId, wallet.Balance())
+		wallet, err := s.getVirtualWallet(ctx, walletId)
+		if err != nil {
+			return err
+		}
+		if err := wallet.Credit(amount); err != nil {
+			return err
+		}
 
-	return nil
+		transactionEntity = NewVirtualWalletTransactionEntity()
+		transactionEntity.SetAmount(amount)
+		transactionEntity.SetCreateTime(time.Now())
+		transactionEntity.SetType(CREDIT)
+		transactionEntity.SetToWalletId(walletId)
+		transactionEntity.SetIdempotencyKey(idempotencyKey)
+		if err := saveIdempotent(ctx, s.transactionRepo, transactionEntity); err != nil {
+			return err
+		}
+		if err := s.saveVirtualWallet(ctx, wallet); err != nil {
+			return err
+		}
+
+		balance := wallet.BalanceIn(amount.Currency())
+		if err := s.walletRepo.UpdateBalance(ctx, walletId, balance, wallet.version); err != nil {
+			return err
+		}
+		return addToOutbox(ctx, s.options.outbox, newWalletMessage(transactionEntity, walletId, balance))
+	})
+	if err != nil {
+		return findConcurrentIdempotent(ctx, s.transactionRepo, err, idempotencyKey, CREDIT, walletId, amount)
+	}
+	return transactionEntity, nil
+}
+
+// Transfer 转账涉及两个钱包，这部分业务逻辑无法放到 VirtualWallet 中，由 Service 负责；
+// 幂等、事务、加锁的流程与基于贫血模型的传统开发模式一样，见 transferRunner。
+func (s *DDDVirtualWalletService) Transfer(ctx context.Context, idempotencyKey, fromWalletId, toWalletId string,
+	amount Money) (*VirtualWalletTransactionEntity, error) {
+	return s.ExchangeTransfer(ctx, idempotencyKey, fromWalletId, toWalletId, amount, amount.Currency())
+}
+
+// ExchangeTransfer 跨币种转账，出账 amount，按照汇率扣除手续费后以 toCurrency 入账
+func (s *DDDVirtualWalletService) ExchangeTransfer(ctx context.Context, idempotencyKey, fromWalletId, toWalletId string,
+	amount Money, toCurrency Currency) (*VirtualWalletTransactionEntity, error) {
+	transfer := newTransferRunner(s.uow, s.walletRepo, s.transactionRepo, s.options)
+	return transfer.run(ctx, idempotencyKey, fromWalletId, toWalletId, amount, toCurrency,
+		func(ctx context.Context, fromEntity, toEntity *VirtualWalletEntity, debit, credit Money) (Money, Money, error) {
+			from, err := s.loadVirtualWallet(ctx, fromEntity)
+			if err != nil {
+				return Money{}, Money{}, err
+			}
+			to, err := s.loadVirtualWallet(ctx, toEntity)
+			if err != nil {
+				return Money{}, Money{}, err
+			}
+			if err := from.Debit(debit); err != nil {
+				return Money{}, Money{}, err
+			}
+			if err := to.Credit(credit); err != nil {
+				return Money{}, Money{}, err
+			}
+			if err := s.saveVirtualWallet(ctx, from); err != nil {
+				return Money{}, Money{}, err
+			}
+			if err := s.saveVirtualWallet(ctx, to); err != nil {
+				return Money{}, Money{}, err
+			}
+			return from.BalanceIn(debit.Currency()), to.BalanceIn(credit.Currency()), nil
+		})
+}
+
+// modifyWallet 加载钱包，执行 fn 修改钱包后把事件追加到账本中；只修改冻结和透支，不影响余额，
+// 与出账入账并发时由账本的序号检查冲突并重试
+func (s *DDDVirtualWalletService) modifyWallet(ctx context.Context, walletId string, fn func(wallet *VirtualWallet) error) error {
+	if s.options.ledger == nil {
+		return ErrLedgerRequired
+	}
+	return retryOnConflict(ctx, s.uow, func(ctx context.Context) error {
+		wallet, err := s.getVirtualWallet(ctx, walletId)
+		if err != nil {
+			return err
+		}
+		if err := fn(wallet); err != nil {
+			return err
+		}
+		return s.saveVirtualWallet(ctx, wallet)
+	})
+}
+
+// Freeze 见 VirtualWallet.Freeze，需要配置账本
+func (s *DDDVirtualWalletService) Freeze(ctx context.Context, walletId, freezeId string, amount Money, reason string,
+	expireTime time.Time) error {
+	return s.modifyWallet(ctx, walletId, func(wallet *VirtualWallet) error {
+		return wallet.Freeze(freezeId, amount, reason, expireTime)
+	})
+}
+
+func (s *DDDVirtualWalletService) UnFreeze(ctx context.Context, walletId, freezeId string, amount Money) error {
+	return s.modifyWallet(ctx, walletId, func(wallet *VirtualWallet) error {
+		return wallet.UnFreeze(freezeId, amount)
+	})
+}
+
+// ReleaseExpiredFreezes 解冻钱包所有已经到期的冻结，返回解冻的冻结
+func (s *DDDVirtualWalletService) ReleaseExpiredFreezes(ctx context.Context, walletId string) ([]FreezeRecord, error) {
+	var released []FreezeRecord
+	err := s.modifyWallet(ctx, walletId, func(wallet *VirtualWallet) error {
+		var err error
+		released, err = wallet.ReleaseExpiredFreezes()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return released, nil
+}
+
+func (s *DDDVirtualWalletService) OpenOverdraft(ctx context.Context, walletId string) error {
+	return s.modifyWallet(ctx, walletId, func(wallet *VirtualWallet) error {
+		wallet.OpenOverdraft()
+		return nil
+	})
+}
+
+func (s *DDDVirtualWalletService) CloseOverdraft(ctx context.Context, walletId string) error {
+	return s.modifyWallet(ctx, walletId, (*VirtualWallet).CloseOverdraft)
 }
 
-func (s *DDDVirtualWalletService) Transfer() {
-	// 与基于贫血模型的传统开发模式，代码一样
+func (s *DDDVirtualWalletService) IncreaseOverdraftAmount(ctx context.Context, walletId string, amount Money) error {
+	return s.modifyWallet(ctx, walletId, func(wallet *VirtualWallet) error {
+		return wallet.IncreaseOverdraftAmount(amount)
+	})
 }
 
-func convert(entity *VirtualWalletEntity) *VirtualWallet {
-	return &VirtualWallet{}
+func (s *DDDVirtualWalletService) DecreaseOverdraftAmount(ctx context.Context, walletId string, amount Money) error {
+	return s.modifyWallet(ctx, walletId, func(wallet *VirtualWallet) error {
+		return wallet.DecreaseOverdraftAmount(amount)
+	})
 }
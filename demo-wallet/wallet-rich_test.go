@@ -0,0 +1,282 @@
+package demo_wallet
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestWallet 余额为 balance 的钱包，时钟固定在 now，之后可以修改 *now 模拟时间流逝
+func newTestWallet(t *testing.T, balance string) (*VirtualWallet, *time.Time) {
+	t.Helper()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	wallet := NewVirtualWallet("w1", CNY)
+	wallet.now = func() time.Time { return now }
+	if balance != "0" {
+		if err := wallet.Credit(cny(balance)); err != nil {
+			t.Fatalf("Credit() error = %v", err)
+		}
+	}
+	wallet.PullEvents()
+	return wallet, &now
+}
+
+func assertAvailable(t *testing.T, wallet *VirtualWallet, want string) {
+	t.Helper()
+	if got, err := wallet.GetAvailableAmount(); err != nil || got != cny(want) {
+		t.Errorf("GetAvailableAmount() got = %v, %v, want %s", got, err, want)
+	}
+}
+
+func TestVirtualWallet_Credit(t *testing.T) {
+	wallet, now := newTestWallet(t, "0")
+	for _, amount := range []Money{cny("0"), cny("-1")} {
+		if err := wallet.Credit(amount); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("Credit(%v) error = %v, want %v", amount, err, ErrInvalidAmount)
+		}
+	}
+
+	// 其他币种的余额单独计算
+	if err := wallet.Credit(MustParseMoney("1", USD)); err != nil {
+		t.Fatalf("Credit() error = %v", err)
+	}
+	if wallet.BalanceIn(USD) != MustParseMoney("1", USD) || wallet.Balance() != cny("0") {
+		t.Errorf("Balances() = %v", wallet.Balances())
+	}
+
+	// 透支中的钱包也可以入账
+	wallet.OpenOverdraft()
+	if err := wallet.IncreaseOverdraftAmount(cny("50")); err != nil {
+		t.Fatalf("IncreaseOverdraftAmount() error = %v", err)
+	}
+	if err := wallet.Debit(cny("30")); err != nil {
+		t.Fatalf("Debit() error = %v", err)
+	}
+	if err := wallet.Credit(cny("10")); err != nil || wallet.Balance() != cny("-20") {
+		t.Errorf("Credit() balance = %v, %v, want -20", wallet.Balance(), err)
+	}
+
+	events := wallet.PullEvents()
+	want := WalletCredited{EventHeader: EventHeader{WalletId: "w1", OccurredAt: *now}, Amount: cny("10"), Balance: cny("-20")}
+	if len(events) != 5 || events[4] != want {
+		t.Errorf("PullEvents() got = %+v, want last %+v", events, want)
+	}
+	if events := wallet.PullEvents(); len(events) != 0 {
+		t.Errorf("PullEvents() again got = %+v, want empty", events)
+	}
+}
+
+func TestVirtualWallet_Freeze(t *testing.T) {
+	wallet, now := newTestWallet(t, "100")
+
+	if err := wallet.Freeze("order-1", cny("30"), "order", time.Time{}); err != nil {
+		t.Fatalf("Freeze() error = %v", err)
+	}
+	if err := wallet.Freeze("order-2", cny("50"), "order", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Freeze() error = %v", err)
+	}
+	assertAvailable(t, wallet, "20")
+
+	invalid := []struct {
+		freezeId string
+		amount   Money
+		expire   time.Time
+		want     error
+	}{
+		{"order-3", cny("20.01"), time.Time{}, ErrInsufficientBalance},
+		{"order-3", cny("0"), time.Time{}, ErrInvalidAmount},
+		{"order-1", cny("1"), time.Time{}, ErrInvalidFreeze},
+		{"", cny("1"), time.Time{}, ErrInvalidFreeze},
+		{"order-3", cny("1"), now.Add(-time.Second), ErrInvalidFreeze},
+	}
+	for _, tt := range invalid {
+		if err := wallet.Freeze(tt.freezeId, tt.amount, "order", tt.expire); !errors.Is(err, tt.want) {
+			t.Errorf("Freeze(%s, %v) error = %v, want %v", tt.freezeId, tt.amount, err, tt.want)
+		}
+	}
+	// 冻结的金额不能出账
+	if err := wallet.Debit(cny("20.01")); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("Debit() error = %v, want %v", err, ErrInsufficientBalance)
+	}
+
+	if err := wallet.UnFreeze("order-1", cny("30.01")); !errors.Is(err, ErrUnfreezeExceedsFrozen) {
+		t.Errorf("UnFreeze() error = %v, want %v", err, ErrUnfreezeExceedsFrozen)
+	}
+	if err := wallet.UnFreeze("order-9", cny("1")); !errors.Is(err, ErrFreezeNotFound) {
+		t.Errorf("UnFreeze() error = %v, want %v", err, ErrFreezeNotFound)
+	}
+	if err := wallet.UnFreeze("order-1", cny("10")); err != nil {
+		t.Fatalf("UnFreeze() error = %v", err)
+	}
+	if err := wallet.UnFreeze("order-1", cny("20")); err != nil {
+		t.Fatalf("UnFreeze() error = %v", err)
+	}
+	if err := wallet.UnFreeze("order-1", cny("1")); !errors.Is(err, ErrFreezeNotFound) {
+		t.Errorf("UnFreeze() fully released error = %v, want %v", err, ErrFreezeNotFound)
+	}
+	assertAvailable(t, wallet, "50")
+
+	// 到期之前不会自动解冻
+	if released, err := wallet.ReleaseExpiredFreezes(); err != nil || len(released) != 0 {
+		t.Errorf("ReleaseExpiredFreezes() got = %+v, %v, want none", released, err)
+	}
+	*now = now.Add(time.Hour)
+	released, err := wallet.ReleaseExpiredFreezes()
+	if err != nil || len(released) != 1 || released[0].Id != "order-2" {
+		t.Fatalf("ReleaseExpiredFreezes() got = %+v, %v", released, err)
+	}
+	assertAvailable(t, wallet, "100")
+	if wallet.FrozenAmount() != cny("0") || len(wallet.Freezes()) != 0 {
+		t.Errorf("FrozenAmount() = %v, Freezes() = %+v", wallet.FrozenAmount(), wallet.Freezes())
+	}
+
+	var unfrozen []FundsUnfrozen
+	for _, event := range wallet.PullEvents() {
+		if e, ok := event.(FundsUnfrozen); ok {
+			unfrozen = append(unfrozen, e)
+		}
+	}
+	want := []FundsUnfrozen{
+		{EventHeader{"w1", now.Add(-time.Hour)}, "order-1", cny("10"), false},
+		{EventHeader{"w1", now.Add(-time.Hour)}, "order-1", cny("20"), false},
+		{EventHeader{"w1", *now}, "order-2", cny("50"), true},
+	}
+	if !reflect.DeepEqual(unfrozen, want) {
+		t.Errorf("FundsUnfrozen events got = %+v, want %+v", unfrozen, want)
+	}
+}
+
+func TestVirtualWallet_MultiCurrency(t *testing.T) {
+	wallet, _ := newTestWallet(t, "10")
+	if err := wallet.Credit(MustParseMoney("20", USD)); err != nil {
+		t.Fatalf("Credit() error = %v", err)
+	}
+	if err := wallet.Freeze("order-1", MustParseMoney("15", USD), "order", time.Time{}); err != nil {
+		t.Fatalf("Freeze() error = %v", err)
+	}
+
+	// 透支只适用于主币种，冻结按照币种分别计算
+	wallet.OpenOverdraft()
+	if err := wallet.IncreaseOverdraftAmount(cny("100")); err != nil {
+		t.Fatalf("IncreaseOverdraftAmount() error = %v", err)
+	}
+	if got, err := wallet.GetAvailableAmountIn(USD); err != nil || got != MustParseMoney("5", USD) {
+		t.Errorf("GetAvailableAmountIn(USD) got = %v, %v, want 5 USD", got, err)
+	}
+	assertAvailable(t, wallet, "110")
+	if err := wallet.Debit(MustParseMoney("5.01", USD)); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("Debit() error = %v, want %v", err, ErrInsufficientBalance)
+	}
+	if err := wallet.Debit(cny("110")); err != nil {
+		t.Errorf("Debit() error = %v", err)
+	}
+
+	want := []Money{cny("-100"), MustParseMoney("20", USD)}
+	if got := wallet.Balances(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Balances() got = %v, want %v", got, want)
+	}
+}
+
+func TestVirtualWallet_Overdraft(t *testing.T) {
+	wallet, _ := newTestWallet(t, "10")
+	if err := wallet.IncreaseOverdraftAmount(cny("100")); err != nil {
+		t.Fatalf("IncreaseOverdraftAmount() error = %v", err)
+	}
+	// 透支没有开启时额度不生效
+	assertAvailable(t, wallet, "10")
+
+	wallet.OpenOverdraft()
+	assertAvailable(t, wallet, "110")
+	if err := wallet.Debit(cny("60")); err != nil {
+		t.Fatalf("Debit() error = %v", err)
+	}
+
+	if err := wallet.CloseOverdraft(); !errors.Is(err, ErrOverdraftInDebt) {
+		t.Errorf("CloseOverdraft() error = %v, want %v", err, ErrOverdraftInDebt)
+	}
+	if err := wallet.DecreaseOverdraftAmount(cny("50.01")); !errors.Is(err, ErrOverdraftInDebt) {
+		t.Errorf("DecreaseOverdraftAmount() error = %v, want %v", err, ErrOverdraftInDebt)
+	}
+	if err := wallet.DecreaseOverdraftAmount(cny("50")); err != nil {
+		t.Fatalf("DecreaseOverdraftAmount() error = %v", err)
+	}
+	assertAvailable(t, wallet, "0")
+	if err := wallet.DecreaseOverdraftAmount(cny("50.01")); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("DecreaseOverdraftAmount() error = %v, want %v", err, ErrInvalidAmount)
+	}
+
+	// 还清透支之后才能关闭
+	if err := wallet.Credit(cny("50")); err != nil {
+		t.Fatalf("Credit() error = %v", err)
+	}
+	if err := wallet.CloseOverdraft(); err != nil || wallet.IsAllowedOverdraft() {
+		t.Fatalf("CloseOverdraft() error = %v", err)
+	}
+	assertAvailable(t, wallet, "0")
+
+	var types []string
+	for _, event := range wallet.PullEvents() {
+		types = append(types, reflect.TypeOf(event).Name())
+	}
+	want := []string{"OverdraftLimitChanged", "OverdraftOpened", "WalletDebited", "OverdraftLimitChanged", "WalletCredited", "OverdraftClosed"}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("PullEvents() got = %v, want %v", types, want)
+	}
+}
+
+func TestDDDVirtualWalletService_FreezeAndOverdraft(t *testing.T) {
+	store := newMemoryStoreWithWallets(t, map[string]Money{"a": cny("100")})
+	service := NewDDDVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository(),
+		WithWalletLedger(NewWalletLedger(store.EventStore(), store.SnapshotStore(), 2)))
+	ctx := context.Background()
+
+	// 冻结的金额保存在账本中，之后的出账重新加载钱包时仍然生效
+	if err := service.Freeze(ctx, "a", "f1", cny("80"), "order", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Freeze() error = %v", err)
+	}
+	if err := service.Debit(ctx, "a", cny("30")); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("Debit() error = %v, want %v", err, ErrInsufficientBalance)
+	}
+	if err := service.UnFreeze(ctx, "a", "f1", cny("50")); err != nil {
+		t.Fatalf("UnFreeze() error = %v", err)
+	}
+	if err := service.Debit(ctx, "a", cny("30")); err != nil {
+		t.Errorf("Debit() error = %v", err)
+	}
+
+	// 开启透支之后可以透支额度以内的金额，正在透支时不能关闭
+	if err := service.OpenOverdraft(ctx, "a"); err != nil {
+		t.Fatalf("OpenOverdraft() error = %v", err)
+	}
+	if err := service.IncreaseOverdraftAmount(ctx, "a", cny("50")); err != nil {
+		t.Fatalf("IncreaseOverdraftAmount() error = %v", err)
+	}
+	if err := service.Debit(ctx, "a", cny("60")); err != nil {
+		t.Fatalf("Debit() overdraft error = %v", err)
+	}
+	if err := service.CloseOverdraft(ctx, "a"); !errors.Is(err, ErrOverdraftInDebt) {
+		t.Errorf("CloseOverdraft() error = %v, want %v", err, ErrOverdraftInDebt)
+	}
+	if err := service.DecreaseOverdraftAmount(ctx, "a", cny("40")); !errors.Is(err, ErrOverdraftInDebt) {
+		t.Errorf("DecreaseOverdraftAmount() error = %v, want %v", err, ErrOverdraftInDebt)
+	}
+	assertBalances(t, store, map[string]Money{"a": cny("10")})
+	wallet, err := service.GetVirtualWallet(ctx, "a")
+	if err != nil || wallet.balance != cny("10") {
+		t.Errorf("GetVirtualWallet() got = %+v, %v", wallet, err)
+	}
+	if released, err := service.ReleaseExpiredFreezes(ctx, "a"); err != nil || len(released) != 0 {
+		t.Errorf("ReleaseExpiredFreezes() got = %v, %v, want none", released, err)
+	}
+
+	// 没有账本时冻结和透支的状态无处保存
+	plain := NewDDDVirtualWalletService(store.UnitOfWork(), store.WalletRepository(), store.TransactionRepository())
+	if err := plain.Freeze(ctx, "a", "f2", cny("1"), "order", time.Time{}); !errors.Is(err, ErrLedgerRequired) {
+		t.Errorf("Freeze() error = %v, want %v", err, ErrLedgerRequired)
+	}
+	if err := plain.OpenOverdraft(ctx, "a"); !errors.Is(err, ErrLedgerRequired) {
+		t.Errorf("OpenOverdraft() error = %v, want %v", err, ErrLedgerRequired)
+	}
+}
@@ -3,8 +3,10 @@ module github.com/promacanthus/design-patterns
 go 1.16
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/Shopify/sarama v1.28.0
 	github.com/google/uuid v1.2.0
 	go.uber.org/multierr v1.7.0 // indirect
-	go.uber.org/zap v1.16.0 // indirect
+	go.uber.org/zap v1.16.0
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
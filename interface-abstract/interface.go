@@ -1,40 +1,267 @@
 package interface_abstract
 
-import "net/rpc"
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"runtime/debug"
+)
 
-// AuthenticationFilter 和 RateLimitFilter 是接口的两个实现类，分别实现了对 RPC 请求鉴权和限流的过滤功能。
+// AuthenticationFilter、RateLimitFilter 和 RecoveryFilter 是接口的实现类，分别实现了对 RPC 请求鉴权、限流和恢复 panic 的过滤功能。
+// Application 只依赖 Filter 接口，增加新的过滤功能时只需要实现新的过滤器，Application 的代码不用修改。
 
 // Filter 接口
 type Filter interface {
-	doFilter(req rpc.Request) error
+	// Before 在调用服务方法之前按照顺序执行，返回错误时不再执行后面的过滤器和服务方法，错误返回给客户端
+	Before(inv *Invocation) error
+	// After 在返回响应之前按照相反的顺序执行，只有 Before 执行成功的过滤器才会执行。
+	// err 是服务方法或者过滤器返回的错误，返回值替换 err 返回给客户端
+	After(inv *Invocation, err error) error
 }
 
-// AuthenticationFilter 接口实现类：鉴权过滤器
-type AuthenticationFilter struct{}
-
-func (a AuthenticationFilter) doFilter(req rpc.Request) error {
-	panic("implement me")
-	// TODO: 鉴权逻辑
+// Invocation 一次 RPC 调用，同一次调用的 Before 和 After 得到的是同一个 Invocation
+type Invocation struct {
+	ServiceMethod string
+	Seq           uint64
+	// RemoteAddr 客户端地址，连接没有地址时为 nil
+	RemoteAddr net.Addr
+	// Args 请求参数，是指向参数的指针
+	Args interface{}
+	// Reply 响应，只在调用成功时的 After 中有效
+	Reply interface{}
 }
 
-// RateLimitFilter 接口实现类：限流过滤器
-type RateLimitFilter struct{}
+// PanicError 过滤器或者服务方法 panic 时作为 err 传给 After，After 没有把它替换为其他错误时重新 panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
 
-func (r RateLimitFilter) doFilter(req rpc.Request) error {
-	panic("implement me")
-	// TODO: 限流逻辑
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
 }
 
-// Application 过滤器使用类
+// invalidRequest 出错时作为响应，和 net/rpc 一致
+var invalidRequest = struct{}{}
+
+// Application 过滤器使用类，在 net/rpc 服务外面执行过滤器链
 type Application struct {
+	server  *rpc.Server
 	filters []Filter
 }
 
-func (a Application) HandleRPCRequest(req rpc.Request) error {
-	for _, filter := range a.filters {
-		if err := filter.doFilter(req); err != nil {
+// NewApplication 服务注册在 server 上，filters 按照顺序执行
+func NewApplication(server *rpc.Server, filters ...Filter) *Application {
+	return &Application{server: server, filters: filters}
+}
+
+// Accept 接受 listener 上的连接并为每个连接启动一个处理协程，和 rpc.Server.Accept 一样在 listener 出错时返回
+func (a *Application) Accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Print("rpc.Serve: accept:", err.Error())
+			return
+		}
+		go a.ServeConn(conn)
+	}
+}
+
+// ServeConn 使用和 rpc.ServeConn 相同的 gob 编码处理连接上的请求，客户端可以直接使用 rpc.Dial 或者 rpc.NewClient
+func (a *Application) ServeConn(conn io.ReadWriteCloser) {
+	buf := bufio.NewWriter(conn)
+	a.serveCodec(&gobServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}, remoteAddr(conn))
+}
+
+// ServeCodec 处理 codec 上的请求直到连接关闭。
+// 同一个连接上的请求按照顺序处理，这样服务方法的 panic 才能在当前协程中恢复，需要并发调用时客户端使用多个连接
+func (a *Application) ServeCodec(codec rpc.ServerCodec) {
+	a.serveCodec(codec, nil)
+}
+
+func (a *Application) serveCodec(codec rpc.ServerCodec, addr net.Addr) {
+	c := &filterCodec{ServerCodec: codec, filters: a.filters, remoteAddr: addr}
+	for c.readErr == nil {
+		c.serveRequest(a.server)
+	}
+	if c.readErr != io.EOF && c.readErr != io.ErrUnexpectedEOF {
+		log.Println("rpc:", c.readErr)
+	}
+	codec.Close()
+}
+
+func remoteAddr(conn io.ReadWriteCloser) net.Addr {
+	if conn, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		return conn.RemoteAddr()
+	}
+	return nil
+}
+
+// filterCodec 在读取请求参数之后执行 Before，在写响应之前执行 After。
+// Before 返回的错误作为读取请求参数的错误，net/rpc 会把它返回给客户端并继续处理下一个请求
+type filterCodec struct {
+	rpc.ServerCodec
+	filters    []Filter
+	remoteAddr net.Addr
+	// readErr 读取请求头失败时连接不能继续使用
+	readErr error
+
+	// 当前请求的状态，passed 是 Before 执行成功的过滤器数量
+	inv       *Invocation
+	passed    int
+	err       error
+	responded bool
+}
+
+func (c *filterCodec) ReadRequestHeader(req *rpc.Request) error {
+	c.inv, c.passed, c.err, c.responded = nil, 0, nil, false
+	if err := c.ServerCodec.ReadRequestHeader(req); err != nil {
+		c.readErr = err
+		return err
+	}
+	c.inv = &Invocation{ServiceMethod: req.ServiceMethod, Seq: req.Seq, RemoteAddr: c.remoteAddr}
+	return nil
+}
+
+// ReadRequestBody body 为 nil 时请求的服务方法不存在，不执行过滤器
+func (c *filterCodec) ReadRequestBody(body interface{}) error {
+	if err := c.ServerCodec.ReadRequestBody(body); err != nil || body == nil {
+		return err
+	}
+	c.inv.Args = body
+	for _, filter := range c.filters {
+		filter := filter
+		if err := protect(func() error { return filter.Before(c.inv) }); err != nil {
+			c.err = err
 			return err
 		}
+		c.passed++
 	}
 	return nil
 }
+
+func (c *filterCodec) WriteResponse(resp *rpc.Response, body interface{}) error {
+	c.responded = true
+	var err error
+	switch {
+	case c.err != nil:
+		err = c.err
+	case resp.Error != "":
+		err = rpc.ServerError(resp.Error)
+	default:
+		c.inv.Reply = body
+	}
+
+	if err = c.after(err); err != nil {
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			panic(panicErr.Value)
+		}
+		resp.Error = err.Error()
+		body = invalidRequest
+	} else {
+		resp.Error = ""
+	}
+	return c.ServerCodec.WriteResponse(resp, body)
+}
+
+func (c *filterCodec) after(err error) error {
+	for i := c.passed - 1; i >= 0; i-- {
+		filter, cause := c.filters[i], err
+		err = protect(func() error { return filter.After(c.inv, cause) })
+	}
+	return err
+}
+
+func (c *filterCodec) serveRequest(server *rpc.Server) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.recover(p)
+		}
+	}()
+	// 读取请求参数失败时 ServeRequest 也返回错误，这时响应已经发送，只有 readErr 才需要停止处理
+	_ = server.ServeRequest(c)
+}
+
+// recover 服务方法 panic 时还没有发送响应，交给 After 处理之后发送，没有过滤器处理时重新 panic
+func (c *filterCodec) recover(p interface{}) {
+	if c.inv == nil || c.responded {
+		panic(p)
+	}
+	c.responded = true
+
+	var panicErr *PanicError
+	err := c.after(&PanicError{Value: p, Stack: debug.Stack()})
+	if err == nil || errors.As(err, &panicErr) {
+		panic(p)
+	}
+	resp := &rpc.Response{ServiceMethod: c.inv.ServiceMethod, Seq: c.inv.Seq, Error: err.Error()}
+	if err := c.ServerCodec.WriteResponse(resp, invalidRequest); err != nil {
+		log.Println("rpc: writing response:", err)
+	}
+}
+
+// protect 把 hook 的 panic 转换为 PanicError
+func protect(hook func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Value: p, Stack: debug.Stack()}
+		}
+	}()
+	return hook()
+}
+
+// gobServerCodec 和 net/rpc 中没有导出的 gob 编码相同
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// gob 编码失败时关闭连接，客户端才能知道
+			log.Println("rpc: gob error encoding response:", err)
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding body:", err)
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		// 只关闭一次
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
@@ -0,0 +1,178 @@
+package interface_abstract
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrInternal        = errors.New("internal error")
+)
+
+var (
+	_ Filter = (*AuthenticationFilter)(nil)
+	_ Filter = (*RateLimitFilter)(nil)
+	_ Filter = (*RecoveryFilter)(nil)
+)
+
+// Credential 客户端的凭证
+type Credential struct {
+	AppId string
+	Token string
+}
+
+// CredentialCarrier 需要鉴权的请求参数实现这个接口来携带凭证
+type CredentialCarrier interface {
+	GetCredential() Credential
+}
+
+// CredentialChecker 校验凭证是否可以调用服务方法，可以替换为查询数据库或者校验签名的实现
+type CredentialChecker interface {
+	Check(serviceMethod string, credential Credential) error
+}
+
+// CredentialCheckerFunc 把函数转换为 CredentialChecker
+type CredentialCheckerFunc func(serviceMethod string, credential Credential) error
+
+func (f CredentialCheckerFunc) Check(serviceMethod string, credential Credential) error {
+	return f(serviceMethod, credential)
+}
+
+// StaticCredentialChecker 使用固定的 appId 和 token，可以调用所有的服务方法
+type StaticCredentialChecker map[string]string
+
+func (c StaticCredentialChecker) Check(serviceMethod string, credential Credential) error {
+	token, ok := c[credential.AppId]
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(credential.Token)) != 1 {
+		return fmt.Errorf("invalid token of app %q", credential.AppId)
+	}
+	return nil
+}
+
+// AuthenticationFilter 接口实现类：鉴权过滤器，请求参数没有携带凭证或者凭证校验失败时返回 ErrUnauthenticated
+type AuthenticationFilter struct {
+	checker CredentialChecker
+}
+
+func NewAuthenticationFilter(checker CredentialChecker) *AuthenticationFilter {
+	return &AuthenticationFilter{checker: checker}
+}
+
+func (f *AuthenticationFilter) Before(inv *Invocation) error {
+	carrier, ok := inv.Args.(CredentialCarrier)
+	if !ok {
+		return fmt.Errorf("%w: %s requires credential", ErrUnauthenticated, inv.ServiceMethod)
+	}
+	if err := f.checker.Check(inv.ServiceMethod, carrier.GetCredential()); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return nil
+}
+
+func (f *AuthenticationFilter) After(inv *Invocation, err error) error {
+	return err
+}
+
+// RateLimit 令牌桶的配置，每秒生成 Rate 个令牌，最多积攒 Burst 个
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// tokenBucket 在取令牌时按照经过的时间补充令牌
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.limit.Rate
+		if b.tokens > float64(b.limit.Burst) {
+			b.tokens = float64(b.limit.Burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RateLimitFilter 接口实现类：限流过滤器，每个服务方法使用一个令牌桶，没有令牌时返回 ErrRateLimited
+type RateLimitFilter struct {
+	defaultLimit RateLimit
+	limits       map[string]RateLimit
+	now          func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewRateLimitFilter limits 是服务方法（如 "Arith.Multiply"）的限流配置，没有配置的服务方法使用 defaultLimit。
+// Rate 小于等于 0 时不限流，Burst 小于等于 0 时为 1
+func NewRateLimitFilter(defaultLimit RateLimit, limits map[string]RateLimit) *RateLimitFilter {
+	return &RateLimitFilter{defaultLimit: defaultLimit, limits: limits, now: time.Now,
+		buckets: make(map[string]*tokenBucket)}
+}
+
+func (f *RateLimitFilter) Before(inv *Invocation) error {
+	limit, ok := f.limits[inv.ServiceMethod]
+	if !ok {
+		limit = f.defaultLimit
+	}
+	if limit.Rate <= 0 {
+		return nil
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	bucket, ok := f.buckets[inv.ServiceMethod]
+	if !ok {
+		// 新的令牌桶是满的
+		bucket = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		f.buckets[inv.ServiceMethod] = bucket
+	}
+	if !bucket.take(now) {
+		return fmt.Errorf("%w: %s", ErrRateLimited, inv.ServiceMethod)
+	}
+	return nil
+}
+
+func (f *RateLimitFilter) After(inv *Invocation, err error) error {
+	return err
+}
+
+// RecoveryFilter 接口实现类：恢复过滤器，把过滤器和服务方法的 panic 记录日志之后作为 ErrInternal 返回给客户端，
+// 连接可以继续使用。放在第一个，它的 After 最后执行，能够处理其他过滤器的 panic
+type RecoveryFilter struct{}
+
+func NewRecoveryFilter() *RecoveryFilter {
+	return &RecoveryFilter{}
+}
+
+func (f *RecoveryFilter) Before(inv *Invocation) error {
+	return nil
+}
+
+func (f *RecoveryFilter) After(inv *Invocation, err error) error {
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		return err
+	}
+	log.Printf("rpc: %s panic: %v\n%s", inv.ServiceMethod, panicErr.Value, panicErr.Stack)
+	return fmt.Errorf("%w: %s", ErrInternal, inv.ServiceMethod)
+}
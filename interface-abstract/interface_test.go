@@ -0,0 +1,138 @@
+package interface_abstract
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type GreetArgs struct {
+	Credential
+	Name string
+}
+
+func (a *GreetArgs) GetCredential() Credential {
+	return a.Credential
+}
+
+type Greeter struct{}
+
+func (g *Greeter) Hello(args *GreetArgs, reply *string) error {
+	switch args.Name {
+	case "":
+		return errors.New("empty name")
+	case "panic":
+		panic("boom")
+	}
+	*reply = "hello " + args.Name
+	return nil
+}
+
+func (g *Greeter) Ping(args *GreetArgs, reply *string) error {
+	*reply = "pong"
+	return nil
+}
+
+// recordingFilter 记录过滤器的执行顺序
+type recordingFilter struct {
+	events []string
+}
+
+func (f *recordingFilter) Before(inv *Invocation) error {
+	f.events = append(f.events, "before "+inv.ServiceMethod)
+	return nil
+}
+
+func (f *recordingFilter) After(inv *Invocation, err error) error {
+	f.events = append(f.events, "after "+inv.ServiceMethod)
+	return err
+}
+
+func TestApplication_ServeConn(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.Register(&Greeter{}); err != nil {
+		t.Fatal(err)
+	}
+	recorder := &recordingFilter{}
+	rateLimit := NewRateLimitFilter(RateLimit{}, map[string]RateLimit{"Greeter.Ping": {Rate: 1, Burst: 2}})
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	rateLimit.now = func() time.Time { return now }
+	app := NewApplication(server, NewRecoveryFilter(), recorder,
+		NewAuthenticationFilter(StaticCredentialChecker{"app-1": "secret"}), rateLimit)
+
+	serverConn, clientConn := net.Pipe()
+	go app.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+
+	valid := Credential{AppId: "app-1", Token: "secret"}
+	call := func(method string, args *GreetArgs) (string, error) {
+		t.Helper()
+		var reply string
+		err := client.Call(method, args, &reply)
+		return reply, err
+	}
+	wantErr := func(err error, want string) {
+		t.Helper()
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("Call() error = %v, want %s", err, want)
+		}
+	}
+
+	if reply, err := call("Greeter.Hello", &GreetArgs{Credential: valid, Name: "alice"}); reply != "hello alice" || err != nil {
+		t.Errorf("Call() got = %q, %v", reply, err)
+	}
+	_, err := call("Greeter.Hello", &GreetArgs{Credential: Credential{AppId: "app-1", Token: "guess"}, Name: "alice"})
+	wantErr(err, ErrUnauthenticated.Error())
+	_, err = call("Greeter.Hello", &GreetArgs{Credential: valid})
+	wantErr(err, "empty name")
+	// 服务方法 panic 之后连接可以继续使用
+	_, err = call("Greeter.Hello", &GreetArgs{Credential: valid, Name: "panic"})
+	wantErr(err, ErrInternal.Error())
+	_, err = call("Greeter.Bye", &GreetArgs{Credential: valid})
+	wantErr(err, "rpc: can't find method")
+
+	// Greeter.Ping 每秒一个令牌，最多两个，其他服务方法不限流
+	for i := 0; i < 2; i++ {
+		if reply, err := call("Greeter.Ping", &GreetArgs{Credential: valid}); reply != "pong" || err != nil {
+			t.Errorf("Call(Ping) got = %q, %v", reply, err)
+		}
+	}
+	_, err = call("Greeter.Ping", &GreetArgs{Credential: valid})
+	wantErr(err, ErrRateLimited.Error())
+	if _, err := call("Greeter.Hello", &GreetArgs{Credential: valid, Name: "bob"}); err != nil {
+		t.Errorf("Call(Hello) after rate limited error = %v", err)
+	}
+	now = now.Add(time.Second)
+	if _, err := call("Greeter.Ping", &GreetArgs{Credential: valid}); err != nil {
+		t.Errorf("Call(Ping) after refill error = %v", err)
+	}
+
+	// 鉴权或者限流失败时，前面的过滤器的 After 也会执行；服务方法不存在时不执行过滤器
+	var want []string
+	for _, method := range []string{"Hello", "Hello", "Hello", "Hello", "Ping", "Ping", "Ping", "Hello", "Ping"} {
+		want = append(want, "before Greeter."+method, "after Greeter."+method)
+	}
+	if !reflect.DeepEqual(recorder.events, want) {
+		t.Errorf("events got = %v, want %v", recorder.events, want)
+	}
+}
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	bucket := &tokenBucket{limit: RateLimit{Rate: 2, Burst: 3}, tokens: 3, last: now}
+	var got []bool
+	for _, elapsed := range []time.Duration{0, 0, 0, 0, 250 * time.Millisecond, 250 * time.Millisecond, 10 * time.Second,
+		0, 0, 0} {
+		now = now.Add(elapsed)
+		got = append(got, bucket.take(now))
+	}
+	want := []bool{true, true, true, false, false, true, true, true, true, false}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("take() got = %v, want %v", got, want)
+	}
+}
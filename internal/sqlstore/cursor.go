@@ -0,0 +1,32 @@
+package sqlstore
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+)
+
+// ErrInvalidCursor 游标不是 EncodeCursor 生成的
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor 按照 ID 倒序分页时，下一页从 ID 小于 beforeId 的记录开始。
+// 游标对客户端是不透明的，以后可以换成其他的排序方式
+func EncodeCursor(beforeId int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(beforeId, 10)))
+}
+
+// DecodeCursor 第一页的游标为空，返回 0；客户端修改过的游标返回 ErrInvalidCursor
+func DecodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	beforeId, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil || beforeId <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return beforeId, nil
+}
@@ -0,0 +1,79 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrDirtyMigration 上一次执行建表语句时进程退出了，不知道语句有没有执行完，需要人工检查表结构之后清除 dirty 标记
+var ErrDirtyMigration = errors.New("dirty migration")
+
+// Migrate 按顺序执行 migrations 中还没有执行过的建表语句，返回本次执行的语句数量。
+// 执行过的版本记录在 table 表中，不同的系统使用不同的表，共用一个数据库时版本号互不影响。
+//
+// MySQL 的 DDL 会隐式提交事务，建表语句和版本记录不能放在同一个事务中，所以不使用事务：
+// 执行之前先记录版本并标记为 dirty，执行成功之后清除标记，执行失败时删除记录（MySQL 的单条 DDL 是原子的，失败时没有修改）。
+// 只有执行期间进程退出才会留下 dirty 的版本，之后的 Migrate 返回 ErrDirtyMigration，不会重复执行不幂等的语句（如 ADD COLUMN、UPDATE）。
+// 建表语句尽量写成幂等的（CREATE TABLE IF NOT EXISTS），人工处理时可以直接重新执行
+func Migrate(ctx context.Context, db *sql.DB, table string, migrations []string) (int, error) {
+	if err := createMigrationTable(ctx, db, table); err != nil {
+		return 0, err
+	}
+
+	var current int
+	var dirty bool
+	err := db.QueryRowContext(ctx, "SELECT version, dirty FROM "+table+" ORDER BY version DESC LIMIT 1").Scan(&current, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if dirty {
+		return 0, fmt.Errorf("%w: version %d in %s", ErrDirtyMigration, current, table)
+	}
+
+	applied := 0
+	for version := current + 1; version <= len(migrations); version++ {
+		if err := migrate(ctx, db, table, version, migrations[version-1]); err != nil {
+			return applied, fmt.Errorf("migrate to version %d: %w", version, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// createMigrationTable 早期的版本表没有 dirty 列，需要时补上
+func createMigrationTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+table+` (
+	version      INT       NOT NULL,
+	dirty        BOOLEAN   NOT NULL DEFAULT FALSE,
+	applied_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (version)
+)`)
+	if err != nil {
+		return err
+	}
+
+	var columns int
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'dirty'", table).Scan(&columns)
+	if err != nil || columns > 0 {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "ALTER TABLE "+table+" ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE AFTER version")
+	return err
+}
+
+func migrate(ctx context.Context, db *sql.DB, table string, version int, statement string) error {
+	if _, err := db.ExecContext(ctx, "INSERT INTO "+table+" (version, dirty) VALUES (?, TRUE)", version); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, statement); err != nil {
+		if _, deleteErr := db.ExecContext(ctx, "DELETE FROM "+table+" WHERE version = ?", version); deleteErr != nil {
+			return fmt.Errorf("%w (delete dirty version: %v)", err, deleteErr)
+		}
+		return err
+	}
+	_, err := db.ExecContext(ctx, "UPDATE "+table+" SET dirty = FALSE WHERE version = ?", version)
+	return err
+}
@@ -0,0 +1,73 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/promacanthus/design-patterns/internal/sqlstore/sqltest"
+)
+
+func TestMigrate(t *testing.T) {
+	migrations := []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"}
+	db, mock := sqltest.NewMockDB(t)
+	// 只执行最后一个还没有执行过的版本
+	sqltest.ExpectMigrate(mock, "test_migrations", 1, migrations[1])
+
+	if applied, err := Migrate(context.Background(), db, "test_migrations", migrations); applied != 1 || err != nil {
+		t.Errorf("Migrate() got = %v, %v, want 1", applied, err)
+	}
+}
+
+func TestMigrate_Failed(t *testing.T) {
+	migrations := []string{"CREATE TABLE a (id INT)", "ALTER TABLE a ADD COLUMN b INT"}
+	db, mock := sqltest.NewMockDB(t)
+	// 早期的版本表没有 dirty 列，第一次执行时补上
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS test_migrations")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM information_schema.COLUMNS")).WithArgs("test_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE test_migrations ADD COLUMN dirty")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, dirty FROM test_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(1, false))
+	// 执行失败时删除 dirty 的版本，修复之后可以重新执行
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO test_migrations (version, dirty) VALUES (?, TRUE)")).
+		WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(migrations[1])).WillReturnError(errors.New("duplicate column name 'b'"))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM test_migrations WHERE version = ?")).
+		WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if applied, err := Migrate(context.Background(), db, "test_migrations", migrations); applied != 0 || err == nil {
+		t.Errorf("Migrate() got = %v, %v, want error", applied, err)
+	}
+}
+
+func TestMigrate_Dirty(t *testing.T) {
+	db, mock := sqltest.NewMockDB(t)
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS test_migrations")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM information_schema.COLUMNS")).WithArgs("test_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, dirty FROM test_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, true))
+
+	// 上一次执行到一半，不会重复执行任何语句
+	migrations := []string{"CREATE TABLE a (id INT)", "UPDATE a SET id = id * 100", "CREATE TABLE b (id INT)"}
+	if _, err := Migrate(context.Background(), db, "test_migrations", migrations); !errors.Is(err, ErrDirtyMigration) {
+		t.Errorf("Migrate() error = %v, want %v", err, ErrDirtyMigration)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	if got, err := DecodeCursor(EncodeCursor(42)); got != 42 || err != nil {
+		t.Errorf("DecodeCursor() got = %d, %v, want 42", got, err)
+	}
+	if got, err := DecodeCursor(""); got != 0 || err != nil {
+		t.Errorf("DecodeCursor(\"\") got = %d, %v, want 0", got, err)
+	}
+	for _, cursor := range []string{"not a cursor", EncodeCursor(0), "YWJj"} {
+		if _, err := DecodeCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) error = %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+}
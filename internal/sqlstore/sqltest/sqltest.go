@@ -0,0 +1,43 @@
+// Package sqltest 基于 go-sqlmock 测试 Repository 的辅助函数
+package sqltest
+
+import (
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// NewMockDB 测试结束时检查所有预期的 SQL 都已经执行并关闭 db
+func NewMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// ExpectMigrate 预期 sqlstore.Migrate 在已经执行到 current 版本、版本表已经有 dirty 列时，依次执行 statements
+func ExpectMigrate(mock sqlmock.Sqlmock, table string, current int, statements ...string) {
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS " + table)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM information_schema.COLUMNS")).WithArgs(table).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, dirty FROM " + table + " ORDER BY version DESC LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(current, false))
+	for i, statement := range statements {
+		version := current + i + 1
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO " + table + " (version, dirty) VALUES (?, TRUE)")).
+			WithArgs(version).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(statement)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE " + table + " SET dirty = FALSE WHERE version = ?")).
+			WithArgs(version).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
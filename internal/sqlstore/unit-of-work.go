@@ -0,0 +1,69 @@
+// Package sqlstore 基于 database/sql 的 Repository 共用的基础设施：工作单元、建表语句的版本管理和分页游标。
+// 钱包和积分系统各自定义表结构和 Repository，只共用这里的事务和迁移流程。
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+)
+
+// UnitOfWork 工作单元，保证一次业务操作中的多次数据读写要么全部提交，要么全部回滚。
+// Service 类负责事务这类非功能性的工作，Repository 从 ctx 中取出当前的事务，不需要感知事务的边界。
+type UnitOfWork interface {
+	// Do 在工作单元中执行 fn，fn 返回错误时回滚。
+	// ctx 中已经有工作单元时加入其中，由最外层的工作单元负责提交。
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Executor *sql.DB 和 *sql.Tx 的公共方法
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txKey struct{}
+
+// ExecutorFrom 在工作单元中时返回当前的事务，否则直接使用 db
+func ExecutorFrom(ctx context.Context, db *sql.DB) Executor {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+var _ UnitOfWork = (*SQLUnitOfWork)(nil)
+
+// SQLUnitOfWork 基于数据库事务的工作单元
+type SQLUnitOfWork struct {
+	db *sql.DB
+}
+
+func NewSQLUnitOfWork(db *sql.DB) *SQLUnitOfWork {
+	return &SQLUnitOfWork{db: db}
+}
+
+func (u *SQLUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}